@@ -0,0 +1,219 @@
+package analytics
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/metrics"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Group is the consumer group shared by the exporters of every instance, so
+// each event is shipped by one of them only.
+const Group = "exporter"
+
+var (
+	exported = metrics.NewCounter("analytics_exported_total", "Click events shipped to the analytics sink.")
+	failures = metrics.NewCounter("analytics_export_failures_total", "Batches the analytics sink failed to accept.")
+)
+
+// Event is a single click as shipped to the analytics store.
+type Event struct {
+	ID        string `json:"id"`
+	Short     string `json:"short"`
+	Timestamp int64  `json:"timestamp"`
+	Country   string `json:"country,omitempty"`
+	Referrer  string `json:"referrer,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// Config controls the click stream and how it is exported.
+type Config struct {
+	Enabled   bool
+	Driver    string
+	DSN       string
+	Batch     int
+	Interval  time.Duration
+	MaxLen    int
+	ClaimIdle time.Duration
+}
+
+// ConfigFromEnv reads the ANALYTICS_EXPORT* environment variables.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Enabled:   os.Getenv("ANALYTICS_EXPORT") == "true",
+		Driver:    os.Getenv("ANALYTICS_EXPORT_DRIVER"),
+		DSN:       os.Getenv("ANALYTICS_EXPORT_DSN"),
+		Batch:     500,
+		Interval:  5 * time.Second,
+		MaxLen:    1000000,
+		ClaimIdle: time.Minute,
+	}
+
+	if v, err := strconv.Atoi(os.Getenv("ANALYTICS_EXPORT_BATCH")); err == nil && v > 0 {
+		cfg.Batch = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("ANALYTICS_EXPORT_INTERVAL")); err == nil && v > 0 {
+		cfg.Interval = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("ANALYTICS_STREAM_MAXLEN")); err == nil && v > 0 {
+		cfg.MaxLen = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("ANALYTICS_EXPORT_CLAIM_IDLE")); err == nil && v > 0 {
+		cfg.ClaimIdle = v
+	}
+
+	return cfg
+}
+
+// AppendEvent queues the command adding a click to the stream. The stream
+// is capped at roughly cfg.MaxLen events so a stalled exporter can't fill
+// redis; the oldest events are dropped first.
+func AppendEvent(p *radix.Pipeline, cfg Config, e Event) {
+	p.Append(radix.Cmd(nil, "XADD", links.ClickStreamKey(), "MAXLEN", "~", strconv.Itoa(cfg.MaxLen), "*",
+		"short", e.Short,
+		"ts", strconv.FormatInt(e.Timestamp, 10),
+		"country", e.Country,
+		"referrer", e.Referrer,
+		"ua", e.UserAgent,
+	))
+}
+
+// Exporter ships the click stream to a sink in batches.
+type Exporter struct {
+	cfg      Config
+	sink     Sink
+	consumer string
+}
+
+// NewExporter returns an exporter reading the stream as consumer.
+func NewExporter(cfg Config, sink Sink, consumer string) *Exporter {
+	return &Exporter{cfg: cfg, sink: sink, consumer: consumer}
+}
+
+// Run exports batches until ctx is cancelled. Events are acknowledged only
+// once the sink accepted them; a failed batch is retried on the next tick
+// and events left pending by a crashed instance are claimed after
+// cfg.ClaimIdle.
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := e.exportOnce(ctx)
+			if err != nil {
+				failures.Inc()
+				log.Printf("analytics: %v", err)
+				break
+			}
+			// A full batch suggests a backlog, keep going until it's drained.
+			if n < e.cfg.Batch {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// exportOnce ships one batch, preferring events already delivered to this
+// consumer or idle in another one, and returns its size.
+func (e *Exporter) exportOnce(ctx context.Context) (int, error) {
+	rClient, err := database.Bulk()
+	if err != nil {
+		return 0, err
+	}
+
+	err = rClient.Do(radix.Cmd(nil, "XGROUP", "CREATE", links.ClickStreamKey(), Group, "0", "MKSTREAM"))
+	if err != nil && !isBusyGroup(err) {
+		return 0, err
+	}
+
+	entries, err := e.claim(rClient)
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		var streams []radix.StreamEntries
+		err := rClient.Do(radix.Cmd(&streams, "XREADGROUP", "GROUP", Group, e.consumer,
+			"COUNT", strconv.Itoa(e.cfg.Batch), "STREAMS", links.ClickStreamKey(), ">"))
+		if err != nil {
+			return 0, err
+		}
+		for _, s := range streams {
+			entries = append(entries, s.Entries...)
+		}
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	events := make([]Event, 0, len(entries))
+	ids := []string{links.ClickStreamKey(), Group}
+	for _, entry := range entries {
+		events = append(events, decode(entry))
+		ids = append(ids, entry.ID.String())
+	}
+
+	if err := e.sink.Write(ctx, events); err != nil {
+		return 0, err
+	}
+	exported.Add(int64(len(events)))
+
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "XACK", ids...))
+	p.Append(radix.Cmd(nil, "XDEL", append([]string{links.ClickStreamKey()}, ids[2:]...)...))
+	if err := rClient.Do(p); err != nil {
+		return 0, err
+	}
+
+	return len(entries), nil
+}
+
+// claim takes over pending events idle for cfg.ClaimIdle, including this
+// consumer's own from a failed batch.
+func (e *Exporter) claim(rClient database.ClientInterface) ([]radix.StreamEntry, error) {
+	var entries []radix.StreamEntry
+	err := rClient.Do(radix.Cmd(radix.Tuple{nil, &entries, nil}, "XAUTOCLAIM", links.ClickStreamKey(), Group, e.consumer,
+		strconv.FormatInt(e.cfg.ClaimIdle.Milliseconds(), 10), "0-0", "COUNT", strconv.Itoa(e.cfg.Batch)))
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func decode(entry radix.StreamEntry) Event {
+	e := Event{ID: entry.ID.String()}
+	for _, f := range entry.Fields {
+		switch f[0] {
+		case "short":
+			e.Short = f[1]
+		case "ts":
+			e.Timestamp, _ = strconv.ParseInt(f[1], 10, 64)
+		case "country":
+			e.Country = f[1]
+		case "referrer":
+			e.Referrer = f[1]
+		case "ua":
+			e.UserAgent = f[1]
+		}
+	}
+
+	return e
+}
+
+func isBusyGroup(err error) bool {
+	return strings.Contains(err.Error(), "BUSYGROUP")
+}
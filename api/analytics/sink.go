@@ -0,0 +1,176 @@
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sink stores batches of click events outside of redis. Write must either
+// accept the whole batch or fail, since a failed batch is delivered again.
+type Sink interface {
+	Write(ctx context.Context, events []Event) error
+	Close() error
+}
+
+// Driver opens a sink from a driver specific data source name, in the
+// manner of database/sql drivers.
+type Driver func(dsn string) (Sink, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = map[string]Driver{
+		"http": openHTTP,
+		"file": openFile,
+	}
+)
+
+// Register makes a sink driver available to Open under name, so that
+// stores with their own client libraries can be plugged in from a build
+// including them.
+func Register(name string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if driver == nil {
+		panic("analytics: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("analytics: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Drivers returns the names of the registered drivers.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Open returns a sink of the named driver.
+func Open(name, dsn string) (Sink, error) {
+	driversMu.RLock()
+	driver, ok := drivers[name]
+	driversMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown analytics driver %q", name)
+	}
+
+	return driver(dsn)
+}
+
+// HTTPSink POSTs each batch as NDJSON. That is what ClickHouse's HTTP
+// interface takes with a DSN such as
+// http://clickhouse:8123/?query=INSERT+INTO+clicks+FORMAT+JSONEachRow,
+// and what most ingestion gateways in front of BigQuery accept.
+type HTTPSink struct {
+	URL string
+
+	// Header is added to every request, for credentials. openHTTP fills it
+	// from ANALYTICS_EXPORT_AUTHORIZATION.
+	Header http.Header
+
+	Client *http.Client
+}
+
+func openHTTP(dsn string) (Sink, error) {
+	if dsn == "" {
+		return nil, errors.New("http analytics driver needs a URL")
+	}
+
+	s := &HTTPSink{URL: dsn, Header: http.Header{}, Client: &http.Client{Timeout: 30 * time.Second}}
+	if auth := os.Getenv("ANALYTICS_EXPORT_AUTHORIZATION"); auth != "" {
+		s.Header.Set("Authorization", auth)
+	}
+
+	return s, nil
+}
+
+// Write implements Sink.
+func (s *HTTPSink) Write(ctx context.Context, events []Event) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, &body)
+	if err != nil {
+		return err
+	}
+	for k, v := range s.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export %d events, err: %w", len(events), err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to export %d events, status: %s", len(events), resp.Status)
+	}
+
+	return nil
+}
+
+// Close implements Sink.
+func (s *HTTPSink) Close() error {
+	return nil
+}
+
+// FileSink appends events as NDJSON to a local file, for stores loading
+// files in bulk.
+type FileSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openFile(dsn string) (Sink, error) {
+	f, err := os.OpenFile(dsn, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open analytics file %s, err: %w", dsn, err)
+	}
+
+	return &FileSink{f: f}, nil
+}
+
+// Write implements Sink.
+func (s *FileSink) Write(_ context.Context, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enc := json.NewEncoder(s.f)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("failed to write analytics file, err: %w", err)
+		}
+	}
+
+	return s.f.Sync()
+}
+
+// Close implements Sink.
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,210 @@
+package anomaly
+
+import (
+	"context"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/jobs"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/ratelimit"
+	"github.com/ksarpe/redis-golang/webhooks"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+const (
+	// Window is the number of one-minute buckets used as the baseline.
+	Window = 60
+
+	// ActionThrottle limits resolutions of a flagged short per second.
+	ActionThrottle = "throttle"
+
+	// ActionCaptcha makes visitors of a flagged short solve a CAPTCHA.
+	ActionCaptcha = "captcha"
+
+	// ActionWarn shows visitors of a flagged short a warning page first.
+	ActionWarn = "warn"
+)
+
+// Config controls when a short is flagged and what happens once it is.
+type Config struct {
+	// Enabled is false to neither record clicks nor flag shorts.
+	Enabled     bool
+	Threshold   float64
+	MinClicks   int64
+	Action      string
+	ThrottleRPS int64
+}
+
+// ConfigFromEnv reads the ANOMALY_* environment variables.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Enabled:     os.Getenv("ANOMALY_DETECTION") == "true",
+		Threshold:   4,
+		MinClicks:   50,
+		Action:      os.Getenv("ANOMALY_ACTION"),
+		ThrottleRPS: 10,
+	}
+
+	if v, err := strconv.ParseFloat(os.Getenv("ANOMALY_Z_THRESHOLD"), 64); err == nil {
+		cfg.Threshold = v
+	}
+	if v, err := strconv.ParseInt(os.Getenv("ANOMALY_MIN_CLICKS"), 10, 64); err == nil {
+		cfg.MinClicks = v
+	}
+	if v, err := strconv.ParseInt(os.Getenv("ANOMALY_THROTTLE_RPS"), 10, 64); err == nil {
+		cfg.ThrottleRPS = v
+	}
+
+	return cfg
+}
+
+// Record adds a click of short to the current minute bucket.
+func Record(rClient database.ClientInterface, short string) error {
+	p := radix.NewPipeline()
+	AppendRecord(p, short)
+
+	return rClient.Do(p)
+}
+
+// AppendRecord queues the commands of Record on p.
+func AppendRecord(p *radix.Pipeline, short string) {
+	now := clock.Now()
+	key := links.BucketKey(short, now.Unix()/60)
+
+	p.Append(radix.Cmd(nil, "INCR", key))
+	p.Append(radix.Cmd(nil, "EXPIRE", key, strconv.Itoa((Window+2)*60)))
+	p.Append(radix.Cmd(nil, "ZADD", links.ActiveKey(), strconv.FormatInt(now.Unix(), 10), short))
+}
+
+// Throttled reports whether a resolution of a flagged short should be
+// rejected because it exceeds the per-second allowance.
+func Throttled(rClient database.ClientInterface, cfg Config, short string, meta map[string]string) bool {
+	if meta["flagged"] == "" || meta["flag_action"] != ActionThrottle {
+		return false
+	}
+
+	result, err := ratelimit.Allow(rClient, "throttle:"+short, cfg.ThrottleRPS, time.Second)
+	if err != nil {
+		return false
+	}
+
+	return !result.Allowed
+}
+
+// Alert describes a detected spike.
+type Alert struct {
+	Short     string  `json:"short"`
+	Clicks    int64   `json:"clicks"`
+	Mean      float64 `json:"mean"`
+	StdDev    float64 `json:"stddev"`
+	ZScore    float64 `json:"z_score"`
+	Action    string  `json:"action"`
+	FlaggedAt int64   `json:"flagged_at"`
+}
+
+// Job returns the scheduled job comparing the last complete minute of every
+// recently clicked short against its baseline.
+func Job(cfg Config) jobs.Func {
+	return func(ctx context.Context, rClient database.ClientInterface) error {
+		now := clock.Now()
+		since := now.Add(-2 * time.Minute).Unix()
+
+		err := rClient.Do(radix.Cmd(nil, "ZREMRANGEBYSCORE", links.ActiveKey(), "-inf", "("+strconv.FormatInt(since, 10)))
+		if err != nil {
+			return err
+		}
+
+		var shorts []string
+		if err := rClient.Do(radix.Cmd(&shorts, "ZRANGE", links.ActiveKey(), "0", "-1")); err != nil {
+			return err
+		}
+
+		last := now.Unix()/60 - 1
+		for _, short := range shorts {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := check(rClient, cfg, short, last); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func check(rClient database.ClientInterface, cfg Config, short string, last int64) error {
+	keys := make([]string, 0, Window+1)
+	for b := last - Window; b <= last; b++ {
+		keys = append(keys, links.BucketKey(short, b))
+	}
+
+	var counts []int64
+	if err := rClient.Do(radix.Cmd(&counts, "MGET", keys...)); err != nil {
+		return err
+	}
+
+	history, current := counts[:Window], counts[Window]
+	if current < cfg.MinClicks {
+		return nil
+	}
+
+	mean, std := meanStdDev(history)
+	z := (float64(current) - mean) / math.Max(std, 1)
+	if z < cfg.Threshold {
+		return nil
+	}
+
+	var added int
+	if err := rClient.Do(radix.Cmd(&added, "SADD", links.FlaggedKey(), short)); err != nil {
+		return err
+	}
+	if added == 0 {
+		return nil
+	}
+
+	alert := Alert{
+		Short:     short,
+		Clicks:    current,
+		Mean:      mean,
+		StdDev:    std,
+		ZScore:    z,
+		Action:    cfg.Action,
+		FlaggedAt: clock.Now().Unix(),
+	}
+
+	err := rClient.Do(links.WriteCmd(short, []string{
+		"flagged", "anomaly",
+		"flag_action", alert.Action,
+		"flagged_at", strconv.FormatInt(alert.FlaggedAt, 10),
+		"flag_z_score", strconv.FormatFloat(z, 'f', 2, 64),
+	}, nil))
+	if err != nil {
+		return err
+	}
+
+	webhooks.Send("link.anomaly", alert)
+
+	return nil
+}
+
+func meanStdDev(values []int64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += float64(v)
+	}
+	mean := sum / float64(len(values))
+
+	var sq float64
+	for _, v := range values {
+		d := float64(v) - mean
+		sq += d * d
+	}
+
+	return mean, math.Sqrt(sq / float64(len(values)))
+}
@@ -0,0 +1,123 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/jobs"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+var errUnknownBackend = errors.New("unknown archive backend")
+
+// Record is the archived state of a short right before it expires.
+type Record struct {
+	Short      string            `json:"short"`
+	URL        string            `json:"url"`
+	Meta       map[string]string `json:"meta,omitempty"`
+	Clicks     int64             `json:"clicks"`
+	ExpiresAt  int64             `json:"expires_at"`
+	ArchivedAt int64             `json:"archived_at"`
+}
+
+// Archiver persists records outside of redis.
+type Archiver interface {
+	Archive(ctx context.Context, records []Record) error
+	Close() error
+}
+
+// FromEnv returns the archiver selected by ARCHIVE_BACKEND, or nil when
+// archival is disabled.
+func FromEnv() (Archiver, error) {
+	switch os.Getenv("ARCHIVE_BACKEND") {
+	case "":
+		return nil, nil
+	case "file":
+		return NewFileArchiver(os.Getenv("ARCHIVE_FILE"))
+	case "s3":
+		return &S3Archiver{
+			Endpoint:  os.Getenv("ARCHIVE_S3_ENDPOINT"),
+			Region:    os.Getenv("ARCHIVE_S3_REGION"),
+			Bucket:    os.Getenv("ARCHIVE_S3_BUCKET"),
+			Prefix:    os.Getenv("ARCHIVE_S3_PREFIX"),
+			AccessKey: os.Getenv("ARCHIVE_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("ARCHIVE_S3_SECRET_KEY"),
+		}, nil
+	}
+
+	return nil, errUnknownBackend
+}
+
+// Job returns the scheduled job archiving shorts expiring within lookahead.
+// Each expiry is archived once; an extended short is archived again when
+// it eventually expires.
+func Job(archiver Archiver, lookahead time.Duration) jobs.Func {
+	return func(ctx context.Context, rClient database.ClientInterface) error {
+		now := clock.Now()
+
+		var entries []string
+		err := rClient.Do(radix.Cmd(&entries, "ZRANGEBYSCORE", links.ExpiringKey(),
+			strconv.FormatInt(now.Unix(), 10), strconv.FormatInt(now.Add(lookahead).Unix(), 10), "WITHSCORES"))
+		if err != nil {
+			return err
+		}
+
+		var records []Record
+		for i := 0; i+1 < len(entries); i += 2 {
+			record, err := load(rClient, entries[i], entries[i+1], now)
+			if err != nil {
+				return err
+			}
+			if record != nil {
+				records = append(records, *record)
+			}
+		}
+
+		if len(records) == 0 {
+			return nil
+		}
+
+		if err := archiver.Archive(ctx, records); err != nil {
+			return err
+		}
+
+		p := radix.NewPipeline()
+		for _, record := range records {
+			p.Append(links.WriteCmd(record.Short, []string{"archived_for", strconv.FormatInt(record.ExpiresAt, 10)}, nil))
+		}
+
+		return rClient.Do(p)
+	}
+}
+
+func load(rClient database.ClientInterface, short, expiresAt string, now time.Time) (*Record, error) {
+	meta, err := links.Load(rClient, short)
+	if err != nil || meta == nil || meta["archived_for"] == expiresAt {
+		return nil, err
+	}
+	url := meta["url"]
+	delete(meta, "url")
+	delete(meta, "archived_for")
+
+	var clicks int64
+	if err := rClient.Do(links.ClicksCmd(&clicks, short)); err != nil {
+		return nil, err
+	}
+
+	expires, _ := strconv.ParseInt(expiresAt, 10, 64)
+
+	return &Record{
+		Short:      short,
+		URL:        url,
+		Meta:       meta,
+		Clicks:     clicks,
+		ExpiresAt:  expires,
+		ArchivedAt: now.Unix(),
+	}, nil
+}
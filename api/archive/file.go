@@ -0,0 +1,45 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileArchiver appends records as NDJSON to a local file.
+type FileArchiver struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileArchiver opens path for appending, creating it if needed.
+func NewFileArchiver(path string) (*FileArchiver, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive %s, err: %w", path, err)
+	}
+
+	return &FileArchiver{f: f}, nil
+}
+
+// Archive implements Archiver.
+func (a *FileArchiver) Archive(_ context.Context, records []Record) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	enc := json.NewEncoder(a.f)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return fmt.Errorf("failed to write archive, err: %w", err)
+		}
+	}
+
+	return a.f.Sync()
+}
+
+// Close implements Archiver.
+func (a *FileArchiver) Close() error {
+	return a.f.Close()
+}
@@ -0,0 +1,130 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Archiver uploads each batch of records as an NDJSON object to an
+// S3-compatible bucket, addressed path-style so MinIO and friends work.
+type S3Archiver struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+
+	// Client sends the uploads, defaultClient when nil.
+	Client *http.Client
+}
+
+// defaultClient bounds an upload, a stalled one would hold back the
+// archive job.
+var defaultClient = &http.Client{Timeout: time.Minute}
+
+// Archive implements Archiver.
+func (a *S3Archiver) Archive(ctx context.Context, records []Record) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC()
+	key := a.Prefix + now.Format("2006/01/02/") + strconv.FormatInt(now.UnixNano(), 10) + ".ndjson"
+
+	endpoint, err := url.Parse(a.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid S3 endpoint %s, err: %w", a.Endpoint, err)
+	}
+	endpoint.Path = "/" + a.Bucket + "/" + key
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint.String(), bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	a.sign(req, body.Bytes(), now)
+
+	client := a.Client
+	if client == nil {
+		client = defaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s, err: %w", key, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to upload %s, status: %s", key, resp.Status)
+	}
+
+	return nil
+}
+
+// Close implements Archiver.
+func (a *S3Archiver) Close() error {
+	return nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req.
+func (a *S3Archiver) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + a.Region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+a.SecretKey), day)
+	key = hmacSHA256(key, a.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+a.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,265 @@
+package backup
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Version is the snapshot format written by Backup.
+const Version = 1
+
+// Conflict policies applied by Restore to keys that already exist.
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictFail      = "fail"
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported snapshot version")
+	ErrConflict           = errors.New("key already exists")
+	errUnknownPolicy      = errors.New("unknown conflict policy")
+)
+
+// Header is the first line of a snapshot.
+type Header struct {
+	Version   int   `json:"version"`
+	CreatedAt int64 `json:"created_at"`
+}
+
+// Entry is one key of a snapshot. Value holds a string for strings, a map
+// for hashes, a list of members for sets and lists, and member/score pairs
+// for sorted sets.
+type Entry struct {
+	Key   string          `json:"key"`
+	Type  string          `json:"type"`
+	TTL   int64           `json:"ttl_ms,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Stats summarises a backup or restore.
+type Stats struct {
+	Keys    int `json:"keys"`
+	Skipped int `json:"skipped"`
+}
+
+// ephemeral keys are coordination state that must not be restored.
+var ephemeral = []string{"lock:job:", "throttle:", "ratelimit:", "quota:", "missing:", "preview:", "extend:", "links:filter", "top:p:", "report:cache:", "integration:slack:connect:"}
+
+// Backup writes a logical snapshot of every shortener key to w as NDJSON:
+// a Header line followed by one Entry per key. Unlike RDB dumps the format
+// does not depend on the redis version.
+func Backup(rClient database.ClientInterface, w io.Writer) (Stats, error) {
+	var stats Stats
+	enc := json.NewEncoder(w)
+
+	if err := enc.Encode(Header{Version: Version, CreatedAt: time.Now().Unix()}); err != nil {
+		return stats, err
+	}
+
+	err := database.Scan(rClient, "*", func(key string) error {
+		for _, prefix := range ephemeral {
+			if strings.HasPrefix(key, prefix) {
+				stats.Skipped++
+				return nil
+			}
+		}
+
+		entry, err := read(rClient, key)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			// Expired or unsupported type.
+			stats.Skipped++
+			return nil
+		}
+
+		stats.Keys++
+
+		return enc.Encode(entry)
+	})
+
+	return stats, err
+}
+
+// Restore loads a snapshot written by Backup, resolving existing keys with
+// the given conflict policy. Shorts restored behind the back of the bloom
+// filter have it rebuilt.
+func Restore(rClient database.ClientInterface, r io.Reader, policy string) (Stats, error) {
+	var stats Stats
+
+	switch policy {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+	default:
+		return stats, errUnknownPolicy
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	if !scanner.Scan() {
+		return stats, fmt.Errorf("empty snapshot: %w", scanner.Err())
+	}
+
+	var header Header
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return stats, fmt.Errorf("invalid snapshot header, err: %w", err)
+	}
+	if header.Version != Version {
+		return stats, fmt.Errorf("%w %d", ErrUnsupportedVersion, header.Version)
+	}
+
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return stats, fmt.Errorf("invalid snapshot entry, err: %w", err)
+		}
+
+		var exists int
+		if err := rClient.Do(radix.Cmd(&exists, "EXISTS", entry.Key)); err != nil {
+			return stats, err
+		}
+		if exists == 1 {
+			switch policy {
+			case ConflictSkip:
+				stats.Skipped++
+				continue
+			case ConflictFail:
+				return stats, fmt.Errorf("%w: %s", ErrConflict, entry.Key)
+			}
+		}
+
+		if err := write(rClient, entry); err != nil {
+			return stats, fmt.Errorf("failed to restore %s, err: %w", entry.Key, err)
+		}
+		stats.Keys++
+	}
+	if err := scanner.Err(); err != nil {
+		return stats, err
+	}
+
+	if stats.Keys > 0 {
+		return stats, rClient.Do(radix.Cmd(nil, "DEL", "links:filter:ready"))
+	}
+
+	return stats, nil
+}
+
+func read(rClient database.ClientInterface, key string) (*Entry, error) {
+	var kind string
+	var ttl int64
+
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(&kind, "TYPE", key))
+	p.Append(radix.Cmd(&ttl, "PTTL", key))
+	if err := rClient.Do(p); err != nil {
+		return nil, err
+	}
+
+	var value any
+	var cmd radix.Action
+	switch kind {
+	case "string":
+		var v string
+		value, cmd = &v, radix.Cmd(&v, "GET", key)
+	case "hash":
+		var v map[string]string
+		value, cmd = &v, radix.Cmd(&v, "HGETALL", key)
+	case "set":
+		var v []string
+		value, cmd = &v, radix.Cmd(&v, "SMEMBERS", key)
+	case "list":
+		var v []string
+		value, cmd = &v, radix.Cmd(&v, "LRANGE", key, "0", "-1")
+	case "zset":
+		var v []string
+		value, cmd = &v, radix.Cmd(&v, "ZRANGE", key, "0", "-1", "WITHSCORES")
+	default:
+		return nil, nil
+	}
+
+	if err := rClient.Do(cmd); err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &Entry{Key: key, Type: kind, Value: raw}
+	if ttl > 0 {
+		entry.TTL = ttl
+	}
+
+	return entry, nil
+}
+
+func write(rClient database.ClientInterface, entry Entry) error {
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "DEL", entry.Key))
+
+	switch entry.Type {
+	case "string":
+		var v string
+		if err := json.Unmarshal(entry.Value, &v); err != nil {
+			return err
+		}
+		p.Append(radix.Cmd(nil, "SET", entry.Key, v))
+	case "hash":
+		var v map[string]string
+		if err := json.Unmarshal(entry.Value, &v); err != nil {
+			return err
+		}
+		args := []string{entry.Key}
+		for field, value := range v {
+			args = append(args, field, value)
+		}
+		if len(args) > 1 {
+			p.Append(radix.Cmd(nil, "HSET", args...))
+		}
+	case "set", "list", "zset":
+		var v []string
+		if err := json.Unmarshal(entry.Value, &v); err != nil {
+			return err
+		}
+		if len(v) > 0 {
+			p.Append(collectionCmd(entry.Type, entry.Key, v))
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", entry.Type)
+	}
+
+	if entry.TTL > 0 {
+		p.Append(radix.Cmd(nil, "PEXPIRE", entry.Key, strconv.FormatInt(entry.TTL, 10)))
+	}
+
+	return rClient.Do(p)
+}
+
+func collectionCmd(kind, key string, values []string) radix.Action {
+	switch kind {
+	case "set":
+		return radix.Cmd(nil, "SADD", append([]string{key}, values...)...)
+	case "list":
+		return radix.Cmd(nil, "RPUSH", append([]string{key}, values...)...)
+	}
+
+	// ZRANGE WITHSCORES yields member, score pairs, ZADD wants score, member.
+	args := []string{key}
+	for i := 0; i+1 < len(values); i += 2 {
+		args = append(args, values[i+1], values[i])
+	}
+
+	return radix.Cmd(nil, "ZADD", args...)
+}
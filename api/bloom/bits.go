@@ -0,0 +1,63 @@
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// bits is an in-memory bloom filter.
+type bits struct {
+	mu     sync.RWMutex
+	words  []uint64
+	hashes uint64
+}
+
+// newBits sizes a filter for capacity items at errorRate.
+func newBits(capacity int, errorRate float64) *bits {
+	n := float64(max(capacity, 1))
+	m := math.Ceil(-n * math.Log(errorRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/n*math.Ln2))
+
+	return &bits{words: make([]uint64, int(m)/64+1), hashes: uint64(k)}
+}
+
+// positions yields the bits of s, by double hashing the two halves of its
+// FNV-1a hash.
+func (b *bits) positions(s string, fn func(word int, mask uint64) bool) {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+
+	size := uint64(len(b.words)) * 64
+	for i := uint64(0); i < b.hashes; i++ {
+		pos := (h1 + i*h2) % size
+		if !fn(int(pos/64), 1<<(pos%64)) {
+			return
+		}
+	}
+}
+
+func (b *bits) add(s string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.positions(s, func(word int, mask uint64) bool {
+		b.words[word] |= mask
+		return true
+	})
+}
+
+func (b *bits) contains(s string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	found := true
+	b.positions(s, func(word int, mask uint64) bool {
+		found = b.words[word]&mask != 0
+		return found
+	})
+
+	return found
+}
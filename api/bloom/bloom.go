@@ -0,0 +1,369 @@
+package bloom
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/jobs"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/metrics"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Channel announces new shorts to the in-memory filters of every instance.
+const Channel = "bloom:added"
+
+// Modes of the filter.
+const (
+	ModeOff    = ""
+	ModeRedis  = "redis"
+	ModeMemory = "memory"
+)
+
+var rejected = metrics.NewCounter("bloom_rejections_total", "Lookups of shorts the bloom filter ruled out.")
+
+// Config controls the filter.
+type Config struct {
+	Mode      string
+	ErrorRate float64
+	Capacity  int
+	// Rebuild is how often an in-memory filter is rebuilt, dropping deleted
+	// shorts and catching up with announcements missed while reconnecting.
+	Rebuild time.Duration
+}
+
+// ConfigFromEnv reads the BLOOM_* environment variables.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Mode:      os.Getenv("BLOOM_FILTER"),
+		ErrorRate: 0.01,
+		Capacity:  1000000,
+		Rebuild:   10 * time.Minute,
+	}
+
+	if v, err := strconv.ParseFloat(os.Getenv("BLOOM_ERROR_RATE"), 64); err == nil && v > 0 && v < 1 {
+		cfg.ErrorRate = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("BLOOM_CAPACITY")); err == nil && v > 0 {
+		cfg.Capacity = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("BLOOM_REBUILD_INTERVAL")); err == nil && v > 0 {
+		cfg.Rebuild = v
+	}
+
+	return cfg
+}
+
+// Filter tells shorts that certainly don't exist from those that may. It
+// answers "may exist" until it is ready, and whenever it can't tell.
+//
+// With RedisBloom the filter is shared by every instance and built once.
+// Without the module each instance keeps its own, rebuilt from SCAN and
+// kept current through Channel.
+type Filter struct {
+	mode  atomic.Value
+	ready atomic.Bool
+	local atomic.Pointer[bits]
+
+	// pending collects the shorts added while an in-memory rebuild scans.
+	mu      sync.Mutex
+	pending []string
+}
+
+// Default is the filter consulted by the routes.
+var Default = &Filter{}
+
+func (f *Filter) currentMode() string {
+	mode, _ := f.mode.Load().(string)
+	return mode
+}
+
+// MightContain reports whether short may exist. Errors count as a yes.
+func (f *Filter) MightContain(rClient database.ClientInterface, short string) bool {
+	if !f.ready.Load() {
+		return true
+	}
+
+	found := true
+	switch f.currentMode() {
+	case ModeRedis:
+		var exists int
+		if err := rClient.Do(radix.Cmd(&exists, "BF.EXISTS", links.FilterKey(), short)); err == nil {
+			found = exists == 1
+		}
+	case ModeMemory:
+		found = f.local.Load().contains(short)
+	}
+
+	if !found {
+		rejected.Inc()
+	}
+
+	return found
+}
+
+// MightContainAll is MightContain for many shorts, in one round trip.
+func (f *Filter) MightContainAll(rClient database.ClientInterface, shorts []string) []bool {
+	found := make([]bool, len(shorts))
+	for i := range found {
+		found[i] = true
+	}
+	if !f.ready.Load() || len(shorts) == 0 {
+		return found
+	}
+
+	switch f.currentMode() {
+	case ModeRedis:
+		var exists []int
+		err := rClient.Do(radix.Cmd(&exists, "BF.MEXISTS", append([]string{links.FilterKey()}, shorts...)...))
+		if err != nil || len(exists) != len(shorts) {
+			return found
+		}
+		for i := range found {
+			found[i] = exists[i] == 1
+		}
+	case ModeMemory:
+		b := f.local.Load()
+		for i, short := range shorts {
+			found[i] = b.contains(short)
+		}
+	}
+
+	for _, ok := range found {
+		if !ok {
+			rejected.Inc()
+		}
+	}
+
+	return found
+}
+
+// Authoritative reports whether a "no" of MightContain holds across
+// instances right away, so that writers can skip their own existence
+// checks. Only the shared RedisBloom filter is.
+func (f *Filter) Authoritative() bool {
+	return f.ready.Load() && f.currentMode() == ModeRedis
+}
+
+// Add records a new short, before it can be looked up.
+func (f *Filter) Add(rClient database.ClientInterface, short string) error {
+	switch f.currentMode() {
+	case ModeRedis:
+		return rClient.Do(radix.Cmd(nil, "BF.ADD", links.FilterKey(), short))
+	case ModeMemory:
+		f.addLocal(short)
+		return rClient.Do(radix.Cmd(nil, "PUBLISH", Channel, short))
+	}
+
+	return nil
+}
+
+func (f *Filter) addLocal(short string) {
+	f.mu.Lock()
+	if f.pending != nil {
+		f.pending = append(f.pending, short)
+	}
+	f.mu.Unlock()
+
+	if b := f.local.Load(); b != nil {
+		b.add(short)
+	}
+}
+
+// Start picks the mode of the filter, ModeRedis falling back to
+// ModeMemory when the module is not loaded, and prepares it in the
+// background, keeping it current until ctx is cancelled. Shorts created
+// from then on are added.
+func (f *Filter) Start(ctx context.Context, cfg Config) error {
+	if cfg.Mode == ModeOff {
+		return nil
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return err
+	}
+
+	mode := cfg.Mode
+	if mode == ModeRedis {
+		err := rClient.Do(radix.Cmd(nil, "BF.EXISTS", links.FilterKey(), ""))
+		if err != nil && strings.Contains(strings.ToLower(err.Error()), "unknown command") {
+			log.Printf("bloom: RedisBloom is not loaded, keeping the filter in memory")
+			mode = ModeMemory
+		}
+	}
+	f.mode.Store(mode)
+
+	go func() {
+		defer rClient.Close()
+
+		if mode == ModeRedis {
+			f.runRedis(ctx, rClient, cfg)
+			return
+		}
+		f.runMemory(ctx, rClient, cfg)
+	}()
+
+	return nil
+}
+
+// runRedis waits for the shared filter, building it when no other instance
+// is. Deleting links.FilterReadyKey, as restores do, has it rebuilt.
+func (f *Filter) runRedis(ctx context.Context, rClient database.ClientInterface, cfg Config) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		var ready string
+		err := rClient.Do(radix.Cmd(&ready, "GET", links.FilterReadyKey()))
+		if err == nil && ready == "" {
+			f.ready.Store(false)
+			err = buildRedis(ctx, rClient, cfg)
+		}
+		if err == nil {
+			f.ready.Store(true)
+		} else if !errors.Is(err, errBuilding) {
+			log.Printf("bloom: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+var errBuilding = errors.New("filter is being built by another instance")
+
+// buildRedis fills the shared filter from SCAN. Add writes to it during the
+// build already, so no short is missed.
+func buildRedis(ctx context.Context, rClient database.ClientInterface, cfg Config) error {
+	var locked string
+	err := rClient.Do(radix.Cmd(&locked, "SET", jobs.LockKey("bloom"), "1", "NX", "EX", "600"))
+	if err != nil {
+		return err
+	}
+	if locked != "OK" {
+		return errBuilding
+	}
+	defer rClient.Do(radix.Cmd(nil, "DEL", jobs.LockKey("bloom")))
+
+	err = rClient.Do(radix.Cmd(nil, "BF.RESERVE", links.FilterKey(),
+		strconv.FormatFloat(cfg.ErrorRate, 'f', -1, 64), strconv.Itoa(cfg.Capacity)))
+	if err != nil && !strings.Contains(err.Error(), "exists") {
+		return err
+	}
+
+	var batch []string
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := rClient.Do(radix.Cmd(nil, "BF.MADD", append([]string{links.FilterKey()}, batch...)...))
+		batch = batch[:0]
+		return err
+	}
+	err = scanShorts(ctx, rClient, func(short string) error {
+		batch = append(batch, short)
+		if len(batch) < 1000 {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	return rClient.Do(radix.Cmd(nil, "SET", links.FilterReadyKey(), "1"))
+}
+
+// runMemory builds the local filter, then follows Channel and rebuilds it
+// every cfg.Rebuild.
+func (f *Filter) runMemory(ctx context.Context, rClient database.ClientInterface, cfg Config) {
+	rebuild := func() {
+		if err := f.buildMemory(ctx, rClient, cfg); err != nil {
+			log.Printf("bloom: %v", err)
+		}
+	}
+
+	go func() {
+		for ctx.Err() == nil {
+			err := database.Subscribe(ctx, Channel, func(short []byte) { f.addLocal(string(short)) })
+			if err != nil {
+				log.Printf("bloom: %v", err)
+				time.Sleep(time.Second)
+			}
+		}
+	}()
+	rebuild()
+
+	ticker := time.NewTicker(cfg.Rebuild)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rebuild()
+		}
+	}
+}
+
+func (f *Filter) buildMemory(ctx context.Context, rClient database.ClientInterface, cfg Config) error {
+	f.mu.Lock()
+	f.pending = []string{}
+	f.mu.Unlock()
+
+	var shorts []string
+	err := scanShorts(ctx, rClient, func(short string) error {
+		shorts = append(shorts, short)
+		return nil
+	})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pending := f.pending
+	f.pending = nil
+	if err != nil {
+		return err
+	}
+
+	b := newBits(max(cfg.Capacity, 2*len(shorts)), cfg.ErrorRate)
+	for _, short := range append(shorts, pending...) {
+		b.add(short)
+	}
+	f.local.Store(b)
+	f.ready.Store(true)
+
+	return nil
+}
+
+// scanShorts calls fn with every short, in either key layout.
+func scanShorts(ctx context.Context, rClient database.ClientInterface, fn func(short string) error) error {
+	return database.Scan(rClient, "*", func(key string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if short, ok := links.ShortFromKey(key); ok {
+			return fn(short)
+		}
+		if links.IsLegacyKey(key) {
+			return fn(key)
+		}
+
+		return nil
+	})
+}
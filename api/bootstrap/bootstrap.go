@@ -0,0 +1,121 @@
+package bootstrap
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Search index states reported by Run.
+const (
+	SearchCreated     = "created"
+	SearchExists      = "exists"
+	SearchUnavailable = "unavailable"
+)
+
+// Reserved lists the words seeded into links.ReservedKey, the first
+// segments of the routes registered next to "/:url".
+var Reserved = []string{
+	"admin", "api", "health", "metrics", "robots.txt", "sitemaps",
+	"favicon.ico", ".well-known", "static", "auth", "dashboard",
+}
+
+// Defaults lists the fields seeded into links.DefaultsKey.
+var Defaults = map[string]string{
+	"expiry_hours": "24",
+}
+
+var counters = []string{"created", "clicks", "deleted"}
+
+// Report describes what Run found missing and created.
+type Report struct {
+	Counters int    `json:"counters"`
+	Reserved int    `json:"reserved"`
+	Defaults int    `json:"defaults"`
+	Search   string `json:"search"`
+}
+
+// Run creates the redis structures the service expects, leaving existing
+// values alone so it is safe to run on every start and from several
+// instances at once.
+func Run(ctx context.Context, rClient database.ClientInterface) (Report, error) {
+	var report Report
+
+	p := radix.NewPipeline()
+	created := make([]int, len(counters))
+	for i, field := range counters {
+		p.Append(radix.Cmd(&created[i], "HSETNX", links.CountersKey(), field, "0"))
+	}
+	p.Append(radix.Cmd(&report.Reserved, "SADD", append([]string{links.ReservedKey()}, Reserved...)...))
+	defaults := make([]int, len(Defaults))
+	i := 0
+	for field, value := range Defaults {
+		p.Append(radix.Cmd(&defaults[i], "HSETNX", links.DefaultsKey(), field, value))
+		i++
+	}
+	p.Append(radix.Cmd(nil, "HSETNX", links.DefaultsKey(), "bootstrapped_at", strconv.FormatInt(time.Now().Unix(), 10)))
+	if err := rClient.Do(p); err != nil {
+		return report, err
+	}
+
+	for _, n := range created {
+		report.Counters += n
+	}
+	for _, n := range defaults {
+		report.Defaults += n
+	}
+
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+
+	search, err := createSearchIndex(rClient)
+	report.Search = search
+
+	return report, err
+}
+
+// createSearchIndex creates links.SearchIndex over the v2 link hashes,
+// unless RediSearch is not loaded or the index already exists.
+func createSearchIndex(rClient database.ClientInterface) (string, error) {
+	var indexes []string
+	if err := rClient.Do(radix.Cmd(&indexes, "FT._LIST")); err != nil {
+		if unknownCommand(err) {
+			return SearchUnavailable, nil
+		}
+		return "", err
+	}
+	for _, index := range indexes {
+		if index == links.SearchIndex {
+			return SearchExists, nil
+		}
+	}
+
+	err := rClient.Do(radix.Cmd(nil, "FT.CREATE", links.SearchIndex,
+		"ON", "HASH", "PREFIX", "1", links.MetaKey(""),
+		"SCHEMA",
+		"url", "TEXT",
+		"title", "TEXT",
+		"description", "TEXT",
+		"owner", "TAG",
+		"campaign", "TAG",
+		"created_at", "NUMERIC", "SORTABLE"))
+	// Another instance won the race.
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "already exists") {
+		return SearchExists, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return SearchCreated, nil
+}
+
+func unknownCommand(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "unknown command")
+}
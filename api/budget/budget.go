@@ -0,0 +1,212 @@
+package budget
+
+import (
+	"context"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ksarpe/redis-golang/metrics"
+)
+
+// windowSize bounds the samples kept per window, older ones are overwritten.
+const windowSize = 1024
+
+var (
+	shedding = metrics.NewGauge("latency_shedding", "1 while non-critical work is shed because redis is over its latency budget.")
+	redisP99 = metrics.NewGauge("redis_latency_p99_seconds", "Rolling p99 of redis commands on the shared pool.")
+	sheds    = metrics.NewCounter("latency_shed_total", "Times shedding of non-critical work started.")
+)
+
+// Config sets the latency budget of redis. A zero budget never sheds.
+type Config struct {
+	Redis  time.Duration
+	Window time.Duration
+}
+
+// ConfigFromEnv reads LATENCY_BUDGET_REDIS and LATENCY_WINDOW.
+func ConfigFromEnv() Config {
+	cfg := Config{Window: 10 * time.Second}
+	if d, err := time.ParseDuration(os.Getenv("LATENCY_BUDGET_REDIS")); err == nil && d > 0 {
+		cfg.Redis = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("LATENCY_WINDOW")); err == nil && d > 0 {
+		cfg.Window = d
+	}
+
+	return cfg
+}
+
+type sample struct {
+	at time.Time
+	d  time.Duration
+}
+
+// Window keeps the latest latency samples to compute rolling quantiles.
+type Window struct {
+	mu      sync.Mutex
+	samples [windowSize]sample
+	next    int
+}
+
+// Observe records a sample.
+func (w *Window) Observe(d time.Duration) {
+	w.mu.Lock()
+	w.samples[w.next%windowSize] = sample{at: time.Now(), d: d}
+	w.next++
+	w.mu.Unlock()
+}
+
+// Quantile returns the q quantile of the samples taken within the last
+// span, and how many there were.
+func (w *Window) Quantile(q float64, span time.Duration) (time.Duration, int) {
+	since := time.Now().Add(-span)
+
+	w.mu.Lock()
+	ds := make([]time.Duration, 0, min(w.next, windowSize))
+	for _, s := range w.samples[:min(w.next, windowSize)] {
+		if s.at.After(since) {
+			ds = append(ds, s.d)
+		}
+	}
+	w.mu.Unlock()
+
+	if len(ds) == 0 {
+		return 0, 0
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+
+	return ds[int(q*float64(len(ds)-1))], len(ds)
+}
+
+// Tracker follows the latency of redis and of every route, and decides
+// when to shed non-critical work.
+type Tracker struct {
+	cfg      Config
+	redis    Window
+	mu       sync.Mutex
+	routes   map[string]*Window
+	shedding atomic.Bool
+}
+
+// Default is the tracker of the process, fed by the shared redis client
+// and the latency middleware.
+var Default = &Tracker{routes: map[string]*Window{}}
+
+// ObserveRedis records the duration of a redis action.
+func (t *Tracker) ObserveRedis(d time.Duration) {
+	t.redis.Observe(d)
+}
+
+// ObserveRoute records the duration of a request to route.
+func (t *Tracker) ObserveRoute(route string, d time.Duration) {
+	t.mu.Lock()
+	w, ok := t.routes[route]
+	if !ok {
+		w = new(Window)
+		t.routes[route] = w
+	}
+	t.mu.Unlock()
+
+	w.Observe(d)
+}
+
+// Shedding reports whether non-critical work, analytics writes and
+// previews, should be skipped to keep redirects fast.
+func (t *Tracker) Shedding() bool {
+	return t.shedding.Load()
+}
+
+// Run checks the rolling p99 of redis against the budget every second
+// until ctx is cancelled. Shedding starts as soon as the p99 is over
+// budget and stops once it is back under half of it, so that the load
+// freed by shedding doesn't make it flap.
+func (t *Tracker) Run(ctx context.Context, cfg Config) {
+	t.mu.Lock()
+	t.cfg = cfg
+	t.mu.Unlock()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		p99, _ := t.redis.Quantile(0.99, cfg.Window)
+		redisP99.Set(p99.Seconds())
+		if cfg.Redis == 0 {
+			continue
+		}
+
+		switch {
+		case p99 > cfg.Redis && !t.shedding.Load():
+			t.shedding.Store(true)
+			shedding.Set(1)
+			sheds.Inc()
+			log.Printf("budget: redis p99 %s over %s, shedding non-critical work", p99, cfg.Redis)
+		case p99 < cfg.Redis/2 && t.shedding.Load():
+			t.shedding.Store(false)
+			shedding.Set(0)
+			log.Printf("budget: redis p99 %s back under budget, no longer shedding", p99)
+		}
+	}
+}
+
+// RouteLatency is the rolling p99 of a route.
+type RouteLatency struct {
+	Route    string  `json:"route"`
+	P99      float64 `json:"p99_ms"`
+	Requests int     `json:"requests"`
+}
+
+// Snapshot is the state of a tracker.
+type Snapshot struct {
+	Shedding bool           `json:"shedding"`
+	Budget   float64        `json:"redis_budget_ms"`
+	RedisP99 float64        `json:"redis_p99_ms"`
+	Routes   []RouteLatency `json:"routes"`
+}
+
+// Snapshot returns the rolling p99 of redis and of every route seen within
+// the window, slowest first.
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	cfg := t.cfg
+	routes := make(map[string]*Window, len(t.routes))
+	for route, w := range t.routes {
+		routes[route] = w
+	}
+	t.mu.Unlock()
+
+	if cfg.Window == 0 {
+		cfg.Window = ConfigFromEnv().Window
+	}
+
+	p99, _ := t.redis.Quantile(0.99, cfg.Window)
+	s := Snapshot{
+		Shedding: t.Shedding(),
+		Budget:   milliseconds(cfg.Redis),
+		RedisP99: milliseconds(p99),
+		Routes:   []RouteLatency{},
+	}
+	for route, w := range routes {
+		p99, n := w.Quantile(0.99, cfg.Window)
+		if n > 0 {
+			s.Routes = append(s.Routes, RouteLatency{Route: route, P99: milliseconds(p99), Requests: n})
+		}
+	}
+	sort.Slice(s.Routes, func(i, j int) bool { return s.Routes[i].P99 > s.Routes[j].P99 })
+
+	return s
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,154 @@
+package card
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Size of a card, the aspect ratio chat tools and social networks expect
+// of a preview image.
+const (
+	Width  = 1200
+	Height = 630
+)
+
+// DefaultAccent colors the edge of cards without a brand color.
+var DefaultAccent = color.RGBA{0x1a, 0x73, 0xe8, 0xff}
+
+// Card is what the preview card of a short shows.
+type Card struct {
+	// Short is the short code, printed large.
+	Short string
+	// Domain is the host of the destination, left out when empty.
+	Domain string
+	// URL is the short URL, printed small and encoded in the QR code.
+	URL    string
+	Accent color.Color
+}
+
+// Palette indexes.
+const (
+	background = iota
+	ink
+	muted
+	accent
+)
+
+const margin = 60
+
+// Render draws c as a PNG.
+func Render(c Card) ([]byte, error) {
+	qr, err := EncodeQR([]byte(c.URL))
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Accent == nil {
+		c.Accent = DefaultAccent
+	}
+	palette := color.Palette{
+		color.White,
+		color.RGBA{0x20, 0x21, 0x24, 0xff},
+		color.RGBA{0x5f, 0x63, 0x68, 0xff},
+		c.Accent,
+	}
+	img := image.NewPaletted(image.Rect(0, 0, Width, Height), palette)
+
+	fill(img, image.Rect(0, 0, 24, Height), accent)
+
+	// The QR code keeps the quiet zone of four modules it needs to scan.
+	module := (Height - 2*margin) / (qr.Size() + 8)
+	side := module * (qr.Size() + 8)
+	qrX, qrY := Width-margin-side, (Height-side)/2
+	for y := 0; y < qr.Size(); y++ {
+		for x := 0; x < qr.Size(); x++ {
+			if qr.Dark(x, y) {
+				px, py := qrX+(x+4)*module, qrY+(y+4)*module
+				fill(img, image.Rect(px, py, px+module, py+module), ink)
+			}
+		}
+	}
+
+	left, width := margin+40, qrX-margin-40
+	y := 160
+	y += drawText(img, c.Short, left, y, width, 14, ink) + 40
+	if c.Domain != "" {
+		drawText(img, c.Domain, left, y, width, 6, muted)
+	}
+	drawText(img, c.URL, left, Height-margin-4*glyphHeight, width, 4, muted)
+
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := enc.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// drawText draws s with its top left corner at x, y, as large as fits in
+// width up to maxScale, shortening it when it doesn't fit at scale 2. It
+// returns the height drawn.
+func drawText(img *image.Paletted, s string, x, y, width, maxScale int, index uint8) int {
+	n := utf8.RuneCountInString(s)
+	if n == 0 {
+		return 0
+	}
+
+	scale := min(maxScale, width/(advance*n))
+	if scale < 2 {
+		scale = 2
+		keep := width/(advance*scale) - 3
+		s = string([]rune(s)[:max(keep, 0)]) + "..."
+	}
+
+	for _, r := range s {
+		g := glyph(r)
+		for col, bits := range g {
+			for row := 0; row < glyphHeight; row++ {
+				if bits>>row&1 == 1 {
+					px, py := x+col*scale, y+row*scale
+					fill(img, image.Rect(px, py, px+scale, py+scale), index)
+				}
+			}
+		}
+		x += advance * scale
+	}
+
+	return glyphHeight * scale
+}
+
+func fill(img *image.Paletted, r image.Rectangle, index uint8) {
+	r = r.Intersect(img.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetColorIndex(x, y, index)
+		}
+	}
+}
+
+// ParseColor parses a "#rgb" or "#rrggbb" brand color.
+func ParseColor(s string) (color.Color, bool) {
+	hex, ok := strings.CutPrefix(s, "#")
+	if !ok {
+		return nil, false
+	}
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return nil, false
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, false
+	}
+
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, true
+}
@@ -0,0 +1,450 @@
+package card
+
+import "errors"
+
+// ErrTooLong is returned for content beyond the largest supported QR code.
+var ErrTooLong = errors.New("content too long for a QR code")
+
+// qrVersion describes the blocks of a QR version at error correction
+// level M, the only level used: it survives a scuffed screenshot while
+// keeping codes small.
+type qrVersion struct {
+	ecPerBlock int
+	// blocks lists the number of data codewords of each block.
+	blocks    []int
+	alignment []int
+}
+
+// qrVersions holds versions 1 to 10, enough for 200 bytes, far above
+// the length of a short URL.
+var qrVersions = []qrVersion{
+	{10, []int{16}, nil},
+	{16, []int{28}, []int{6, 18}},
+	{26, []int{44}, []int{6, 22}},
+	{18, []int{32, 32}, []int{6, 26}},
+	{24, []int{43, 43}, []int{6, 30}},
+	{16, []int{27, 27, 27, 27}, []int{6, 34}},
+	{18, []int{31, 31, 31, 31}, []int{6, 22, 38}},
+	{22, []int{38, 38, 39, 39}, []int{6, 24, 42}},
+	{22, []int{36, 36, 36, 37, 37}, []int{6, 26, 46}},
+	{26, []int{43, 43, 43, 43, 44}, []int{6, 28, 50}},
+}
+
+func (v qrVersion) dataCodewords() int {
+	n := 0
+	for _, b := range v.blocks {
+		n += b
+	}
+
+	return n
+}
+
+// QR is a QR code as a square of modules, true being dark.
+type QR struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// Size returns the number of modules per side, without quiet zone.
+func (q *QR) Size() int {
+	return q.size
+}
+
+// Dark reports whether the module at column x and row y is dark.
+func (q *QR) Dark(x, y int) bool {
+	return q.modules[y][x]
+}
+
+// EncodeQR encodes data in byte mode into the smallest QR code holding it.
+func EncodeQR(data []byte) (*QR, error) {
+	number := 0
+	for i, v := range qrVersions {
+		countBits := 8
+		if i+1 >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*v.dataCodewords() {
+			number = i + 1
+			break
+		}
+	}
+	if number == 0 {
+		return nil, ErrTooLong
+	}
+	v := qrVersions[number-1]
+
+	q := &QR{size: 17 + 4*number}
+	q.modules = make([][]bool, q.size)
+	q.function = make([][]bool, q.size)
+	for i := range q.modules {
+		q.modules[i] = make([]bool, q.size)
+		q.function[i] = make([]bool, q.size)
+	}
+
+	q.drawFunctionPatterns(number, v)
+	q.drawCodewords(interleave(v, encodeData(number, v, data)))
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormat(mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormat(best)
+
+	return q, nil
+}
+
+// encodeData returns the data codewords: mode, length, bytes, terminator
+// and padding.
+func encodeData(number int, v qrVersion, data []byte) []byte {
+	var bits []bool
+	put := func(value, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, value>>i&1 == 1)
+		}
+	}
+
+	countBits := 8
+	if number >= 10 {
+		countBits = 16
+	}
+	put(0b0100, 4)
+	put(len(data), countBits)
+	for _, b := range data {
+		put(int(b), 8)
+	}
+
+	capacity := 8 * v.dataCodewords()
+	put(0, min(4, capacity-len(bits)))
+	put(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		put(pad, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i/8] |= 1 << (7 - i%8)
+		}
+	}
+
+	return codewords
+}
+
+// interleave splits the data into its blocks, appends their error
+// correction and interleaves the codewords of every block.
+func interleave(v qrVersion, data []byte) []byte {
+	generator := rsGenerator(v.ecPerBlock)
+
+	var blocks, ecs [][]byte
+	longest := 0
+	for _, n := range v.blocks {
+		blocks = append(blocks, data[:n])
+		ecs = append(ecs, rsRemainder(data[:n], generator))
+		data = data[n:]
+		longest = max(longest, n)
+	}
+
+	var out []byte
+	for i := 0; i < longest; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := 0; i < v.ecPerBlock; i++ {
+		for _, ec := range ecs {
+			out = append(out, ec[i])
+		}
+	}
+
+	return out
+}
+
+func (q *QR) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+func (q *QR) drawFunctionPatterns(number int, v qrVersion) {
+	for i := 0; i < q.size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+
+	q.drawFinder(3, 3)
+	q.drawFinder(q.size-4, 3)
+	q.drawFinder(3, q.size-4)
+
+	last := len(v.alignment) - 1
+	for i, x := range v.alignment {
+		for j, y := range v.alignment {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserves the format areas, drawn for real once the mask is chosen.
+	q.drawFormat(0)
+
+	if number >= 7 {
+		rem := number
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := number<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 == 1
+			a, b := q.size-11+i%3, i/3
+			q.set(a, b, dark)
+			q.set(b, a, dark)
+		}
+	}
+}
+
+// drawFinder draws a finder pattern centered on x, y with its separator.
+func (q *QR) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= q.size || yy < 0 || yy >= q.size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			q.set(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// drawFormat draws both copies of the format information, level M with
+// the given mask.
+func (q *QR) drawFormat(mask int) {
+	data := mask // level M is 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true)
+}
+
+// drawCodewords places the codewords in the zigzag order of the standard,
+// two columns at a time from the bottom right.
+func (q *QR) drawCodewords(codewords []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if q.function[y][x] || i >= 8*len(codewords) {
+					continue
+				}
+				q.modules[y][x] = codewords[i/8]>>(7-i%8)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// applyMask flips the data modules selected by mask, so applying it twice
+// undoes it.
+func (q *QR) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.function[y][x] {
+				continue
+			}
+
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			q.modules[y][x] = q.modules[y][x] != flip
+		}
+	}
+}
+
+// penalty scores how hard the symbol is to scan, following the four rules
+// of the standard used to pick a mask.
+func (q *QR) penalty() int {
+	score := 0
+	line := make([]bool, q.size)
+
+	for _, vertical := range []bool{false, true} {
+		for i := 0; i < q.size; i++ {
+			for j := range line {
+				if vertical {
+					line[j] = q.modules[j][i]
+				} else {
+					line[j] = q.modules[i][j]
+				}
+			}
+
+			run := 1
+			for j := 1; j <= q.size; j++ {
+				if j < q.size && line[j] == line[j-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+
+			for j := 0; j+7 <= q.size; j++ {
+				if !finderLike(line[j : j+7]) {
+					continue
+				}
+				if lightRun(line, j-4, j) || lightRun(line, j+7, j+11) {
+					score += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x+1 < q.size && y+1 < q.size {
+				c := q.modules[y][x]
+				if q.modules[y][x+1] == c && q.modules[y+1][x] == c && q.modules[y+1][x+1] == c {
+					score += 3
+				}
+			}
+		}
+	}
+	percent := dark * 100 / (q.size * q.size)
+	score += abs(percent-50) / 5 * 10
+
+	return score
+}
+
+// finderLike reports whether seven modules read dark, light, dark x3,
+// light, dark.
+func finderLike(m []bool) bool {
+	return m[0] && !m[1] && m[2] && m[3] && m[4] && !m[5] && m[6]
+}
+
+// lightRun reports whether the modules from i to j are light, the area
+// outside of the symbol counting as light.
+func lightRun(line []bool, i, j int) bool {
+	for ; i < j; i++ {
+		if i >= 0 && i < len(line) && line[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rsGenerator returns the Reed-Solomon generator polynomial of the given
+// degree over GF(256), highest coefficient first and the leading 1 left
+// out.
+func rsGenerator(degree int) []byte {
+	g := make([]byte, degree)
+	g[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range g {
+			g[j] = gfMul(g[j], root)
+			if j+1 < len(g) {
+				g[j] ^= g[j+1]
+			}
+		}
+		root = gfMul(root, 0x02)
+	}
+
+	return g
+}
+
+// rsRemainder returns the error correction codewords of data.
+func rsRemainder(data, generator []byte) []byte {
+	rem := make([]byte, len(generator))
+	for _, b := range data {
+		factor := b ^ rem[0]
+		copy(rem, rem[1:])
+		rem[len(rem)-1] = 0
+		for i, g := range generator {
+			rem[i] ^= gfMul(g, factor)
+		}
+	}
+
+	return rem
+}
+
+// gfMul multiplies in GF(256) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+
+	return byte(z)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
@@ -0,0 +1,218 @@
+package clicklog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/metrics"
+)
+
+var (
+	written = metrics.NewCounter("click_log_written_total", "Clicks written to the local click log.")
+	dropped = metrics.NewCounter("click_log_dropped_total", "Clicks dropped because the click log was behind or failing.")
+)
+
+// Entry is a click as written to the log, one JSON object per line. It
+// holds what the click analytics keep under the privacy policy of the
+// link.
+type Entry struct {
+	Short     string `json:"short"`
+	Timestamp int64  `json:"ts"`
+	Owner     string `json:"owner,omitempty"`
+	Campaign  string `json:"campaign,omitempty"`
+	Country   string `json:"country,omitempty"`
+	Referrer  string `json:"referrer,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// Config controls the click log.
+type Config struct {
+	// Dir is where the log files are written, the log is off without it.
+	Dir string
+	// MaxSize and MaxAge are the size and age at which a file is rotated.
+	MaxSize int64
+	MaxAge  time.Duration
+	// Buffer is the number of clicks waiting to be written before new ones
+	// are dropped.
+	Buffer int
+}
+
+// ConfigFromEnv reads CLICK_LOG_DIR, CLICK_LOG_MAX_SIZE in bytes, 64MB by
+// default, CLICK_LOG_ROTATE_INTERVAL, an hour by default, and
+// CLICK_LOG_BUFFER, 10000 clicks by default.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Dir:     os.Getenv("CLICK_LOG_DIR"),
+		MaxSize: 64 << 20,
+		MaxAge:  time.Hour,
+		Buffer:  10000,
+	}
+	if v, err := strconv.ParseInt(os.Getenv("CLICK_LOG_MAX_SIZE"), 10, 64); err == nil && v > 0 {
+		cfg.MaxSize = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("CLICK_LOG_ROTATE_INTERVAL")); err == nil && v > 0 {
+		cfg.MaxAge = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("CLICK_LOG_BUFFER")); err == nil && v > 0 {
+		cfg.Buffer = v
+	}
+
+	return cfg
+}
+
+// flushInterval bounds the clicks lost with the host, they are written
+// and synced to disk at least this often.
+const flushInterval = time.Second
+
+var queue chan Entry
+
+// Start writes the clicks passed to Log into cfg.Dir until ctx is
+// cancelled. It must be called before the server starts, and does nothing
+// when cfg.Dir is empty.
+//
+// Every process writes its own files, named after the time they were
+// opened and the process id, so that prefork children never share one.
+// The file being written ends in .ndjson.open and is renamed to .ndjson
+// once rotated; a file left open by a crash is still readable up to its
+// last complete line.
+func Start(ctx context.Context, cfg Config) error {
+	if cfg.Dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return fmt.Errorf("click log: %w", err)
+	}
+
+	queue = make(chan Entry, cfg.Buffer)
+	w := &writer{cfg: cfg}
+
+	go func() {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				for {
+					select {
+					case e := <-queue:
+						w.write(e)
+					default:
+						w.close()
+						return
+					}
+				}
+			case e := <-queue:
+				w.write(e)
+			case <-ticker.C:
+				w.flush()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Log queues a click to be written. It never blocks, clicks are dropped
+// when the writer is behind. It does nothing unless Start was called.
+func Log(e Entry) {
+	if queue == nil {
+		return
+	}
+
+	select {
+	case queue <- e:
+	default:
+		dropped.Inc()
+	}
+}
+
+// writer owns the file being written, only used by the goroutine of
+// Start.
+type writer struct {
+	cfg    Config
+	file   *os.File
+	buf    *bufio.Writer
+	size   int64
+	opened time.Time
+}
+
+func (w *writer) write(e Entry) {
+	if w.file != nil && (w.size >= w.cfg.MaxSize || clock.Now().Sub(w.opened) >= w.cfg.MaxAge) {
+		w.close()
+	}
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			dropped.Inc()
+			log.Printf("click log: %v", err)
+			return
+		}
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		dropped.Inc()
+		return
+	}
+	n, err := w.buf.Write(append(line, '\n'))
+	w.size += int64(n)
+	if err != nil {
+		dropped.Inc()
+		log.Printf("click log: %v", err)
+		w.close()
+		return
+	}
+	written.Inc()
+}
+
+func (w *writer) open() error {
+	now := clock.Now().UTC()
+	name := fmt.Sprintf("clicks-%s-%d.ndjson.open", now.Format("20060102T150405.000Z"), os.Getpid())
+	f, err := os.OpenFile(filepath.Join(w.cfg.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+
+	w.file, w.buf, w.size, w.opened = f, bufio.NewWriter(f), 0, now
+
+	return nil
+}
+
+func (w *writer) flush() {
+	if w.file == nil {
+		return
+	}
+	if err := w.buf.Flush(); err != nil {
+		log.Printf("click log: %v", err)
+		return
+	}
+	if err := w.file.Sync(); err != nil {
+		log.Printf("click log: %v", err)
+	}
+}
+
+// close flushes and closes the current file, renaming it to its final
+// name.
+func (w *writer) close() {
+	if w.file == nil {
+		return
+	}
+
+	w.flush()
+	name := w.file.Name()
+	if err := w.file.Close(); err != nil {
+		log.Printf("click log: %v", err)
+	}
+	if err := os.Rename(name, name[:len(name)-len(".open")]); err != nil {
+		log.Printf("click log: %v", err)
+	}
+	w.file, w.buf = nil, nil
+}
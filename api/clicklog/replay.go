@@ -0,0 +1,121 @@
+package clicklog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/reports"
+	"github.com/ksarpe/redis-golang/top"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// replayBatch is the number of clicks counted per pipeline.
+const replayBatch = 500
+
+// ReplayOptions selects the clicks to replay. Replaying counts clicks
+// again, so Since is typically when the snapshot redis was restored from
+// was taken, and files must not be replayed twice.
+type ReplayOptions struct {
+	// Since and Until bound the clicks replayed, zero for no bound.
+	Since, Until time.Time
+	Reports      reports.AggregateConfig
+}
+
+// ReplayStats is the outcome of Replay.
+type ReplayStats struct {
+	Replayed  int
+	Skipped   int
+	Missing   int
+	Malformed int
+}
+
+// Replay counts the clicks logged in r back into redis: the click
+// counters, the countries, the daily series and report buckets, and the
+// leaderboards, on the day the clicks were made. Days past their
+// retention only count towards the totals. Clicks of links that no
+// longer exist are skipped, as are lines that cannot be decoded, such as
+// the last one of a file cut short by a crash.
+func Replay(ctx context.Context, rClient database.ClientInterface, r io.Reader, opts ReplayOptions) (ReplayStats, error) {
+	var stats ReplayStats
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	batch := make([]Entry, 0, replayBatch)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Short == "" || e.Timestamp == 0 {
+			stats.Malformed++
+			continue
+		}
+		at := time.Unix(e.Timestamp, 0)
+		if (!opts.Since.IsZero() && at.Before(opts.Since)) || (!opts.Until.IsZero() && !at.Before(opts.Until)) {
+			stats.Skipped++
+			continue
+		}
+
+		if batch = append(batch, e); len(batch) == replayBatch {
+			if err := replay(ctx, rClient, batch, opts, &stats); err != nil {
+				return stats, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return stats, err
+	}
+
+	return stats, replay(ctx, rClient, batch, opts, &stats)
+}
+
+func replay(ctx context.Context, rClient database.ClientInterface, batch []Entry, opts ReplayOptions, stats *ReplayStats) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	exists := make([]int, len(batch))
+	p := radix.NewPipeline()
+	for i, e := range batch {
+		p.Append(radix.Cmd(&exists[i], "EXISTS", links.MetaKey(e.Short)))
+	}
+	if err := rClient.Do(p); err != nil {
+		return err
+	}
+
+	today, replayed := reports.Today(), stats.Replayed
+	p = radix.NewPipeline()
+	for i, e := range batch {
+		if exists[i] == 0 {
+			stats.Missing++
+			continue
+		}
+
+		p.Append(radix.Cmd(nil, "INCR", links.ClicksKey(e.Short)))
+		p.Append(radix.Cmd(nil, "HINCRBY", links.CountersKey(), "clicks", "1"))
+		if e.Country != "" {
+			p.Append(radix.Cmd(nil, "HINCRBY", links.CountriesKey(e.Short), e.Country, "1"))
+		}
+		day := reports.DayOf(time.Unix(e.Timestamp, 0))
+		if day > today-top.Days {
+			top.AppendRecordOn(p, day, e.Short)
+		} else {
+			p.Append(radix.Cmd(nil, "ZINCRBY", links.TopAllKey(), "1", e.Short))
+		}
+		if day > today-int64(opts.Reports.Retention) {
+			reports.AppendClickOn(p, opts.Reports, day, e.Short, e.Owner, e.Country, e.Referrer)
+		}
+		stats.Replayed++
+	}
+	if stats.Replayed == replayed {
+		return nil
+	}
+
+	return rClient.Do(p)
+}
@@ -0,0 +1,79 @@
+package clock
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock tells the time. Expiry, rate limiting and analytics bucketing read
+// it through Now so that tests can move time forward. TTLs set in redis
+// keep running on the server's clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type system struct{}
+
+func (system) Now() time.Time {
+	return time.Now()
+}
+
+// System is the wall clock, the default.
+var System Clock = system{}
+
+var current atomic.Pointer[Clock]
+
+func init() {
+	current.Store(&System)
+}
+
+// Now returns the time of the clock in use.
+func Now() time.Time {
+	return (*current.Load()).Now()
+}
+
+// Set replaces the clock in use and returns a func restoring the previous
+// one.
+func Set(c Clock) (restore func()) {
+	previous := current.Swap(&c)
+
+	return func() {
+		current.Store(previous)
+	}
+}
+
+// Fake is a clock that only moves when told to.
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFake returns a fake clock stopped at now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now implements Clock.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+// Advance moves the clock forward by d.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+}
+
+// SetTime moves the clock to now.
+func (f *Fake) SetTime(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = now
+}
@@ -0,0 +1,54 @@
+// Command e2e runs end-to-end scenarios against a running instance and
+// exits non-zero when any fails, for gating releases. Point it at each
+// deployment variant in turn (standalone, TLS, ACL, cluster) after
+// bringing it up with docker compose, or run the tests of the e2e build
+// tag, which bring them up themselves.
+//
+//	e2e -addr http://localhost:3000 -api-key sk_... -run resolve
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ksarpe/redis-golang/e2e"
+)
+
+func main() {
+	addr := flag.String("addr", "http://localhost:3000", "base URL of the instance")
+	apiKey := flag.String("api-key", "", "API key with the links scopes")
+	run := flag.String("run", "", "comma separated scenarios to run, all by default")
+	flag.Parse()
+
+	c := e2e.NewClient(*addr, *apiKey)
+
+	selected := map[string]bool{}
+	for _, name := range strings.Split(*run, ",") {
+		if name != "" {
+			selected[name] = true
+		}
+	}
+
+	failed := 0
+	for _, s := range e2e.Scenarios {
+		if len(selected) > 0 && !selected[s.Name] {
+			continue
+		}
+
+		start := time.Now()
+		if err := s.Run(c); err != nil {
+			failed++
+			fmt.Printf("FAIL %-10s %v\n", s.Name, err)
+			continue
+		}
+		fmt.Printf("ok   %-10s %v\n", s.Name, time.Since(start).Round(time.Millisecond))
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "e2e: %d scenarios failed\n", failed)
+		os.Exit(1)
+	}
+}
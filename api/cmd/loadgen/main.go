@@ -0,0 +1,188 @@
+// Command loadgen drives a mix of shorten and resolve requests against a
+// running instance and prints latency histograms per operation.
+//
+//	loadgen -addr http://localhost:3000 -duration 30s -c 32 -resolve 0.95
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bounds are the upper bounds of the histogram buckets.
+var bounds = []time.Duration{
+	250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second,
+}
+
+type histogram struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	errors  int
+}
+
+func (h *histogram) observe(d time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err != nil {
+		h.errors++
+		return
+	}
+	h.samples = append(h.samples, d)
+}
+
+func (h *histogram) print(name string, elapsed time.Duration) {
+	sort.Slice(h.samples, func(i, j int) bool { return h.samples[i] < h.samples[j] })
+
+	n := len(h.samples)
+	fmt.Printf("%s: %d ok, %d errors, %.0f req/s\n", name, n, h.errors, float64(n)/elapsed.Seconds())
+	if n == 0 {
+		return
+	}
+
+	pct := func(p float64) time.Duration { return h.samples[int(float64(n-1)*p)] }
+	fmt.Printf("  p50 %v  p90 %v  p99 %v  max %v\n", pct(0.5), pct(0.9), pct(0.99), h.samples[n-1])
+
+	i := 0
+	for _, bound := range bounds {
+		count := 0
+		for i < n && h.samples[i] <= bound {
+			count++
+			i++
+		}
+		fmt.Printf("  <= %-8v %8d %s\n", bound, count, strings.Repeat("#", count*50/n))
+	}
+	fmt.Printf("  >  %-8v %8d %s\n", bounds[len(bounds)-1], n-i, strings.Repeat("#", (n-i)*50/n))
+}
+
+type generator struct {
+	addr   string
+	apiKey string
+	client *http.Client
+
+	mu     sync.Mutex
+	shorts []string
+}
+
+func (g *generator) shorten() error {
+	body, _ := json.Marshal(map[string]string{"url": fmt.Sprintf("https://example.com/%d", rand.Int63())})
+
+	req, err := http.NewRequest(http.MethodPost, g.addr+"/api/v1", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.apiKey != "" {
+		req.Header.Set("X-API-Key", g.apiKey)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("shorten: %s", resp.Status)
+	}
+
+	var out struct {
+		Short string `json:"short"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	g.shorts = append(g.shorts, out.Short[strings.LastIndex(out.Short, "/")+1:])
+	g.mu.Unlock()
+
+	return nil
+}
+
+func (g *generator) resolve() error {
+	g.mu.Lock()
+	short := g.shorts[rand.Intn(len(g.shorts))]
+	g.mu.Unlock()
+
+	resp, err := g.client.Get(g.addr + "/" + short)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMovedPermanently {
+		return fmt.Errorf("resolve: %s", resp.Status)
+	}
+
+	return nil
+}
+
+func main() {
+	addr := flag.String("addr", "http://localhost:3000", "base URL of the instance")
+	apiKey := flag.String("api-key", "", "API key sent with shorten requests")
+	duration := flag.Duration("duration", 30*time.Second, "how long to generate load")
+	concurrency := flag.Int("c", 16, "number of concurrent workers")
+	ratio := flag.Float64("resolve", 0.9, "share of requests that resolve rather than shorten")
+	seed := flag.Int("seed", 100, "shorts created before the run starts")
+	flag.Parse()
+
+	g := &generator{
+		addr:   strings.TrimSuffix(*addr, "/"),
+		apiKey: *apiKey,
+		client: &http.Client{
+			Timeout:       5 * time.Second,
+			Transport:     &http.Transport{MaxIdleConnsPerHost: *concurrency},
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+
+	for i := 0; i < *seed; i++ {
+		if err := g.shorten(); err != nil {
+			fmt.Fprintln(os.Stderr, "loadgen: seeding failed:", err)
+			os.Exit(1)
+		}
+	}
+
+	var shortens, resolves histogram
+	deadline := time.Now().Add(*duration)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				began := time.Now()
+				if rand.Float64() < *ratio {
+					err := g.resolve()
+					resolves.observe(time.Since(began), err)
+				} else {
+					err := g.shorten()
+					shortens.observe(time.Since(began), err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	resolves.print("resolve", elapsed)
+	shortens.print("shorten", elapsed)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ksarpe/redis-golang/backup"
+	"github.com/ksarpe/redis-golang/bootstrap"
+	"github.com/ksarpe/redis-golang/clicklog"
+	"github.com/ksarpe/redis-golang/config"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/reports"
+	"github.com/ksarpe/redis-golang/suggest"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: shortctl <command> [flags]
+
+commands:
+  backup  -o FILE                          write a snapshot of all shortener keys
+  restore -i FILE [-conflict skip|overwrite|fail]  load a snapshot
+  migrate                                  convert links to the current key schema
+  bootstrap                                create missing counters, reserved words, defaults and search index
+  index-suggestions                        build the did-you-mean index of existing shorts
+  replay-clicks [-since T] [-until T] FILE...  count the clicks of click log files again`)
+	os.Exit(2)
+}
+
+func main() {
+	// Same settings as the server, DB_PASS_FILE and friends included.
+	if _, err := config.Load(nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "backup":
+		err = runBackup(os.Args[2:])
+	case "restore":
+		err = runRestore(os.Args[2:])
+	case "migrate":
+		err = runMigrate()
+	case "bootstrap":
+		err = runBootstrap()
+	case "index-suggestions":
+		err = runIndexSuggestions()
+	case "replay-clicks":
+		err = runReplayClicks(os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "shortctl:", err)
+		os.Exit(1)
+	}
+}
+
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := fs.String("o", "-", "snapshot file, - for stdout")
+	fs.Parse(args)
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return err
+	}
+	defer rClient.Close()
+
+	stats, err := backup.Backup(rClient, w)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "backed up %d keys, skipped %d\n", stats.Keys, stats.Skipped)
+
+	return nil
+}
+
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := fs.String("i", "-", "snapshot file, - for stdin")
+	conflict := fs.String("conflict", backup.ConflictSkip, "policy for existing keys: skip, overwrite or fail")
+	fs.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return err
+	}
+	defer rClient.Close()
+
+	stats, err := backup.Restore(rClient, r, *conflict)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "restored %d keys, skipped %d\n", stats.Keys, stats.Skipped)
+
+	return nil
+}
+
+func runMigrate() error {
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return err
+	}
+	defer rClient.Close()
+
+	stats, err := links.MigrateAll(context.Background(), rClient)
+	fmt.Fprintf(os.Stderr, "scanned %d keys, migrated %d links to schema v%d\n", stats.Scanned, stats.Migrated, links.SchemaVersion)
+
+	return err
+}
+
+func runBootstrap() error {
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return err
+	}
+	defer rClient.Close()
+
+	report, err := bootstrap.Run(context.Background(), rClient)
+	fmt.Fprintf(os.Stderr, "created %d counters, %d reserved words, %d defaults, search index %s\n",
+		report.Counters, report.Reserved, report.Defaults, report.Search)
+
+	return err
+}
+
+func runIndexSuggestions() error {
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return err
+	}
+	defer rClient.Close()
+
+	indexed, err := suggest.Rebuild(context.Background(), rClient)
+	fmt.Fprintf(os.Stderr, "indexed %d shorts\n", indexed)
+
+	return err
+}
+
+func runReplayClicks(args []string) error {
+	fs := flag.NewFlagSet("replay-clicks", flag.ExitOnError)
+	since := fs.String("since", "", "replay clicks from this RFC 3339 time, typically when the restored snapshot was taken")
+	until := fs.String("until", "", "replay clicks before this RFC 3339 time")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		usage()
+	}
+
+	opts := clicklog.ReplayOptions{Reports: reports.AggregateConfigFromEnv()}
+	for _, bound := range []struct {
+		value string
+		t     *time.Time
+	}{{*since, &opts.Since}, {*until, &opts.Until}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, bound.value)
+		if err != nil {
+			return err
+		}
+		*bound.t = t
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return err
+	}
+	defer rClient.Close()
+
+	var total clicklog.ReplayStats
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		stats, err := clicklog.Replay(context.Background(), rClient, f, opts)
+		f.Close()
+		total.Replayed += stats.Replayed
+		total.Skipped += stats.Skipped
+		total.Missing += stats.Missing
+		total.Malformed += stats.Malformed
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "replayed %d clicks, skipped %d out of range, %d of missing links, %d malformed lines\n",
+		total.Replayed, total.Skipped, total.Missing, total.Malformed)
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ksarpe/redis-golang/database"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// checkKey is the scratch key the permission checks write to. It expires
+// on its own should a check be interrupted.
+func checkKey() string {
+	host, _ := os.Hostname()
+
+	return "lock:check:" + host + "-" + strconv.Itoa(os.Getpid())
+}
+
+// permissionChecks run every command family the service relies on against
+// a scratch key.
+var permissionChecks = []struct {
+	name string
+	run  func(c database.ClientInterface, key string) error
+}{
+	{"SET/GET/EXPIRE", func(c database.ClientInterface, key string) error {
+		var got string
+		p := radix.NewPipeline()
+		p.Append(radix.Cmd(nil, "SET", key, "1", "EX", "60"))
+		p.Append(radix.Cmd(&got, "GET", key))
+		p.Append(radix.Cmd(nil, "EXPIRE", key, "60"))
+		if err := c.Do(p); err != nil {
+			return err
+		}
+		if got != "1" {
+			return fmt.Errorf("GET returned %q after SET", got)
+		}
+		return nil
+	}},
+	{"INCR", func(c database.ClientInterface, key string) error {
+		return c.Do(radix.Cmd(nil, "INCR", key))
+	}},
+	{"HSET/HGETALL", func(c database.ClientInterface, key string) error {
+		p := radix.NewPipeline()
+		p.Append(radix.Cmd(nil, "DEL", key))
+		p.Append(radix.Cmd(nil, "HSET", key, "url", "https://example.com"))
+		p.Append(radix.Cmd(nil, "HGETALL", key))
+		return c.Do(p)
+	}},
+	{"EVAL (link schema scripts)", func(c database.ClientInterface, key string) error {
+		return c.Do(radix.NewEvalScript(`return redis.call("EXISTS", KEYS[1])`).Cmd(nil, []string{key}))
+	}},
+	{"SCAN (jobs)", func(c database.ClientInterface, key string) error {
+		return c.Do(radix.Cmd(nil, "SCAN", "0", "MATCH", key, "COUNT", "1"))
+	}},
+	{"PUBLISH (rewrite rules, live stats)", func(c database.ClientInterface, key string) error {
+		return c.Do(radix.Cmd(nil, "PUBLISH", key, "check"))
+	}},
+	{"XADD (analytics, creation feed)", func(c database.ClientInterface, key string) error {
+		p := radix.NewPipeline()
+		p.Append(radix.Cmd(nil, "DEL", key))
+		p.Append(radix.Cmd(nil, "XADD", key, "*", "check", "1"))
+		return c.Do(p)
+	}},
+	{"DEL", func(c database.ClientInterface, key string) error {
+		return c.Do(radix.Cmd(nil, "DEL", key))
+	}},
+}
+
+// Check runs Validate, then verifies that the redis user may run every
+// command the service needs. It returns the number of failed checks.
+func Check(w io.Writer) int {
+	failed, connected := validate(w)
+	if !connected {
+		fmt.Fprintln(w, "skip command permissions: redis is not reachable")
+		return failed
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		fmt.Fprintf(w, "FAIL redis connectivity: %v\n", err)
+		return failed + 1
+	}
+	defer rClient.Close()
+
+	key := checkKey()
+	for _, c := range permissionChecks {
+		if err := c.run(rClient, key); err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL command %s: %v\n", c.name, err)
+			if hint := diagnose(err); hint != "" {
+				fmt.Fprintf(w, "     %s\n", hint)
+			}
+			continue
+		}
+		fmt.Fprintf(w, "ok   command %s\n", c.name)
+	}
+	_ = rClient.Do(radix.Cmd(nil, "DEL", key))
+
+	return failed
+}
+
+// diagnose suggests a fix for the failures operators run into most.
+func diagnose(err error) string {
+	msg := err.Error()
+
+	var netErr *net.OpError
+	switch {
+	case strings.Contains(msg, "NOPERM"):
+		user := os.Getenv("DB_USER")
+		if user == "" {
+			user = "default"
+		}
+		return "the redis user lacks a permission, see ACL LOG on the server and grant it with ACL SETUSER " + user + " +<command> ~*"
+	case strings.Contains(msg, "CONFIG"):
+		return "clients run CONFIG SET cluster-announce-ip when connecting, grant +config|set to the redis user"
+	case strings.Contains(msg, "WRONGPASS"), strings.Contains(msg, "NOAUTH"), strings.Contains(msg, "invalid password"):
+		return "authentication failed, check DB_USER and DB_PASS (or DB_PASS_FILE / SECRETS_PROVIDER)"
+	case strings.Contains(msg, "x509"), strings.Contains(msg, "tls:"):
+		return "the TLS handshake failed, check DB_TLS_CA_CERT, DB_TLS_CERT, DB_TLS_KEY and DB_TLS_SERVER_NAME"
+	case strings.Contains(msg, "no such host"):
+		return "the redis host does not resolve, check DB_ADDR"
+	case strings.Contains(msg, "connection refused"), errors.As(err, &netErr):
+		return "redis is not reachable, check DB_ADDR (" + os.Getenv("DB_ADDR") + ") and network policies"
+	case strings.Contains(msg, "time:"):
+		return "use Go durations such as 500ms, 30s or 5m"
+	case strings.Contains(msg, "strconv"):
+		return "expected a whole number"
+	}
+
+	return ""
+}
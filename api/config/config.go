@@ -0,0 +1,199 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// Source is where a setting came from, later sources winning.
+type Source int
+
+// Sources in increasing precedence.
+const (
+	Default Source = iota
+	File
+	Env
+	Flag
+)
+
+func (s Source) String() string {
+	switch s {
+	case File:
+		return "file"
+	case Env:
+		return "env"
+	case Flag:
+		return "flag"
+	}
+
+	return "default"
+}
+
+// SecretSuffix marks settings holding the path of a file with the actual
+// value, as in DB_PASS_FILE=/run/secrets/redis-password.
+const SecretSuffix = "_FILE"
+
+// Secrets are the settings that may be read from a file. Other variables
+// ending in _FILE belong to someone else and are left alone.
+var Secrets = []string{
+	"ADMIN_TOKEN",
+	"ANALYTICS_EXPORT_AUTHORIZATION",
+	"ARCHIVE_S3_ACCESS_KEY",
+	"ARCHIVE_S3_SECRET_KEY",
+	"CAPTCHA_SECRET",
+	"DB_PASS",
+	"DB_USER",
+	"EVENTS_KAFKA_REST_AUTHORIZATION",
+	"GEOIP_LICENSE_KEY",
+	"JWT_SECRET",
+	"OIDC_CLIENT_SECRET",
+	"SMTP_PASS",
+	"SMTP_USER",
+	"VAULT_TOKEN",
+	"WEBHOOK_SECRET",
+}
+
+// shortcuts are flags for the settings most often passed on the command
+// line, every other setting is passed with -set KEY=VALUE.
+var shortcuts = []struct {
+	flag, key, usage string
+}{
+	{"port", "APP_PORT", "listen address, such as :3000"},
+	{"db-addr", "DB_ADDR", "redis addresses, host:port, comma separated for failover"},
+	{"domain", "DOMAIN", "public domain of the short links"},
+}
+
+// Options are the command line switches that aren't settings.
+type Options struct {
+	File           string
+	ValidateConfig bool
+	Check          bool
+	Sources        map[string]Source
+}
+
+type setFlags map[string]string
+
+func (s setFlags) String() string { return "" }
+
+func (s setFlags) Set(v string) error {
+	key, value, ok := strings.Cut(v, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected KEY=VALUE, got %q", v)
+	}
+	s[key] = value
+
+	return nil
+}
+
+// Load resolves the settings from flags, then the environment, then the
+// dotenv file given by -config or CONFIG_FILE (.env by default), and
+// exports the result to the environment read by the rest of the service.
+// A secret NAME is replaced by the content of the file NAME_FILE points to
+// unless NAME itself comes from a higher source.
+func Load(args []string) (*Options, error) {
+	fs := flag.NewFlagSet("redis-golang", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	opts := &Options{Sources: map[string]Source{}}
+	set := setFlags{}
+	fs.StringVar(&opts.File, "config", "", "dotenv file to read settings from (default .env, or CONFIG_FILE)")
+	fs.BoolVar(&opts.ValidateConfig, "validate-config", false, "check the configuration, redis connectivity and TLS material, then exit")
+	fs.BoolVar(&opts.Check, "check", false, "like -validate-config, also checking the redis user may run every command the service needs")
+	fs.Var(set, "set", "KEY=VALUE setting, may be repeated")
+	values := make([]*string, len(shortcuts))
+	for i, s := range shortcuts {
+		values[i] = fs.String(s.flag, "", s.usage+" ("+s.key+")")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(os.Stderr)
+			fs.PrintDefaults()
+		}
+		return nil, err
+	}
+	for i, s := range shortcuts {
+		if *values[i] != "" {
+			set[s.key] = *values[i]
+		}
+	}
+
+	for _, kv := range os.Environ() {
+		if key, _, ok := strings.Cut(kv, "="); ok {
+			opts.Sources[key] = Env
+		}
+	}
+
+	explicit := opts.File != "" || os.Getenv("CONFIG_FILE") != ""
+	if opts.File == "" {
+		opts.File = os.Getenv("CONFIG_FILE")
+	}
+	if opts.File == "" {
+		opts.File = ".env"
+	}
+
+	file, err := godotenv.Read(opts.File)
+	if err != nil && (explicit || !os.IsNotExist(err)) {
+		return nil, fmt.Errorf("failed to read config file %s, err: %w", opts.File, err)
+	}
+	for key, value := range file {
+		if _, ok := opts.Sources[key]; !ok {
+			os.Setenv(key, value)
+			opts.Sources[key] = File
+		}
+	}
+
+	for key, value := range set {
+		os.Setenv(key, value)
+		opts.Sources[key] = Flag
+	}
+
+	if err := opts.resolveSecrets(); err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
+
+func (o *Options) resolveSecrets() error {
+	for _, name := range Secrets {
+		key := name + SecretSuffix
+		if os.Getenv(key) == "" {
+			continue
+		}
+
+		source := o.Sources[key]
+		if o.Sources[name] > source {
+			continue
+		}
+		if o.Sources[name] == source && os.Getenv(name) != "" {
+			return fmt.Errorf("both %s and %s are set by %s, set only one", name, key, source)
+		}
+
+		value, err := ReadSecret(os.Getenv(key))
+		if err != nil {
+			return err
+		}
+		os.Setenv(name, value)
+		o.Sources[name] = source
+	}
+
+	return nil
+}
+
+// ReadSecret returns the content of a mounted secret file without the
+// trailing newline editors and kubectl tend to leave.
+func ReadSecret(path string) (string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file %s, err: %w", path, err)
+	}
+
+	return strings.TrimRight(string(raw), "\r\n"), nil
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// durations and integers are settings whose values are parsed at startup
+// or on first use, where a typo would silently fall back to the default.
+var (
+	durations = []string{
+		"SERVER_READ_TIMEOUT", "SERVER_WRITE_TIMEOUT", "SERVER_IDLE_TIMEOUT",
+		"EVICTION_CHECK_INTERVAL", "REWRITE_REFRESH_INTERVAL", "REPORT_INTERVAL",
+		"CONSISTENCY_CHECK_INTERVAL", "LINKCHECK_INTERVAL", "FLATTEN_TIMEOUT",
+		"HTTPS_UPGRADE_TIMEOUT", "ANALYTICS_EXPORT_INTERVAL", "LIVE_STATS_INTERVAL",
+		"DB_WARMUP_TIMEOUT", "DB_RESOLVE_INTERVAL", "CLICK_CONSOLIDATE_INTERVAL",
+		"ANONYMOUS_MAX_EXPIRY", "SAFE_BROWSING_TIMEOUT", "CLICK_RETENTION", "AUDIT_RETENTION",
+		"TRASH_RETENTION", "CLICK_LOG_ROTATE_INTERVAL", "JOURNAL_REPLAY_INTERVAL",
+		"LINK_WAIT_TIMEOUT",
+	}
+	integers = []string{
+		"SERVER_MAX_CONNS", "DB_POOL_SIZE", "DB_BULK_POOL_SIZE", "SHORT_MAX_DEPTH", "RESOLVE_BATCH_MAX",
+		"FLATTEN_MAX_HOPS", "ANALYTICS_EXPORT_BATCH", "EVENTS_BUFFER", "URL_COMPRESSION_MIN",
+		"CLICK_SHARDS", "CLICK_SHARD_THRESHOLD", "WARM_TOP_N", "API_RATE_LIMIT",
+		"ANONYMOUS_RATE_LIMIT", "CLICK_LOG_MAX_SIZE", "CLICK_LOG_BUFFER",
+		"JOURNAL_MAX_PENDING", "LINK_WAIT_REPLICAS",
+	}
+)
+
+// Validate checks the loaded settings, the TLS material and that redis
+// answers, writing one line per check to w. It returns the number of
+// failed checks.
+func Validate(w io.Writer) int {
+	failed, _ := validate(w)
+
+	return failed
+}
+
+func validate(w io.Writer) (int, bool) {
+	failed := 0
+	check := func(name string, err error) {
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL %s: %v\n", name, err)
+			if hint := diagnose(err); hint != "" {
+				fmt.Fprintf(w, "     %s\n", hint)
+			}
+			return
+		}
+		fmt.Fprintf(w, "ok   %s\n", name)
+	}
+
+	for _, key := range durations {
+		if v := os.Getenv(key); v != "" {
+			_, err := time.ParseDuration(v)
+			check(key, err)
+		}
+	}
+	for _, key := range integers {
+		if v := os.Getenv(key); v != "" {
+			_, err := strconv.Atoi(v)
+			check(key, err)
+		}
+	}
+
+	opts := database.ClientOptionsFromEnv()
+	tlsErr := database.CheckTLS(&opts)
+	if opts.TLSEnabled {
+		check("redis TLS material", tlsErr)
+	}
+	proxyErr := database.CheckProxy(&opts)
+	if opts.Proxy != "" {
+		check("redis proxy URL", proxyErr)
+	}
+
+	connected := false
+	if tlsErr == nil && proxyErr == nil {
+		err := ping()
+		check("redis connectivity", err)
+		connected = err == nil
+	}
+
+	return failed, connected
+}
+
+func ping() error {
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return err
+	}
+	defer rClient.Close()
+
+	var pong string
+	if err := rClient.Do(radix.Cmd(&pong, "PING")); err != nil {
+		return err
+	}
+	if pong != "PONG" {
+		return fmt.Errorf("unexpected PING reply %q", pong)
+	}
+
+	return nil
+}
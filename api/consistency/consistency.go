@@ -0,0 +1,217 @@
+package consistency
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/jobs"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// LastReportKey holds the JSON of the most recent report.
+const LastReportKey = "consistency:last"
+
+// Kinds of discrepancies found by Check.
+const (
+	OrphanedMeta      = "orphaned_meta"
+	MissingOwnerIndex = "missing_owner_index"
+	MissingCampaign   = "missing_campaign_index"
+	DanglingMember    = "dangling_member"
+	NegativeCounter   = "negative_counter"
+)
+
+// Issue is a single broken invariant.
+type Issue struct {
+	Kind     string `json:"kind"`
+	Key      string `json:"key"`
+	Detail   string `json:"detail,omitempty"`
+	Repaired bool   `json:"repaired"`
+}
+
+// Report is the outcome of a Check run.
+type Report struct {
+	StartedAt  int64   `json:"started_at"`
+	FinishedAt int64   `json:"finished_at"`
+	Checked    int     `json:"checked"`
+	Repair     bool    `json:"repair"`
+	Issues     []Issue `json:"issues"`
+}
+
+type checker struct {
+	ctx     context.Context
+	rClient database.ClientInterface
+	report  *Report
+}
+
+// Check verifies the invariants tying the link keys to their indexes:
+// metadata belongs to an existing short, owned and campaign shorts appear
+// in the matching sets, index sets only hold existing shorts and click
+// counters are not negative. With repair set, discrepancies are fixed.
+func Check(ctx context.Context, rClient database.ClientInterface, repair bool) (*Report, error) {
+	c := &checker{
+		ctx:     ctx,
+		rClient: rClient,
+		report:  &Report{StartedAt: time.Now().Unix(), Repair: repair, Issues: []Issue{}},
+	}
+
+	steps := []func(bool) error{c.checkMeta, c.checkIndexes, c.checkCounters}
+	for _, step := range steps {
+		if err := step(repair); err != nil {
+			return nil, err
+		}
+	}
+
+	c.report.FinishedAt = time.Now().Unix()
+
+	return c.report, nil
+}
+
+// Job returns the scheduled check, storing each report for the admin API.
+func Job(repair bool) jobs.Func {
+	return func(ctx context.Context, rClient database.ClientInterface) error {
+		report, err := Check(ctx, rClient, repair)
+		if err != nil {
+			return err
+		}
+
+		return Save(rClient, report)
+	}
+}
+
+// Save stores report as the last one.
+func Save(rClient database.ClientInterface, report *Report) error {
+	out, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	return rClient.Do(radix.Cmd(nil, "SET", LastReportKey, string(out)))
+}
+
+func (c *checker) add(issue Issue, repair bool, fix radix.Action) error {
+	if repair {
+		if err := c.rClient.Do(fix); err != nil {
+			return err
+		}
+		issue.Repaired = true
+	}
+	c.report.Issues = append(c.report.Issues, issue)
+
+	return nil
+}
+
+func (c *checker) checkMeta(repair bool) error {
+	return database.Scan(c.rClient, links.MetaKey("*"), func(key string) error {
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
+		c.report.Checked++
+
+		short, _ := links.ShortFromKey(key)
+
+		var meta []string
+		if err := c.rClient.Do(links.FieldsCmd(&meta, short, "url", "owner", "campaign")); err != nil {
+			return err
+		}
+
+		if meta[0] == "" {
+			return c.add(Issue{Kind: OrphanedMeta, Key: key}, repair,
+				radix.Cmd(nil, "DEL", append([]string{key}, links.ClickKeys(short)...)...))
+		}
+
+		if owner := meta[1]; owner != "" {
+			if err := c.checkMember(links.UserLinksKey(owner), short, MissingOwnerIndex, repair); err != nil {
+				return err
+			}
+		}
+		if campaign := meta[2]; campaign != "" {
+			if err := c.checkMember(links.CampaignLinksKey(campaign), short, MissingCampaign, repair); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (c *checker) checkMember(set, short, kind string, repair bool) error {
+	var member int
+	if err := c.rClient.Do(radix.Cmd(&member, "SISMEMBER", set, short)); err != nil {
+		return err
+	}
+	if member == 1 {
+		return nil
+	}
+
+	return c.add(Issue{Kind: kind, Key: set, Detail: short}, repair, radix.Cmd(nil, "SADD", set, short))
+}
+
+func (c *checker) checkIndexes(repair bool) error {
+	for _, pattern := range []string{links.UserLinksKey("*"), links.CampaignLinksKey("*")} {
+		err := database.Scan(c.rClient, pattern, func(set string) error {
+			if err := c.ctx.Err(); err != nil {
+				return err
+			}
+			c.report.Checked++
+
+			var shorts []string
+			if err := c.rClient.Do(radix.Cmd(&shorts, "SMEMBERS", set)); err != nil {
+				return err
+			}
+
+			for _, short := range shorts {
+				exists, err := links.Exists(c.rClient, short)
+				if err != nil {
+					return err
+				}
+				if exists {
+					continue
+				}
+				err = c.add(Issue{Kind: DanglingMember, Key: set, Detail: short}, repair,
+					radix.Cmd(nil, "SREM", set, short))
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *checker) checkCounters(repair bool) error {
+	return database.Scan(c.rClient, links.ClicksKey("*"), func(key string) error {
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
+
+		// Only the plain per-short counters, not the per-minute buckets or
+		// the per-country hashes hanging off the same prefix.
+		if strings.Count(key, ":") != 1 {
+			return nil
+		}
+		c.report.Checked++
+
+		var value string
+		if err := c.rClient.Do(radix.Cmd(&value, "GET", key)); err != nil {
+			return nil
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n >= 0 {
+			return nil
+		}
+
+		return c.add(Issue{Kind: NegativeCounter, Key: key, Detail: value}, repair,
+			radix.Cmd(nil, "SET", key, "0", "KEEPTTL"))
+	})
+}
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// DefaultResolveInterval is how often addresses are re-resolved and
+// health-checked unless DB_RESOLVE_INTERVAL says otherwise.
+const DefaultResolveInterval = 10 * time.Second
+
+// healthCheckTimeout bounds the dial and PING of a health check.
+const healthCheckTimeout = 2 * time.Second
+
+// target is an address to dial, with the host it was resolved from to
+// verify TLS certificates against.
+type target struct {
+	host string
+	addr string
+}
+
+// dialTargetFunc opens a connection to one target.
+type dialTargetFunc func(ctx context.Context, network string, t target) (radix.Conn, error)
+
+// addrSet follows the addresses of DB_ADDR, "host:port" entries separated
+// by commas tried in order. Hosts are re-resolved periodically, so that a
+// headless service whose pods changed IPs is followed, and every address
+// is health-checked so that dials go to live ones first.
+type addrSet struct {
+	hosts []string
+	// local is false behind a proxy, which resolves hosts itself.
+	local bool
+
+	mu      sync.RWMutex
+	targets []target
+	down    map[string]bool
+	// dial is the dial function of the latest client, used by the health
+	// checks.
+	dial dialTargetFunc
+}
+
+var (
+	addrSetsMu sync.Mutex
+	addrSets   = map[string]*addrSet{}
+)
+
+// addrsFor returns the address set of addr, resolving it and starting its
+// health checks on first use.
+func addrsFor(addr string, local bool, dial dialTargetFunc) *addrSet {
+	addrSetsMu.Lock()
+	defer addrSetsMu.Unlock()
+
+	if s, ok := addrSets[addr]; ok {
+		s.mu.Lock()
+		s.dial = dial
+		s.mu.Unlock()
+		return s
+	}
+
+	s := &addrSet{local: local, dial: dial, down: map[string]bool{}}
+	for _, host := range strings.Split(addr, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			s.hosts = append(s.hosts, host)
+		}
+	}
+	s.targets = s.resolve(Ctx)
+	addrSets[addr] = s
+
+	interval := DefaultResolveInterval
+	if d, err := time.ParseDuration(os.Getenv("DB_RESOLVE_INTERVAL")); err == nil && d > 0 {
+		interval = d
+	}
+	go s.run(Ctx, interval)
+
+	return s
+}
+
+// FirstAddr returns the first address of a DB_ADDR list.
+func FirstAddr(addr string) string {
+	first, _, _ := strings.Cut(addr, ",")
+
+	return strings.TrimSpace(first)
+}
+
+// resolve looks up every host, keeping a host as is when it doesn't
+// resolve so the dial reports why, or when a proxy resolves it.
+func (s *addrSet) resolve(ctx context.Context) []target {
+	var targets []target
+	for _, hostPort := range s.hosts {
+		host, port, err := net.SplitHostPort(hostPort)
+		if err != nil || !s.local {
+			targets = append(targets, target{host: hostPort, addr: hostPort})
+			continue
+		}
+
+		ips, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil || len(ips) == 0 {
+			targets = append(targets, target{host: host, addr: hostPort})
+			continue
+		}
+		for _, ip := range ips {
+			targets = append(targets, target{host: host, addr: net.JoinHostPort(ip, port)})
+		}
+	}
+
+	return targets
+}
+
+// run re-resolves the hosts and health-checks every address each interval
+// until ctx is cancelled.
+func (s *addrSet) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		targets := s.resolve(ctx)
+		down := map[string]bool{}
+		for _, t := range targets {
+			if err := s.check(ctx, t); err != nil {
+				down[t.addr] = true
+			}
+		}
+
+		s.mu.Lock()
+		for addr := range down {
+			if !s.down[addr] {
+				log.Printf("database: %s is down, failing over", addr)
+			}
+		}
+		s.targets, s.down = targets, down
+		s.mu.Unlock()
+	}
+}
+
+// check dials t and PINGs it.
+func (s *addrSet) check(ctx context.Context, t target) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	s.mu.RLock()
+	dial := s.dial
+	s.mu.RUnlock()
+
+	conn, err := dial(ctx, "tcp", t)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Do(ctx, radix.Cmd(nil, "PING"))
+}
+
+// ordered returns the targets to try, healthy ones first.
+func (s *addrSet) ordered() []target {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	healthy := make([]target, 0, len(s.targets))
+	var down []target
+	for _, t := range s.targets {
+		if s.down[t.addr] {
+			down = append(down, t)
+		} else {
+			healthy = append(healthy, t)
+		}
+	}
+
+	return append(healthy, down...)
+}
+
+// connect dials the first target that accepts a connection, marking the
+// ones that didn't as down until the next health check.
+func (s *addrSet) connect(ctx context.Context, network string, dial dialTargetFunc) (radix.Conn, error) {
+	var errs []error
+	for _, t := range s.ordered() {
+		conn, err := dial(ctx, network, t)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+
+		s.mu.Lock()
+		s.down[t.addr] = true
+		s.mu.Unlock()
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no redis address configured")
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// withServerName makes a TLS dialer verify the certificate against host,
+// as the address dialed may be a resolved IP.
+func withServerName(d radix.Dialer, host string) radix.Dialer {
+	secure, ok := d.NetDialer.(*tlsDialer)
+	if !ok || secure.config.ServerName != "" {
+		return d
+	}
+
+	config := secure.config.Clone()
+	config.ServerName = host
+	d.NetDialer = &tlsDialer{netDialer: secure.netDialer, config: config}
+
+	return d
+}
@@ -0,0 +1,86 @@
+//go:build chaos
+
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"time"
+
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+var errInjected = errors.New("chaos: injected redis error")
+
+type chaosConfig struct {
+	errorRate   float64
+	dropRate    float64
+	latencyRate float64
+	latency     time.Duration
+}
+
+func chaosConfigFromEnv() chaosConfig {
+	rate := func(name string) float64 {
+		v, err := strconv.ParseFloat(os.Getenv(name), 64)
+		if err != nil || v < 0 {
+			return 0
+		}
+		return min(v, 1)
+	}
+
+	cfg := chaosConfig{
+		errorRate:   rate("CHAOS_ERROR_RATE"),
+		dropRate:    rate("CHAOS_DROP_RATE"),
+		latencyRate: rate("CHAOS_LATENCY_RATE"),
+		latency:     100 * time.Millisecond,
+	}
+	if d, err := time.ParseDuration(os.Getenv("CHAOS_LATENCY")); err == nil && d > 0 {
+		cfg.latency = d
+	}
+
+	return cfg
+}
+
+// faultyClient delays and fails actions of the wrapped client at random.
+type faultyClient struct {
+	ClientInterface
+	cfg chaosConfig
+}
+
+// injectFaults wraps c when one of the CHAOS_* rates is set. Only builds
+// tagged chaos inject faults, to check in staging how the service copes
+// with a misbehaving redis.
+func injectFaults(c ClientInterface) ClientInterface {
+	cfg := chaosConfigFromEnv()
+	if cfg.errorRate == 0 && cfg.dropRate == 0 && cfg.latencyRate == 0 {
+		return c
+	}
+
+	log.Printf("chaos: injecting redis faults, errors %.2f, dropped replies %.2f, latency %s at %.2f",
+		cfg.errorRate, cfg.dropRate, cfg.latency, cfg.latencyRate)
+
+	return &faultyClient{ClientInterface: c, cfg: cfg}
+}
+
+// Do fails the action before sending it at errorRate, and after running it
+// at dropRate, as when a connection drops before the reply arrives.
+func (c *faultyClient) Do(action radix.Action) error {
+	if rand.Float64() < c.cfg.latencyRate {
+		time.Sleep(c.cfg.latency)
+	}
+	if rand.Float64() < c.cfg.errorRate {
+		return fmt.Errorf("failed to perform action %s, err: %w", action, errInjected)
+	}
+
+	err := c.ClientInterface.Do(action)
+	if err == nil && rand.Float64() < c.cfg.dropRate {
+		return fmt.Errorf("failed to perform action %s, err: chaos: dropped connection: %w", action, io.ErrUnexpectedEOF)
+	}
+
+	return err
+}
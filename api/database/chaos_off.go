@@ -0,0 +1,8 @@
+//go:build !chaos
+
+package database
+
+// injectFaults is a no-op unless built with the chaos tag.
+func injectFaults(c ClientInterface) ClientInterface {
+	return c
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// ErrNil is returned by the typed helpers for keys that don't exist, so
+// that callers need not tell an empty reply from a missing key.
+var ErrNil = errors.New("key does not exist")
+
+// The helpers below run the commands the route and service code needs
+// most. A reply that doesn't fit the receiver fails the action like any
+// other error, instead of leaving a zero value behind.
+
+// GetString returns the value of key, ErrNil if it doesn't exist.
+func GetString(c ClientInterface, key string) (string, error) {
+	var value string
+	maybe := radix.Maybe{Rcv: &value}
+	if err := c.Do(radix.Cmd(&maybe, "GET", key)); err != nil {
+		return "", err
+	}
+	if maybe.Null {
+		return "", ErrNil
+	}
+
+	return value, nil
+}
+
+// SetWithTTL sets key to value, expiring it after ttl. A ttl of zero or
+// less keeps the key until it is deleted.
+func SetWithTTL(c ClientInterface, key string, value interface{}, ttl time.Duration) error {
+	if ttl <= 0 {
+		return c.Do(radix.FlatCmd(nil, "SET", key, value))
+	}
+
+	return c.Do(radix.FlatCmd(nil, "SET", key, value, "PX", strconv.FormatInt(ttl.Milliseconds(), 10)))
+}
+
+// HGetAll reads the hash at key into dst, a pointer to a map or to a struct
+// whose fields are named by `redis:"field"` tags. It returns ErrNil if the
+// hash doesn't exist.
+func HGetAll(c ClientInterface, key string, dst interface{}) error {
+	maybe := radix.Maybe{Rcv: dst}
+	if err := c.Do(radix.Cmd(&maybe, "HGETALL", key)); err != nil {
+		return err
+	}
+	if maybe.Null || maybe.Empty {
+		return ErrNil
+	}
+
+	return nil
+}
+
+// Incr increments the counter at key, returning its new value.
+func Incr(c ClientInterface, key string) (int64, error) {
+	var n int64
+	if err := c.Do(radix.Cmd(&n, "INCR", key)); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"net"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 	"fmt"
 	"errors"
@@ -14,7 +16,6 @@ import (
 
 var (
 	errCACertificate = errors.New("unable to append the CA certificate")
-	errTypeAssertion = errors.New("type assertion to *net.Dialer failed")
 )
 
 const (
@@ -61,53 +62,28 @@ type ClientOptions struct {
 	DialConnectTimeout time.Duration
 	DialWriteTimeout   time.Duration
 	DialReadTimeout    time.Duration
+
+	// Proxy is a socks5://, socks5h:// or http:// URL to connect through.
+	Proxy string
 }
 
 var Ctx = context.Background()
 
 func (prod RadixV4ClientsProducer) NewClient(addr string) (ClientInterface, error){
+	return newClient(addr, PoolSize)
+}
 
-	clientOpts := ClientOptions{
-		TLSEnabled:false,
-		CaCert:"",
-		ClientCert:"",
-		ClientKey:"",
-		SubjectCommonName:"",
-
-		// ACL.
-		ACLEnabled:false,
-		Username:"",
-		Password:"",
+func newClient(addr string, poolSize int) (ClientInterface, error){
 
-		// Timeouts.
-		DialConnectTimeout: 10 * time.Second,
-		DialWriteTimeout: 1 * time.Second,
-		DialReadTimeout: 1 * time.Second,
-		}
-	dialer := radix.Dialer{
-		AuthUser: clientOpts.Username,
-		AuthPass: clientOpts.Password,
-		NetDialer: &net.Dialer{Timeout: clientOpts.DialConnectTimeout},
-	}
-
-	if clientOpts.TLSEnabled {
-		tlsConfig, err := createTLSConfig(&clientOpts)
-		if err != nil {
-			return nil, err
-		}
-		netDialer, ok := dialer.NetDialer.(*net.Dialer)
-		if !ok {
-			return nil, errTypeAssertion
-		}
-		dialer.NetDialer = &tls.Dialer{
-			NetDialer: netDialer,
-			Config:    tlsConfig,
-		}
+	clientOpts := ClientOptionsFromEnv()
+	dialer, err := newDialer(&clientOpts)
+	if err != nil {
+		return nil, err
 	}
 
 	poolCfg := radix.PoolConfig{
 		Dialer: dialer,
-		Size: PoolSize,
+		Size: poolSize,
 		PingInterval: PingInterval,
 		MinReconnectInterval: MinReconnectInterval,
 		MaxReconnectInterval: MaxReconnectInterval,
@@ -121,13 +97,13 @@ func (prod RadixV4ClientsProducer) NewClient(addr string) (ClientInterface, erro
 	c := &Client{pool: pool}
 
 	ipAddr := ""
-	ipAddr, _, err = net.SplitHostPort(addr)
+	ipAddr, _, err = net.SplitHostPort(FirstAddr(addr))
 	if err != nil{
 		c.Close()
 		return nil, fmt.Errorf("failed split address, closing client connection, err:%w", err)
 	}
 
-	err = c.Do(radix.Cmd(nil, "CONFIG", "SET", "cluster-announce-ip", ipAddr))
+	err = c.Do(Privileged(radix.Cmd(nil, "CONFIG", "SET", "cluster-announce-ip", ipAddr)))
 	if err != nil {
 		c.Close()
 
@@ -139,7 +115,7 @@ func (prod RadixV4ClientsProducer) NewClient(addr string) (ClientInterface, erro
 	// config since the value is dynamically set in a K8s secret and must be
 	// fetched at runtime.
 	if clientOpts.ACLEnabled {
-		err := c.Do(radix.Cmd(nil, "CONFIG", "SET", "masterauth", clientOpts.Password))
+		err := c.Do(Privileged(radix.Cmd(nil, "CONFIG", "SET", "masterauth", clientOpts.Password)))
 		if err != nil {
 			c.Close()
 
@@ -147,10 +123,147 @@ func (prod RadixV4ClientsProducer) NewClient(addr string) (ClientInterface, erro
 		}
 	}
 
-	return c, nil
+	return injectFaults(c), nil
+}
+
+// ClientOptionsFromEnv reads the connection options from DB_USER, DB_PASS,
+// DB_ACL and the DB_TLS* variables.
+func ClientOptionsFromEnv() ClientOptions {
+	return ClientOptions{
+		// TLS.
+		TLSEnabled:        os.Getenv("DB_TLS") == "true",
+		CaCert:            os.Getenv("DB_TLS_CA_CERT"),
+		ClientCert:        os.Getenv("DB_TLS_CERT"),
+		ClientKey:         os.Getenv("DB_TLS_KEY"),
+		SubjectCommonName: os.Getenv("DB_TLS_SERVER_NAME"),
+
+		// ACL.
+		ACLEnabled: os.Getenv("DB_ACL") == "true",
+		Username:   os.Getenv("DB_USER"),
+		Password:   os.Getenv("DB_PASS"),
+
+		// Timeouts.
+		DialConnectTimeout: 10 * time.Second,
+		DialWriteTimeout:   1 * time.Second,
+		DialReadTimeout:    1 * time.Second,
+
+		// Proxy.
+		Proxy: os.Getenv("DB_PROXY"),
+	}
+}
+
+// CheckTLS loads the TLS material of opts, reporting missing or invalid
+// files before any connection is attempted.
+func CheckTLS(opts *ClientOptions) error {
+	if !opts.TLSEnabled {
+		return nil
+	}
+
+	_, err := createTLSConfig(opts)
+
+	return err
+}
+
+// AuthFunc returns the credentials a new connection authenticates with.
+type AuthFunc func() (user, pass string)
+
+var auth atomic.Pointer[AuthFunc]
+
+// SetAuth makes new connections, including reconnections of existing
+// pools, authenticate with the credentials fn returns at dial time instead
+// of DB_USER and DB_PASS, so a rotated password is picked up without a
+// restart. Established connections stay authenticated.
+func SetAuth(fn AuthFunc) {
+	auth.Store(&fn)
+}
+
+func newDialer(clientOpts *ClientOptions) (radix.Dialer, error) {
+	forward := &net.Dialer{Timeout: clientOpts.DialConnectTimeout}
+	dialer := radix.Dialer{
+		AuthUser: clientOpts.Username,
+		AuthPass: clientOpts.Password,
+		NetDialer: forward,
+	}
+
+	if clientOpts.Proxy != "" {
+		proxy, err := newProxyDialer(clientOpts.Proxy, forward)
+		if err != nil {
+			return dialer, err
+		}
+		dialer.NetDialer = proxy
+	}
+
+	if clientOpts.TLSEnabled {
+		tlsConfig, err := createTLSConfig(clientOpts)
+		if err != nil {
+			return dialer, err
+		}
+		dialer.NetDialer = &tlsDialer{
+			netDialer: dialer.NetDialer,
+			config:    tlsConfig,
+		}
+	}
+
+	base := dialer
+	dial := func(ctx context.Context, network string, t target) (radix.Conn, error) {
+		d := withServerName(base, t.host)
+		if fn := auth.Load(); fn != nil {
+			d.AuthUser, d.AuthPass = (*fn)()
+		}
+
+		return d.Dial(ctx, network, t.addr)
+	}
+	dialer.CustomConn = func(ctx context.Context, network, addr string) (radix.Conn, error) {
+		return addrsFor(addr, clientOpts.Proxy == "", dial).connect(ctx, network, dial)
+	}
+
+	return dialer, nil
+}
+
+// TLSMaterialFunc returns the PEM encoded CA certificate, client
+// certificate and client key.
+type TLSMaterialFunc func() (caCert, clientCert, clientKey []byte)
+
+var tlsMaterial atomic.Pointer[TLSMaterialFunc]
+
+// SetTLSMaterial makes connections use the TLS material fn returns instead
+// of the DB_TLS_* files. The client certificate is fetched at every
+// handshake so a rotated one is presented by new connections.
+func SetTLSMaterial(fn TLSMaterialFunc) {
+	tlsMaterial.Store(&fn)
+}
+
+func createTLSConfigFromPEM(opts *ClientOptions, fn TLSMaterialFunc) (*tls.Config, error) {
+	caCert, clientCert, clientKey := fn()
+
+	caCertPool := x509.NewCertPool()
+	if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
+		return nil, errCACertificate
+	}
+
+	if _, err := tls.X509KeyPair(clientCert, clientKey); err != nil {
+		return nil, fmt.Errorf("failed to parse the client key pair, err: %w", err)
+	}
+
+	return &tls.Config{
+		RootCAs:    caCertPool,
+		ServerName: opts.SubjectCommonName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			_, clientCert, clientKey := fn()
+			cert, err := tls.X509KeyPair(clientCert, clientKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse the client key pair, err: %w", err)
+			}
+			return &cert, nil
+		},
+	}, nil
 }
 
 func createTLSConfig(opts *ClientOptions) (*tls.Config, error) {
+	if fn := tlsMaterial.Load(); fn != nil {
+		return createTLSConfigFromPEM(opts, *fn)
+	}
+
 	var tlsconfig tls.Config
 
 	certBytes, err := os.ReadFile(opts.CaCert)
@@ -205,10 +318,126 @@ func (c *Client) Close() error {
 //
 //nolint:contextcheck // radix v4 introduced context handling but for now no need to refactor entire call stack
 func (c *Client) Do(action radix.Action) error {
-	err := c.pool.Do(context.Background(), action)
+	err := c.pool.Do(context.Background(), guarded(action))
 	if err != nil {
 		return fmt.Errorf("failed to perform action %s, err: %w", action, err)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+// NewDefaultClient creates a client for the redis instance configured
+// through DB_ADDR, falling back to the docker-compose service address.
+func NewDefaultClient() (ClientInterface, error) {
+	addr := os.Getenv("DB_ADDR")
+	if addr == "" {
+		addr = "db:6379"
+	}
+
+	return RadixV4ClientsProducer{}.NewClient(addr)
+}
+
+// Scan iterates over every key matching pattern with SCAN, calling fn once
+// per key. Keys created or deleted during the iteration may or may not be
+// seen, as documented for SCAN.
+func Scan(c ClientInterface, pattern string, fn func(key string) error) error {
+	cursor := "0"
+	for {
+		var keys []string
+		err := c.Do(radix.Cmd(radix.Tuple{&cursor, &keys}, "SCAN", cursor, "MATCH", pattern, "COUNT", "1000"))
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err := fn(key); err != nil {
+				return err
+			}
+		}
+
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// HScan iterates over the fields of a hash with HSCAN, calling fn once per
+// field, without loading the whole hash.
+func HScan(c ClientInterface, key string, fn func(field, value string) error) error {
+	cursor := "0"
+	for {
+		var pairs []string
+		err := c.Do(radix.Cmd(radix.Tuple{&cursor, &pairs}, "HSCAN", key, cursor, "COUNT", "1000"))
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i+1 < len(pairs); i += 2 {
+			if err := fn(pairs[i], pairs[i+1]); err != nil {
+				return err
+			}
+		}
+
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// Info runs INFO for the given section and parses the "field:value" lines.
+func Info(c ClientInterface, section string) (map[string]string, error) {
+	var raw string
+	if err := c.Do(radix.Cmd(&raw, "INFO", section)); err != nil {
+		return nil, err
+	}
+
+	info := map[string]string{}
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if field, value, ok := strings.Cut(line, ":"); ok {
+			info[field] = value
+		}
+	}
+
+	return info, nil
+}
+
+// Subscribe calls fn with every message published on channel until ctx is
+// cancelled, reconnecting and resubscribing whenever the connection drops.
+func Subscribe(ctx context.Context, channel string, fn func(message []byte)) error {
+	addr := os.Getenv("DB_ADDR")
+	if addr == "" {
+		addr = "db:6379"
+	}
+
+	clientOpts := ClientOptionsFromEnv()
+	dialer, err := newDialer(&clientOpts)
+	if err != nil {
+		return err
+	}
+
+	conn, err := radix.PersistentPubSubConnConfig{Dialer: dialer}.New(ctx, func() (string, string, error) {
+		return "tcp", addr, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect for pub/sub, err: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.Subscribe(ctx, channel); err != nil {
+		return fmt.Errorf("failed to subscribe to %s, err: %w", channel, err)
+	}
+
+	for {
+		msg, err := conn.Next(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read from %s, err: %w", channel, err)
+		}
+		fn(msg.Message)
+	}
+}
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// ErrUnavailable marks errors meaning redis could not be reached.
+var ErrUnavailable = errors.New("redis unavailable")
+
+// Unavailable reports whether err means redis could not be reached or did
+// not answer in time, as opposed to a command failing. Retrying later may
+// succeed.
+func Unavailable(err error) bool {
+	var netErr net.Error
+
+	return errors.Is(err, ErrUnavailable) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
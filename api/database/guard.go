@@ -0,0 +1,207 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ksarpe/redis-golang/metrics"
+	radix "github.com/mediocregopher/radix/v4"
+	"github.com/mediocregopher/radix/v4/resp"
+	"github.com/mediocregopher/radix/v4/resp/resp3"
+)
+
+// ErrCommandBlocked is returned for commands outside the allowlist.
+var ErrCommandBlocked = errors.New("command not in the allowlist")
+
+var blocked = metrics.NewCounter("redis_commands_blocked_total",
+	"Redis commands outside the allowlist, rejected or only logged depending on DB_COMMAND_GUARD.")
+
+// allowed lists the commands the service runs. Container commands are
+// allowed per subcommand.
+var allowed = []string{
+	"BF.ADD", "BF.EXISTS", "BF.MADD", "BF.MEXISTS", "BF.RESERVE",
+	"CONFIG GET", "DEL", "EVAL", "EVALSHA", "EXEC", "EXISTS", "EXPIRE", "EXPIREAT",
+	"FT.CREATE", "FT._LIST", "GET", "GETDEL",
+	"HDEL", "HGET", "HGETALL", "HINCRBY", "HMGET", "HSCAN", "HSET", "HSETNX",
+	"INCR", "INFO", "LPOP", "LRANGE", "MEMORY USAGE", "MGET", "MULTI",
+	"PERSIST", "PEXPIRE", "PING", "PTTL", "PUBLISH", "RENAME", "ROLE", "RPUSH",
+	"SADD", "SCAN", "SCARD", "SET", "SISMEMBER", "SLOWLOG GET", "SMEMBERS", "SMOVE", "SREM", "SSCAN", "SUNION",
+	"TTL", "TYPE", "WAIT",
+	"XACK", "XADD", "XAUTOCLAIM", "XDEL", "XGROUP CREATE", "XRANGE", "XREAD", "XREADGROUP", "XREVRANGE", "XTRIM",
+	"ZADD", "ZINCRBY", "ZRANGE", "ZRANGEBYSCORE", "ZREM", "ZREMRANGEBYRANK", "ZREMRANGEBYSCORE",
+	"ZREVRANGE", "ZSCORE", "ZUNIONSTORE",
+}
+
+type guardConfig struct {
+	// mode is "enforce" to reject commands outside the allowlist, "log" to
+	// only log them, anything else to let every command through.
+	mode    string
+	allowed map[string]bool
+	// containers are the commands allowed per subcommand, reported with
+	// their subcommand. Other commands are reported alone, their first
+	// argument is usually a key.
+	containers map[string]bool
+}
+
+// guardSettings reads DB_COMMAND_GUARD and DB_COMMAND_ALLOW, extra commands
+// separated by commas, lazily so that the .env file is loaded first.
+var guardSettings = sync.OnceValue(func() guardConfig {
+	cfg := guardConfig{
+		mode:       os.Getenv("DB_COMMAND_GUARD"),
+		allowed:    map[string]bool{},
+		containers: map[string]bool{},
+	}
+	names := append(strings.Split(os.Getenv("DB_COMMAND_ALLOW"), ","), allowed...)
+	for _, name := range names {
+		if name = strings.ToUpper(strings.TrimSpace(name)); name != "" {
+			cfg.allowed[name] = true
+		}
+		if container, _, ok := strings.Cut(name, " "); ok {
+			cfg.containers[container] = true
+		}
+	}
+
+	return cfg
+})
+
+// privileged is an action exempt from the allowlist.
+type privileged struct {
+	radix.Action
+}
+
+// Privileged exempts action from the command allowlist, for admin tooling
+// and setup that legitimately runs commands the request paths never
+// should.
+func Privileged(action radix.Action) radix.Action {
+	return privileged{action}
+}
+
+// guarded wraps action so that its commands are checked against the
+// allowlist as they are written to the connection. Checking there rather
+// than up front covers pipelines, which only encode their commands as they
+// are performed.
+func guarded(action radix.Action) radix.Action {
+	cfg := guardSettings()
+	if cfg.mode != "enforce" && cfg.mode != "log" {
+		return action
+	}
+	if p, ok := action.(privileged); ok {
+		return p.Action
+	}
+
+	return guardedAction{Action: action, cfg: cfg}
+}
+
+type guardedAction struct {
+	radix.Action
+	cfg guardConfig
+}
+
+func (a guardedAction) Perform(ctx context.Context, conn radix.Conn) error {
+	return a.Action.Perform(ctx, guardConn{Conn: conn, cfg: a.cfg})
+}
+
+// guardConn checks what is written to the connection it wraps.
+type guardConn struct {
+	radix.Conn
+	cfg guardConfig
+}
+
+func (c guardConn) Do(ctx context.Context, action radix.Action) error {
+	return action.Perform(ctx, c)
+}
+
+func (c guardConn) EncodeDecode(ctx context.Context, m, u interface{}) error {
+	if m != nil {
+		if err := c.cfg.check(m); err != nil {
+			return err
+		}
+	}
+
+	return c.Conn.EncodeDecode(ctx, m, u)
+}
+
+// check returns an error for the first command encoded by m outside the
+// allowlist. Anything that can't be parsed as commands passes.
+func (cfg guardConfig) check(m interface{}) error {
+	var buf bytes.Buffer
+	if err := resp3.Marshal(&buf, m, resp.NewOpts()); err != nil {
+		return nil
+	}
+	names, err := commandNames(&buf)
+	if err != nil {
+		return nil
+	}
+
+	for _, name := range names {
+		if cfg.allowed[name[0]] || cfg.allowed[name[0]+" "+name[1]] {
+			continue
+		}
+
+		blocked.Inc()
+		command := name[0]
+		if cfg.containers[command] {
+			command += " " + name[1]
+		}
+		if cfg.mode == "log" {
+			log.Printf("database: %s is not in the allowlist", command)
+			continue
+		}
+
+		// The redis error keeps the pool from closing the connection,
+		// nothing was written on it.
+		return fmt.Errorf("%w: %w", ErrCommandBlocked, resp3.SimpleError{S: command})
+	}
+
+	return nil
+}
+
+// commandNames reads the name and first argument, upper-cased, of every
+// command encoded in r, a sequence of RESP arrays of bulk strings.
+func commandNames(r io.Reader) ([][2]string, error) {
+	br := bufio.NewReader(r)
+
+	var names [][2]string
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+		if err != nil || line[0] != '*' {
+			return nil, fmt.Errorf("unexpected %q", line)
+		}
+
+		var name [2]string
+		for i := 0; i < n; i++ {
+			head, err := br.ReadString('\n')
+			if err != nil {
+				return nil, err
+			}
+			size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(head, "$")))
+			if err != nil {
+				return nil, err
+			}
+			arg := make([]byte, size+2)
+			if _, err := io.ReadFull(br, arg); err != nil {
+				return nil, err
+			}
+			if i < 2 {
+				name[i] = strings.ToUpper(string(arg[:size]))
+			}
+		}
+		names = append(names, name)
+	}
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// hashField is a struct field stored as a hash field, named by its
+// `redis:"name"` tag like radix names them when decoding HGETALL.
+type hashField struct {
+	index     int
+	name      string
+	omitEmpty bool
+	// def is the value of records written before the field existed, from
+	// a `default:"value"` tag.
+	def string
+}
+
+func hashFields(t reflect.Type) []hashField {
+	var fields []hashField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("redis")
+		if !ok || tag == "-" || !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fields = append(fields, hashField{
+			index:     i,
+			name:      name,
+			omitEmpty: opts == "omitempty",
+			def:       f.Tag.Get("default"),
+		})
+	}
+
+	return fields
+}
+
+func structValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("expected a pointer to a struct, got %T", v)
+	}
+
+	return rv.Elem(), nil
+}
+
+// MarshalHash returns the fields of v, a pointer to a struct with `redis`
+// tags, as HSET arguments in set, and the empty `omitempty` fields in del
+// for HDEL. Booleans are stored as "1" when true, the way flags are
+// checked. With only, just the named fields are returned, for partial
+// updates.
+func MarshalHash(v interface{}, only ...string) (set, del []string, err error) {
+	rv, err := structValue(v)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, f := range hashFields(rv.Type()) {
+		if len(only) > 0 && !slices.Contains(only, f.name) {
+			continue
+		}
+		fv := rv.Field(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			del = append(del, f.name)
+			continue
+		}
+
+		value, err := formatField(fv)
+		if err != nil {
+			return nil, nil, fmt.Errorf("field %s: %w", f.name, err)
+		}
+		set = append(set, f.name, value)
+	}
+
+	return set, del, nil
+}
+
+// UnmarshalHash sets the fields of v, a pointer to a struct with `redis`
+// tags, from the hash fields, as read by HGETALL. Missing fields take their
+// `default` tag, if any, so that records written by older builds read like
+// new ones.
+func UnmarshalHash(fields map[string]string, v interface{}) error {
+	rv, err := structValue(v)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range hashFields(rv.Type()) {
+		value, ok := fields[f.name]
+		if !ok {
+			value = f.def
+		}
+		if err := parseField(rv.Field(f.index), value); err != nil {
+			return fmt.Errorf("field %s: %w", f.name, err)
+		}
+	}
+
+	return nil
+}
+
+func formatField(fv reflect.Value) (string, error) {
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String(), nil
+	case reflect.Bool:
+		if fv.Bool() {
+			return "1", nil
+		}
+		return "", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(fv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(fv.Float(), 'f', -1, 64), nil
+	}
+
+	return "", fmt.Errorf("unsupported type %s", fv.Type())
+}
+
+// parseField sets fv from value. Empty values leave the zero value, as
+// for flags, which are only ever checked for being set.
+func parseField(fv reflect.Value, value string) error {
+	if value == "" {
+		fv.SetZero()
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		fv.SetBool(value != "0" && value != "false")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,255 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var errProxyScheme = errors.New("proxy scheme must be socks5, socks5h or http")
+
+// netDialer is what radix.Dialer needs to open network connections.
+type netDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// proxyDialer opens connections through a SOCKS5 or HTTP CONNECT proxy,
+// for redis only reachable through an egress proxy or an SSH tunnel
+// (ssh -D). Host names are passed on to the proxy unresolved.
+type proxyDialer struct {
+	proxy   *url.URL
+	forward *net.Dialer
+}
+
+func newProxyDialer(rawURL string, forward *net.Dialer) (*proxyDialer, error) {
+	proxy, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL, err: %w", err)
+	}
+	switch proxy.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return nil, errProxyScheme
+	}
+
+	return &proxyDialer{proxy: proxy, forward: forward}, nil
+}
+
+// CheckProxy parses the proxy URL of opts, if any.
+func CheckProxy(opts *ClientOptions) error {
+	if opts.Proxy == "" {
+		return nil
+	}
+	_, err := newProxyDialer(opts.Proxy, nil)
+
+	return err
+}
+
+func (d *proxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	proxyAddr := d.proxy.Host
+	if d.proxy.Port() == "" {
+		port := "1080"
+		if d.proxy.Scheme == "http" {
+			port = "3128"
+		}
+		proxyAddr = net.JoinHostPort(d.proxy.Hostname(), port)
+	}
+
+	conn, err := d.forward.DialContext(ctx, network, proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach proxy %s, err: %w", proxyAddr, err)
+	}
+
+	// The handshake is bounded by the dial timeout.
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else if d.forward.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(d.forward.Timeout))
+	}
+
+	tunnel := conn
+	if d.proxy.Scheme == "http" {
+		tunnel, err = d.connect(conn, addr)
+	} else {
+		err = d.socks5(conn, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused %s, err: %w", proxyAddr, addr, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	return tunnel, nil
+}
+
+// socks5 asks the proxy to connect to addr, as in RFC 1928, authenticating
+// as in RFC 1929 when the proxy URL has credentials.
+func (d *proxyDialer) socks5(conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+
+	method := byte(0x00)
+	if d.proxy.User != nil {
+		method = 0x02
+	}
+	if _, err := conn.Write([]byte{0x05, 0x01, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 || reply[1] != method {
+		return errors.New("socks5: no acceptable authentication method")
+	}
+
+	if method == 0x02 {
+		user := d.proxy.User.Username()
+		pass, _ := d.proxy.User.Password()
+		if len(user) > 255 || len(pass) > 255 {
+			return errors.New("socks5: credentials too long")
+		}
+		req := append([]byte{0x01, byte(len(user))}, user...)
+		req = append(append(req, byte(len(pass))), pass...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("socks5: authentication failed")
+		}
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New("socks5: host name too long")
+		}
+		req = append(append(req, 0x03, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 0x01), ip4...)
+	} else {
+		req = append(append(req, 0x04), ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// VER REP RSV ATYP, then the bound address.
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0x00 {
+		return fmt.Errorf("socks5: connect failed with code %d", head[1])
+	}
+	var skip int
+	switch head[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return errors.New("socks5: invalid bound address")
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+
+	return err
+}
+
+// connect opens a tunnel to addr with HTTP CONNECT.
+func (d *proxyDialer) connect(conn net.Conn, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if d.proxy.User != nil {
+		pass, _ := d.proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(d.proxy.User.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT answered %s", resp.Status)
+	}
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+
+	return conn, nil
+}
+
+// bufferedConn reads what the proxy sent past its response first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// tlsDialer runs the TLS handshake over connections of any dialer, where
+// tls.Dialer needs a *net.Dialer.
+type tlsDialer struct {
+	netDialer netDialer
+	config    *tls.Config
+}
+
+func (d *tlsDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.netDialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	config := d.config
+	if config.ServerName == "" {
+		host, _, _ := net.SplitHostPort(addr)
+		config = config.Clone()
+		config.ServerName = host
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ksarpe/redis-golang/metrics"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// DefaultSharedPoolSize is the connection pool size of Shared unless
+// DB_POOL_SIZE says otherwise.
+const DefaultSharedPoolSize = 16
+
+// DefaultBulkPoolSize is the connection pool size of Bulk unless
+// DB_BULK_POOL_SIZE says otherwise.
+const DefaultBulkPoolSize = 4
+
+// pool is a process-wide client dedicated to one class of traffic. Each
+// class has its own connections, so a SCAN or a long export pipeline never
+// sits in front of a redirect on a connection.
+type pool struct {
+	mu      sync.Mutex
+	client  ClientInterface
+	sizeEnv string
+	size    int
+	dialed  int
+	timed   bool
+	pending *metrics.Gauge
+	// inFlight counts the actions sent and not answered yet, published
+	// as pending.
+	inFlight atomic.Int64
+}
+
+var (
+	interactive = &pool{
+		sizeEnv: "DB_POOL_SIZE",
+		size:    DefaultSharedPoolSize,
+		timed:   true,
+		pending: metrics.NewGauge("redis_pool_interactive_pending",
+			"Actions sent on the interactive redis pool and not answered yet."),
+	}
+	bulk = &pool{
+		sizeEnv: "DB_BULK_POOL_SIZE",
+		size:    DefaultBulkPoolSize,
+		pending: metrics.NewGauge("redis_pool_bulk_pending",
+			"Actions sent on the bulk redis pool and not answered yet."),
+	}
+)
+
+// Shared returns the process-wide client for the hot paths, dialing it on
+// first use. Unlike clients from NewDefaultClient it must not be closed.
+func Shared() (ClientInterface, error) {
+	return interactive.get()
+}
+
+// Bulk returns the process-wide client for exports, imports, scans and
+// analytics shipping, the work that may wait. Like Shared it must not be
+// closed.
+func Bulk() (ClientInterface, error) {
+	return bulk.get()
+}
+
+// SetShared makes Shared return c rather than dialing DB_ADDR, for the
+// benchmarks running without redis.
+func SetShared(c ClientInterface) {
+	interactive.mu.Lock()
+	defer interactive.mu.Unlock()
+
+	interactive.client = pooledClient{ClientInterface: c, pool: interactive}
+}
+
+func (p *pool) get() (ClientInterface, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.client != nil {
+		return p.client, nil
+	}
+
+	addr := os.Getenv("DB_ADDR")
+	if addr == "" {
+		addr = "db:6379"
+	}
+
+	size, err := strconv.Atoi(os.Getenv(p.sizeEnv))
+	if err != nil || size <= 0 {
+		size = p.size
+	}
+
+	c, err := newClient(addr, size)
+	if err != nil {
+		return nil, err
+	}
+	p.client = pooledClient{ClientInterface: c, pool: p}
+	p.dialed = size
+
+	return p.client, nil
+}
+
+// LatencyFunc observes how long an action took to complete.
+type LatencyFunc func(time.Duration)
+
+var latencyObserver atomic.Pointer[LatencyFunc]
+
+// SetLatencyObserver makes the shared client report the duration of every
+// action to fn, failed actions included. Only Shared is observed, other
+// clients run blocking commands or bulk work whose duration says nothing
+// of redis.
+func SetLatencyObserver(fn LatencyFunc) {
+	latencyObserver.Store(&fn)
+}
+
+type pooledClient struct {
+	ClientInterface
+	pool *pool
+}
+
+func (c pooledClient) Do(action radix.Action) error {
+	c.pool.pending.Set(float64(c.pool.inFlight.Add(1)))
+	start := time.Now()
+	err := c.ClientInterface.Do(action)
+	if fn := latencyObserver.Load(); fn != nil && c.pool.timed {
+		(*fn)(time.Since(start))
+	}
+	c.pool.pending.Set(float64(c.pool.inFlight.Add(-1)))
+
+	return err
+}
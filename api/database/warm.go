@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// WarmConfig controls the warm-up of the shared pools at startup.
+type WarmConfig struct {
+	Enabled bool
+	Timeout time.Duration
+	// Required makes a failed warm-up fatal instead of leaving the pools
+	// to connect on first use.
+	Required bool
+}
+
+// WarmConfigFromEnv reads DB_WARMUP, DB_WARMUP_TIMEOUT and
+// DB_WARMUP_REQUIRED. Warm-up is on unless DB_WARMUP is "false".
+func WarmConfigFromEnv() WarmConfig {
+	cfg := WarmConfig{
+		Enabled:  os.Getenv("DB_WARMUP") != "false",
+		Timeout:  10 * time.Second,
+		Required: os.Getenv("DB_WARMUP_REQUIRED") == "true",
+	}
+	if d, err := time.ParseDuration(os.Getenv("DB_WARMUP_TIMEOUT")); err == nil && d > 0 {
+		cfg.Timeout = d
+	}
+
+	return cfg
+}
+
+// WarmReport describes the pools after a warm-up.
+type WarmReport struct {
+	Connections int
+	Version     string
+	Role        string
+	Elapsed     time.Duration
+}
+
+var errWarmTimeout = errors.New("timed out waiting for connections")
+
+// Warm dials every connection of the Shared and Bulk pools and checks
+// them, so that the first requests don't pay for dialing and TLS
+// handshakes: the pools open one connection up front and the others in
+// the background. INFO and ROLE then check the server answers commands
+// beyond PING.
+func Warm(ctx context.Context, timeout time.Duration) (WarmReport, error) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var report WarmReport
+	for _, p := range []*pool{interactive, bulk} {
+		c, err := p.get()
+		if err != nil {
+			return report, err
+		}
+		if err := pingAll(ctx, c, p.dialed); err != nil {
+			return report, err
+		}
+		report.Connections += p.dialed
+	}
+
+	c, _ := interactive.get()
+	info, err := Info(c, "server")
+	if err != nil {
+		return report, err
+	}
+	report.Version = info["redis_version"]
+
+	// ROLE may be denied by an ACL, it only adds to the report.
+	var role []interface{}
+	if err := c.Do(radix.Cmd(&role, "ROLE")); err == nil && len(role) > 0 {
+		name, _ := role[0].([]byte)
+		report.Role = string(name)
+	}
+	report.Elapsed = time.Since(start)
+
+	return report, nil
+}
+
+// pingAll PINGs n connections of c at once. Every PING holds its
+// connection until all of them answered, forcing the pool to use, and so
+// to have dialed, n distinct connections.
+func pingAll(ctx context.Context, c ClientInterface, n int) error {
+	h := &holdConn{ctx: ctx}
+	h.arrived.Add(n)
+
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			errs <- c.Do(h)
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("%w, %d of %d up", errWarmTimeout, i, n)
+		}
+	}
+
+	return nil
+}
+
+// holdConn is a PING that keeps its connection until every holdConn
+// sharing the wait group has answered.
+type holdConn struct {
+	ctx     context.Context
+	arrived sync.WaitGroup
+}
+
+func (h *holdConn) Properties() radix.ActionProperties {
+	return radix.ActionProperties{}
+}
+
+func (h *holdConn) Perform(ctx context.Context, conn radix.Conn) error {
+	err := radix.Cmd(nil, "PING").Perform(ctx, conn)
+	h.arrived.Done()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.arrived.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-h.ctx.Done():
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package destination
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// FlattenConfig controls how far redirect chains are followed at creation.
+type FlattenConfig struct {
+	Enabled bool
+	MaxHops int
+	Timeout time.Duration
+}
+
+// FlattenConfigFromEnv reads the FLATTEN_* environment variables.
+func FlattenConfigFromEnv() FlattenConfig {
+	cfg := FlattenConfig{
+		Enabled: os.Getenv("FLATTEN_REDIRECTS") == "true",
+		MaxHops: 5,
+		Timeout: 3 * time.Second,
+	}
+
+	if v, err := strconv.Atoi(os.Getenv("FLATTEN_MAX_HOPS")); err == nil && v > 0 {
+		cfg.MaxHops = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("FLATTEN_TIMEOUT")); err == nil && v > 0 {
+		cfg.Timeout = v
+	}
+
+	return cfg
+}
+
+var noFollowClient = &http.Client{
+	Transport: Transport(),
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// Flatten follows the redirects of dest up to cfg.MaxHops times and returns
+// the last URL reached with the number of hops taken. A chain that can't
+// be followed to the end stops at the last URL that answered.
+func Flatten(ctx context.Context, cfg FlattenConfig, dest string) (string, int) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	current := dest
+	for hops := 0; hops < cfg.MaxHops; hops++ {
+		next, ok := nextHop(ctx, current)
+		if !ok {
+			return current, hops
+		}
+		current = next
+	}
+
+	return current, cfg.MaxHops
+}
+
+// nextHop returns where u redirects to, if anywhere.
+func nextHop(ctx context.Context, u string) (string, bool) {
+	resp, err := do(ctx, http.MethodHead, u)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp, err = do(ctx, http.MethodGet, u)
+	}
+	if err != nil {
+		return "", false
+	}
+
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return "", false
+	}
+
+	base, err := url.Parse(u)
+	if err != nil {
+		return "", false
+	}
+	location, err := base.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return "", false
+	}
+	if location.Scheme != "http" && location.Scheme != "https" {
+		return "", false
+	}
+
+	return location.String(), true
+}
+
+func do(ctx context.Context, method, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := noFollowClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return resp, nil
+}
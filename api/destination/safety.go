@@ -0,0 +1,132 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrUnsafe is returned for destinations on a blocked domain or flagged by
+// Safe Browsing.
+var ErrUnsafe = errors.New("destination flagged as unsafe")
+
+const safeBrowsingURL = "https://safebrowsing.googleapis.com/v4/threatMatches:find"
+
+// SafetyConfig controls the screening of destinations.
+type SafetyConfig struct {
+	// BlockedDomains are refused along with their subdomains.
+	BlockedDomains []string
+	// SafeBrowsingKey is the Google Safe Browsing API key, destinations are
+	// only checked against BlockedDomains without it.
+	SafeBrowsingKey string
+	Timeout         time.Duration
+}
+
+// SafetyConfigFromEnv reads SAFETY_BLOCKED_DOMAINS, a comma separated list,
+// SAFE_BROWSING_API_KEY and SAFE_BROWSING_TIMEOUT.
+func SafetyConfigFromEnv() SafetyConfig {
+	cfg := SafetyConfig{
+		SafeBrowsingKey: os.Getenv("SAFE_BROWSING_API_KEY"),
+		Timeout:         3 * time.Second,
+	}
+
+	for _, domain := range strings.Split(os.Getenv("SAFETY_BLOCKED_DOMAINS"), ",") {
+		if domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), "."); domain != "" {
+			cfg.BlockedDomains = append(cfg.BlockedDomains, domain)
+		}
+	}
+	if v, err := time.ParseDuration(os.Getenv("SAFE_BROWSING_TIMEOUT")); err == nil && v > 0 {
+		cfg.Timeout = v
+	}
+
+	return cfg
+}
+
+var safeBrowsingClient = &http.Client{}
+
+// Screen checks every URL of dests, such as a destination and the URL it
+// was submitted as, against the blocked domains and Safe Browsing. It
+// returns ErrUnsafe for flagged destinations and other errors when Safe
+// Browsing cannot be consulted, for callers failing closed.
+func Screen(ctx context.Context, cfg SafetyConfig, dests ...string) error {
+	for _, dest := range dests {
+		u, err := url.Parse(dest)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUnsafe, err)
+		}
+		host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+		for _, domain := range cfg.BlockedDomains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return fmt.Errorf("%w: %s is blocked", ErrUnsafe, host)
+			}
+		}
+	}
+
+	if cfg.SafeBrowsingKey == "" {
+		return nil
+	}
+
+	return safeBrowsing(ctx, cfg, dests)
+}
+
+func safeBrowsing(ctx context.Context, cfg SafetyConfig, dests []string) error {
+	type entry struct {
+		URL string `json:"url"`
+	}
+	entries := make([]entry, len(dests))
+	for i, dest := range dests {
+		entries[i] = entry{URL: dest}
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		"client": map[string]string{"clientId": "redis-golang", "clientVersion": "1.0"},
+		"threatInfo": map[string]any{
+			"threatTypes":      []string{"MALWARE", "SOCIAL_ENGINEERING", "UNWANTED_SOFTWARE", "POTENTIALLY_HARMFUL_APPLICATION"},
+			"platformTypes":    []string{"ANY_PLATFORM"},
+			"threatEntryTypes": []string{"URL"},
+			"threatEntries":    entries,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, safeBrowsingURL+"?key="+url.QueryEscape(cfg.SafeBrowsingKey), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := safeBrowsingClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("safe browsing: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("safe browsing: status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Matches []struct {
+			ThreatType string `json:"threatType"`
+		} `json:"matches"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("safe browsing: %w", err)
+	}
+	if len(result.Matches) > 0 {
+		return fmt.Errorf("%w: %s", ErrUnsafe, strings.ToLower(result.Matches[0].ThreatType))
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package destination
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// DefaultSchemes are allowed unless ALLOWED_SCHEMES says otherwise.
+const DefaultSchemes = "http,https"
+
+// Errors returned by Check for destinations malformed for their scheme.
+var (
+	ErrInvalidMailto = errors.New("mailto destination must contain a valid email address")
+	ErrInvalidTel    = errors.New("tel destination must contain a phone number")
+	ErrInvalidURL    = errors.New("destination is not a valid URL")
+)
+
+var (
+	telPattern    = regexp.MustCompile(`^\+?[0-9][0-9().\- ]{2,}$`)
+	schemePattern = regexp.MustCompile(`^[a-z][a-z0-9+.\-]*$`)
+)
+
+// opaqueSchemes are written without "//", so "mailto:x" can't be mistaken
+// for a host and port.
+var opaqueSchemes = map[string]bool{"mailto": true, "tel": true, "sms": true}
+
+// SchemePolicy lists the URL schemes destinations may use.
+type SchemePolicy []string
+
+// ParseSchemes reads a comma separated list of schemes.
+func ParseSchemes(list string) SchemePolicy {
+	var p SchemePolicy
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			p = append(p, s)
+		}
+	}
+
+	return p
+}
+
+// DefaultPolicy returns the policy configured by ALLOWED_SCHEMES.
+func DefaultPolicy() SchemePolicy {
+	list := os.Getenv("ALLOWED_SCHEMES")
+	if list == "" {
+		list = DefaultSchemes
+	}
+
+	return ParseSchemes(list)
+}
+
+// Validate rejects empty policies and names that can't be URL schemes.
+func (p SchemePolicy) Validate() error {
+	if len(p) == 0 {
+		return errors.New("at least one scheme is required")
+	}
+	for _, s := range p {
+		if !schemePattern.MatchString(s) {
+			return fmt.Errorf("%q is not a valid scheme", s)
+		}
+	}
+
+	return nil
+}
+
+// Allows reports whether scheme is part of the policy.
+func (p SchemePolicy) Allows(scheme string) bool {
+	for _, s := range p {
+		if s == scheme {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithScheme prefixes destinations typed without a scheme, such as
+// "example.com/page", with http://. Destinations with a scheme the policy
+// knows are left alone.
+func (p SchemePolicy) WithScheme(raw string) string {
+	if strings.Contains(raw, "://") {
+		return raw
+	}
+
+	if scheme, _, ok := strings.Cut(raw, ":"); ok {
+		scheme = strings.ToLower(scheme)
+		if opaqueSchemes[scheme] || p.Allows(scheme) {
+			return raw
+		}
+	}
+
+	return "http://" + raw
+}
+
+// Check returns the scheme of raw, or an error when the policy doesn't
+// allow it or the destination is malformed for its scheme.
+func (p SchemePolicy) Check(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" {
+		return "", ErrInvalidURL
+	}
+	scheme := strings.ToLower(u.Scheme)
+
+	if !p.Allows(scheme) {
+		return "", fmt.Errorf("scheme %q is not allowed, allowed schemes: %s", scheme, strings.Join(p, ", "))
+	}
+
+	switch scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return "", ErrInvalidURL
+		}
+	case "mailto":
+		address, _, _ := strings.Cut(u.Opaque, "?")
+		if _, err := mail.ParseAddress(address); err != nil {
+			return "", ErrInvalidMailto
+		}
+	case "tel":
+		if !telPattern.MatchString(u.Opaque) {
+			return "", ErrInvalidTel
+		}
+	default:
+		// Custom app schemes only need something after the scheme.
+		if u.Opaque == "" && u.Host == "" && u.Path == "" {
+			return "", ErrInvalidURL
+		}
+	}
+
+	return scheme, nil
+}
@@ -0,0 +1,70 @@
+package destination
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Decisions recorded by Upgrade.
+const (
+	// Upgraded means the https:// variant answered and replaced dest.
+	Upgraded = "upgraded"
+
+	// Unavailable means the https:// variant didn't answer, dest was kept.
+	Unavailable = "unavailable"
+)
+
+// UpgradeConfig controls the HTTPS upgrade check made at creation.
+type UpgradeConfig struct {
+	Enabled bool
+	Timeout time.Duration
+}
+
+// UpgradeConfigFromEnv reads the HTTPS_UPGRADE* environment variables.
+func UpgradeConfigFromEnv() UpgradeConfig {
+	cfg := UpgradeConfig{
+		Enabled: os.Getenv("HTTPS_UPGRADE") == "true",
+		Timeout: 3 * time.Second,
+	}
+
+	if v, err := time.ParseDuration(os.Getenv("HTTPS_UPGRADE_TIMEOUT")); err == nil && v > 0 {
+		cfg.Timeout = v
+	}
+
+	return cfg
+}
+
+// Upgrade returns the https:// variant of an http:// dest when it answers
+// without a server error, with the decision taken. Other destinations are
+// returned as they are with no decision.
+func Upgrade(ctx context.Context, cfg UpgradeConfig, dest string) (string, string) {
+	rest, ok := cutPrefixFold(dest, "http://")
+	if !ok {
+		return dest, ""
+	}
+	secure := "https://" + rest
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	resp, err := do(ctx, http.MethodHead, secure)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp, err = do(ctx, http.MethodGet, secure)
+	}
+	if err != nil || resp.StatusCode >= 500 {
+		return dest, Unavailable
+	}
+
+	return secure, Upgraded
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+
+	return s[len(prefix):], true
+}
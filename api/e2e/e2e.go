@@ -0,0 +1,197 @@
+// Package e2e holds the end-to-end scenarios run against a live instance,
+// by the e2e command gating releases and by the tests of the e2e build tag
+// bringing up each deployment variant with docker compose.
+package e2e
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client calls the HTTP API of an instance, never following redirects so
+// that they can be checked.
+type Client struct {
+	Addr   string
+	APIKey string
+	HTTP   *http.Client
+}
+
+// NewClient returns a client of the instance at addr, authenticating with
+// apiKey unless empty.
+func NewClient(addr, apiKey string) *Client {
+	return &Client{
+		Addr:   strings.TrimSuffix(addr, "/"),
+		APIKey: apiKey,
+		HTTP: &http.Client{
+			Timeout:       10 * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+// Do sends a request with body encoded as JSON, returning the response and
+// its body.
+func (c *Client) Do(method, path string, body any) (*http.Response, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.Addr+path, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+
+	return resp, data, err
+}
+
+// Expect runs a request and fails unless it answers with one of statuses.
+func (c *Client) Expect(method, path string, body any, statuses ...int) (*http.Response, []byte, error) {
+	resp, data, err := c.Do(method, path, body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	for _, status := range statuses {
+		if resp.StatusCode == status {
+			return resp, data, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("%s %s: got %s, want %v: %s", method, path, resp.Status, statuses, data)
+}
+
+// Shorten creates a link from body, the fields of a shorten request, and
+// returns its short.
+func (c *Client) Shorten(body map[string]string) (string, error) {
+	return c.shorten(body, http.StatusOK)
+}
+
+// ShortenPending is Shorten for a link journaled while redis is
+// unavailable, which only resolves once replayed.
+func (c *Client) ShortenPending(body map[string]string) (string, error) {
+	return c.shorten(body, http.StatusAccepted)
+}
+
+func (c *Client) shorten(body map[string]string, status int) (string, error) {
+	_, data, err := c.Expect(http.MethodPost, "/api/v1", body, status)
+	if err != nil {
+		return "", err
+	}
+
+	var out struct {
+		Short string `json:"short"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return "", err
+	}
+
+	return out.Short[strings.LastIndex(out.Short, "/")+1:], nil
+}
+
+// Resolve fails unless short redirects to url.
+func (c *Client) Resolve(short, url string) error {
+	resp, _, err := c.Expect(http.MethodGet, "/"+short, nil, http.StatusMovedPermanently)
+	if err != nil {
+		return err
+	}
+	if got := resp.Header.Get("Location"); got != url {
+		return fmt.Errorf("resolve: redirected to %q, want %q", got, url)
+	}
+
+	return nil
+}
+
+// Scenario is a check run against an instance.
+type Scenario struct {
+	Name string
+	Run  func(c *Client) error
+}
+
+// Scenarios are the checks every deployment variant must pass, with an
+// API key holding the links scopes.
+var Scenarios = []Scenario{
+	{"health", func(c *Client) error {
+		_, _, err := c.Expect(http.MethodGet, "/health", nil, http.StatusOK)
+		return err
+	}},
+	{"resolve", func(c *Client) error {
+		url := fmt.Sprintf("https://example.com/e2e/%d", rand.Int63())
+		short, err := c.Shorten(map[string]string{"url": url})
+		if err != nil {
+			return err
+		}
+
+		return c.Resolve(short, url)
+	}},
+	{"unknown", func(c *Client) error {
+		_, _, err := c.Expect(http.MethodGet, fmt.Sprintf("/e2e-missing-%d", rand.Int63()), nil, http.StatusNotFound)
+		return err
+	}},
+	{"custom", func(c *Client) error {
+		custom := fmt.Sprintf("e2e-%d", rand.Int63())
+		if _, err := c.Shorten(map[string]string{"url": "https://example.com/first", "short": custom}); err != nil {
+			return err
+		}
+		_, _, err := c.Expect(http.MethodPost, "/api/v1", map[string]string{"url": "https://example.com/second", "short": custom}, http.StatusForbidden)
+
+		return err
+	}},
+	{"info", func(c *Client) error {
+		short, err := c.Shorten(map[string]string{"url": "https://example.com/info"})
+		if err != nil {
+			return err
+		}
+		_, _, err = c.Expect(http.MethodGet, "/api/v1/links/"+short, nil, http.StatusOK)
+
+		return err
+	}},
+	{"delete", func(c *Client) error {
+		short, err := c.Shorten(map[string]string{"url": "https://example.com/delete"})
+		if err != nil {
+			return err
+		}
+		if _, _, err := c.Expect(http.MethodDelete, "/api/v1/links/"+short, nil, http.StatusNoContent); err != nil {
+			return err
+		}
+		_, _, err = c.Expect(http.MethodGet, "/"+short, nil, http.StatusNotFound, http.StatusGone)
+
+		return err
+	}},
+	{"expiry", func(c *Client) error {
+		url := "https://example.com/expiry"
+		short, err := c.Shorten(map[string]string{"url": url, "expiry": "2s"})
+		if err != nil {
+			return err
+		}
+		if err := c.Resolve(short, url); err != nil {
+			return err
+		}
+
+		time.Sleep(3 * time.Second)
+		_, _, err = c.Expect(http.MethodGet, "/"+short, nil, http.StatusNotFound, http.StatusGone)
+
+		return err
+	}},
+}
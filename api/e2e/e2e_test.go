@@ -0,0 +1,266 @@
+//go:build e2e
+
+// The tests bring every deployment variant up with docker compose, from
+// the compose files of testdata/compose, and run the scenarios against it:
+//
+//	go test -tags e2e -timeout 30m ./e2e
+package e2e
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const adminToken = "e2e-admin-token"
+
+// variants are the deployments every scenario runs against, each on its
+// own port so that a variant left up by an interrupted run does not get in
+// the way of the next.
+var variants = []struct {
+	name string
+	port int
+}{
+	{"standalone", 3101},
+	{"tls", 3102},
+	{"acl", 3103},
+	{"cluster", 3104},
+}
+
+func TestScenarios(t *testing.T) {
+	for _, v := range variants {
+		t.Run(v.name, func(t *testing.T) {
+			c := up(t, v.name, v.port)
+			for _, s := range Scenarios {
+				t.Run(s.Name, func(t *testing.T) {
+					if err := s.Run(c); err != nil {
+						t.Fatal(err)
+					}
+				})
+			}
+		})
+	}
+}
+
+// TestFailover checks that links created while redis is down are
+// journaled and written once it is back, that links answered before the
+// primary is lost still resolve once its replica is promoted, and that
+// links can be created again.
+func TestFailover(t *testing.T) {
+	c := up(t, "failover", 3105)
+
+	url := "https://example.com/e2e/failover"
+	short, err := c.Shorten(map[string]string{"url": url})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The key authenticated above is honored while redis is down.
+	compose(t, "failover", "stop", "replica", "db")
+	var pending string
+	eventually(t, func() error {
+		pending, err = c.ShortenPending(map[string]string{"url": url})
+		return err
+	})
+	compose(t, "failover", "start", "db")
+	eventually(t, func() error { return c.Resolve(pending, url) })
+	compose(t, "failover", "up", "-d", "--wait", "replica")
+
+	compose(t, "failover", "stop", "db")
+	compose(t, "failover", "exec", "-T", "replica", "redis-cli", "replicaof", "no", "one")
+
+	eventually(t, func() error { return c.Resolve(short, url) })
+	eventually(t, func() error { return c.Resolve(pending, url) })
+	eventually(t, func() error {
+		short, err := c.Shorten(map[string]string{"url": url})
+		if err != nil {
+			return err
+		}
+		return c.Resolve(short, url)
+	})
+}
+
+// up brings variant up on port, taking it down when t ends, and returns a
+// client holding an API key with the links scopes.
+func up(t *testing.T, variant string, port int) *Client {
+	t.Helper()
+
+	// Read by the compose files, and by the cleanup below.
+	t.Setenv("E2E_PORT", strconv.Itoa(port))
+	t.Setenv("E2E_ADMIN_TOKEN", adminToken)
+	if variant == "tls" {
+		t.Setenv("E2E_CERTS", writeCerts(t))
+	}
+
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("api logs:\n%s", composeOutput(t, variant, "logs", "api"))
+		}
+		compose(t, variant, "down", "-v")
+	})
+	compose(t, variant, "up", "-d", "--build", "--wait")
+
+	admin := NewClient(fmt.Sprintf("http://localhost:%d", port), "")
+	admin.HTTP.Transport = bearer(adminToken)
+	_, data, err := admin.Expect(http.MethodPost, "/admin/apikeys", map[string]string{"owner": "e2e-" + variant}, http.StatusCreated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key struct {
+		APIKey string `json:"api_key"`
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewClient(admin.Addr, key.APIKey)
+}
+
+// compose runs docker compose on the project of variant.
+func compose(t *testing.T, variant string, args ...string) {
+	t.Helper()
+
+	if out, err := composeCmd(variant, args...).CombinedOutput(); err != nil {
+		t.Fatalf("docker compose %v: %v\n%s", args, err, out)
+	}
+}
+
+func composeOutput(t *testing.T, variant string, args ...string) []byte {
+	t.Helper()
+
+	out, err := composeCmd(variant, args...).CombinedOutput()
+	if err != nil {
+		t.Logf("docker compose %v: %v", args, err)
+	}
+
+	return out
+}
+
+func composeCmd(variant string, args ...string) *exec.Cmd {
+	files := []string{
+		"-p", "e2e-" + variant,
+		"-f", filepath.Join("testdata", "compose", "base.yaml"),
+		"-f", filepath.Join("testdata", "compose", variant+".yaml"),
+	}
+
+	return exec.Command("docker", append(append([]string{"compose"}, files...), args...)...)
+}
+
+// eventually retries fn for up to 30 seconds, the time for the service to
+// notice a failover.
+func eventually(t *testing.T, fn func() error) {
+	t.Helper()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		err := fn()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+type bearer string
+
+func (b bearer) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+string(b))
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// writeCerts writes a CA, a certificate for the db host and a client
+// certificate into a directory readable by the containers, returning it.
+func writeCerts(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	caKey := newKey(t)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "e2e CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "ca.crt"), "CERTIFICATE", caDER)
+
+	for i, leaf := range []struct {
+		name  string
+		usage x509.ExtKeyUsage
+	}{
+		{"server", x509.ExtKeyUsageServerAuth},
+		{"client", x509.ExtKeyUsageClientAuth},
+	} {
+		key := newKey(t)
+		cert := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject:      pkix.Name{CommonName: leaf.name},
+			DNSNames:     []string{"db"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(24 * time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{leaf.usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writePEM(t, filepath.Join(dir, leaf.name+".crt"), "CERTIFICATE", der)
+		writePEM(t, filepath.Join(dir, leaf.name+".key"), "PRIVATE KEY", keyDER)
+	}
+
+	return dir
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// writePEM writes a world readable file, the containers run as their own
+// users.
+func writePEM(t *testing.T, name, typ string, der []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,144 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksarpe/redis-golang/metrics"
+	"github.com/ksarpe/redis-golang/webhooks"
+)
+
+var errUnknownBackend = errors.New("unknown event bus backend")
+
+var (
+	published = metrics.NewCounter("events_published_total", "Events published to the event bus.")
+	dropped   = metrics.NewCounter("events_dropped_total", "Events dropped because the event bus was unavailable or behind.")
+)
+
+// Publisher delivers encoded events to a subject, a NATS subject or a
+// Kafka topic depending on the backend.
+type Publisher interface {
+	Publish(ctx context.Context, subject string, payload []byte) error
+	Close() error
+}
+
+// FromEnv returns the publisher selected by EVENTS_BACKEND, or nil when
+// the event bus is disabled.
+func FromEnv() (Publisher, error) {
+	switch os.Getenv("EVENTS_BACKEND") {
+	case "":
+		return nil, nil
+	case "nats":
+		return NewNATSPublisher(os.Getenv("EVENTS_NATS_URL")), nil
+	case "kafka":
+		return &KafkaRESTPublisher{
+			URL:           os.Getenv("EVENTS_KAFKA_REST_URL"),
+			Authorization: os.Getenv("EVENTS_KAFKA_REST_AUTHORIZATION"),
+		}, nil
+	}
+
+	return nil, errUnknownBackend
+}
+
+// Link is the payload of the link lifecycle events.
+type Link struct {
+	Short     string `json:"short"`
+	URL       string `json:"url,omitempty"`
+	Owner     string `json:"owner,omitempty"`
+	Campaign  string `json:"campaign,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+}
+
+// Click is the payload of link.clicked, only published when EVENTS_CLICKS
+// is true since it fires on every redirect.
+type Click struct {
+	Short    string `json:"short"`
+	Country  string `json:"country,omitempty"`
+	Referrer string `json:"referrer,omitempty"`
+}
+
+// Clicks reports whether link.clicked events are published.
+func Clicks() bool {
+	return queue != nil && clicks
+}
+
+type message struct {
+	subject string
+	payload []byte
+}
+
+var (
+	queue  chan message
+	prefix string
+	clicks bool
+)
+
+// Start publishes the events passed to Emit through p until ctx is
+// cancelled. It must be called before the server starts. Events are published in the background, in order, and dropped
+// when more than EVENTS_BUFFER are waiting so the event bus can never slow
+// down redirects.
+func Start(ctx context.Context, p Publisher) {
+	size := 10000
+	if v, err := strconv.Atoi(os.Getenv("EVENTS_BUFFER")); err == nil && v > 0 {
+		size = v
+	}
+	prefix = os.Getenv("EVENTS_SUBJECT_PREFIX")
+	clicks = os.Getenv("EVENTS_CLICKS") == "true"
+	queue = make(chan message, size)
+
+	go func() {
+		defer p.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m := <-queue:
+				pctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+				err := p.Publish(pctx, m.subject, m.payload)
+				cancel()
+				if err != nil {
+					dropped.Inc()
+					log.Printf("events: %v", err)
+					continue
+				}
+				published.Inc()
+			}
+		}
+	}()
+}
+
+// Emit queues an event of eventType, such as "link.created", in the same
+// envelope as webhooks. It does nothing unless Start was called.
+func Emit(eventType string, data any) {
+	if queue == nil {
+		return
+	}
+
+	payload, err := json.Marshal(webhooks.Event{Type: eventType, CreatedAt: time.Now().Unix(), Data: data})
+	if err != nil {
+		log.Printf("events: failed to marshal %s event, err: %v", eventType, err)
+		return
+	}
+
+	select {
+	case queue <- message{subject: Subject(eventType), payload: payload}:
+	default:
+		dropped.Inc()
+	}
+}
+
+// Subject returns the subject eventType is published to.
+func Subject(eventType string) string {
+	if prefix == "" {
+		return eventType
+	}
+
+	return strings.TrimSuffix(prefix, ".") + "." + eventType
+}
@@ -0,0 +1,64 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// KafkaRESTPublisher produces events through a Kafka REST proxy (the
+// Confluent REST API v2), one topic per subject, so no Kafka client library
+// is needed.
+type KafkaRESTPublisher struct {
+	URL           string
+	Authorization string
+
+	Client *http.Client
+}
+
+// Publish implements Publisher.
+func (p *KafkaRESTPublisher) Publish(ctx context.Context, subject string, payload []byte) error {
+	body, err := json.Marshal(map[string]any{
+		"records": []map[string]json.RawMessage{{"value": payload}},
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimSuffix(p.URL, "/") + "/topics/" + url.PathEscape(subject)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	if p.Authorization != "" {
+		req.Header.Set("Authorization", p.Authorization)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s, err: %w", subject, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to publish to %s, status: %s", subject, resp.Status)
+	}
+
+	return nil
+}
+
+// Close implements Publisher.
+func (p *KafkaRESTPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,153 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATSPublisher speaks the core NATS text protocol, which is small enough
+// that no client library is needed for publishing. The connection is
+// dialed on first use and again after any error.
+type NATSPublisher struct {
+	addr, user, pass string
+
+	mu   sync.Mutex
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+// NewNATSPublisher returns a publisher for a nats://[user:pass@]host:port
+// URL, defaulting to a local server.
+func NewNATSPublisher(rawURL string) *NATSPublisher {
+	p := &NATSPublisher{addr: "localhost:4222"}
+
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		p.addr = u.Host
+		if u.Port() == "" {
+			p.addr = net.JoinHostPort(u.Hostname(), "4222")
+		}
+		if u.User != nil {
+			p.user = u.User.Username()
+			p.pass, _ = u.User.Password()
+		}
+	}
+
+	return p
+}
+
+// Publish implements Publisher.
+func (p *NATSPublisher) Publish(ctx context.Context, subject string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		if err := p.connect(ctx); err != nil {
+			return fmt.Errorf("failed to connect to NATS at %s, err: %w", p.addr, err)
+		}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		p.conn.SetWriteDeadline(deadline)
+	}
+
+	fmt.Fprintf(p.w, "PUB %s %d\r\n", subject, len(payload))
+	p.w.Write(payload)
+	p.w.WriteString("\r\n")
+	if err := p.w.Flush(); err != nil {
+		p.reset()
+		return fmt.Errorf("failed to publish to %s, err: %w", subject, err)
+	}
+
+	return nil
+}
+
+// Close implements Publisher.
+func (p *NATSPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.reset()
+
+	return nil
+}
+
+func (p *NATSPublisher) connect(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return err
+	}
+
+	// The server greets with INFO before accepting CONNECT.
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected greeting %q, err: %v", line, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	connect, err := json.Marshal(struct {
+		Verbose  bool   `json:"verbose"`
+		Pedantic bool   `json:"pedantic"`
+		Name     string `json:"name"`
+		User     string `json:"user,omitempty"`
+		Pass     string `json:"pass,omitempty"`
+	}{Name: "redis-golang", User: p.user, Pass: p.pass})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "CONNECT %s\r\n", connect)
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return err
+	}
+
+	p.conn, p.w = conn, w
+	go p.serve(conn, r)
+
+	return nil
+}
+
+// serve answers the server's keepalive PINGs and drops the connection when
+// the server reports an error or goes away.
+func (p *NATSPublisher) serve(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil || strings.HasPrefix(line, "-ERR") {
+			p.mu.Lock()
+			if p.conn == conn {
+				p.reset()
+			}
+			p.mu.Unlock()
+			return
+		}
+
+		if strings.HasPrefix(line, "PING") {
+			p.mu.Lock()
+			if p.conn == conn {
+				p.w.WriteString("PONG\r\n")
+				p.w.Flush()
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *NATSPublisher) reset() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.conn, p.w = nil, nil
+}
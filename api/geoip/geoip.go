@@ -0,0 +1,244 @@
+package geoip
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ksarpe/redis-golang/metrics"
+)
+
+const downloadURL = "https://download.maxmind.com/app/geoip_download"
+
+// client bounds a download, stalled ones would hold back every later
+// update. Editions are tens of megabytes.
+var client = &http.Client{Timeout: 5 * time.Minute}
+
+var errNoDatabaseInArchive = errors.New("no .mmdb file found in archive")
+
+var (
+	lookups        = metrics.NewCounter("geoip_lookups_total", "GeoIP lookups performed.")
+	lookupFailures = metrics.NewCounter("geoip_lookup_failures_total", "GeoIP lookups that failed.")
+	updates        = metrics.NewCounter("geoip_updates_total", "GeoIP database updates applied.")
+	updateFailures = metrics.NewCounter("geoip_update_failures_total", "GeoIP database updates that failed.")
+)
+
+// Manager keeps a MaxMind database on disk up to date and serves lookups
+// from the currently loaded copy.
+type Manager struct {
+	Path       string
+	Edition    string
+	LicenseKey string
+
+	mu sync.RWMutex
+	db *DB
+}
+
+// Default is the manager used by the routes, configured by Setup.
+var Default = &Manager{}
+
+// Setup configures Default from the GEOIP_* environment variables, loads
+// the database and, when a license key is set, schedules periodic updates.
+// GeoIP stays disabled when GEOIP_DB_PATH is empty.
+func Setup(ctx context.Context) {
+	Default.Path = os.Getenv("GEOIP_DB_PATH")
+	Default.Edition = os.Getenv("GEOIP_EDITION")
+	Default.LicenseKey = os.Getenv("GEOIP_LICENSE_KEY")
+	if Default.Path == "" {
+		return
+	}
+	if Default.Edition == "" {
+		Default.Edition = "GeoLite2-Country"
+	}
+
+	if err := Default.Load(); err != nil {
+		log.Printf("geoip: %v", err)
+	}
+
+	if Default.LicenseKey == "" {
+		return
+	}
+
+	interval, err := time.ParseDuration(os.Getenv("GEOIP_UPDATE_INTERVAL"))
+	if err != nil {
+		interval = 24 * time.Hour
+	}
+	go Default.Run(ctx, interval)
+}
+
+// Load opens the database at Path, replacing the one currently in use.
+func (m *Manager) Load() error {
+	db, err := Open(m.Path)
+	if err != nil {
+		return err
+	}
+
+	m.swap(db)
+
+	return nil
+}
+
+// Run updates the database immediately if none is loaded, then once per
+// interval until ctx is cancelled.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	if !m.Loaded() {
+		m.updateAndLog(ctx)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.updateAndLog(ctx)
+		}
+	}
+}
+
+func (m *Manager) updateAndLog(ctx context.Context) {
+	if err := m.Update(ctx); err != nil {
+		updateFailures.Inc()
+		log.Printf("geoip: %v", err)
+		return
+	}
+	updates.Inc()
+}
+
+// Update downloads the latest edition, validates it and swaps it in.
+func (m *Manager) Update(ctx context.Context) error {
+	query := url.Values{
+		"edition_id":  {m.Edition},
+		"license_key": {m.LicenseKey},
+		"suffix":      {"tar.gz"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s, err: %w", m.Edition, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s, status: %s", m.Edition, resp.Status)
+	}
+
+	tmp := m.Path + ".tmp"
+	if err := extractDatabase(resp.Body, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	db, err := Open(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// The mapping survives the rename since it refers to the same inode.
+	if err := os.Rename(tmp, m.Path); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to replace %s, err: %w", m.Path, err)
+	}
+
+	m.swap(db)
+
+	return nil
+}
+
+// Loaded reports whether a database is available for lookups.
+func (m *Manager) Loaded() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.db != nil
+}
+
+// Country returns the ISO country code for ip, or "" when GeoIP is disabled
+// or the address is unknown.
+func (m *Manager) Country(ip string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.db == nil {
+		return ""
+	}
+
+	lookups.Inc()
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		lookupFailures.Inc()
+		return ""
+	}
+
+	country, err := m.db.Country(parsed)
+	if err != nil {
+		lookupFailures.Inc()
+		return ""
+	}
+
+	return country
+}
+
+func (m *Manager) swap(db *DB) {
+	m.mu.Lock()
+	old := m.db
+	m.db = db
+	m.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+}
+
+func extractDatabase(r io.Reader, dst string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read archive, err: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return errNoDatabaseInArchive
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive, err: %w", err)
+		}
+		if !strings.HasSuffix(hdr.Name, ".mmdb") {
+			continue
+		}
+
+		f, err := os.Create(dst)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return err
+		}
+
+		return f.Close()
+	}
+}
@@ -0,0 +1,373 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+)
+
+var (
+	errInvalidDatabase = errors.New("invalid MaxMind database")
+	errIPv6InIPv4DB    = errors.New("cannot look up an IPv6 address in an IPv4-only database")
+)
+
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// DB is a MaxMind DB (mmdb) file opened for lookups. The file contents are
+// memory-mapped where the platform allows it.
+type DB struct {
+	data       []byte
+	tree       []byte
+	section    []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+	release    func() error
+}
+
+// Open memory-maps the database at path and parses its metadata.
+func Open(path string) (*DB, error) {
+	data, release, err := mmapFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s, err: %w", path, err)
+	}
+
+	db, err := newDB(data)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("failed to parse %s, err: %w", path, err)
+	}
+	db.release = release
+
+	return db, nil
+}
+
+func newDB(data []byte) (*DB, error) {
+	start := bytes.LastIndex(data, metadataMarker)
+	if start == -1 {
+		return nil, errInvalidDatabase
+	}
+
+	metaSection := data[start+len(metadataMarker):]
+	meta, _, err := decoder{metaSection}.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := meta.(map[string]any)
+	if !ok {
+		return nil, errInvalidDatabase
+	}
+
+	db := &DB{
+		data:       data,
+		nodeCount:  toUint(fields["node_count"]),
+		recordSize: toUint(fields["record_size"]),
+		ipVersion:  toUint(fields["ip_version"]),
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+
+	treeSize := db.recordSize * 2 / 8 * db.nodeCount
+	if treeSize+16 > uint(start) {
+		return nil, errInvalidDatabase
+	}
+	db.tree = data[:treeSize]
+	db.section = data[treeSize+16 : start]
+
+	// IPv4 addresses live under ::/96 in IPv6 databases.
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+
+	return db, nil
+}
+
+// Close releases the underlying memory mapping.
+func (db *DB) Close() error {
+	if db.release == nil {
+		return nil
+	}
+
+	return db.release()
+}
+
+// Lookup returns the record stored for ip, or nil when the address is not
+// in the database.
+func (db *DB) Lookup(ip net.IP) (map[string]any, error) {
+	node, bits, err := db.startNode(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-uint(i%8))) & 1
+		node = db.record(node, bit)
+	}
+
+	switch {
+	case node == db.nodeCount:
+		return nil, nil
+	case node < db.nodeCount:
+		return nil, errInvalidDatabase
+	}
+
+	offset := node - db.nodeCount - 16
+	if offset >= uint(len(db.section)) {
+		return nil, errInvalidDatabase
+	}
+
+	value, _, err := decoder{db.section}.decode(offset)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]any)
+
+	return record, nil
+}
+
+// Country returns the ISO 3166 country code of ip, or "" when unknown.
+func (db *DB) Country(ip net.IP) (string, error) {
+	record, err := db.Lookup(ip)
+	if err != nil || record == nil {
+		return "", err
+	}
+
+	for _, field := range []string{"country", "registered_country"} {
+		if country, ok := record[field].(map[string]any); ok {
+			if code, ok := country["iso_code"].(string); ok {
+				return code, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+func (db *DB) startNode(ip net.IP) (uint, []byte, error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		if db.ipVersion == 6 {
+			return db.ipv4Start, ip4, nil
+		}
+		return 0, ip4, nil
+	}
+
+	if db.ipVersion == 4 {
+		return 0, nil, errIPv6InIPv4DB
+	}
+
+	return 0, ip.To16(), nil
+}
+
+func (db *DB) record(node, bit uint) uint {
+	switch db.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		b := db.tree[off : off+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := db.tree[node*7 : node*7+7]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(db.tree[off : off+4]))
+	}
+}
+
+// decoder reads values from the data section format described in the
+// MaxMind DB specification.
+type decoder struct {
+	buf []byte
+}
+
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+func (d decoder) decode(offset uint) (any, uint, error) {
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, errInvalidDatabase
+	}
+
+	ctrl := d.buf[offset]
+	offset++
+	kind := uint(ctrl >> 5)
+
+	if kind == typePointer {
+		pointer, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer)
+		return value, next, err
+	}
+
+	if kind == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, errInvalidDatabase
+		}
+		kind = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size, offset, err := d.size(ctrl, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch kind {
+	case typeMap:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			var key, value any
+			if key, offset, err = d.decode(offset); err != nil {
+				return nil, 0, err
+			}
+			if value, offset, err = d.decode(offset); err != nil {
+				return nil, 0, err
+			}
+			k, _ := key.(string)
+			m[k] = value
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			var value any
+			if value, offset, err = d.decode(offset); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errInvalidDatabase
+	}
+	b := d.buf[offset : offset+size]
+	offset += size
+
+	switch kind {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return append([]byte(nil), b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errInvalidDatabase
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errInvalidDatabase
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	case typeInt32:
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int32(v), offset, nil
+	case typeUint128:
+		// Only used for very large counters, which we never read.
+		return append([]byte(nil), b...), offset, nil
+	}
+
+	return nil, 0, fmt.Errorf("unknown data type %d", kind)
+}
+
+func (d decoder) size(ctrl byte, offset uint) (uint, uint, error) {
+	size := uint(ctrl & 0x1f)
+	if size < 29 {
+		return size, offset, nil
+	}
+
+	n := size - 28
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errInvalidDatabase
+	}
+
+	var v uint
+	for _, c := range d.buf[offset : offset+n] {
+		v = v<<8 | uint(c)
+	}
+
+	switch size {
+	case 29:
+		return 29 + v, offset + n, nil
+	case 30:
+		return 285 + v, offset + n, nil
+	default:
+		return 65821 + v, offset + n, nil
+	}
+}
+
+func (d decoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	n := uint((ctrl>>3)&0x3) + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errInvalidDatabase
+	}
+
+	var v uint
+	if n < 4 {
+		v = uint(ctrl & 0x7)
+	}
+	for _, c := range d.buf[offset : offset+n] {
+		v = v<<8 | uint(c)
+	}
+
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+
+	return v, offset + n, nil
+}
+
+func toUint(v any) uint {
+	switch n := v.(type) {
+	case uint64:
+		return uint(n)
+	case int32:
+		return uint(n)
+	}
+
+	return 0
+}
@@ -0,0 +1,385 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Request is the body of a GraphQL request.
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// Response is the body of a GraphQL response. Data is omitted when the
+// request could not be executed at all.
+type Response struct {
+	Data   any     `json:"data,omitempty"`
+	Errors []Error `json:"errors,omitempty"`
+}
+
+// Error is a request or field error. Path locates the field that failed.
+type Error struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+// Resolver returns the value of a field given its arguments, with
+// variables substituted and enums as strings. Objects are returned as
+// structs or maps, which are projected on the selection through their JSON
+// form; lists as slices. A map entry may itself be a Resolver, for fields
+// that are only worth computing when selected.
+type Resolver func(args map[string]any) (any, error)
+
+// Schema holds the root fields of the query and mutation types.
+type Schema struct {
+	Query    map[string]Resolver
+	Mutation map[string]Resolver
+}
+
+// Execute runs the operation of req. Introspection is not supported.
+func (s *Schema) Execute(req Request) Response {
+	doc, err := Parse(req.Query)
+	if err != nil {
+		return Response{Errors: []Error{{Message: err.Error()}}}
+	}
+
+	op, err := pick(doc, req.OperationName)
+	if err != nil {
+		return Response{Errors: []Error{{Message: err.Error()}}}
+	}
+
+	vars, err := variables(op, req.Variables)
+	if err != nil {
+		return Response{Errors: []Error{{Message: err.Error()}}}
+	}
+
+	roots := s.Query
+	if op.Type == "mutation" {
+		roots = s.Mutation
+	}
+
+	e := &executor{doc: doc, vars: vars}
+	data := e.selectRoot(roots, op.Selections)
+
+	return Response{Data: data, Errors: e.errors}
+}
+
+func pick(doc *Document, name string) (*Operation, error) {
+	if name == "" {
+		if len(doc.Operations) != 1 {
+			return nil, errors.New("operationName is required when the document has several operations")
+		}
+		return doc.Operations[0], nil
+	}
+
+	for _, op := range doc.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+func variables(op *Operation, given map[string]any) (map[string]any, error) {
+	vars := map[string]any{}
+	for _, def := range op.Variables {
+		v, ok := given[def.Name]
+		if !ok && def.HasValue {
+			v, ok = resolveValue(def.Default, nil), true
+		}
+		if def.NonNull && (!ok || v == nil) {
+			return nil, fmt.Errorf("variable $%s is required", def.Name)
+		}
+		vars[def.Name] = v
+	}
+
+	return vars, nil
+}
+
+// resolveValue turns a parsed value into plain Go values, substituting
+// variables.
+func resolveValue(v Value, vars map[string]any) any {
+	switch v := v.(type) {
+	case Variable:
+		return vars[string(v)]
+	case Enum:
+		return string(v)
+	case []Value:
+		list := make([]any, len(v))
+		for i := range v {
+			list[i] = resolveValue(v[i], vars)
+		}
+		return list
+	case map[string]Value:
+		obj := make(map[string]any, len(v))
+		for k := range v {
+			obj[k] = resolveValue(v[k], vars)
+		}
+		return obj
+	}
+
+	return v
+}
+
+type executor struct {
+	doc    *Document
+	vars   map[string]any
+	errors []Error
+}
+
+func (e *executor) fail(path []any, err error) {
+	e.errors = append(e.errors, Error{Message: err.Error(), Path: append([]any(nil), path...)})
+}
+
+func (e *executor) selectRoot(roots map[string]Resolver, sel []Selection) *object {
+	out := &object{}
+	for _, f := range e.collect(sel) {
+		key := f.responseKey()
+		path := []any{key}
+
+		resolve, ok := roots[f.Name]
+		if !ok {
+			e.fail(path, fmt.Errorf("cannot query field %q", f.Name))
+			out.set(key, nil)
+			continue
+		}
+
+		v, err := resolve(e.args(f))
+		if err != nil {
+			e.fail(path, err)
+			out.set(key, nil)
+			continue
+		}
+		out.set(key, e.complete(path, f, v))
+	}
+
+	return out
+}
+
+// complete projects v on the selection of f.
+func (e *executor) complete(path []any, f *Field, v any) any {
+	if v == nil {
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+	}
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+		list := make([]any, rv.Len())
+		for i := range list {
+			list[i] = e.complete(append(path, i), f, rv.Index(i).Interface())
+		}
+		return list
+	}
+
+	if len(f.Selections) == 0 {
+		if isObject(rv) {
+			e.fail(path, fmt.Errorf("field %q must have a selection", f.Name))
+			return nil
+		}
+		return v
+	}
+
+	fields, err := toFields(v)
+	if err != nil {
+		e.fail(path, err)
+		return nil
+	}
+
+	out := &object{}
+	for _, sub := range e.collect(f.Selections) {
+		key := sub.responseKey()
+		subPath := append(append([]any(nil), path...), key)
+
+		value, ok := lookup(fields, sub.Name)
+		if !ok {
+			e.fail(subPath, fmt.Errorf("cannot query field %q", sub.Name))
+			out.set(key, nil)
+			continue
+		}
+		if resolve, ok := value.(Resolver); ok {
+			if value, err = resolve(e.args(sub)); err != nil {
+				e.fail(subPath, err)
+				out.set(key, nil)
+				continue
+			}
+		}
+		out.set(key, e.complete(subPath, sub, value))
+	}
+
+	return out
+}
+
+func (e *executor) args(f *Field) map[string]any {
+	args := make(map[string]any, len(f.Arguments))
+	for k, v := range f.Arguments {
+		args[k] = resolveValue(v, e.vars)
+	}
+
+	return args
+}
+
+// collect flattens fragments into the fields of a selection set, merging
+// fields requested twice under the same key.
+func (e *executor) collect(sel []Selection) []*Field {
+	var fields []*Field
+	seen := map[string]*Field{}
+
+	var walk func([]Selection, map[string]bool)
+	walk = func(sel []Selection, visited map[string]bool) {
+		for _, s := range sel {
+			if !e.included(s.directives()) {
+				continue
+			}
+
+			switch s := s.(type) {
+			case *Field:
+				if prev, ok := seen[s.responseKey()]; ok {
+					merged := *prev
+					merged.Selections = append(append([]Selection(nil), prev.Selections...), s.Selections...)
+					*prev = merged
+					continue
+				}
+				f := *s
+				seen[s.responseKey()] = &f
+				fields = append(fields, &f)
+			case *InlineFragment:
+				walk(s.Selections, visited)
+			case *FragmentSpread:
+				frag, ok := e.doc.Fragments[s.Name]
+				if !ok || visited[s.Name] {
+					continue
+				}
+				visited[s.Name] = true
+				walk(frag.Selections, visited)
+				delete(visited, s.Name)
+			}
+		}
+	}
+	walk(sel, map[string]bool{})
+
+	return fields
+}
+
+func (e *executor) included(dirs []Directive) bool {
+	for _, d := range dirs {
+		cond, _ := resolveValue(d.Arguments["if"], e.vars).(bool)
+		if d.Name == "skip" && cond || d.Name == "include" && !cond {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (f *Field) responseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+
+	return f.Name
+}
+
+func isObject(rv reflect.Value) bool {
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+
+	return rv.Kind() == reflect.Struct || rv.Kind() == reflect.Map
+}
+
+// toFields returns the fields of an object value keyed by JSON name.
+func toFields(v any) (map[string]any, error) {
+	if m, ok := v.(map[string]any); ok {
+		return m, nil
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("value is not an object")
+	}
+
+	return m, nil
+}
+
+// lookup finds a field by its GraphQL name, falling back to the snake case
+// JSON name the REST API uses, so createdAt selects created_at.
+func lookup(fields map[string]any, name string) (any, bool) {
+	if v, ok := fields[name]; ok {
+		return v, true
+	}
+	v, ok := fields[snakeCase(name)]
+
+	return v, ok
+}
+
+func snakeCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// object keeps response fields in the order they were selected.
+type object struct {
+	keys   []string
+	values map[string]any
+}
+
+func (o *object) set(key string, v any) {
+	if o.values == nil {
+		o.values = map[string]any{}
+	}
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+// MarshalJSON implements json.Marshaler.
+func (o *object) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		b.Write(key)
+		b.WriteByte(':')
+		v, err := json.Marshal(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,646 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Document is a parsed request document.
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+// Operation is a query or mutation of a document.
+type Operation struct {
+	Type       string
+	Name       string
+	Variables  []VariableDefinition
+	Selections []Selection
+}
+
+// VariableDefinition declares a variable of an operation. Types are not
+// checked beyond non-null markers, values are coerced by the resolvers.
+type VariableDefinition struct {
+	Name     string
+	NonNull  bool
+	Default  Value
+	HasValue bool
+}
+
+// Fragment is a named fragment, spread into selections with ...Name.
+type Fragment struct {
+	Name       string
+	Selections []Selection
+}
+
+// Selection is a *Field, a *FragmentSpread or an *InlineFragment.
+type Selection interface {
+	directives() []Directive
+}
+
+// Field selects a field, optionally under an alias.
+type Field struct {
+	Alias      string
+	Name       string
+	Arguments  map[string]Value
+	Directives []Directive
+	Selections []Selection
+}
+
+// FragmentSpread includes a named fragment.
+type FragmentSpread struct {
+	Name       string
+	Directives []Directive
+}
+
+// InlineFragment groups selections, typically to apply a directive.
+type InlineFragment struct {
+	Directives []Directive
+	Selections []Selection
+}
+
+// Directive is an @name(args) annotation, only @skip and @include have
+// an effect.
+type Directive struct {
+	Name      string
+	Arguments map[string]Value
+}
+
+func (f *Field) directives() []Directive          { return f.Directives }
+func (f *FragmentSpread) directives() []Directive { return f.Directives }
+func (f *InlineFragment) directives() []Directive { return f.Directives }
+
+// Value is an argument value: nil, bool, int64, float64, string, Enum,
+// Variable, []Value or map[string]Value.
+type Value any
+
+// Enum is an enum literal such as DESC.
+type Enum string
+
+// Variable references an operation variable.
+type Variable string
+
+// Parse parses a request document.
+func Parse(src string) (*Document, error) {
+	p := &parser{lex: lexer{src: strings.TrimPrefix(src, "\ufeff")}}
+	p.next()
+
+	doc := &Document{Fragments: map[string]*Fragment{}}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.tok.is(tokPunct, "{"):
+			sel, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, &Operation{Type: "query", Selections: sel})
+		case p.tok.is(tokName, "query"), p.tok.is(tokName, "mutation"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		case p.tok.is(tokName, "fragment"):
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			doc.Fragments[f.Name] = f
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	return doc, nil
+}
+
+type parser struct {
+	lex lexer
+	tok token
+	err error
+}
+
+func (p *parser) next() {
+	if p.err != nil {
+		p.tok = token{kind: tokEOF}
+		return
+	}
+	p.tok, p.err = p.lex.next()
+}
+
+func (p *parser) unexpected() error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.tok.kind == tokEOF {
+		return fmt.Errorf("syntax error: unexpected end of document")
+	}
+
+	return fmt.Errorf("syntax error: unexpected %q at offset %d", p.tok.text, p.tok.pos)
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	if !p.tok.is(kind, text) {
+		return p.unexpected()
+	}
+	p.next()
+
+	return nil
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.unexpected()
+	}
+	name := p.tok.text
+	p.next()
+
+	return name, nil
+}
+
+func (p *parser) operation() (*Operation, error) {
+	op := &Operation{Type: p.tok.text}
+	p.next()
+
+	if p.tok.kind == tokName {
+		op.Name = p.tok.text
+		p.next()
+	}
+
+	if p.tok.is(tokPunct, "(") {
+		p.next()
+		for !p.tok.is(tokPunct, ")") {
+			def, err := p.variableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			op.Variables = append(op.Variables, def)
+		}
+		p.next()
+	}
+
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+
+	sel, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.Selections = sel
+
+	return op, nil
+}
+
+func (p *parser) variableDefinition() (VariableDefinition, error) {
+	var def VariableDefinition
+	if err := p.expect(tokPunct, "$"); err != nil {
+		return def, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return def, err
+	}
+	def.Name = name
+
+	if err := p.expect(tokPunct, ":"); err != nil {
+		return def, err
+	}
+	if def.NonNull, err = p.typeRef(); err != nil {
+		return def, err
+	}
+
+	if p.tok.is(tokPunct, "=") {
+		p.next()
+		if def.Default, err = p.value(true); err != nil {
+			return def, err
+		}
+		def.HasValue = true
+	}
+
+	return def, nil
+}
+
+// typeRef skips a type such as [String!]!, reporting whether it is non-null.
+func (p *parser) typeRef() (bool, error) {
+	if p.tok.is(tokPunct, "[") {
+		p.next()
+		if _, err := p.typeRef(); err != nil {
+			return false, err
+		}
+		if err := p.expect(tokPunct, "]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.name(); err != nil {
+		return false, err
+	}
+
+	if p.tok.is(tokPunct, "!") {
+		p.next()
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (p *parser) fragment() (*Fragment, error) {
+	p.next()
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokName, "on"); err != nil {
+		return nil, err
+	}
+	if _, err := p.name(); err != nil {
+		return nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+
+	sel, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Fragment{Name: name, Selections: sel}, nil
+}
+
+func (p *parser) selectionSet() ([]Selection, error) {
+	if err := p.expect(tokPunct, "{"); err != nil {
+		return nil, err
+	}
+
+	var sel []Selection
+	for !p.tok.is(tokPunct, "}") {
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, s)
+	}
+	p.next()
+
+	if len(sel) == 0 {
+		return nil, fmt.Errorf("syntax error: empty selection set")
+	}
+
+	return sel, nil
+}
+
+func (p *parser) selection() (Selection, error) {
+	if p.tok.is(tokPunct, "...") {
+		p.next()
+
+		if p.tok.kind == tokName && p.tok.text != "on" {
+			name := p.tok.text
+			p.next()
+			dirs, err := p.directives()
+			if err != nil {
+				return nil, err
+			}
+			return &FragmentSpread{Name: name, Directives: dirs}, nil
+		}
+
+		if p.tok.is(tokName, "on") {
+			p.next()
+			if _, err := p.name(); err != nil {
+				return nil, err
+			}
+		}
+		dirs, err := p.directives()
+		if err != nil {
+			return nil, err
+		}
+		sel, err := p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+		return &InlineFragment{Directives: dirs, Selections: sel}, nil
+	}
+
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	f := &Field{Name: name}
+
+	if p.tok.is(tokPunct, ":") {
+		p.next()
+		f.Alias = name
+		if f.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Arguments, err = p.arguments(); err != nil {
+		return nil, err
+	}
+	if f.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.is(tokPunct, "{") {
+		if f.Selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+func (p *parser) arguments() (map[string]Value, error) {
+	if !p.tok.is(tokPunct, "(") {
+		return nil, nil
+	}
+	p.next()
+
+	args := map[string]Value{}
+	for !p.tok.is(tokPunct, ")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokPunct, ":"); err != nil {
+			return nil, err
+		}
+		if args[name], err = p.value(false); err != nil {
+			return nil, err
+		}
+	}
+	p.next()
+
+	return args, nil
+}
+
+func (p *parser) directives() ([]Directive, error) {
+	var dirs []Directive
+	for p.tok.is(tokPunct, "@") {
+		p.next()
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, Directive{Name: name, Arguments: args})
+	}
+
+	return dirs, nil
+}
+
+func (p *parser) value(constant bool) (Value, error) {
+	tok := p.tok
+
+	switch tok.kind {
+	case tokPunct:
+		switch tok.text {
+		case "$":
+			if constant {
+				return nil, p.unexpected()
+			}
+			p.next()
+			name, err := p.name()
+			return Variable(name), err
+		case "[":
+			p.next()
+			list := []Value{}
+			for !p.tok.is(tokPunct, "]") {
+				v, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			p.next()
+			return list, nil
+		case "{":
+			p.next()
+			obj := map[string]Value{}
+			for !p.tok.is(tokPunct, "}") {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(tokPunct, ":"); err != nil {
+					return nil, err
+				}
+				if obj[name], err = p.value(constant); err != nil {
+					return nil, err
+				}
+			}
+			p.next()
+			return obj, nil
+		}
+	case tokInt:
+		p.next()
+		return strconv.ParseInt(tok.text, 10, 64)
+	case tokFloat:
+		p.next()
+		return strconv.ParseFloat(tok.text, 64)
+	case tokString:
+		p.next()
+		return tok.text, nil
+	case tokName:
+		p.next()
+		switch tok.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return Enum(tok.text), nil
+	}
+
+	return nil, p.unexpected()
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) next() (token, error) {
+	// Whitespace, commas and comments are insignificant.
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			l.pos++
+			continue
+		}
+		if c == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+			continue
+		}
+		break
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: l.pos}, nil
+	}
+
+	start := l.pos
+	c := l.src[l.pos]
+
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return token{kind: tokPunct, text: "...", pos: start}, nil
+	case strings.IndexByte("!$()&:=@[]{}|", c) >= 0:
+		l.pos++
+		return token{kind: tokPunct, text: string(c), pos: start}, nil
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokName, text: l.src[start:l.pos], pos: start}, nil
+	case c == '-' || isDigit(c):
+		return l.number()
+	case c == '"':
+		return l.string()
+	}
+
+	return token{}, fmt.Errorf("syntax error: unexpected character %q at offset %d", c, start)
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	kind := tokInt
+
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	digits := func() int {
+		n := 0
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+			n++
+		}
+		return n
+	}
+	if digits() == 0 {
+		return token{}, fmt.Errorf("syntax error: invalid number at offset %d", start)
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		l.pos++
+		kind = tokFloat
+		if digits() == 0 {
+			return token{}, fmt.Errorf("syntax error: invalid number at offset %d", start)
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		l.pos++
+		kind = tokFloat
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if digits() == 0 {
+			return token{}, fmt.Errorf("syntax error: invalid number at offset %d", start)
+		}
+	}
+
+	return token{kind: kind, text: l.src[start:l.pos], pos: start}, nil
+}
+
+func (l *lexer) string() (token, error) {
+	start := l.pos
+
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		end := strings.Index(l.src[l.pos+3:], `"""`)
+		if end < 0 {
+			return token{}, fmt.Errorf("syntax error: unterminated string at offset %d", start)
+		}
+		text := l.src[l.pos+3 : l.pos+3+end]
+		l.pos += end + 6
+		return token{kind: tokString, text: strings.TrimSpace(text), pos: start}, nil
+	}
+
+	l.pos++
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return token{kind: tokString, text: b.String(), pos: start}, nil
+		case c == '\n':
+			return token{}, fmt.Errorf("syntax error: unterminated string at offset %d", start)
+		case c == '\\' && l.pos+1 < len(l.src):
+			l.pos++
+			switch e := l.src[l.pos]; e {
+			case '"', '\\', '/':
+				b.WriteByte(e)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.pos+5 > len(l.src) {
+					return token{}, fmt.Errorf("syntax error: invalid escape at offset %d", l.pos)
+				}
+				r, err := strconv.ParseUint(l.src[l.pos+1:l.pos+5], 16, 32)
+				if err != nil {
+					return token{}, fmt.Errorf("syntax error: invalid escape at offset %d", l.pos)
+				}
+				b.WriteRune(rune(r))
+				l.pos += 4
+			default:
+				return token{}, fmt.Errorf("syntax error: invalid escape at offset %d", l.pos)
+			}
+			l.pos++
+		default:
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			b.WriteRune(r)
+			l.pos += size
+		}
+	}
+
+	return token{}, fmt.Errorf("syntax error: unterminated string at offset %d", start)
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,107 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/metrics"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// EvictionCheck is the name of the eviction policy check.
+const EvictionCheck = "redis_eviction"
+
+var (
+	evictionRisk = metrics.NewGauge("redis_eviction_risk",
+		"Risk of redis evicting link keys: 0 none, 1 keys with a TTL, 2 any key.")
+	memoryRatio = metrics.NewGauge("redis_memory_used_ratio",
+		"used_memory divided by maxmemory, 0 when maxmemory is unset.")
+)
+
+// CheckEviction inspects maxmemory-policy and memory usage. Silently
+// evicted links are the worst failure a shortener can have, so any policy
+// other than noeviction is reported: allkeys-* policies may evict every
+// link, volatile-* ones the links created with an expiry.
+func CheckEviction(rClient database.ClientInterface) (Check, error) {
+	var policy []string
+	if err := rClient.Do(radix.Cmd(&policy, "CONFIG", "GET", "maxmemory-policy")); err != nil {
+		return Check{}, err
+	}
+	if len(policy) != 2 {
+		return Check{}, fmt.Errorf("unexpected CONFIG GET reply %v", policy)
+	}
+
+	info, err := database.Info(rClient, "memory")
+	if err != nil {
+		return Check{}, err
+	}
+
+	used, _ := strconv.ParseFloat(info["used_memory"], 64)
+	maxMemory, _ := strconv.ParseFloat(info["maxmemory"], 64)
+	ratio := 0.0
+	if maxMemory > 0 {
+		ratio = used / maxMemory
+	}
+	memoryRatio.Set(ratio)
+
+	check := Check{Name: EvictionCheck, Status: StatusOK, Message: "maxmemory-policy is " + policy[1]}
+	switch {
+	case strings.HasPrefix(policy[1], "allkeys-"):
+		evictionRisk.Set(2)
+		check.Status = StatusCritical
+		check.Message = "maxmemory-policy " + policy[1] + " can evict any link"
+	case strings.HasPrefix(policy[1], "volatile-"):
+		evictionRisk.Set(1)
+		check.Status = StatusWarning
+		check.Message = "maxmemory-policy " + policy[1] + " can evict links with an expiry"
+	default:
+		evictionRisk.Set(0)
+	}
+
+	if maxMemory > 0 && ratio > 0.9 && check.Status == StatusOK {
+		check.Status = StatusWarning
+		check.Message = fmt.Sprintf("redis memory is %.0f%% full, writes will fail at maxmemory", ratio*100)
+	}
+
+	return check, nil
+}
+
+// MonitorEviction runs CheckEviction now and then every interval, logging
+// any policy that puts links at risk.
+func MonitorEviction(ctx context.Context, interval time.Duration) {
+	for {
+		runEvictionCheck()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func runEvictionCheck() {
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		Set(EvictionCheck, StatusWarning, "unable to connect to redis")
+		return
+	}
+	defer rClient.Close()
+
+	check, err := CheckEviction(rClient)
+	if err != nil {
+		Set(EvictionCheck, StatusWarning, "unable to inspect redis memory settings")
+		log.Printf("health: %v", err)
+		return
+	}
+
+	Set(check.Name, check.Status, check.Message)
+	if check.Status != StatusOK {
+		log.Printf("health: %s: %s", check.Status, check.Message)
+	}
+}
@@ -0,0 +1,63 @@
+package health
+
+import (
+	"sort"
+	"sync"
+)
+
+// Statuses of a check, from best to worst.
+const (
+	StatusOK       = "ok"
+	StatusWarning  = "warning"
+	StatusCritical = "critical"
+)
+
+// Check is the latest outcome of a named health check.
+type Check struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+var (
+	mu     sync.RWMutex
+	checks = map[string]Check{}
+)
+
+// Set records the outcome of a check.
+func Set(name, status, message string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	checks[name] = Check{Name: name, Status: status, Message: message}
+}
+
+// Snapshot returns all checks sorted by name and the overall status, which
+// is the worst status of any check.
+func Snapshot() ([]Check, string) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	all := make([]Check, 0, len(checks))
+	overall := StatusOK
+	for _, check := range checks {
+		all = append(all, check)
+		if rank(check.Status) > rank(overall) {
+			overall = check.Status
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+
+	return all, overall
+}
+
+func rank(status string) int {
+	switch status {
+	case StatusWarning:
+		return 1
+	case StatusCritical:
+		return 2
+	}
+
+	return 0
+}
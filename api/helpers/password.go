@@ -0,0 +1,89 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+const passwordIterations = 100000
+
+// HashPassword derives a PBKDF2-SHA256 hash of password with a random salt,
+// encoded as "iterations$salt$hash".
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := pbkdf2([]byte(password), salt, passwordIterations)
+
+	return strconv.Itoa(passwordIterations) + "$" + hex.EncodeToString(salt) + "$" + hex.EncodeToString(key), nil
+}
+
+// CheckPassword reports whether password matches a hash from HashPassword.
+func CheckPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 3 {
+		return false
+	}
+
+	iterations, err := strconv.Atoi(parts[0])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	want, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(pbkdf2([]byte(password), salt, iterations), want)
+}
+
+// RandomToken returns n random bytes hex encoded.
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// pbkdf2 derives a single SHA-256 sized block, which is all we store.
+func pbkdf2(password, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, password)
+
+	prf.Write(salt)
+	prf.Write(binary.BigEndian.AppendUint32(nil, 1))
+	u := prf.Sum(nil)
+
+	out := make([]byte, len(u))
+	copy(out, u)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range out {
+			out[j] ^= u[j]
+		}
+	}
+
+	return out
+}
+
+// HashToken returns the hex SHA-256 of a high entropy token such as an API
+// key. Unlike passwords these don't need a slow, salted hash.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
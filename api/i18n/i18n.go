@@ -0,0 +1,150 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the language messages are written in, used when nothing in
+// Accept-Language is supported.
+const Default = "en"
+
+// Catalog maps messages, as written in the code in English, to their
+// translation. Messages may hold fmt verbs filled in by T.
+type Catalog map[string]string
+
+//go:embed locales/*.json
+var locales embed.FS
+
+var catalogs = map[string]Catalog{Default: {}}
+
+func init() {
+	entries, err := locales.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	for _, entry := range entries {
+		data, err := locales.ReadFile("locales/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+		if err := load(entry.Name(), data); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Register adds the messages of catalog to lang, replacing translations
+// already known. Call it before serving requests.
+func Register(lang string, catalog Catalog) {
+	lang = strings.ToLower(lang)
+	if catalogs[lang] == nil {
+		catalogs[lang] = Catalog{}
+	}
+	for msg, translation := range catalog {
+		catalogs[lang][msg] = translation
+	}
+}
+
+// LoadDir registers every "<lang>.json" catalog in dir, e.g. to add a
+// language or fix a translation without a rebuild.
+func LoadDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := load(filepath.Base(path), data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func load(name string, data []byte) error {
+	var catalog Catalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return fmt.Errorf("failed to parse catalog %s, err: %w", name, err)
+	}
+	Register(strings.TrimSuffix(name, ".json"), catalog)
+
+	return nil
+}
+
+// Languages returns the supported languages, sorted.
+func Languages() []string {
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+
+	return langs
+}
+
+// Match returns the supported language preferred by an Accept-Language
+// header. A region falls back to its language, "pt-BR" to "pt".
+func Match(acceptLanguage string) string {
+	type tag struct {
+		lang string
+		q    float64
+	}
+
+	var tags []tag
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		lang, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if lang != "" && q > 0 {
+			tags = append(tags, tag{strings.ToLower(lang), q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, t := range tags {
+		if _, ok := catalogs[t.lang]; ok {
+			return t.lang
+		}
+		base, _, _ := strings.Cut(t.lang, "-")
+		if _, ok := catalogs[base]; ok {
+			return base
+		}
+	}
+
+	return Default
+}
+
+// T translates msg to lang and formats it with args. Messages missing from
+// the catalog are used as they are.
+func T(lang, msg string, args ...any) string {
+	if translation, ok := catalogs[lang][msg]; ok {
+		msg = translation
+	}
+	if len(args) == 0 {
+		return msg
+	}
+
+	return fmt.Sprintf(msg, args...)
+}
+
+// Translated reports whether lang has a translation of msg.
+func Translated(lang, msg string) bool {
+	_, ok := catalogs[lang][msg]
+
+	return ok
+}
@@ -0,0 +1,72 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Func is the body of a scheduled job. It receives a client connected for
+// the duration of a single run.
+type Func func(ctx context.Context, rClient database.ClientInterface) error
+
+var instanceID = func() string {
+	host, _ := os.Hostname()
+	return host + "-" + strconv.Itoa(os.Getpid())
+}()
+
+// LockKey returns the key used to elect the instance running a job.
+func LockKey(name string) string {
+	return "lock:job:" + name
+}
+
+// Every runs fn once per interval until ctx is cancelled. Every instance of
+// the API schedules the job, but a run only happens on the instance that
+// wins the redis lock for that tick, so replicas don't duplicate the work.
+func Every(ctx context.Context, name string, interval time.Duration, fn Func) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := runOnce(ctx, name, interval, fn); err != nil {
+				log.Printf("job %s: %v", name, err)
+			}
+		}
+	}
+}
+
+func runOnce(ctx context.Context, name string, interval time.Duration, fn Func) error {
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return fmt.Errorf("failed to connect, err: %w", err)
+	}
+	defer rClient.Close()
+
+	// The lock expires slightly before the next tick so the current leader
+	// can keep the job while a crashed leader is replaced within a period.
+	ttl := interval - interval/10
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+
+	var ok radix.Maybe
+	err = rClient.Do(radix.Cmd(&ok, "SET", LockKey(name), instanceID, "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10)))
+	if err != nil {
+		return fmt.Errorf("failed to acquire lock, err: %w", err)
+	}
+	if ok.Null {
+		return nil
+	}
+
+	return fn(ctx, rClient)
+}
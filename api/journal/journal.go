@@ -0,0 +1,340 @@
+package journal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/metrics"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+var (
+	// ErrDisabled is returned by Append when JOURNAL_DIR is not set.
+	ErrDisabled = errors.New("journal is disabled")
+	// ErrFull is returned by Append past JOURNAL_MAX_PENDING writes.
+	ErrFull = errors.New("journal is full")
+)
+
+var (
+	appended = metrics.NewCounter("journal_appended_total", "Writes journaled while redis was unavailable.")
+	replayed = metrics.NewCounter("journal_replayed_total", "Journaled writes replayed into redis.")
+	failed   = metrics.NewCounter("journal_failed_total", "Journaled writes dropped because their replay failed.")
+)
+
+// Cmds is the type of the writes made of plain redis commands, replayed
+// as they are.
+const Cmds = "cmds"
+
+// Entry is a journaled write, one JSON object per line.
+type Entry struct {
+	Type string          `json:"type"`
+	At   int64           `json:"at"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Handler replays the data of a journaled write. Writes replayed more
+// than once, after a crash in the middle of a replay, must do no harm.
+type Handler func(ctx context.Context, rClient database.ClientInterface, data json.RawMessage) error
+
+// Config controls the journal.
+type Config struct {
+	// Dir is where the journal is written, it is off without it.
+	Dir string
+	// MaxPending is the number of writes a process journals before
+	// refusing more.
+	MaxPending int
+	// Interval is how often redis is checked for the journal to be
+	// replayed.
+	Interval time.Duration
+}
+
+// ConfigFromEnv reads JOURNAL_DIR, JOURNAL_MAX_PENDING, 10000 writes by
+// default, and JOURNAL_REPLAY_INTERVAL, 5 seconds by default.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Dir:        os.Getenv("JOURNAL_DIR"),
+		MaxPending: 10000,
+		Interval:   5 * time.Second,
+	}
+	if v, err := strconv.Atoi(os.Getenv("JOURNAL_MAX_PENDING")); err == nil && v > 0 {
+		cfg.MaxPending = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("JOURNAL_REPLAY_INTERVAL")); err == nil && v > 0 {
+		cfg.Interval = v
+	}
+
+	return cfg
+}
+
+// The journal is a directory of segments. Every process appends to its
+// own segment, named after the process id and ending in .ndjson.open, and
+// closes it by dropping the .open suffix once redis is back. Closed
+// segments are claimed by renaming them, so that a single process replays
+// each, and deleted once replayed.
+var (
+	mu       sync.Mutex
+	cfg      Config
+	handlers map[string]Handler
+	segment  *os.File
+	pending  int
+)
+
+// Start replays the journal every cfg.Interval until ctx is cancelled,
+// with handlers keyed by the type of the writes. It must be called before
+// the server starts, and does nothing when cfg.Dir is empty.
+//
+// With adopt, segments left open or half replayed by processes that
+// are gone are closed to be replayed again: it must only be set in the
+// process starting first, before any other appends.
+func Start(ctx context.Context, c Config, adopt bool, h map[string]Handler) error {
+	if c.Dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(c.Dir, 0o750); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+
+	cfg, handlers = c, map[string]Handler{Cmds: replayCmds}
+	for typ, handler := range h {
+		handlers[typ] = handler
+	}
+
+	if adopt {
+		names, err := filepath.Glob(filepath.Join(cfg.Dir, "journal-*"))
+		if err != nil {
+			return fmt.Errorf("journal: %w", err)
+		}
+		for _, name := range names {
+			if closed, ok := closedName(name); ok {
+				if err := os.Rename(name, closed); err != nil {
+					return fmt.Errorf("journal: %w", err)
+				}
+			} else if strings.HasSuffix(name, ".tmp") {
+				// The rest of a segment still claimed, replayed again.
+				os.Remove(name)
+			}
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := replay(ctx); err != nil {
+					log.Printf("journal: %v", err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// closedName returns the name of the closed segment of an open or
+// claimed one.
+func closedName(name string) (string, bool) {
+	if base, ok := strings.CutSuffix(name, ".open"); ok {
+		return base, true
+	}
+	if i := strings.Index(name, ".ndjson."); i >= 0 && strings.HasSuffix(name, ".replaying") {
+		return name[:i+len(".ndjson")], true
+	}
+
+	return name, false
+}
+
+// Enabled reports whether writes can be journaled.
+func Enabled() bool {
+	return cfg.Dir != ""
+}
+
+// Append journals a write of typ, its data being passed to the handler of
+// typ on replay. It returns once the write is synced to disk.
+func Append(typ string, data any) error {
+	if !Enabled() {
+		return ErrDisabled
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(Entry{Type: typ, At: clock.Now().Unix(), Data: raw})
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if pending >= cfg.MaxPending {
+		return ErrFull
+	}
+	if segment == nil {
+		name := fmt.Sprintf("journal-%d-%d.ndjson.open", os.Getpid(), clock.Now().UnixNano())
+		if segment, err = os.OpenFile(filepath.Join(cfg.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640); err != nil {
+			return fmt.Errorf("journal: %w", err)
+		}
+	}
+	if _, err := segment.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	if err := segment.Sync(); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	pending++
+	appended.Inc()
+
+	return nil
+}
+
+// AppendCmds journals redis commands, such as counter increments, each
+// given as its name and arguments.
+func AppendCmds(cmds ...[]string) error {
+	return Append(Cmds, cmds)
+}
+
+func replayCmds(ctx context.Context, rClient database.ClientInterface, data json.RawMessage) error {
+	var cmds [][]string
+	if err := json.Unmarshal(data, &cmds); err != nil {
+		return err
+	}
+
+	p := radix.NewPipeline()
+	for _, cmd := range cmds {
+		if len(cmd) > 0 {
+			p.Append(radix.Cmd(nil, cmd[0], cmd[1:]...))
+		}
+	}
+
+	return rClient.Do(p)
+}
+
+// replay closes the segment of this process and replays every closed
+// segment, once redis answers.
+func replay(ctx context.Context) error {
+	mu.Lock()
+	idle := segment == nil
+	mu.Unlock()
+
+	names, err := filepath.Glob(filepath.Join(cfg.Dir, "journal-*.ndjson"))
+	if err != nil {
+		return err
+	}
+	if idle && len(names) == 0 {
+		return nil
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return nil
+	}
+	if err := rClient.Do(radix.Cmd(nil, "PING")); err != nil {
+		return nil
+	}
+
+	if !idle {
+		mu.Lock()
+		name := segment.Name()
+		err := segment.Close()
+		segment, pending = nil, 0
+		mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(name, strings.TrimSuffix(name, ".open")); err != nil {
+			return err
+		}
+		names = append(names, strings.TrimSuffix(name, ".open"))
+	}
+
+	for _, name := range names {
+		claimed := fmt.Sprintf("%s.%d.replaying", name, os.Getpid())
+		if err := os.Rename(name, claimed); err != nil {
+			// Claimed by another process.
+			continue
+		}
+		if err := replaySegment(ctx, rClient, claimed); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replaySegment replays the writes of a claimed segment and deletes it.
+// When redis fails again, the writes left are saved in a new closed
+// segment for a later replay.
+func replaySegment(ctx context.Context, rClient database.ClientInterface, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var left []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		if left != nil {
+			left = append(left, scanner.Text())
+			continue
+		}
+
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// The last line of a segment cut short by a crash.
+			failed.Inc()
+			continue
+		}
+		handler, ok := handlers[e.Type]
+		if !ok {
+			failed.Inc()
+			log.Printf("journal: no handler for %s writes", e.Type)
+			continue
+		}
+
+		err := handler(ctx, rClient, e.Data)
+		switch {
+		case err == nil:
+			replayed.Inc()
+		case database.Unavailable(err) || ctx.Err() != nil:
+			left = []string{scanner.Text()}
+		default:
+			failed.Inc()
+			log.Printf("journal: dropped %s write from %s: %v", e.Type, time.Unix(e.At, 0).UTC().Format(time.RFC3339), err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if left != nil {
+		rest := filepath.Join(filepath.Dir(name), fmt.Sprintf("journal-%d-%d.ndjson", os.Getpid(), clock.Now().UnixNano()))
+		if err := os.WriteFile(rest+".tmp", []byte(strings.Join(left, "\n")+"\n"), 0o640); err != nil {
+			return err
+		}
+		if err := os.Rename(rest+".tmp", rest); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(name)
+}
@@ -0,0 +1,84 @@
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed = errors.New("malformed token")
+	ErrSignature = errors.New("invalid token signature")
+	ErrExpired   = errors.New("token expired")
+)
+
+// header is the only header issued and accepted, HS256 keeps the service
+// its own verifier.
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Claims are the registered claims used by the API, Subject being the
+// owner the token acts as.
+type Claims struct {
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub"`
+	Email     string `json:"email,omitempty"`
+	Name      string `json:"name,omitempty"`
+	ID        string `json:"jti,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Sign returns the HS256 token of claims.
+func Sign(claims Claims, secret []byte) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	return signed + "." + signature(signed, secret), nil
+}
+
+// Parse verifies the signature and expiry of token and returns its claims.
+func Parse(token string, secret []byte, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != header {
+		return nil, ErrMalformed
+	}
+
+	if !hmac.Equal([]byte(parts[2]), []byte(signature(parts[0]+"."+parts[1], secret))) {
+		return nil, ErrSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return nil, ErrMalformed
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrExpired
+	}
+
+	return &claims, nil
+}
+
+// Looks reports whether token is shaped like a JWT rather than an API key.
+func Looks(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func signature(signed string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
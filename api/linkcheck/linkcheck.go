@@ -0,0 +1,286 @@
+package linkcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/destination"
+	"github.com/ksarpe/redis-golang/jobs"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/mail"
+	"github.com/ksarpe/redis-golang/metrics"
+	"github.com/ksarpe/redis-golang/webhooks"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// UserAgent identifies the checker to destination servers and is the agent
+// matched against their robots.txt.
+const UserAgent = "redis-golang-linkcheck/1.0"
+
+const (
+	// StatusOK marks a destination that answered the last check.
+	StatusOK = "ok"
+
+	// StatusBroken marks a destination that answered 404/410 or did not
+	// answer at all.
+	StatusBroken = "broken"
+)
+
+var (
+	checked = metrics.NewCounter("linkcheck_checked_total", "Destinations checked by the link checker.")
+	broken  = metrics.NewCounter("linkcheck_broken_total", "Destinations found broken by the link checker.")
+	skipped = metrics.NewCounter("linkcheck_robots_skipped_total", "Destinations skipped because robots.txt disallows them.")
+)
+
+// CursorKey stores the SCAN cursor so consecutive runs walk the whole
+// keyspace instead of rechecking its start.
+const CursorKey = "linkcheck:cursor"
+
+// Config controls how many destinations are checked and how politely.
+type Config struct {
+	Interval     time.Duration
+	Batch        int
+	RecheckAfter time.Duration
+	Delay        time.Duration
+	Timeout      time.Duration
+	Notify       bool
+}
+
+// ConfigFromEnv reads the LINKCHECK_* environment variables.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Interval:     10 * time.Minute,
+		Batch:        200,
+		RecheckAfter: 24 * time.Hour,
+		Delay:        500 * time.Millisecond,
+		Timeout:      10 * time.Second,
+		Notify:       os.Getenv("LINKCHECK_NOTIFY") == "true",
+	}
+
+	if v, err := time.ParseDuration(os.Getenv("LINKCHECK_INTERVAL")); err == nil && v > 0 {
+		cfg.Interval = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("LINKCHECK_BATCH")); err == nil && v > 0 {
+		cfg.Batch = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("LINKCHECK_RECHECK_AFTER")); err == nil && v > 0 {
+		cfg.RecheckAfter = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("LINKCHECK_DELAY")); err == nil && v >= 0 {
+		cfg.Delay = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("LINKCHECK_TIMEOUT")); err == nil && v > 0 {
+		cfg.Timeout = v
+	}
+
+	return cfg
+}
+
+// Broken is the payload of the link.broken webhook.
+type Broken struct {
+	Short      string `json:"short"`
+	Owner      string `json:"owner,omitempty"`
+	URL        string `json:"url"`
+	HTTPStatus int    `json:"http_status,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Job returns the scheduled job checking up to cfg.Batch destinations per
+// run, one request every cfg.Delay at most.
+func Job(cfg Config, mailer mail.Mailer) jobs.Func {
+	return func(ctx context.Context, rClient database.ClientInterface) error {
+		// Stay within the period the job lock is held for.
+		ctx, cancel := context.WithTimeout(ctx, cfg.Interval-cfg.Interval/5)
+		defer cancel()
+
+		c := &checker{
+			cfg:     cfg,
+			mailer:  mailer,
+			rClient: rClient,
+			client: &http.Client{
+				Timeout:   cfg.Timeout,
+				Transport: destination.Transport(),
+				CheckRedirect: func(req *http.Request, via []*http.Request) error {
+					if len(via) >= 5 {
+						return http.ErrUseLastResponse
+					}
+					return nil
+				},
+			},
+			robots: map[string]*robots{},
+		}
+
+		return c.run(ctx)
+	}
+}
+
+type checker struct {
+	cfg     Config
+	mailer  mail.Mailer
+	rClient database.ClientInterface
+	client  *http.Client
+	robots  map[string]*robots
+}
+
+func (c *checker) run(ctx context.Context) error {
+	var cursor string
+	if err := c.rClient.Do(radix.Cmd(&cursor, "GET", CursorKey)); err != nil {
+		return err
+	}
+	if cursor == "" {
+		cursor = "0"
+	}
+
+	done := 0
+	for done < c.cfg.Batch {
+		var keys []string
+		err := c.rClient.Do(radix.Cmd(radix.Tuple{&cursor, &keys}, "SCAN", cursor, "MATCH", links.MetaKey("*"), "COUNT", "100"))
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err := ctx.Err(); err != nil {
+				return nil
+			}
+			ok, err := c.check(ctx, key[len(links.MetaKey("")):])
+			if err != nil {
+				return err
+			}
+			if ok {
+				done++
+			}
+		}
+
+		if err := c.rClient.Do(radix.Cmd(nil, "SET", CursorKey, cursor)); err != nil {
+			return err
+		}
+		if cursor == "0" {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// check verifies the destination of short unless it was checked recently,
+// reporting whether a request was made.
+func (c *checker) check(ctx context.Context, short string) (bool, error) {
+	var meta []string
+	err := c.rClient.Do(links.FieldsCmd(&meta, short, "url", "owner", "dest_status", "dest_checked_at"))
+	if err != nil {
+		return false, err
+	}
+	dest, owner, previous := meta[0], meta[1], meta[2]
+	checkedAt, _ := strconv.ParseInt(meta[3], 10, 64)
+	if dest == "" || time.Since(time.Unix(checkedAt, 0)) < c.cfg.RecheckAfter {
+		return false, nil
+	}
+
+	u, err := url.Parse(dest)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false, nil
+	}
+	if !c.allowed(ctx, u) {
+		skipped.Inc()
+		return false, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return false, nil
+	case <-time.After(c.cfg.Delay):
+	}
+
+	status, checkErr := c.probe(ctx, dest)
+	if ctx.Err() != nil {
+		// Cut short by the deadline, not the destination's fault.
+		return false, nil
+	}
+	checked.Inc()
+
+	result := StatusOK
+	if checkErr != nil || status == http.StatusNotFound || status == http.StatusGone {
+		result = StatusBroken
+	}
+
+	fields := []string{
+		"dest_status", result,
+		"dest_http_status", strconv.Itoa(status),
+		"dest_checked_at", strconv.FormatInt(time.Now().Unix(), 10),
+	}
+	if err := c.rClient.Do(links.WriteCmd(short, fields, nil)); err != nil {
+		return true, err
+	}
+
+	if result == StatusBroken && previous != StatusBroken {
+		broken.Inc()
+		event := Broken{Short: short, Owner: owner, URL: dest, HTTPStatus: status}
+		if checkErr != nil {
+			event.Error = checkErr.Error()
+		}
+		if err := c.notify(event); err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+// probe HEADs dest, falling back to GET for servers that don't allow HEAD.
+func (c *checker) probe(ctx context.Context, dest string) (int, error) {
+	status, err := c.request(ctx, http.MethodHead, dest)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		return c.request(ctx, http.MethodGet, dest)
+	}
+
+	return status, err
+}
+
+func (c *checker) request(ctx context.Context, method, dest string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, dest, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) && urlErr.Timeout() {
+			return 0, fmt.Errorf("timed out after %s", c.cfg.Timeout)
+		}
+		return 0, err
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+func (c *checker) notify(event Broken) error {
+	webhooks.Send("link.broken", event)
+
+	if !c.cfg.Notify || event.Owner == "" || !c.mailer.Enabled() {
+		return nil
+	}
+
+	var email string
+	if err := c.rClient.Do(radix.Cmd(&email, "HGET", links.UserKey(event.Owner), "email")); err != nil {
+		return err
+	}
+	if email == "" {
+		return nil
+	}
+
+	body := fmt.Sprintf("The destination of your short %s/%s looks broken:\n%s\n",
+		os.Getenv("DOMAIN"), event.Short, event.URL)
+
+	return c.mailer.Send(email, "Your short link "+event.Short+" points to a broken page", body)
+}
@@ -0,0 +1,131 @@
+package linkcheck
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxRobotsBytes bounds how much of a robots.txt is read.
+const maxRobotsBytes = 512 << 10
+
+// robots holds the rules of a robots.txt that apply to UserAgent.
+type robots struct {
+	allow    []string
+	disallow []string
+}
+
+// allowed reports whether robots.txt of u's host lets the checker fetch u.
+// Hosts are only asked once per run, a missing or unreadable robots.txt
+// allows everything.
+func (c *checker) allowed(ctx context.Context, u *url.URL) bool {
+	origin := u.Scheme + "://" + u.Host
+
+	r, ok := c.robots[origin]
+	if !ok {
+		r = c.fetchRobots(ctx, origin)
+		c.robots[origin] = r
+	}
+
+	return r.allows(u.EscapedPath())
+}
+
+func (c *checker) fetchRobots(ctx context.Context, origin string) *robots {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin+"/robots.txt", nil)
+	if err != nil {
+		return &robots{}
+	}
+	req.Header.Set("User-Agent", UserAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return &robots{}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &robots{}
+	}
+
+	return parseRobots(io.LimitReader(resp.Body, maxRobotsBytes))
+}
+
+// parseRobots keeps the rules of the groups naming our agent, or of the "*"
+// group when no group names it.
+func parseRobots(r io.Reader) *robots {
+	agent := strings.ToLower(UserAgent[:strings.Index(UserAgent, "/")])
+
+	var specific, wildcard robots
+	var inSpecific, inWildcard, sawRule, matchedSpecific bool
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		field, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		field = strings.ToLower(strings.TrimSpace(field))
+		value = strings.TrimSpace(value)
+
+		switch field {
+		case "user-agent":
+			// A user-agent line after rules starts a new group.
+			if sawRule {
+				inSpecific, inWildcard, sawRule = false, false, false
+			}
+			ua := strings.ToLower(value)
+			if ua == "*" {
+				inWildcard = true
+			} else if strings.Contains(agent, ua) {
+				inSpecific, matchedSpecific = true, true
+			}
+		case "allow", "disallow":
+			sawRule = true
+			if value == "" {
+				continue
+			}
+			for _, g := range []struct {
+				in    bool
+				rules *robots
+			}{{inSpecific, &specific}, {inWildcard, &wildcard}} {
+				if !g.in {
+					continue
+				}
+				if field == "allow" {
+					g.rules.allow = append(g.rules.allow, value)
+				} else {
+					g.rules.disallow = append(g.rules.disallow, value)
+				}
+			}
+		}
+	}
+
+	if matchedSpecific {
+		return &specific
+	}
+
+	return &wildcard
+}
+
+// allows applies the longest matching rule, Allow winning ties.
+func (r *robots) allows(path string) bool {
+	if path == "" {
+		path = "/"
+	}
+
+	longest := func(rules []string) int {
+		best := -1
+		for _, rule := range rules {
+			if strings.HasPrefix(path, strings.TrimSuffix(rule, "*")) && len(rule) > best {
+				best = len(rule)
+			}
+		}
+		return best
+	}
+
+	return longest(r.allow) >= longest(r.disallow)
+}
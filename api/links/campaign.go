@@ -0,0 +1,34 @@
+package links
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxCampaignLength is the maximum number of characters of a campaign
+// name.
+const MaxCampaignLength = 64
+
+var (
+	ErrCampaignRequired = errors.New("campaign name is required")
+	ErrCampaignTooLong  = errors.New("campaign name is too long")
+	ErrCampaignInvalid  = errors.New("campaign name cannot contain ':'")
+)
+
+// ValidateCampaign checks a campaign name. Names are part of the keys of
+// the campaign, a name such as "foo:links" would share the keys of the
+// campaign "foo".
+func ValidateCampaign(name string) error {
+	if name == "" {
+		return ErrCampaignRequired
+	}
+	if utf8.RuneCountInString(name) > MaxCampaignLength {
+		return ErrCampaignTooLong
+	}
+	if strings.Contains(name, ":") {
+		return ErrCampaignInvalid
+	}
+
+	return nil
+}
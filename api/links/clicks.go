@@ -0,0 +1,174 @@
+package links
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	radix "github.com/mediocregopher/radix/v4"
+	"github.com/mediocregopher/radix/v4/resp"
+	"github.com/mediocregopher/radix/v4/resp/resp3"
+)
+
+// ClickShardKey returns one of the sub-counters taking the clicks of a
+// short too hot for a single counter, merged into ClicksKey by
+// ConsolidateClicks.
+func ClickShardKey(short string, shard int) string {
+	return "clickshard:" + strconv.Itoa(shard) + ":" + short
+}
+
+type shardConfig struct {
+	// shards is the number of sub-counters of a hot short, sharding is off
+	// below 2.
+	shards int
+	// threshold is the clicks per second on a short, in one process, from
+	// which it is counted on sub-counters.
+	threshold int64
+}
+
+// shardSettings reads CLICK_SHARDS and CLICK_SHARD_THRESHOLD, 200 clicks
+// per second by default.
+var shardSettings = sync.OnceValue(func() shardConfig {
+	cfg := shardConfig{threshold: 200}
+	cfg.shards, _ = strconv.Atoi(os.Getenv("CLICK_SHARDS"))
+	if v, err := strconv.ParseInt(os.Getenv("CLICK_SHARD_THRESHOLD"), 10, 64); err == nil && v > 0 {
+		cfg.threshold = v
+	}
+
+	return cfg
+})
+
+// rate counts the clicks of the current second. Shorts share the rates of
+// their hash bucket, so that a flood of distinct shorts takes no memory: a
+// short sharing the bucket of a hot one is merely sharded too.
+type rate struct {
+	second atomic.Int64
+	clicks atomic.Int64
+}
+
+var rates [4096]rate
+
+// ClickCounterKey returns the key to count a click of short on: ClicksKey,
+// or a random sub-counter while short is hot.
+func ClickCounterKey(short string) string {
+	cfg := shardSettings()
+	if cfg.shards < 2 {
+		return ClicksKey(short)
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(short))
+	r := &rates[h.Sum32()%uint32(len(rates))]
+	now := time.Now().Unix()
+	if r.second.Swap(now) != now {
+		r.clicks.Store(0)
+	}
+	if r.clicks.Add(1) < cfg.threshold {
+		return ClicksKey(short)
+	}
+
+	return ClickShardKey(short, rand.IntN(cfg.shards))
+}
+
+// ClickKeys returns the counter of short followed by its sub-counters
+// when sharding is on, the keys deleted and expired with the short.
+func ClickKeys(short string) []string {
+	keys := []string{ClicksKey(short)}
+	if shards := shardSettings().shards; shards >= 2 {
+		for i := 0; i < shards; i++ {
+			keys = append(keys, ClickShardKey(short, i))
+		}
+	}
+
+	return keys
+}
+
+// ClicksCmd reads the clicks of short into rcv, summing its sub-counters
+// when sharding is on. Sub-counters beyond CLICK_SHARDS, left from a
+// larger setting, are only counted once consolidated.
+func ClicksCmd(rcv *int64, short string) radix.Action {
+	keys := ClickKeys(short)
+	if len(keys) == 1 {
+		return radix.Cmd(rcv, "GET", keys[0])
+	}
+
+	return radix.Cmd(clickSum{rcv: rcv}, "MGET", keys...)
+}
+
+// clickSum reads the counters of an MGET into their sum.
+type clickSum struct {
+	rcv *int64
+}
+
+func (s clickSum) UnmarshalRESP(br resp.BufferedReader, o *resp.Opts) error {
+	var counts []string
+	if err := resp3.Unmarshal(br, &counts, o); err != nil {
+		return err
+	}
+
+	*s.rcv = 0
+	for _, count := range counts {
+		n, _ := strconv.ParseInt(count, 10, 64)
+		*s.rcv += n
+	}
+
+	return nil
+}
+
+// consolidateScript moves a sub-counter into the counter of its short,
+// giving a new counter the TTL of the short. Sub-counters of shorts that
+// no longer exist are dropped.
+//
+// KEYS[1] sub-counter, KEYS[2] counter, KEYS[3] link record.
+var consolidateScript = radix.NewEvalScript(`
+local clicks = redis.call('GETDEL', KEYS[1])
+if not clicks or redis.call('EXISTS', KEYS[3]) == 0 then
+	return 0
+end
+local created = redis.call('EXISTS', KEYS[2]) == 0
+redis.call('INCRBY', KEYS[2], clicks)
+local ttl = redis.call('PTTL', KEYS[3])
+if created and ttl > 0 then
+	redis.call('PEXPIRE', KEYS[2], ttl)
+end
+return tonumber(clicks)
+`)
+
+// ConsolidateInterval returns how often ConsolidateClicks runs, every
+// CLICK_CONSOLIDATE_INTERVAL or 10 minutes, and whether it runs at all:
+// while sharding is on, or when the interval is set alone to merge the
+// sub-counters left after sharding was turned off.
+func ConsolidateInterval() (time.Duration, bool) {
+	interval, err := time.ParseDuration(os.Getenv("CLICK_CONSOLIDATE_INTERVAL"))
+	if err == nil && interval > 0 {
+		return interval, true
+	}
+
+	return 10 * time.Minute, shardSettings().shards >= 2
+}
+
+// ConsolidateClicks merges every sub-counter into the counter of its
+// short, so that hot shorts cooling down leave a single key behind. The
+// sub-counters of shorts whose record is gone are deleted, without
+// creating their counter again.
+func ConsolidateClicks(ctx context.Context, rClient database.ClientInterface) error {
+	return database.Scan(rClient, "clickshard:*", func(key string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		_, short, ok := strings.Cut(strings.TrimPrefix(key, "clickshard:"), ":")
+		if !ok {
+			return nil
+		}
+
+		return rClient.Do(consolidateScript.Cmd(nil, []string{key, ClicksKey(short), MetaKey(short)}))
+	})
+}
@@ -0,0 +1,115 @@
+package links
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mediocregopher/radix/v4/resp"
+	"github.com/mediocregopher/radix/v4/resp/resp3"
+)
+
+// deflated marks a destination stored DEFLATE compressed. Destinations are
+// http(s) URLs, which never start with a control character.
+const deflated = "\x01"
+
+type compressionConfig struct {
+	enabled bool
+	// min is the length from which destinations are compressed, shorter
+	// ones seldom get smaller.
+	min int
+}
+
+// urlCompression reads URL_COMPRESSION, "deflate" to compress the
+// destinations of at least URL_COMPRESSION_MIN bytes, 512 by default, for
+// the tracking URLs of several kilobytes some campaigns shorten. Snappy
+// and zstd would be cheaper but aren't in the standard library.
+var urlCompression = sync.OnceValue(func() compressionConfig {
+	cfg := compressionConfig{enabled: os.Getenv("URL_COMPRESSION") == "deflate", min: 512}
+	if v, err := strconv.Atoi(os.Getenv("URL_COMPRESSION_MIN")); err == nil && v > 0 {
+		cfg.min = v
+	}
+
+	return cfg
+})
+
+var inflaters = sync.Pool{New: func() any {
+	return flate.NewReader(nil)
+}}
+
+// EncodeURL returns dest as it is stored under "url", compressed when
+// enabled, long enough and made shorter by it.
+func EncodeURL(dest string) string {
+	cfg := urlCompression()
+	if !cfg.enabled || len(dest) < cfg.min {
+		return dest
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(deflated)
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	if _, err := io.WriteString(w, dest); err != nil || w.Close() != nil || buf.Len() >= len(dest) {
+		return dest
+	}
+
+	return buf.String()
+}
+
+// DecodeURL returns the destination stored as value, compressed or not,
+// whatever URL_COMPRESSION is now. A corrupt value reads as no destination.
+func DecodeURL(value string) string {
+	body, ok := strings.CutPrefix(value, deflated)
+	if !ok {
+		return value
+	}
+
+	r := inflaters.Get().(io.ReadCloser)
+	defer inflaters.Put(r)
+	if err := r.(flate.Resetter).Reset(strings.NewReader(body), nil); err != nil {
+		return ""
+	}
+	dest, err := io.ReadAll(r)
+	if err != nil {
+		return ""
+	}
+
+	return string(dest)
+}
+
+// decodedFields reads the fields of FieldsCmd, decoding the destination
+// when it is one of them.
+type decodedFields struct {
+	rcv *[]string
+	url int
+}
+
+func (d decodedFields) UnmarshalRESP(br resp.BufferedReader, o *resp.Opts) error {
+	if err := resp3.Unmarshal(br, d.rcv, o); err != nil {
+		return err
+	}
+	if d.url >= 0 && d.url < len(*d.rcv) {
+		(*d.rcv)[d.url] = DecodeURL((*d.rcv)[d.url])
+	}
+
+	return nil
+}
+
+// decodedRecord reads the record of RecordCmd, decoding the destination.
+type decodedRecord struct {
+	rcv *map[string]string
+}
+
+func (d decodedRecord) UnmarshalRESP(br resp.BufferedReader, o *resp.Opts) error {
+	if err := resp3.Unmarshal(br, d.rcv, o); err != nil {
+		return err
+	}
+	if url, ok := (*d.rcv)["url"]; ok {
+		(*d.rcv)["url"] = DecodeURL(url)
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package links_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ksarpe/redis-golang/links"
+)
+
+// trackingURL is the kind of destination URL_COMPRESSION is meant for.
+var trackingURL = "https://example.com/landing?utm_source=newsletter&utm_medium=email" +
+	strings.Repeat("&utm_content=spring-sale-banner-variant-b", 64)
+
+func BenchmarkEncodeURL(b *testing.B) {
+	b.Setenv("URL_COMPRESSION", "deflate")
+
+	for i := 0; i < b.N; i++ {
+		links.EncodeURL(trackingURL)
+	}
+}
+
+func BenchmarkDecodeURL(b *testing.B) {
+	b.Setenv("URL_COMPRESSION", "deflate")
+	value := links.EncodeURL(trackingURL)
+	if len(value) >= len(trackingURL) || links.DecodeURL(value) != trackingURL {
+		b.Fatal("DecodeURL(EncodeURL(url)) != url, or url left uncompressed")
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		links.DecodeURL(value)
+	}
+}
@@ -0,0 +1,36 @@
+package links
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ksarpe/redis-golang/clock"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// ExpiringKey returns the sorted set of shorts with a TTL, scored by the
+// unix time they expire at.
+func ExpiringKey() string {
+	return "links:expiring"
+}
+
+// ExtendTokenKey returns the one-click token extending a short from a
+// reminder email.
+func ExtendTokenKey(token string) string {
+	return "extend:" + token
+}
+
+// AppendExpire queues the commands expiring every key of short after ttl,
+// recording the time it expires at in its "expires_at" field, and indexing
+// the expiry for reminders. short must exist.
+func AppendExpire(p *radix.Pipeline, short string, ttl time.Duration) {
+	expiresAt := strconv.FormatInt(clock.Now().Add(ttl).Unix(), 10)
+	p.Append(WriteCmd(short, []string{"expires_at", expiresAt}, nil))
+
+	seconds := strconv.FormatInt(int64(ttl/time.Second), 10)
+	for _, key := range append([]string{MetaKey(short)}, ClickKeys(short)...) {
+		p.Append(radix.Cmd(nil, "EXPIRE", key, seconds))
+	}
+
+	p.Append(radix.Cmd(nil, "ZADD", ExpiringKey(), expiresAt, short))
+}
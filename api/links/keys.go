@@ -0,0 +1,325 @@
+package links
+
+import "strconv"
+
+// Redis key layout shared by the routes and background jobs.
+//
+// The destination and metadata of a short live in one hash (see
+// SchemaVersion), counters and indexes hang off other prefixed keys.
+
+// MetaKey returns the record holding the destination, under "url", and the
+// metadata of a short. Use Load or Exists before writing to it so that v1
+// links are migrated first, and FieldsCmd and WriteCmd rather than hash
+// commands, records may be encoded as strings.
+func MetaKey(short string) string {
+	return "v2:link:" + short
+}
+
+// ClicksKey returns the counter incremented on every resolution of a short.
+func ClicksKey(short string) string {
+	return "clicks:" + short
+}
+
+// CampaignKey returns the hash holding campaign metadata.
+func CampaignKey(name string) string {
+	return "campaign:" + name
+}
+
+// CampaignLinksKey returns the set of shorts belonging to a campaign.
+func CampaignLinksKey(name string) string {
+	return "campaign:" + name + ":links"
+}
+
+// CampaignsKey returns the set of all campaign names.
+func CampaignsKey() string {
+	return "campaigns"
+}
+
+// CountriesKey returns the hash counting resolutions of a short per country.
+func CountriesKey(short string) string {
+	return "clicks:" + short + ":countries"
+}
+
+// ReferrersKey returns the hash counting resolutions of a short per
+// referring host.
+func ReferrersKey(short string) string {
+	return "clicks:" + short + ":referrers"
+}
+
+// DayClicksKey returns the counter of resolutions of a short during one UTC
+// day, day being the unix time divided by 86400.
+func DayClicksKey(short string, day int64) string {
+	return "clicks:" + short + ":d:" + strconv.FormatInt(day, 10)
+}
+
+// BucketKey returns the counter of resolutions of a short during one minute,
+// bucket being the unix time divided by 60.
+func BucketKey(short string, bucket int64) string {
+	return "clicks:" + short + ":m:" + strconv.FormatInt(bucket, 10)
+}
+
+// ActiveKey returns the sorted set of shorts scored by their last click.
+func ActiveKey() string {
+	return "links:active"
+}
+
+// FlaggedKey returns the set of shorts flagged by the abuse systems.
+func FlaggedKey() string {
+	return "links:flagged"
+}
+
+// PreviewKey returns the one-time token letting a reviewer open a protected
+// short without its password.
+func PreviewKey(token string) string {
+	return "preview:" + token
+}
+
+// AssetKey returns the cached favicon or og:image of a short's destination.
+func AssetKey(kind, short string) string {
+	return "asset:" + kind + ":" + short
+}
+
+// APIKeyKey returns the hash describing an API key, addressed by the
+// SHA-256 of the key so the plain key is never stored.
+func APIKeyKey(hash string) string {
+	return "apikey:" + hash
+}
+
+// UserKey returns the hash holding a key owner's profile.
+func UserKey(owner string) string {
+	return "user:" + owner
+}
+
+// UserLinksKey returns the set of shorts created by an owner.
+func UserLinksKey(owner string) string {
+	return "user:" + owner + ":links"
+}
+
+// OrgKey returns the hash describing an organization, its "name" and the
+// "max_links" quota shared by its members.
+func OrgKey(org string) string {
+	return "org:" + org
+}
+
+// OrgMembersKey returns the hash mapping the members of an organization to
+// their role.
+func OrgMembersKey(org string) string {
+	return "org:" + org + ":members"
+}
+
+// OrgLinksKey returns the set of shorts owned by the members of an
+// organization, the pool its quota counts.
+func OrgLinksKey(org string) string {
+	return "org:" + org + ":links"
+}
+
+// SessionKey returns the hash of a dashboard session, addressed by the
+// SHA-256 of its cookie.
+func SessionKey(id string) string {
+	return "session:" + id
+}
+
+// UserAPIKeysKey returns the set of an owner's API keys, by hash.
+func UserAPIKeysKey(owner string) string {
+	return "user:" + owner + ":apikeys"
+}
+
+// UserSessionsKey returns the set of an owner's dashboard sessions.
+func UserSessionsKey(owner string) string {
+	return "user:" + owner + ":sessions"
+}
+
+// LoginStateKey returns the pending single sign-on login started with
+// state, consumed by the callback.
+func LoginStateKey(state string) string {
+	return "oidc:" + state
+}
+
+// BrandingKey returns the hash holding an owner's brand, "name", "logo_url"
+// and "color", the custom domains serving it and "page:<name>" templates
+// overriding the built-in HTML pages.
+func BrandingKey(owner string) string {
+	return "user:" + owner + ":branding"
+}
+
+// DomainsKey returns the hash mapping custom domains to their owner.
+func DomainsKey() string {
+	return "links:domains"
+}
+
+// HeadRequestsKey returns the counter of HEAD requests on a short, which are
+// not counted as clicks.
+func HeadRequestsKey(short string) string {
+	return "clicks:" + short + ":head"
+}
+
+// SitemapKey returns the cached rendering of one sitemap page of an owner,
+// page 0 being the sitemap index.
+func SitemapKey(owner string, page int) string {
+	return "sitemap:" + owner + ":" + strconv.Itoa(page)
+}
+
+// ClickStreamKey returns the stream of click events waiting to be exported
+// to the analytics store.
+func ClickStreamKey() string {
+	return "stream:clicks"
+}
+
+// CreationStreamKey returns the stream of link creations followed by the
+// admin feed.
+func CreationStreamKey() string {
+	return "stream:created"
+}
+
+// WebhookDeliveriesKey returns the capped stream of delivery attempts to
+// one webhook.
+func WebhookDeliveriesKey(id string) string {
+	return "webhook:" + id + ":deliveries"
+}
+
+// OutboxKey returns the stream of events written along with the data they
+// describe, waiting to be relayed.
+func OutboxKey() string {
+	return "outbox:events"
+}
+
+// LinkRateKey returns the counter limiting the resolutions of a short to
+// its max_rpm.
+func LinkRateKey(short string) string {
+	return "ratelimit:link:" + short
+}
+
+// ExtensionRateKey returns the counter limiting the shortenings made by a
+// browser extension with one token.
+func ExtensionRateKey(token string) string {
+	return "ratelimit:ext:" + token
+}
+
+// AnonymousRateKey returns the counter limiting the links created without
+// an API key from one IP.
+func AnonymousRateKey(ip string) string {
+	return "ratelimit:anon:" + ip
+}
+
+// APIRateKey returns the counter limiting the requests of an API key, or
+// of an owner authenticated with a token, to API_RATE_LIMIT.
+func APIRateKey(subject string) string {
+	return "ratelimit:api:" + subject
+}
+
+// QuotaKey returns the counter of the requests of an owner on a day, as
+// YYYYMMDD in UTC, for their daily quota.
+func QuotaKey(owner, day string) string {
+	return "quota:" + owner + ":" + day
+}
+
+// PurgeKey returns the report of a purge of user data, kept as JSON.
+func PurgeKey(id string) string {
+	return "purge:" + id
+}
+
+// PurgeQueueKey returns the list of the purges waiting to run.
+func PurgeQueueKey() string {
+	return "purge:queue"
+}
+
+// TrashKey returns the record of a deleted short, kept for
+// TRASH_RETENTION.
+func TrashKey(short string) string {
+	return "trash:link:" + short
+}
+
+// TrashIndexKey returns the sorted set of the shorts in the trash, scored
+// by deletion time.
+func TrashIndexKey() string {
+	return "trash:index"
+}
+
+// ReadOnlyKey returns the hash present while the API refuses writes.
+func ReadOnlyKey() string {
+	return "config:read_only"
+}
+
+// MaintenanceKey returns the hash of scheduled maintenance windows, by id.
+func MaintenanceKey() string {
+	return "config:maintenance"
+}
+
+// CountersKey returns the hash of service-wide totals, "created", "clicks"
+// and "deleted", counted since the environment was bootstrapped.
+func CountersKey() string {
+	return "links:counters"
+}
+
+// ReservedKey returns the set of words that cannot start a custom short,
+// mostly the first segments of the service's own routes.
+func ReservedKey() string {
+	return "links:reserved"
+}
+
+// DefaultsKey returns the hash of settings applied when a request leaves
+// them out, editable at runtime without a deploy.
+func DefaultsKey() string {
+	return "config:defaults"
+}
+
+// SearchIndex is the RediSearch index over link hashes, created by the
+// bootstrap when the module is loaded.
+const SearchIndex = "idx:links"
+
+// FilterKey returns the RedisBloom filter of existing shorts.
+func FilterKey() string {
+	return "links:filter"
+}
+
+// FilterReadyKey is set once FilterKey holds every short, before which the
+// filter is not consulted.
+func FilterReadyKey() string {
+	return "links:filter:ready"
+}
+
+// TopDayKey returns the sorted set of shorts scored by their clicks during
+// one UTC day, day being the unix time divided by 86400.
+func TopDayKey(day int64) string {
+	return "top:d:" + strconv.FormatInt(day, 10)
+}
+
+// TopAllKey returns the sorted set of shorts scored by all their clicks.
+func TopAllKey() string {
+	return "top:all"
+}
+
+// TopPeriodKey returns the cached union of the last days TopDayKey sets
+// up to day.
+func TopPeriodKey(days int, day int64) string {
+	return "top:p:" + strconv.Itoa(days) + ":" + strconv.FormatInt(day, 10)
+}
+
+// ReportBucketKey returns the hash counting the clicks of one UTC day by
+// dimension ("countries" or "referrers"), over the links of an owner or,
+// for an empty owner, over every link.
+func ReportBucketKey(owner, dimension string, day int64) string {
+	scope := "all"
+	if owner != "" {
+		scope = "user:" + owner
+	}
+
+	return "report:d:" + scope + ":" + dimension + ":" + strconv.FormatInt(day, 10)
+}
+
+// ReportCacheKey returns the cached result of an aggregate report.
+func ReportCacheKey(owner string, from, to int64) string {
+	return "report:cache:" + owner + ":" + strconv.FormatInt(from, 10) + ":" + strconv.FormatInt(to, 10)
+}
+
+// SlackUsersKey returns the hash mapping Slack users, as "team:user", to
+// the owners they act as.
+func SlackUsersKey() string {
+	return "integration:slack:users"
+}
+
+// SlackConnectKey returns the one-time code a Slack user is given to
+// connect their account, holding their "team:user".
+func SlackConnectKey(code string) string {
+	return "integration:slack:connect:" + code
+}
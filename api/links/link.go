@@ -0,0 +1,46 @@
+package links
+
+import "github.com/ksarpe/redis-golang/database"
+
+// Link is the record of a short as stored in its MetaKey hash, read and
+// written with database.UnmarshalHash and database.MarshalHash. Fields
+// added later take a `default` tag for the records written before them.
+type Link struct {
+	URL          string `redis:"url"`
+	OriginalURL  string `redis:"original_url,omitempty"`
+	RedirectHops int    `redis:"redirect_hops,omitempty"`
+	Title        string `redis:"title,omitempty"`
+	Description  string `redis:"description,omitempty"`
+	Campaign     string `redis:"campaign,omitempty"`
+	Owner        string `redis:"owner,omitempty"`
+	CreatedAt    int64  `redis:"created_at"`
+	ExpiresAt    int64  `redis:"expires_at,omitempty"`
+	PasswordHash string `redis:"password_hash,omitempty"`
+	Disabled     bool   `redis:"disabled,omitempty"`
+	Indexable    bool   `redis:"indexable,omitempty"`
+	Passthrough  bool   `redis:"passthrough,omitempty"`
+	MaxRPM       int64  `redis:"max_rpm,omitempty"`
+	HTTPSUpgrade string `redis:"https_upgrade,omitempty"`
+
+	Flagged    string `redis:"flagged,omitempty"`
+	FlagAction string `redis:"flag_action,omitempty"`
+
+	DestinationStatus    string `redis:"dest_status,omitempty"`
+	DestinationCheckedAt int64  `redis:"dest_checked_at,omitempty"`
+}
+
+// LoadLink is Load decoding the fields into a Link. It returns nil when
+// the short does not exist.
+func LoadLink(rClient database.ClientInterface, short string) (*Link, error) {
+	fields, err := Load(rClient, short)
+	if err != nil || fields == nil {
+		return nil, err
+	}
+
+	link := &Link{}
+	if err := database.UnmarshalHash(fields, link); err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
@@ -0,0 +1,236 @@
+package links
+
+import (
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Link records are stored in one of three encodings, selected by
+// LINK_META_ENCODING:
+//
+//	hash:     a hash of the fields, the default.
+//	msgpack:  a string, "M" followed by a MessagePack map of the fields.
+//	protobuf: a string, "P" followed by a LinkMeta message,
+//	          message LinkMeta { map<string, string> fields = 1; }
+//
+// A single string takes far less memory than a small hash for tens of
+// millions of links, at the cost of rewriting the whole record on every
+// change and of the links search index, which only covers hashes. Records
+// are read in any encoding and converted to the selected one when next
+// written, so the setting can be changed at any time.
+const (
+	EncodingHash     = "hash"
+	EncodingMsgpack  = "msgpack"
+	EncodingProtobuf = "protobuf"
+)
+
+// MetaEncoding returns the encoding records are written in.
+var MetaEncoding = sync.OnceValue(func() string {
+	switch encoding := os.Getenv("LINK_META_ENCODING"); encoding {
+	case EncodingMsgpack, EncodingProtobuf:
+		return encoding
+	}
+
+	return EncodingHash
+})
+
+// MetaLua defines the functions reading and writing link records in any
+// encoding, for the scripts touching them:
+//
+//	meta_read(key) returns the fields as a table, and the type of key.
+//	meta_flat(fields) returns the fields as field/value pairs.
+//	meta_write(key, encoding, set, del) sets the fields of the set table
+//	and deletes the fields listed in del, keeping the TTL of key.
+const MetaLua = `
+local function pb_varint(s, i)
+	local n, mul = 0, 1
+	while true do
+		local b = string.byte(s, i)
+		i = i + 1
+		n = n + (b % 128) * mul
+		if b < 128 then
+			return n, i
+		end
+		mul = mul * 128
+	end
+end
+
+local function pb_put_varint(n)
+	local out = {}
+	while n >= 128 do
+		out[#out + 1] = string.char(n % 128 + 128)
+		n = math.floor(n / 128)
+	end
+	out[#out + 1] = string.char(n)
+	return table.concat(out)
+end
+
+local function pb_bytes(s, i)
+	local n
+	n, i = pb_varint(s, i)
+	return string.sub(s, i, i + n - 1), i + n
+end
+
+local function pb_decode(s)
+	local fields, i = {}, 1
+	while i <= #s do
+		local tag, entry
+		tag, i = pb_varint(s, i)
+		entry, i = pb_bytes(s, i)
+		if tag == 10 then
+			local name, value, j = '', '', 1
+			while j <= #entry do
+				local field, str
+				field, j = pb_varint(entry, j)
+				str, j = pb_bytes(entry, j)
+				if field == 10 then
+					name = str
+				elseif field == 18 then
+					value = str
+				end
+			end
+			fields[name] = value
+		end
+	end
+	return fields
+end
+
+local function pb_encode(fields)
+	local out = {}
+	for name, value in pairs(fields) do
+		local entry = '\10' .. pb_put_varint(#name) .. name .. '\18' .. pb_put_varint(#value) .. value
+		out[#out + 1] = '\10' .. pb_put_varint(#entry) .. entry
+	end
+	return table.concat(out)
+end
+
+local function meta_read(key)
+	local kind = redis.call('TYPE', key).ok
+	local fields = {}
+	if kind == 'hash' then
+		local flat = redis.call('HGETALL', key)
+		for i = 1, #flat, 2 do
+			fields[flat[i]] = flat[i + 1]
+		end
+	elseif kind == 'string' then
+		local blob = redis.call('GET', key)
+		local marker, body = string.sub(blob, 1, 1), string.sub(blob, 2)
+		if marker == 'M' then
+			fields = cmsgpack.unpack(body)
+		elseif marker == 'P' then
+			fields = pb_decode(body)
+		end
+	end
+	return fields, kind
+end
+
+local function meta_flat(fields)
+	local flat = {}
+	for name, value in pairs(fields) do
+		flat[#flat + 1] = name
+		flat[#flat + 1] = value
+	end
+	return flat
+end
+
+local function meta_write(key, encoding, set, del)
+	local kind = redis.call('TYPE', key).ok
+	if encoding == 'hash' and kind ~= 'string' then
+		local flat = meta_flat(set)
+		if #flat > 0 then
+			redis.call('HSET', key, unpack(flat))
+		end
+		if #del > 0 then
+			redis.call('HDEL', key, unpack(del))
+		end
+		return
+	end
+
+	local fields = meta_read(key)
+	for name, value in pairs(set) do
+		fields[name] = value
+	end
+	for _, name in ipairs(del) do
+		fields[name] = nil
+	end
+
+	local ttl = redis.call('PTTL', key)
+	redis.call('DEL', key)
+	if next(fields) == nil then
+		return
+	end
+	if encoding == 'msgpack' then
+		redis.call('SET', key, 'M' .. cmsgpack.pack(fields))
+	elseif encoding == 'protobuf' then
+		redis.call('SET', key, 'P' .. pb_encode(fields))
+	else
+		redis.call('HSET', key, unpack(meta_flat(fields)))
+	end
+	if ttl > 0 then
+		redis.call('PEXPIRE', key, ttl)
+	end
+end
+`
+
+// The scripts below are sent with EVAL rather than as radix scripts so
+// that they can be pipelined and queued in MULTI.
+
+// fieldsLua returns the values of ARGV in the record at KEYS[1], "" for
+// missing ones, like HMGET.
+const fieldsLua = MetaLua + `
+local fields = meta_read(KEYS[1])
+local values = {}
+for i, name in ipairs(ARGV) do
+	values[i] = fields[name] or ''
+end
+return values
+`
+
+// recordLua returns the record at KEYS[1] as field/value pairs, like
+// HGETALL.
+const recordLua = MetaLua + `
+return meta_flat((meta_read(KEYS[1])))
+`
+
+// writeLua sets and deletes fields of the record at KEYS[1]. ARGV[1] is
+// the encoding, ARGV[2] the number of field/value pairs to set, followed
+// by the pairs and then the fields to delete.
+const writeLua = MetaLua + `
+local n = tonumber(ARGV[2])
+local set, del = {}, {}
+for i = 3, 2 + 2 * n, 2 do
+	set[ARGV[i]] = ARGV[i + 1]
+end
+for i = 3 + 2 * n, #ARGV do
+	del[#del + 1] = ARGV[i]
+end
+meta_write(KEYS[1], ARGV[1], set, del)
+return 1
+`
+
+// FieldsCmd reads the fields names of the record of short into rcv, like
+// HMGET does for hashes.
+func FieldsCmd(rcv *[]string, short string, names ...string) radix.Action {
+	args := append([]string{fieldsLua, "1", MetaKey(short)}, names...)
+
+	return radix.Cmd(decodedFields{rcv: rcv, url: slices.Index(names, "url")}, "EVAL", args...)
+}
+
+// RecordCmd reads the record of short into rcv, like HGETALL does for
+// hashes. Unlike Load, it doesn't migrate v1 links.
+func RecordCmd(rcv *map[string]string, short string) radix.Action {
+	return radix.Cmd(decodedRecord{rcv: rcv}, "EVAL", recordLua, "1", MetaKey(short))
+}
+
+// WriteCmd sets the field/value pairs of set and deletes the fields of del
+// in the record of short, in the encoding of MetaEncoding.
+func WriteCmd(short string, set, del []string) radix.Action {
+	args := []string{writeLua, "1", MetaKey(short), MetaEncoding(), strconv.Itoa(len(set) / 2)}
+	args = append(append(args, set...), del...)
+
+	return radix.Cmd(nil, "EVAL", args...)
+}
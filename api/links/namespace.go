@@ -0,0 +1,58 @@
+package links
+
+import "strings"
+
+// namespaces maps key prefixes to the namespace reported in operational
+// tooling. Keys without a known prefix are v1 link destinations.
+var namespaces = []struct {
+	prefix    string
+	namespace string
+}{
+	{"v2:link:", "links"},
+	{"link:", "links"},
+	{"links:", "links"},
+	{"clicks:", "analytics"},
+	{"clickshard:", "analytics"},
+	{"report:", "analytics"},
+	{"stream:", "analytics"},
+	{"top:", "analytics"},
+	{"campaign:", "campaigns"},
+	{"campaigns", "campaigns"},
+	{"user:", "accounts"},
+	{"apikey:", "accounts"},
+	{"org:", "accounts"},
+	{"session:", "sessions"},
+	{"oidc:", "sessions"},
+	{"asset:", "cache"},
+	{"sitemap:", "cache"},
+	{"suggest:", "cache"},
+	{"missing:", "cache"},
+	{"lock:", "internal"},
+	{"throttle:", "internal"},
+	{"ratelimit:", "internal"},
+	{"quota:", "internal"},
+	{"purge:", "internal"},
+	{"trash:", "trash"},
+	{"lockout:", "internal"},
+	{"webhook:", "internal"},
+	{"outbox:", "internal"},
+	{"preview:", "internal"},
+	{"extend:", "internal"},
+	{"consistency:", "internal"},
+	{"linkcheck:", "internal"},
+	{"rewrite:", "internal"},
+	{"config:", "internal"},
+	{"transfer:", "accounts"},
+	{"integration:", "accounts"},
+}
+
+// Namespace classifies a key by the feature owning it.
+func Namespace(key string) string {
+	for _, ns := range namespaces {
+		if strings.HasPrefix(key, ns.prefix) {
+			return ns.namespace
+		}
+	}
+
+	return "links"
+}
@@ -0,0 +1,119 @@
+package links
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/metrics"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+var negativeHits = metrics.NewCounter("negative_cache_hits_total", "Resolutions of missing shorts answered from the local negative cache.")
+
+type negativeConfig struct {
+	ttl        time.Duration
+	size       int
+	tombstones bool
+}
+
+// negativeSettings is read lazily so that the .env file is loaded first.
+// A NEGATIVE_CACHE_TTL of 0 disables negative caching.
+var negativeSettings = sync.OnceValue(func() negativeConfig {
+	cfg := negativeConfig{ttl: 5 * time.Second, size: 10000}
+	if v, err := time.ParseDuration(os.Getenv("NEGATIVE_CACHE_TTL")); err == nil && v >= 0 {
+		cfg.ttl = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("NEGATIVE_CACHE_SIZE")); err == nil && v > 0 {
+		cfg.size = v
+	}
+	cfg.tombstones = os.Getenv("NEGATIVE_CACHE_TOMBSTONES") == "true"
+
+	return cfg
+})
+
+// MissingKey returns the tombstone marking short as missing, letting every
+// instance skip the lookups of Hit for it.
+func MissingKey(short string) string {
+	return "missing:" + short
+}
+
+// missing is the local negative cache, shorts Hit found missing recently.
+var missing = struct {
+	sync.Mutex
+	until map[string]time.Time
+}{until: map[string]time.Time{}}
+
+// KnownMissing reports whether short was found missing within the last
+// NEGATIVE_CACHE_TTL, by Hit or a caller of RememberMissing.
+func KnownMissing(short string) bool {
+	if negativeSettings().ttl == 0 {
+		return false
+	}
+
+	missing.Lock()
+	defer missing.Unlock()
+
+	until, ok := missing.until[short]
+	if !ok {
+		return false
+	}
+	if clock.Now().After(until) {
+		delete(missing.until, short)
+		return false
+	}
+
+	return true
+}
+
+// RememberMissing caches short as missing, for readers that found it so
+// without Hit.
+func RememberMissing(short string) {
+	cfg := negativeSettings()
+	if cfg.ttl == 0 {
+		return
+	}
+
+	missing.Lock()
+	defer missing.Unlock()
+
+	// Scans of random shorts would grow the cache without bound, start
+	// over rather than tracking the oldest entries.
+	if len(missing.until) >= cfg.size {
+		clear(missing.until)
+	}
+	missing.until[short] = clock.Now().Add(cfg.ttl)
+}
+
+// tombstoneTTL returns the lifetime of tombstones in milliseconds for
+// hitScript, "0" when they are disabled.
+func tombstoneTTL() string {
+	cfg := negativeSettings()
+	if !cfg.tombstones {
+		return "0"
+	}
+
+	return strconv.FormatInt(cfg.ttl.Milliseconds(), 10)
+}
+
+// Forget drops short from the negative caches, for writers creating it.
+// Other instances may keep reporting it missing for NEGATIVE_CACHE_TTL.
+func Forget(rClient database.ClientInterface, short string) error {
+	ForgetLocal(short)
+
+	if !negativeSettings().tombstones {
+		return nil
+	}
+
+	return rClient.Do(radix.Cmd(nil, "DEL", MissingKey(short)))
+}
+
+// ForgetLocal drops short from the negative cache of this process only.
+func ForgetLocal(short string) {
+	missing.Lock()
+	delete(missing.until, short)
+	missing.Unlock()
+}
@@ -0,0 +1,32 @@
+package links
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+const (
+	// MaxTitleLength is the maximum number of characters of a link title.
+	MaxTitleLength = 120
+
+	// MaxDescriptionLength is the maximum number of characters of a link
+	// description.
+	MaxDescriptionLength = 1000
+)
+
+var (
+	ErrTitleTooLong       = errors.New("title is too long")
+	ErrDescriptionTooLong = errors.New("description is too long")
+)
+
+// ValidateNotes checks the optional title and description of a link.
+func ValidateNotes(title, description string) error {
+	if utf8.RuneCountInString(title) > MaxTitleLength {
+		return ErrTitleTooLong
+	}
+	if utf8.RuneCountInString(description) > MaxDescriptionLength {
+		return ErrDescriptionTooLong
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package links
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Organization roles. Admins manage members and the quota, members share
+// the link pool.
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+var (
+	ErrOrgNotFound = errors.New("organization not found")
+	ErrOrgExists   = errors.New("organization already exists")
+	ErrOtherOrg    = errors.New("user belongs to another organization")
+	ErrNotMember   = errors.New("user is not a member of the organization")
+	ErrLastAdmin   = errors.New("an organization needs at least one admin")
+)
+
+var orgErrors = map[string]error{
+	"NOORG":     ErrOrgNotFound,
+	"ORGEXISTS": ErrOrgExists,
+	"OTHERORG":  ErrOtherOrg,
+	"NOMEMBER":  ErrNotMember,
+	"LASTADMIN": ErrLastAdmin,
+}
+
+// orgLua defines the membership functions shared by the organization
+// scripts.
+//
+// KEYS[1] organization hash, KEYS[2] members, KEYS[3] organization links,
+// KEYS[4] user hash, KEYS[5] user links; ARGV[1] organization, ARGV[2] user.
+const orgLua = `
+local function admins()
+	local count = 0
+	for _, role in ipairs(redis.call('HVALS', KEYS[2])) do
+		if role == 'admin' then
+			count = count + 1
+		end
+	end
+	return count
+end
+
+local function join(role)
+	local current = redis.call('HGET', KEYS[4], 'org')
+	if current and current ~= ARGV[1] then
+		return redis.error_reply('OTHERORG')
+	end
+	if role ~= 'admin' and redis.call('HGET', KEYS[2], ARGV[2]) == 'admin' and admins() == 1 then
+		return redis.error_reply('LASTADMIN')
+	end
+	redis.call('HSET', KEYS[2], ARGV[2], role)
+	redis.call('HSET', KEYS[4], 'org', ARGV[1])
+	redis.call('SUNIONSTORE', KEYS[3], KEYS[3], KEYS[5])
+	return 1
+end
+`
+
+// createOrgScript creates an organization with the user as its admin.
+// ARGV[3] name, ARGV[4] quota, ARGV[5] creation time.
+var createOrgScript = radix.NewEvalScript(orgLua + `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return redis.error_reply('ORGEXISTS')
+end
+local current = redis.call('HGET', KEYS[4], 'org')
+if current then
+	return redis.error_reply('OTHERORG')
+end
+redis.call('HSET', KEYS[1], 'name', ARGV[3], 'max_links', ARGV[4], 'created_at', ARGV[5])
+return join('admin')
+`)
+
+// joinOrgScript adds the user to the organization, pooling their links, or
+// changes their role. ARGV[3] role.
+var joinOrgScript = radix.NewEvalScript(orgLua + `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return redis.error_reply('NOORG')
+end
+return join(ARGV[3])
+`)
+
+// leaveOrgScript removes the user and their links from the organization.
+var leaveOrgScript = radix.NewEvalScript(orgLua + `
+local role = redis.call('HGET', KEYS[2], ARGV[2])
+if not role then
+	return redis.error_reply('NOMEMBER')
+end
+if role == 'admin' and admins() == 1 then
+	return redis.error_reply('LASTADMIN')
+end
+redis.call('HDEL', KEYS[2], ARGV[2])
+redis.call('HDEL', KEYS[4], 'org')
+redis.call('SDIFFSTORE', KEYS[3], KEYS[3], KEYS[5])
+return 1
+`)
+
+func orgKeys(org, user string) []string {
+	return []string{OrgKey(org), OrgMembersKey(org), OrgLinksKey(org), UserKey(user), UserLinksKey(user)}
+}
+
+// CreateOrg creates an organization administered by user, sharing a quota
+// of maxLinks shorts, 0 meaning unlimited.
+func CreateOrg(rClient database.ClientInterface, org, name, user string, maxLinks int) error {
+	return orgError(rClient.Do(createOrgScript.Cmd(nil, orgKeys(org, user),
+		org, user, name, strconv.Itoa(maxLinks), strconv.FormatInt(time.Now().Unix(), 10))))
+}
+
+// JoinOrg adds user to an organization with role, or changes the role of
+// a member. The links of a new member join the organization pool.
+func JoinOrg(rClient database.ClientInterface, org, user, role string) error {
+	return orgError(rClient.Do(joinOrgScript.Cmd(nil, orgKeys(org, user), org, user, role)))
+}
+
+// LeaveOrg removes user and their links from an organization.
+func LeaveOrg(rClient database.ClientInterface, org, user string) error {
+	return orgError(rClient.Do(leaveOrgScript.Cmd(nil, orgKeys(org, user), org, user)))
+}
+
+// OrgOf returns the organization of user, or "".
+func OrgOf(rClient database.ClientInterface, user string) (string, error) {
+	var org string
+	err := rClient.Do(radix.Cmd(&org, "HGET", UserKey(user), "org"))
+
+	return org, err
+}
+
+func orgError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for code, orgErr := range orgErrors {
+		if strings.Contains(err.Error(), code) {
+			return orgErr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,235 @@
+package links
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ksarpe/redis-golang/database"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// SchemaVersion is the version of the key layout written by this build.
+//
+//	v1: the destination under the bare short key as a string, metadata in
+//	    the "link:<short>" hash.
+//	v2: destination and metadata in a single "v2:link:<short>" record, the
+//	    destination under the "url" field. Records are hashes unless
+//	    LINK_META_ENCODING says otherwise, see MetaEncoding.
+//
+// v1 links are converted on first read by Load and in bulk by MigrateAll.
+const SchemaVersion = 2
+
+// legacyMetaKey returns the v1 metadata hash of a short.
+func legacyMetaKey(short string) string {
+	return "link:" + short
+}
+
+// loadLua defines load(encoding), converting the v1 keys of a short to a
+// v2 record in encoding while keeping the remaining TTL, and returning the
+// v2 record as field/value pairs. It returns an empty table when the short
+// exists in neither layout.
+//
+// KEYS[1] v2 record, KEYS[2] v1 destination, KEYS[3] v1 metadata hash,
+// ARGV[1] "1" when KEYS[2] may be a v1 destination.
+const loadLua = MetaLua + `
+local function load(encoding)
+	local current = meta_flat((meta_read(KEYS[1])))
+	if #current > 0 then
+		return current
+	end
+	if ARGV[1] ~= '1' or redis.call('TYPE', KEYS[2]).ok ~= 'string' then
+		return {}
+	end
+	local fields = {}
+	if redis.call('TYPE', KEYS[3]).ok == 'hash' then
+		fields = meta_read(KEYS[3])
+	end
+	fields['url'] = redis.call('GET', KEYS[2])
+	local ttl = redis.call('PTTL', KEYS[2])
+	meta_write(KEYS[1], encoding, fields, {})
+	if ttl > 0 then
+		redis.call('PEXPIRE', KEYS[1], ttl)
+	end
+	redis.call('DEL', KEYS[2], KEYS[3])
+	return meta_flat(fields)
+end
+`
+
+// migrateLua is load() alone. ARGV[2] is the encoding.
+const migrateLua = loadLua + `return load(ARGV[2])`
+
+var migrateScript = radix.NewEvalScript(migrateLua)
+
+// hitScript loads a short like migrateScript and counts a click when the
+// short redirects without further checks, i.e. it is not disabled, password
+// protected, flagged or rate limited. Missing shorts leave a tombstone
+// skipping the lookups next time, when enabled.
+//
+// KEYS[4] clicks counter or sub-counter, KEYS[5] tombstone, ARGV[2] "1" to count the
+// click, ARGV[3] tombstone lifetime in milliseconds, "0" for none, ARGV[4]
+// the encoding.
+var hitScript = radix.NewEvalScript(loadLua + `
+if ARGV[3] ~= '0' and redis.call('EXISTS', KEYS[5]) == 1 then
+	return {}
+end
+local fields = load(ARGV[4])
+if #fields == 0 and ARGV[3] ~= '0' then
+	redis.call('SET', KEYS[5], '1', 'PX', ARGV[3])
+end
+local plain = #fields > 0
+for i = 1, #fields, 2 do
+	local field = fields[i]
+	if (field == 'disabled' or field == 'password_hash' or field == 'flagged' or field == 'max_rpm') and fields[i + 1] ~= '' then
+		plain = false
+	end
+end
+if plain and ARGV[2] == '1' then
+	redis.call('INCR', KEYS[4])
+end
+return fields
+`)
+
+// Load returns the fields of a short, destination included under "url",
+// migrating it from the v1 layout first if needed. It returns nil when the
+// short does not exist.
+func Load(rClient database.ClientInterface, short string) (map[string]string, error) {
+	var fields map[string]string
+	err := rClient.Do(migrateScript.Cmd(&fields, []string{MetaKey(short), short, legacyMetaKey(short)}, legacyFlag(short), MetaEncoding()))
+	if err != nil {
+		return nil, err
+	}
+
+	return existing(fields), nil
+}
+
+// LoadCmd is Load for pipelines, reading the fields of short into rcv
+// with the destination decoded. Missing shorts have no "url". The script
+// is sent whole like FieldsCmd, pipelines can't retry a missing EVALSHA.
+func LoadCmd(rcv *map[string]string, short string) radix.Action {
+	return radix.Cmd(decodedRecord{rcv: rcv}, "EVAL", migrateLua, "3",
+		MetaKey(short), short, legacyMetaKey(short), legacyFlag(short), MetaEncoding())
+}
+
+// Hit is Load for the redirect path: in the same round trip it counts a
+// click when count is set and the short needs no check before redirecting,
+// reported by Counted on the returned fields. Shorts found missing are
+// cached as such for NEGATIVE_CACHE_TTL, until Forget.
+func Hit(rClient database.ClientInterface, short string, count bool) (map[string]string, error) {
+	if KnownMissing(short) {
+		negativeHits.Inc()
+		return nil, nil
+	}
+
+	countFlag, counter := "0", ClicksKey(short)
+	if count {
+		countFlag, counter = "1", ClickCounterKey(short)
+	}
+
+	var fields map[string]string
+	keys := []string{MetaKey(short), short, legacyMetaKey(short), counter, MissingKey(short)}
+	if err := rClient.Do(hitScript.Cmd(&fields, keys, legacyFlag(short), countFlag, tombstoneTTL(), MetaEncoding())); err != nil {
+		return nil, err
+	}
+
+	fields = existing(fields)
+	if fields == nil {
+		RememberMissing(short)
+	}
+
+	return fields, nil
+}
+
+// Counted reports whether Hit counted the click for a short with fields.
+func Counted(fields map[string]string) bool {
+	return fields["disabled"] == "" && fields["password_hash"] == "" && fields["flagged"] == "" && fields["max_rpm"] == ""
+}
+
+// legacyFlag tells the scripts whether short may be a v1 destination, so
+// another feature's string key is never mistaken for one.
+func legacyFlag(short string) string {
+	if isLegacyLinkKey(short) {
+		return "1"
+	}
+
+	return "0"
+}
+
+// existing returns nil for a record without a destination, which writers
+// racing an expiry can leave behind and is as good as missing, and decodes
+// the destination of the others.
+func existing(fields map[string]string) map[string]string {
+	dest := DecodeURL(fields["url"])
+	if dest == "" {
+		return nil
+	}
+	fields["url"] = dest
+
+	return fields
+}
+
+// Exists reports whether short exists, migrating it from the v1 layout so
+// that callers can write to MetaKey right after.
+func Exists(rClient database.ClientInterface, short string) (bool, error) {
+	fields, err := Load(rClient, short)
+	if err != nil {
+		return false, err
+	}
+
+	return fields != nil, nil
+}
+
+// MigrateStats summarises a MigrateAll run.
+type MigrateStats struct {
+	Scanned  int `json:"scanned"`
+	Migrated int `json:"migrated"`
+}
+
+// MigrateAll converts every v1 link to the v2 layout. It is safe to run
+// while the service is serving traffic and to interrupt at any point.
+func MigrateAll(ctx context.Context, rClient database.ClientInterface) (MigrateStats, error) {
+	var stats MigrateStats
+
+	err := database.Scan(rClient, "*", func(key string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !isLegacyLinkKey(key) {
+			return nil
+		}
+		stats.Scanned++
+
+		fields, err := Load(rClient, key)
+		if err != nil {
+			return err
+		}
+		if fields != nil {
+			stats.Migrated++
+		}
+
+		return nil
+	})
+
+	return stats, err
+}
+
+// IsLegacyKey reports whether key may be the destination of a v1 short,
+// the short being the key itself.
+func IsLegacyKey(key string) bool {
+	return isLegacyLinkKey(key)
+}
+
+// isLegacyLinkKey reports whether key may be a v1 destination, i.e. a key
+// no other feature owns.
+func isLegacyLinkKey(key string) bool {
+	if Namespace(key) != "links" {
+		return false
+	}
+
+	for _, prefix := range []string{"link:", "links:", "v2:"} {
+		if strings.HasPrefix(key, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
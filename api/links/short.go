@@ -0,0 +1,143 @@
+package links
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// MaxSegmentLength is the longest segment of a short accepted.
+const MaxSegmentLength = 64
+
+// ErrInvalidShort is returned for custom shorts that ParseShort rejects.
+var ErrInvalidShort = errors.New("short segments may only contain letters, digits, '-', '_', '.' and '~' and be at most 64 characters")
+
+// ErrShortTooDeep is returned for shorts with more segments than allowed.
+var ErrShortTooDeep = errors.New("short has too many segments")
+
+type hierarchy struct {
+	separator string
+	maxDepth  int
+}
+
+// shortHierarchy reads SHORT_SEPARATOR and SHORT_MAX_DEPTH lazily so that
+// the .env file is loaded first. The default depth of 1 keeps shorts flat.
+var shortHierarchy = sync.OnceValue(func() hierarchy {
+	h := hierarchy{separator: os.Getenv("SHORT_SEPARATOR"), maxDepth: 1}
+	if h.separator == "" {
+		h.separator = "/"
+	}
+	if v, err := strconv.Atoi(os.Getenv("SHORT_MAX_DEPTH")); err == nil && v > 0 {
+		h.maxDepth = v
+	}
+
+	return h
+})
+
+// ParseShort validates a short as typed by users, segments separated by
+// SHORT_SEPARATOR as in "team/docs", and returns its canonical form used in
+// keys, segments always separated by "/".
+func ParseShort(s string) (string, error) {
+	h := shortHierarchy()
+
+	segments := strings.Split(s, h.separator)
+	if len(segments) > h.maxDepth {
+		return "", ErrShortTooDeep
+	}
+	for _, segment := range segments {
+		if !validSegment(segment, h.separator) {
+			return "", ErrInvalidShort
+		}
+	}
+
+	return strings.Join(segments, "/"), nil
+}
+
+// NewShort returns a random short of six lowercase hex digits, a valid
+// segment, drawing another while reserved reports the short reserved, as
+// a word added to ReservedKey by admins may be six hex digits.
+func NewShort(reserved func(short string) (bool, error)) (string, error) {
+	for {
+		short := uuid.New().String()[:6]
+		taken, err := reserved(short)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return short, nil
+		}
+	}
+}
+
+// ShortFromKey returns the short whose MetaKey is key, reporting whether
+// key is one.
+func ShortFromKey(key string) (string, bool) {
+	short, ok := strings.CutPrefix(key, MetaKey(""))
+	if !ok || short == "" {
+		return "", false
+	}
+
+	return short, true
+}
+
+// DisplayShort returns the canonical short as users type it.
+func DisplayShort(short string) string {
+	return strings.ReplaceAll(short, "/", shortHierarchy().separator)
+}
+
+// Prefix is a candidate split of a request path into a short and the
+// sub-path following it.
+type Prefix struct {
+	Short string
+	Rest  string
+}
+
+// Prefixes returns the ways path, without its leading slash, may address a
+// short, deepest first, so that "team/docs/intro" finds "team/docs" before
+// "team". Shorts are not validated.
+func Prefixes(path string) []Prefix {
+	h := shortHierarchy()
+
+	if h.separator != "/" {
+		short, rest, _ := strings.Cut(path, "/")
+		return []Prefix{{Short: short, Rest: rest}}
+	}
+
+	segments := strings.Split(path, "/")
+	depth := min(h.maxDepth, len(segments))
+
+	prefixes := make([]Prefix, 0, depth)
+	for d := depth; d >= 1; d-- {
+		prefixes = append(prefixes, Prefix{
+			Short: strings.Join(segments[:d], "/"),
+			Rest:  strings.Join(segments[d:], "/"),
+		})
+	}
+
+	return prefixes
+}
+
+// validSegment reports whether s only uses characters that need no escaping
+// in a URL path, so that it can be looked up without further decoding. "."
+// and ".." are rejected too, clients resolve them before sending the path.
+func validSegment(s, separator string) bool {
+	if s == "" || s == "." || s == ".." || len(s) > MaxSegmentLength || strings.Contains(s, separator) {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == '~':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
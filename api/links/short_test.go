@@ -0,0 +1,83 @@
+package links_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/ksarpe/redis-golang/bootstrap"
+	"github.com/ksarpe/redis-golang/links"
+)
+
+func FuzzParseShort(f *testing.F) {
+	for _, seed := range []string{"abc123", "team/docs", "a.b-c_d~e", "", ".", "..", "a b", "a:b", "é"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		short, err := links.ParseShort(s)
+		if err != nil {
+			return
+		}
+
+		if display := links.DisplayShort(short); display != s {
+			t.Fatalf("DisplayShort(ParseShort(%q)) = %q", s, display)
+		}
+		again, err := links.ParseShort(links.DisplayShort(short))
+		if err != nil || again != short {
+			t.Fatalf("ParseShort(DisplayShort(%q)) = %q, %v", short, again, err)
+		}
+		if key, ok := links.ShortFromKey(links.MetaKey(short)); !ok || key != short {
+			t.Fatalf("ShortFromKey(MetaKey(%q)) = %q, %v", short, key, ok)
+		}
+	})
+}
+
+func TestNewShort(t *testing.T) {
+	// Half the shorts are reserved besides the bootstrap words, so that
+	// NewShort has to draw again about every other call.
+	reserved := func(short string) (bool, error) {
+		return slices.Contains(bootstrap.Reserved, short) || short[0] < '8', nil
+	}
+
+	for i := 0; i < 10000; i++ {
+		short, err := links.NewShort(reserved)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed, err := links.ParseShort(short); err != nil || parsed != short {
+			t.Fatalf("ParseShort(%q) = %q, %v", short, parsed, err)
+		}
+		if taken, _ := reserved(short); taken {
+			t.Fatalf("NewShort() = %q, a reserved word", short)
+		}
+	}
+}
+
+func TestNewShortError(t *testing.T) {
+	want := errors.New("unavailable")
+	_, err := links.NewShort(func(string) (bool, error) { return false, want })
+	if err != want {
+		t.Fatalf("NewShort() error = %v, want %v", err, want)
+	}
+}
+
+func BenchmarkParseShort(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := links.ParseShort("spring-sale_2024.v2"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNewShort(b *testing.B) {
+	reserved := func(short string) (bool, error) {
+		return slices.Contains(bootstrap.Reserved, short), nil
+	}
+
+	for i := 0; i < b.N; i++ {
+		if _, err := links.NewShort(reserved); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package links
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/ksarpe/redis-golang/database"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// ErrTransferNotFound is returned by Transfer when the transfer was already
+// accepted, cancelled or has expired.
+var ErrTransferNotFound = errors.New("transfer not found")
+
+// TransferKey returns the hash describing a pending ownership transfer.
+func TransferKey(id string) string {
+	return "transfer:" + id
+}
+
+// UserTransfersKey returns the set of transfers offered to an owner.
+func UserTransfersKey(owner string) string {
+	return "user:" + owner + ":transfers"
+}
+
+// transferScript hands the shorts still owned by the sender over to the
+// recipient and consumes the transfer, all or nothing.
+//
+// KEYS[1] transfer hash, KEYS[2] recipient transfers, KEYS[3] sender links,
+// KEYS[4] recipient links, KEYS[5] campaign hash, KEYS[6] sender
+// organization links, KEYS[7] recipient organization links, KEYS[8..] link
+// records; ARGV[1] transfer id, ARGV[2] sender, ARGV[3] recipient, ARGV[4]
+// campaign or "", ARGV[5] sender organization or "", ARGV[6] recipient
+// organization or "", ARGV[7] record encoding, ARGV[8..] the shorts of
+// KEYS[8..].
+var transferScript = radix.NewEvalScript(MetaLua + `
+if redis.call('DEL', KEYS[1]) == 0 then
+	return redis.error_reply('NOTRANSFER transfer not found')
+end
+redis.call('SREM', KEYS[2], ARGV[1])
+local moved = {}
+for i = 8, #KEYS do
+	local short = ARGV[i]
+	if meta_read(KEYS[i])['owner'] == ARGV[2] then
+		meta_write(KEYS[i], ARGV[7], {owner = ARGV[3]}, {})
+		redis.call('SREM', KEYS[3], short)
+		redis.call('SADD', KEYS[4], short)
+		if ARGV[5] ~= '' then
+			redis.call('SREM', KEYS[6], short)
+		end
+		if ARGV[6] ~= '' then
+			redis.call('SADD', KEYS[7], short)
+		end
+		moved[#moved + 1] = short
+	end
+end
+if ARGV[4] ~= '' and redis.call('EXISTS', KEYS[5]) == 1 then
+	redis.call('HSET', KEYS[5], 'owner', ARGV[3])
+end
+return moved
+`)
+
+// Transfer moves shorts from one owner to another, and between the
+// organization pools of the two, and consumes transfer id, returning the
+// shorts moved. Shorts the sender no longer owns are skipped. The shorts
+// must have been loaded first so that v1 links are migrated.
+func Transfer(rClient database.ClientInterface, id, from, to, campaign string, shorts []string) ([]string, error) {
+	fromOrg, err := OrgOf(rClient, from)
+	if err != nil {
+		return nil, err
+	}
+	toOrg, err := OrgOf(rClient, to)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{TransferKey(id), UserTransfersKey(to), UserLinksKey(from), UserLinksKey(to),
+		CampaignKey(campaign), OrgLinksKey(fromOrg), OrgLinksKey(toOrg)}
+	args := []string{id, from, to, campaign, fromOrg, toOrg, MetaEncoding()}
+	for _, short := range shorts {
+		keys = append(keys, MetaKey(short))
+		args = append(args, short)
+	}
+
+	var moved []string
+	err = rClient.Do(transferScript.Cmd(&moved, keys, args...))
+	if err != nil && strings.Contains(err.Error(), "NOTRANSFER") {
+		return nil, ErrTransferNotFound
+	}
+
+	return moved, err
+}
@@ -0,0 +1,119 @@
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// LinkChannel returns the pub/sub channel carrying the clicks of a short.
+func LinkChannel(short string) string {
+	return "live:link:" + short
+}
+
+// CampaignChannel returns the pub/sub channel carrying the clicks of every
+// short of a campaign.
+func CampaignChannel(name string) string {
+	return "live:campaign:" + name
+}
+
+// Click is published for every counted click.
+type Click struct {
+	Short     string `json:"short"`
+	Campaign  string `json:"campaign,omitempty"`
+	Country   string `json:"country,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// AppendPublish queues the commands publishing click to the channels of its
+// short and campaign.
+func AppendPublish(p *radix.Pipeline, click Click) {
+	payload, err := json.Marshal(click)
+	if err != nil {
+		return
+	}
+
+	p.Append(radix.Cmd(nil, "PUBLISH", LinkChannel(click.Short), string(payload)))
+	if click.Campaign != "" {
+		p.Append(radix.Cmd(nil, "PUBLISH", CampaignChannel(click.Campaign), string(payload)))
+	}
+}
+
+// Hub shares one redis subscription per channel between every listener of
+// this instance, so a popular dashboard costs one connection, not one per
+// viewer.
+type Hub struct {
+	mu       sync.Mutex
+	channels map[string]*channel
+}
+
+type channel struct {
+	listeners map[chan []byte]struct{}
+	cancel    context.CancelFunc
+}
+
+// Default is the hub used by the live endpoints.
+var Default = &Hub{}
+
+// Listen returns the messages published on name from now on, until stop is
+// called. Messages are dropped for listeners that fall behind.
+func (h *Hub) Listen(name string) (<-chan []byte, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.channels == nil {
+		h.channels = map[string]*channel{}
+	}
+
+	ch, ok := h.channels[name]
+	if !ok {
+		ctx, cancel := context.WithCancel(database.Ctx)
+		ch = &channel{listeners: map[chan []byte]struct{}{}, cancel: cancel}
+		h.channels[name] = ch
+		go h.subscribe(ctx, name, ch)
+	}
+
+	messages := make(chan []byte, 64)
+	ch.listeners[messages] = struct{}{}
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			delete(ch.listeners, messages)
+			if len(ch.listeners) == 0 {
+				ch.cancel()
+				delete(h.channels, name)
+			}
+		})
+	}
+
+	return messages, stop
+}
+
+func (h *Hub) subscribe(ctx context.Context, name string, ch *channel) {
+	for ctx.Err() == nil {
+		err := database.Subscribe(ctx, name, func(message []byte) {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			for listener := range ch.listeners {
+				select {
+				case listener <- message:
+				default:
+				}
+			}
+		})
+		if err != nil {
+			log.Printf("live: %v", err)
+			time.Sleep(time.Second)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package lockout
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/metrics"
+	"github.com/ksarpe/redis-golang/webhooks"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+var (
+	failures = metrics.NewCounter("auth_failures_total", "Failed authentication attempts.")
+	lockouts = metrics.NewCounter("auth_lockouts_total", "Authentication lockouts started.")
+)
+
+// Config controls when repeated failures lock a subject out. Once
+// Threshold failures happened within Window, every further failure locks
+// the subject for Base, doubling each time up to Max.
+type Config struct {
+	Enabled    bool
+	Threshold  int64
+	Window     time.Duration
+	Base       time.Duration
+	Max        time.Duration
+	AlertAfter int64
+}
+
+// ConfigFromEnv reads the LOCKOUT_* environment variables.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Enabled:    os.Getenv("LOCKOUT_ENABLED") != "false",
+		Threshold:  5,
+		Window:     15 * time.Minute,
+		Base:       time.Second,
+		Max:        15 * time.Minute,
+		AlertAfter: 20,
+	}
+
+	if v, err := strconv.ParseInt(os.Getenv("LOCKOUT_THRESHOLD"), 10, 64); err == nil && v > 0 {
+		cfg.Threshold = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("LOCKOUT_WINDOW")); err == nil && v > 0 {
+		cfg.Window = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("LOCKOUT_BASE_DELAY")); err == nil && v > 0 {
+		cfg.Base = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("LOCKOUT_MAX_DELAY")); err == nil && v > 0 {
+		cfg.Max = v
+	}
+	if v, err := strconv.ParseInt(os.Getenv("LOCKOUT_ALERT_AFTER"), 10, 64); err == nil {
+		cfg.AlertAfter = v
+	}
+
+	return cfg
+}
+
+// IP returns the subject of a client address.
+func IP(ip string) string {
+	return "ip:" + ip
+}
+
+// Account returns the subject of an account, or of anything else guarded
+// by a secret such as a password protected link. Failures from any IP
+// count towards it.
+func Account(name string) string {
+	return "account:" + name
+}
+
+func failuresKey(subject string) string {
+	return "lockout:" + subject
+}
+
+func lockKey(subject string) string {
+	return "lockout:" + subject + ":lock"
+}
+
+// failScript counts a failure and, past the threshold, locks the subject
+// with an exponential delay. It returns the failure count and the lock in
+// milliseconds.
+//
+// KEYS[1] failure counter, KEYS[2] lock; ARGV[1] window, ARGV[2] threshold,
+// ARGV[3] base delay, ARGV[4] max delay, all durations in milliseconds.
+var failScript = radix.NewEvalScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+local over = count - tonumber(ARGV[2])
+if over < 0 then
+	return {count, 0}
+end
+local delay = math.min(tonumber(ARGV[3]) * math.pow(2, math.min(over, 30)), tonumber(ARGV[4]))
+redis.call('SET', KEYS[2], '1', 'PX', math.floor(delay))
+redis.call('PEXPIRE', KEYS[1], math.max(redis.call('PTTL', KEYS[1]), math.floor(delay) + tonumber(ARGV[1])))
+return {count, math.floor(delay)}
+`)
+
+// Alert is the payload of the auth.lockout webhook.
+type Alert struct {
+	Subject  string `json:"subject"`
+	Failures int64  `json:"failures"`
+	LockedMS int64  `json:"locked_ms"`
+	At       int64  `json:"at"`
+}
+
+// Locked returns how long the most locked of subjects stays locked, 0 when
+// none is. Lookups failing don't lock anyone out.
+func Locked(rClient database.ClientInterface, cfg Config, subjects ...string) time.Duration {
+	if !cfg.Enabled {
+		return 0
+	}
+
+	ttls := make([]int64, len(subjects))
+	p := radix.NewPipeline()
+	for i, subject := range subjects {
+		p.Append(radix.Cmd(&ttls[i], "PTTL", lockKey(subject)))
+	}
+	if err := rClient.Do(p); err != nil {
+		return 0
+	}
+
+	var locked int64
+	for _, ttl := range ttls {
+		if ttl > locked {
+			locked = ttl
+		}
+	}
+
+	return time.Duration(locked) * time.Millisecond
+}
+
+// Fail records a failed attempt for every subject and returns how long the
+// most locked of them is now locked. Subjects reaching AlertAfter failures
+// are reported through the auth.lockout webhook.
+func Fail(rClient database.ClientInterface, cfg Config, subjects ...string) time.Duration {
+	failures.Inc()
+	if !cfg.Enabled {
+		return 0
+	}
+
+	results := make([][]int64, len(subjects))
+	p := radix.NewPipeline()
+	for i, subject := range subjects {
+		p.Append(failScript.Cmd(&results[i], []string{failuresKey(subject), lockKey(subject)},
+			ms(cfg.Window), strconv.FormatInt(cfg.Threshold, 10), ms(cfg.Base), ms(cfg.Max)))
+	}
+	if err := rClient.Do(p); err != nil {
+		log.Printf("lockout: %v", err)
+		return 0
+	}
+
+	var locked int64
+	for i, result := range results {
+		if len(result) != 2 {
+			continue
+		}
+		count, delay := result[0], result[1]
+		if delay > 0 {
+			lockouts.Inc()
+		}
+		if delay > locked {
+			locked = delay
+		}
+		if cfg.AlertAfter > 0 && count == cfg.AlertAfter {
+			log.Printf("lockout: %s failed %d times", subjects[i], count)
+			webhooks.Send("auth.lockout", Alert{Subject: subjects[i], Failures: count, LockedMS: delay, At: time.Now().Unix()})
+		}
+	}
+
+	return time.Duration(locked) * time.Millisecond
+}
+
+// Succeed clears the failures of subjects after a successful attempt. Only
+// pass the subjects the success vouches for, an attacker holding one valid
+// key must not reset the failures of their IP.
+func Succeed(rClient database.ClientInterface, cfg Config, subjects ...string) {
+	if !cfg.Enabled || len(subjects) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, 2*len(subjects))
+	for _, subject := range subjects {
+		keys = append(keys, failuresKey(subject), lockKey(subject))
+	}
+	_ = rClient.Do(radix.Cmd(nil, "DEL", keys...))
+}
+
+func ms(d time.Duration) string {
+	return strconv.FormatInt(d.Milliseconds(), 10)
+}
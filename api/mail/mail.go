@@ -0,0 +1,61 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// ErrHeader is returned by Send for header values spanning several lines,
+// which would inject headers or recipients.
+var ErrHeader = errors.New("mail header cannot contain line breaks")
+
+// Mailer sends plain text emails through an SMTP relay.
+type Mailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+// FromEnv builds a Mailer from the SMTP_* environment variables.
+func FromEnv() Mailer {
+	m := Mailer{
+		Addr: os.Getenv("SMTP_ADDR"),
+		From: os.Getenv("SMTP_FROM"),
+	}
+
+	if user := os.Getenv("SMTP_USER"); user != "" {
+		host := strings.Split(m.Addr, ":")[0]
+		m.Auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASS"), host)
+	}
+
+	return m
+}
+
+// Enabled reports whether an SMTP relay is configured.
+func (m Mailer) Enabled() bool {
+	return m.Addr != ""
+}
+
+// Send delivers a single email.
+func (m Mailer) Send(to, subject, body string) error {
+	for _, value := range []string{m.From, to, subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return ErrHeader
+		}
+	}
+
+	msg := "From: " + m.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + body
+
+	if err := smtp.SendMail(m.Addr, m.Auth, m.From, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send mail to %s, err: %w", to, err)
+	}
+
+	return nil
+}
@@ -1,31 +1,453 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
-	"github.com/joho/godotenv"
+	"github.com/ksarpe/redis-golang/analytics"
+	"github.com/ksarpe/redis-golang/anomaly"
+	"github.com/ksarpe/redis-golang/archive"
+	"github.com/ksarpe/redis-golang/bloom"
+	"github.com/ksarpe/redis-golang/bootstrap"
+	"github.com/ksarpe/redis-golang/budget"
+	"github.com/ksarpe/redis-golang/clicklog"
+	"github.com/ksarpe/redis-golang/config"
+	"github.com/ksarpe/redis-golang/consistency"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/events"
+	"github.com/ksarpe/redis-golang/geoip"
+	"github.com/ksarpe/redis-golang/health"
+	"github.com/ksarpe/redis-golang/i18n"
+	"github.com/ksarpe/redis-golang/jobs"
+	"github.com/ksarpe/redis-golang/journal"
+	"github.com/ksarpe/redis-golang/linkcheck"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/mail"
+	"github.com/ksarpe/redis-golang/metrics"
+	"github.com/ksarpe/redis-golang/outbox"
+	"github.com/ksarpe/redis-golang/redisstats"
+	"github.com/ksarpe/redis-golang/reminders"
+	"github.com/ksarpe/redis-golang/reports"
+	"github.com/ksarpe/redis-golang/retention"
+	"github.com/ksarpe/redis-golang/rewrite"
 	"github.com/ksarpe/redis-golang/routes"
+	"github.com/ksarpe/redis-golang/secrets"
+	"github.com/ksarpe/redis-golang/top"
 	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 func setupRoutes(app *fiber.App) {
+	app.Get("/metrics", metrics.Handler)
+	app.Get("/health", routes.Health)
+	app.Get("/robots.txt", routes.RobotsTxt)
+	app.Get("/sitemaps/:owner", routes.SitemapIndex)
+	app.Get("/sitemaps/:owner/:page", routes.SitemapPage)
+
+	app.Get("/auth/oidc/login", routes.SSOLogin)
+	app.Get("/auth/oidc/callback", routes.SSOCallback)
+	app.Post("/auth/logout", routes.Logout)
+
+	app.Post("/integrations/slack", routes.SlackCommand)
+
+	bitly := app.Group("/v4", routes.RequireAPIKey)
+	bitly.Post("/shorten", routes.RequireScope(routes.ScopeLinksWrite), routes.BitlyShorten)
+	bitly.Post("/expand", routes.RequireScope(routes.ScopeLinksRead), routes.BitlyExpand)
+	bitly.Get("/bitlinks/+/clicks/summary", routes.RequireScope(routes.ScopeStatsRead), routes.BitlyClicksSummary)
+	bitly.Get("/bitlinks/+", routes.RequireScope(routes.ScopeLinksRead), routes.BitlyGet)
+
+	dashboard := app.Group("/dashboard", routes.RequireSession)
+	dashboard.Get("/me", routes.CurrentUser)
+	dashboard.Get("/sessions", routes.ListSessions)
+	dashboard.Delete("/sessions/:id", routes.RevokeSession)
+	dashboard.Post("/sessions/revoke-all", routes.RevokeAllSessions)
+	dashboard.Get("/apikeys", routes.ListAPIKeys)
+	dashboard.Post("/apikeys", routes.CreateOwnAPIKey)
+	dashboard.Delete("/apikeys/:id", routes.RevokeAPIKey)
+	dashboard.Post("/integrations/slack/connect", routes.ConnectSlack)
+
+	admin := app.Group("/admin", routes.RequireAdmin)
+	admin.Get("/alerts", routes.ListAlerts)
+	admin.Delete("/alerts/:short", routes.ClearAlert)
+	admin.Post("/links/:short/preview-tokens", routes.CreatePreviewToken)
+	admin.Post("/apikeys", routes.CreateAPIKey)
+	admin.Put("/integrations/slack/users/:team/:user", routes.SetSlackUser)
+	admin.Post("/consistency/check", routes.RunConsistencyCheck)
+	admin.Get("/consistency/last", routes.LastConsistencyReport)
+	admin.Get("/memory", routes.MemoryUsage)
+	admin.Get("/diagnostics/slowlog", routes.Slowlog)
+	admin.Get("/diagnostics/latency", routes.LatencyReport)
+	admin.Post("/schema/migrate", routes.MigrateSchema)
+	admin.Post("/warm", routes.WarmLinks)
+	admin.Post("/purges", routes.RequestPurge)
+	admin.Get("/purges/:id", routes.PurgeReport)
+	admin.Get("/rewrite-rules", routes.ListRewriteRules)
+	admin.Put("/rewrite-rules", routes.ReplaceRewriteRules)
+	admin.Put("/users/:owner/schemes", routes.SetAllowedSchemes)
+	admin.Put("/users/:owner/plan", routes.SetPlan)
+	admin.Get("/users/:owner/branding", routes.GetBranding)
+	admin.Put("/users/:owner/branding", routes.SetBranding)
+	admin.Get("/feed/links", routes.CreationFeed)
+	admin.Get("/reports/clicks", routes.AdminClickReport)
+	admin.Get("/read-only", routes.GetReadOnly)
+	admin.Put("/read-only", routes.SetReadOnly)
+	admin.Get("/maintenance", routes.ListMaintenance)
+	admin.Post("/maintenance", routes.ScheduleMaintenance)
+	admin.Delete("/maintenance/:id", routes.CancelMaintenance)
+	admin.Get("/webhooks", routes.ListWebhooks)
+	admin.Get("/webhooks/:id/deliveries", routes.WebhookDeliveries)
+	admin.Post("/webhooks/:id/deliveries/:attempt/redeliver", routes.RedeliverWebhook)
+
 	app.Get("/:url", routes.ResolveURL)
-	app.Post("/api/v1", routes.ShortenURL)
+	app.Post("/:url", routes.VerifyCaptcha)
+	apiRoutes(app.Group("/api/v1", routes.APIVersion(1)))
+	apiRoutes(app.Group("/api/v2", routes.APIVersion(2)))
+
+	// Registered last so it never shadows a multi-segment route above.
+	app.Get("/:url/*", routes.ResolveURL)
+	app.Post("/:url/*", routes.VerifyCaptcha)
+}
+
+// apiRoutes registers the routes shared by every version of the API on
+// the group of one version.
+func apiRoutes(api fiber.Router) {
+	api.Post("/", routes.OptionalAPIKey, routes.AllowAnonymous, routes.RequireScope(routes.ScopeLinksWrite), routes.ShortenURL)
+	api.Get("/shorten", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.ShortenByGet)
+
+	ext := api.Group("/ext", routes.ExtensionCORS)
+	ext.Get("/shorten", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.ExtensionShorten)
+	ext.Post("/shorten", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.ExtensionShorten)
+
+	api.Get("/reports/clicks", routes.RequireAPIKey, routes.RequireScope(routes.ScopeStatsRead), routes.ClickReport)
+
+	api.Put("/account/sitemap", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.SetSitemap)
+	api.Get("/account/privacy", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksRead), routes.GetPrivacy)
+	api.Put("/account/privacy", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.SetPrivacy)
+
+	api.Get("/maintenance", routes.ListMaintenance)
+	api.Get("/top", routes.TopLinks)
+	api.Post("/resolve/batch", routes.OptionalAPIKey, routes.ResolveBatch)
+	api.Post("/graphql", routes.OptionalAPIKey, routes.AllowAnonymous, routes.GraphQL)
+
+	api.Get("/links/:short", routes.GetLink)
+	api.Patch("/links/:short", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.UpdateLink)
+	api.Delete("/links/:short", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.DeleteLink)
+	api.Post("/links/extend", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.BulkExtend)
+	api.Post("/links/:short/extend", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.ExtendLink)
+	api.Get("/extend/:token", routes.ExtendByToken)
+	api.Get("/links/:short/favicon", routes.Shed, routes.LinkFavicon)
+	api.Get("/links/:short/og-image", routes.Shed, routes.LinkOGImage)
+	api.Get("/card/:short", routes.Shed, routes.LinkCard)
+	api.Get("/links/:short/live", routes.RequireAPIKey, routes.RequireScope(routes.ScopeStatsRead), routes.LiveLink)
+	api.Get("/stats/:short/export", routes.RequireAPIKey, routes.RequireScope(routes.ScopeStatsRead), routes.ExportStats)
+
+	api.Get("/apikeys", routes.RequireAPIKey, routes.ListAPIKeys)
+	api.Post("/apikeys", routes.RequireAPIKey, routes.CreateOwnAPIKey)
+	api.Delete("/apikeys/:id", routes.RequireAPIKey, routes.RevokeAPIKey)
+
+	api.Post("/integrations/slack/connect", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.ConnectSlack)
+
+	api.Post("/transfers", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.CreateTransfer)
+	api.Get("/transfers", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksRead), routes.ListTransfers)
+	api.Post("/transfers/:id/accept", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.AcceptTransfer)
+	api.Delete("/transfers/:id", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.CancelTransfer)
+
+	api.Post("/orgs", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.CreateOrg)
+	api.Get("/orgs/:org", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksRead), routes.GetOrg)
+	api.Patch("/orgs/:org", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.UpdateOrg)
+	api.Get("/orgs/:org/links", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksRead), routes.OrgLinks)
+	api.Put("/orgs/:org/members/:member", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.SetOrgMember)
+	api.Delete("/orgs/:org/members/:member", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.RemoveOrgMember)
+
+	api.Post("/campaigns", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.CreateCampaign)
+	api.Post("/campaigns/:name/links", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.AddCampaignLinks)
+	api.Get("/campaigns/:name/stats", routes.RequireAPIKey, routes.RequireScope(routes.ScopeStatsRead), routes.CampaignStats)
+	api.Get("/campaigns/:name/live", routes.RequireAPIKey, routes.RequireScope(routes.ScopeStatsRead), routes.LiveCampaign)
+	api.Post("/campaigns/:name/disable", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.DisableCampaign)
+	api.Post("/campaigns/:name/enable", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.EnableCampaign)
+	api.Post("/campaigns/:name/extend", routes.RequireAPIKey, routes.RequireScope(routes.ScopeLinksWrite), routes.ExtendCampaign)
+}
+
+// serverConfig reads the SERVER_* tuning knobs. fasthttp only speaks
+// HTTP/1.1, HTTP/2 is left to the load balancer in front of the service.
+func serverConfig() fiber.Config {
+	cfg := fiber.Config{
+		ReadTimeout:      envDuration("SERVER_READ_TIMEOUT", 5*time.Second),
+		WriteTimeout:     envDuration("SERVER_WRITE_TIMEOUT", 10*time.Second),
+		IdleTimeout:      envDuration("SERVER_IDLE_TIMEOUT", 60*time.Second),
+		Prefork:          os.Getenv("SERVER_PREFORK") == "true",
+		DisableKeepalive: os.Getenv("SERVER_DISABLE_KEEPALIVE") == "true",
+		ErrorHandler:     routes.ErrorHandler,
+	}
+
+	if v, err := strconv.Atoi(os.Getenv("SERVER_MAX_CONNS")); err == nil && v > 0 {
+		cfg.Concurrency = v
+	}
+
+	return cfg
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+
+	return d
+}
+
+// watchDBCredentials keeps the redis credentials, and the TLS material
+// with a remote provider, up to date with the secrets provider so rotated
+// secrets are used by new connections.
+func watchDBCredentials() error {
+	provider, err := secrets.FromEnv()
+	if err != nil {
+		return err
+	}
+
+	interval := envDuration("SECRETS_REFRESH_INTERVAL", 30*time.Second)
+	user, err := secrets.Watch(database.Ctx, provider, "DB_USER", interval)
+	if err != nil {
+		return err
+	}
+	pass, err := secrets.Watch(database.Ctx, provider, "DB_PASS", interval)
+	if err != nil {
+		return err
+	}
+
+	database.SetAuth(func() (string, string) {
+		return user.Get(), pass.Get()
+	})
+
+	if !secrets.Remote(provider) || os.Getenv("DB_TLS") != "true" {
+		return nil
+	}
+
+	material := make([]*secrets.Value, 3)
+	for i, name := range []string{"DB_TLS_CA_CERT", "DB_TLS_CERT", "DB_TLS_KEY"} {
+		if material[i], err = secrets.Watch(database.Ctx, provider, name, interval); err != nil {
+			return err
+		}
+	}
+	database.SetTLSMaterial(func() ([]byte, []byte, []byte) {
+		return []byte(material[0].Get()), []byte(material[1].Get()), []byte(material[2].Get())
+	})
+
+	return nil
+}
+
+// bootstrapRedis seeds the redis structures a fresh environment lacks. A
+// failure is logged only, the routes fall back to built-in defaults.
+func bootstrapRedis() {
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		log.Printf("bootstrap: %v", err)
+		return
+	}
+	defer rClient.Close()
+
+	report, err := bootstrap.Run(database.Ctx, rClient)
+	if err != nil {
+		log.Printf("bootstrap: %v", err)
+		return
+	}
+	log.Printf("bootstrap: created %d counters, %d reserved words, %d defaults, search index %s",
+		report.Counters, report.Reserved, report.Defaults, report.Search)
+}
+
+// warmRedis connects the shared pools before the first request. A failure
+// is fatal with DB_WARMUP_REQUIRED, logged only otherwise, the pools then
+// keep connecting in the background.
+func warmRedis(cfg database.WarmConfig) {
+	report, err := database.Warm(database.Ctx, cfg.Timeout)
+	if err != nil {
+		if cfg.Required {
+			log.Fatalf("warm-up: %v", err)
+		}
+		log.Printf("warm-up: %v", err)
+		return
+	}
+	log.Printf("warm-up: %d connections to redis %s (%s) in %s",
+		report.Connections, report.Version, report.Role, report.Elapsed.Round(time.Millisecond))
+}
+
+func startJobs() {
+	geoip.Setup(database.Ctx)
+
+	evictionInterval, err := time.ParseDuration(os.Getenv("EVICTION_CHECK_INTERVAL"))
+	if err != nil {
+		evictionInterval = 5 * time.Minute
+	}
+	go health.MonitorEviction(database.Ctx, evictionInterval)
+
+	rewriteInterval, err := time.ParseDuration(os.Getenv("REWRITE_REFRESH_INTERVAL"))
+	if err != nil {
+		rewriteInterval = time.Minute
+	}
+	go rewrite.Default.Run(database.Ctx, rewriteInterval)
+
+	database.SetLatencyObserver(budget.Default.ObserveRedis)
+	go budget.Default.Run(database.Ctx, budget.ConfigFromEnv())
+
+	if cfg := redisstats.ConfigFromEnv(); cfg.Enabled {
+		go redisstats.NewCollector().Run(database.Ctx, cfg.Interval)
+	}
+
+	// Every process consults its own filter, unless it is shared.
+	if err := bloom.Default.Start(database.Ctx, bloom.ConfigFromEnv()); err != nil {
+		log.Printf("bloom: %v", err)
+	}
+
+	warmTop, err := strconv.Atoi(os.Getenv("WARM_TOP_N"))
+	if err != nil {
+		warmTop = 100
+	}
+	go routes.RunWarmer(database.Ctx, warmTop)
+
+	// With prefork every child serves requests, scheduled jobs only run in
+	// the parent process.
+	if fiber.IsChild() {
+		return
+	}
+
+	// Reports are due once per REPORT_INTERVAL, checked hourly against the
+	// last run stored in redis so that restarts don't reset the period.
+	if os.Getenv("REPORTS_ENABLED") == "true" {
+		interval, err := time.ParseDuration(os.Getenv("REPORT_INTERVAL"))
+		if err != nil {
+			interval = 7 * 24 * time.Hour
+		}
+		go jobs.Every(database.Ctx, "reports", time.Hour, reports.Job(mail.FromEnv(), interval))
+	}
+
+	if cfg := anomaly.ConfigFromEnv(); cfg.Enabled {
+		go jobs.Every(database.Ctx, "anomaly", time.Minute, anomaly.Job(cfg))
+	}
+
+	if os.Getenv("REMINDERS_ENABLED") == "true" {
+		go jobs.Every(database.Ctx, "reminders", time.Hour, reminders.Job(reminders.ConfigFromEnv(), mail.FromEnv()))
+	}
+
+	go jobs.Every(database.Ctx, "top", time.Hour, top.Job(top.ConfigFromEnv()))
+	go jobs.Every(database.Ctx, "purges", 30*time.Second, routes.RunPurges)
+	go jobs.Every(database.Ctx, "reaper", time.Hour, retention.Job(retention.ConfigFromEnv()))
+
+	if interval, err := time.ParseDuration(os.Getenv("CONSISTENCY_CHECK_INTERVAL")); err == nil && interval > 0 {
+		repair := os.Getenv("CONSISTENCY_REPAIR") == "true"
+		go jobs.Every(database.Ctx, "consistency", interval, consistency.Job(repair))
+	}
+
+	if interval, ok := links.ConsolidateInterval(); ok {
+		go jobs.Every(database.Ctx, "clicks", interval, links.ConsolidateClicks)
+	}
+
+	if os.Getenv("LINKCHECK_ENABLED") == "true" {
+		cfg := linkcheck.ConfigFromEnv()
+		go jobs.Every(database.Ctx, "linkcheck", cfg.Interval, linkcheck.Job(cfg, mail.FromEnv()))
+	}
+
+	// Consumer groups spread the streams over every instance, no job lock
+	// needed.
+	host, _ := os.Hostname()
+	consumer := host + "-" + strconv.Itoa(os.Getpid())
+
+	if cfg := analytics.ConfigFromEnv(); cfg.Enabled {
+		sink, err := analytics.Open(cfg.Driver, cfg.DSN)
+		if err != nil {
+			log.Printf("analytics: %v", err)
+		} else {
+			go analytics.NewExporter(cfg, sink, consumer).Run(database.Ctx)
+		}
+	}
+
+	// The relay gets its own publisher, it waits for every event to be
+	// accepted while Emit never blocks.
+	publisher, err := events.FromEnv()
+	if err != nil {
+		log.Printf("outbox: %v", err)
+	}
+	go outbox.NewRelay(outbox.ConfigFromEnv(), publisher, consumer).Run(database.Ctx)
+
+	archiver, err := archive.FromEnv()
+	if err != nil {
+		log.Printf("archive: %v", err)
+	} else if archiver != nil {
+		// Look two runs ahead so a short is archived even if one run fails.
+		go jobs.Every(database.Ctx, "archive", 5*time.Minute, archive.Job(archiver, 10*time.Minute))
+	}
 }
 
 func main() {
-	err := godotenv.Load()
+	opts, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if os.Getenv("DB_PASS_FILE") != "" || os.Getenv("SECRETS_PROVIDER") != "" {
+		if err := watchDBCredentials(); err != nil {
+			log.Fatalf("secrets: %v", err)
+		}
+	}
 
+	if opts.ValidateConfig || opts.Check {
+		validate := config.Validate
+		if opts.Check {
+			validate = config.Check
+		}
+		if failed := validate(os.Stdout); failed > 0 {
+			fmt.Printf("%d check(s) failed\n", failed)
+			os.Exit(1)
+		}
+		fmt.Println("configuration is valid")
+		os.Exit(0)
+	}
+
+	if dir := os.Getenv("I18N_DIR"); dir != "" {
+		if err := i18n.LoadDir(dir); err != nil {
+			log.Fatalf("i18n: %v", err)
+		}
+	}
+
+	publisher, err := events.FromEnv()
 	if err != nil {
-		fmt.Println(err)
+		log.Printf("events: %v", err)
+	} else if publisher != nil {
+		events.Start(database.Ctx, publisher)
+	}
+	if err := clicklog.Start(database.Ctx, clicklog.ConfigFromEnv()); err != nil {
+		log.Printf("%v", err)
+	}
+	// Every process replays its own journal, the first one also those left
+	// behind by processes that are gone.
+	if err := journal.Start(database.Ctx, journal.ConfigFromEnv(), !fiber.IsChild(), routes.JournalHandlers()); err != nil {
+		log.Printf("%v", err)
 	}
 
-	app := fiber.New()
+	app := fiber.New(serverConfig())
 	app.Use(logger.New())
+	app.Use(routes.Latency())
+	app.Use(routes.Compress())
+	app.Use(routes.Envelope())
+	app.Use(routes.Localize())
+	app.Use(routes.ReadOnly())
+	app.Use(routes.Maintenance())
+
+	if !fiber.IsChild() && os.Getenv("BOOTSTRAP_ON_START") == "true" {
+		bootstrapRedis()
+	}
+	if cfg := database.WarmConfigFromEnv(); cfg.Enabled {
+		warmRedis(cfg)
+	}
 
 	setupRoutes(app)
+	startJobs()
 
 	log.Fatal(app.Listen(os.Getenv("APP_PORT")))
 }
@@ -0,0 +1,200 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ksarpe/redis-golang/destination"
+)
+
+// MaxPageBytes bounds how much of a destination page is read when looking
+// for metadata.
+const MaxPageBytes = 512 << 10
+
+var (
+	ErrNotFound    = errors.New("asset not found")
+	ErrTooLarge    = errors.New("asset exceeds size limit")
+	ErrNotAnImage  = errors.New("asset is not an image")
+	errBadResponse = errors.New("unexpected response status")
+)
+
+var client = &http.Client{Timeout: 10 * time.Second, Transport: destination.Transport()}
+
+var (
+	tagPattern  = regexp.MustCompile(`(?is)<(meta|link)\b[^>]*>`)
+	attrPattern = regexp.MustCompile(`(?is)([a-z][a-z0-9:_-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// rasterTypes are the image types served back from the API origin. SVG
+// and other types able to carry scripts are refused.
+var rasterTypes = map[string]bool{
+	"image/png":                true,
+	"image/jpeg":               true,
+	"image/gif":                true,
+	"image/webp":               true,
+	"image/x-icon":             true,
+	"image/vnd.microsoft.icon": true,
+}
+
+// RasterType returns the media type of contentType without parameters,
+// and whether it is a raster image type.
+func RasterType(contentType string) (string, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+
+	return mediaType, rasterTypes[mediaType]
+}
+
+// Asset is a fetched binary resource.
+type Asset struct {
+	ContentType string
+	Body        []byte
+}
+
+// Favicon returns the icon declared by the page, falling back to
+// /favicon.ico on the destination host.
+func Favicon(ctx context.Context, pageURL string, maxBytes int64) (*Asset, error) {
+	page, base, err := fetchPage(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	href := findTag(page, "link", "rel", func(rel string) bool {
+		for _, v := range strings.Fields(strings.ToLower(rel)) {
+			if v == "icon" {
+				return true
+			}
+		}
+		return false
+	})
+	if href == "" {
+		href = "/favicon.ico"
+	}
+
+	return fetchImage(ctx, base, href, maxBytes)
+}
+
+// OGImage returns the og:image declared by the page.
+func OGImage(ctx context.Context, pageURL string, maxBytes int64) (*Asset, error) {
+	page, base, err := fetchPage(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	href := findTag(page, "meta", "property", func(v string) bool {
+		return strings.EqualFold(v, "og:image")
+	})
+	if href == "" {
+		return nil, ErrNotFound
+	}
+
+	return fetchImage(ctx, base, href, maxBytes)
+}
+
+func fetchPage(ctx context.Context, pageURL string) (string, *url.URL, error) {
+	resp, err := get(ctx, pageURL)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxPageBytes))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return string(body), resp.Request.URL, nil
+}
+
+func fetchImage(ctx context.Context, base *url.URL, href string, maxBytes int64) (*Asset, error) {
+	ref, err := url.Parse(href)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	resp, err := get(ctx, base.ResolveReference(ref).String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	contentType, ok := RasterType(resp.Header.Get("Content-Type"))
+	if !ok {
+		return nil, ErrNotAnImage
+	}
+	if resp.ContentLength > maxBytes {
+		return nil, ErrTooLarge
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, ErrTooLarge
+	}
+
+	return &Asset{ContentType: contentType, Body: body}, nil
+}
+
+func get(ctx context.Context, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "redis-golang-shortener/1.0 (+preview)")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s, err: %w", target, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w %s from %s", errBadResponse, resp.Status, target)
+	}
+
+	return resp, nil
+}
+
+// findTag returns the href/content of the first tag named name whose attr
+// attribute satisfies match.
+func findTag(page, name, attr string, match func(string) bool) string {
+	for _, tag := range tagPattern.FindAllStringSubmatch(page, -1) {
+		if !strings.EqualFold(tag[1], name) {
+			continue
+		}
+
+		attrs := map[string]string{}
+		for _, a := range attrPattern.FindAllStringSubmatch(tag[0], -1) {
+			attrs[strings.ToLower(a[1])] = html.UnescapeString(a[2] + a[3] + a[4])
+		}
+
+		if !match(attrs[attr]) {
+			continue
+		}
+		if v := attrs["href"]; v != "" {
+			return v
+		}
+		if v := attrs["content"]; v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// collector is a metric rendered on /metrics.
+type collector interface {
+	metricName() string
+	write(b *strings.Builder)
+}
+
+// Counter is a monotonically increasing value exposed on /metrics.
+type Counter struct {
+	name  string
+	help  string
+	value atomic.Int64
+}
+
+// Gauge is a value that can go up and down, exposed on /metrics.
+type Gauge struct {
+	name string
+	help string
+	bits atomic.Uint64
+}
+
+var (
+	mu         sync.Mutex
+	collectors = map[string]collector{}
+)
+
+// NewCounter registers a counter. Registering the same name twice returns
+// the existing counter.
+func NewCounter(name, help string) *Counter {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if c, ok := collectors[name].(*Counter); ok {
+		return c
+	}
+
+	c := &Counter{name: name, help: help}
+	collectors[name] = c
+
+	return c
+}
+
+// NewGauge registers a gauge. Registering the same name twice returns the
+// existing gauge.
+func NewGauge(name, help string) *Gauge {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if g, ok := collectors[name].(*Gauge); ok {
+		return g
+	}
+
+	g := &Gauge{name: name, help: help}
+	collectors[name] = g
+
+	return g
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add adds n to the counter.
+func (c *Counter) Add(n int64) {
+	c.value.Add(n)
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() int64 {
+	return c.value.Load()
+}
+
+func (c *Counter) metricName() string {
+	return c.name
+}
+
+func (c *Counter) write(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.Value())
+}
+
+// Set replaces the value of the gauge.
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) metricName() string {
+	return g.name
+}
+
+func (g *Gauge) write(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name,
+		strconv.FormatFloat(g.Value(), 'g', -1, 64))
+}
+
+// Handler serves all registered metrics in the Prometheus text format.
+func Handler(c *fiber.Ctx) error {
+	mu.Lock()
+	all := make([]collector, 0, len(collectors))
+	for _, m := range collectors {
+		all = append(all, m)
+	}
+	mu.Unlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].metricName() < all[j].metricName() })
+
+	var b strings.Builder
+	for _, m := range all {
+		m.write(&b)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4")
+	return c.SendString(b.String())
+}
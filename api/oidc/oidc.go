@@ -0,0 +1,272 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotConfigured = errors.New("single sign-on is not configured")
+	ErrInvalidToken  = errors.New("invalid ID token")
+	ErrDomain        = errors.New("email domain not allowed")
+)
+
+// Config selects the identity provider, OIDC_ISSUER being its issuer URL
+// as listed in its discovery document.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// AllowedDomains restricts logins to these email domains when set.
+	AllowedDomains []string
+}
+
+// ConfigFromEnv reads the OIDC_* environment variables.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Issuer:       strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+
+	if v := os.Getenv("OIDC_SCOPES"); v != "" {
+		cfg.Scopes = strings.Fields(strings.ReplaceAll(v, ",", " "))
+	}
+	for _, domain := range strings.Split(os.Getenv("OIDC_ALLOWED_DOMAINS"), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			cfg.AllowedDomains = append(cfg.AllowedDomains, strings.ToLower(domain))
+		}
+	}
+
+	return cfg
+}
+
+// Enabled reports whether a provider is configured.
+func (cfg Config) Enabled() bool {
+	return cfg.Issuer != "" && cfg.ClientID != ""
+}
+
+// Identity is the verified user behind a login.
+type Identity struct {
+	Subject string
+	Email   string
+	Name    string
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// Provider runs the authorization code flow with PKCE against the
+// configured identity provider.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu        sync.Mutex
+	endpoints *discovery
+}
+
+// New returns the provider of cfg. Its discovery document is fetched on
+// first use and kept once it succeeds.
+func New(cfg Config) *Provider {
+	return &Provider{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.endpoints != nil {
+		return p.endpoints, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch discovery document, err: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch discovery document, status: %s", resp.Status)
+	}
+
+	var d discovery
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, fmt.Errorf("failed to parse discovery document, err: %w", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %s", d.Issuer)
+	}
+	p.endpoints = &d
+
+	return p.endpoints, nil
+}
+
+// AuthURL returns where to send the user to log in. The provider echoes
+// state back to the callback, nonce comes back inside the ID token and
+// verifier is the PKCE secret later sent to Exchange.
+func (p *Provider) AuthURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return d.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// Exchange redeems the authorization code and returns the identity in the
+// ID token. The token comes straight from the token endpoint over TLS, so
+// as allowed by OpenID Connect Core 3.1.3.7 its claims are checked rather
+// than its signature.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {verifier},
+	}
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem code, err: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to redeem code, status: %s", resp.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil || tokens.IDToken == "" {
+		return nil, ErrInvalidToken
+	}
+
+	return p.verify(tokens.IDToken, nonce, time.Now())
+}
+
+func (p *Provider) verify(idToken, nonce string, now time.Time) (*Identity, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims struct {
+		Issuer        string          `json:"iss"`
+		Subject       string          `json:"sub"`
+		Audience      json.RawMessage `json:"aud"`
+		ExpiresAt     int64           `json:"exp"`
+		Nonce         string          `json:"nonce"`
+		Email         string          `json:"email"`
+		EmailVerified *bool           `json:"email_verified"`
+		Name          string          `json:"name"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if strings.TrimSuffix(claims.Issuer, "/") != p.cfg.Issuer || claims.Subject == "" ||
+		!audience(claims.Audience, p.cfg.ClientID) || now.Unix() >= claims.ExpiresAt || claims.Nonce != nonce {
+		return nil, ErrInvalidToken
+	}
+
+	if len(p.cfg.AllowedDomains) > 0 {
+		_, domain, _ := strings.Cut(strings.ToLower(claims.Email), "@")
+		allowed := claims.EmailVerified == nil || *claims.EmailVerified
+		if !allowed || !contains(p.cfg.AllowedDomains, domain) {
+			return nil, ErrDomain
+		}
+	}
+
+	return &Identity{Subject: claims.Subject, Email: claims.Email, Name: claims.Name}, nil
+}
+
+// audience reports whether aud, a string or an array of strings, holds
+// clientID.
+func audience(aud json.RawMessage, clientID string) bool {
+	var one string
+	if json.Unmarshal(aud, &one) == nil {
+		return one == clientID
+	}
+
+	var many []string
+	if json.Unmarshal(aud, &many) == nil {
+		return contains(many, clientID)
+	}
+
+	return false
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
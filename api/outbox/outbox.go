@@ -0,0 +1,246 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/events"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/metrics"
+	"github.com/ksarpe/redis-golang/webhooks"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Group is the consumer group shared by the relays of every instance, so
+// each event is relayed by one of them only.
+const Group = "relay"
+
+var (
+	relayed  = metrics.NewCounter("outbox_relayed_total", "Outbox events published to the event bus and webhooks.")
+	failures = metrics.NewCounter("outbox_relay_failures_total", "Outbox events that failed to publish and will be retried.")
+	expired  = metrics.NewCounter("outbox_expired_total", "Outbox events given up on after OUTBOX_MAX_AGE.")
+)
+
+// writeScript writes a link record and queues the event describing the
+// write in a single step, so an event is never lost nor sent for a write
+// that didn't happen.
+//
+// KEYS[1] link record, KEYS[2] outbox; ARGV[1] event type, ARGV[2] encoded
+// event, ARGV[3] record encoding, ARGV[4] number of field/value pairs to
+// set, followed by the pairs and then the fields to delete.
+var writeScript = radix.NewEvalScript(links.MetaLua + `
+local n = tonumber(ARGV[4])
+local set, del = {}, {}
+for i = 5, 4 + 2 * n, 2 do
+	set[ARGV[i]] = ARGV[i + 1]
+end
+for i = 5 + 2 * n, #ARGV do
+	del[#del + 1] = ARGV[i]
+end
+meta_write(KEYS[1], ARGV[3], set, del)
+return redis.call('XADD', KEYS[2], '*', 'type', ARGV[1], 'payload', ARGV[2])
+`)
+
+func encode(eventType string, data any) (string, error) {
+	payload, err := json.Marshal(webhooks.Event{Type: eventType, CreatedAt: time.Now().Unix(), Data: data})
+
+	return string(payload), err
+}
+
+// Write sets the field/value pairs of set and deletes the fields of del in
+// the link record at key, queueing an event of eventType in the same
+// script.
+func Write(rClient database.ClientInterface, key string, set, del []string, eventType string, data any) error {
+	payload, err := encode(eventType, data)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{eventType, payload, links.MetaEncoding(), strconv.Itoa(len(set) / 2)}, set...)
+	args = append(args, del...)
+
+	return rClient.Do(writeScript.Cmd(nil, []string{key, links.OutboxKey()}, args...))
+}
+
+// Cmd returns the command queueing an event, for writes that can't go
+// through Write. It must run in the same MULTI as the write.
+func Cmd(eventType string, data any) (radix.Action, error) {
+	payload, err := encode(eventType, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return radix.Cmd(nil, "XADD", links.OutboxKey(), "*", "type", eventType, "payload", payload), nil
+}
+
+// Config controls the relay.
+type Config struct {
+	Batch     int
+	Interval  time.Duration
+	ClaimIdle time.Duration
+	MaxAge    time.Duration
+}
+
+// ConfigFromEnv reads the OUTBOX_* environment variables.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Batch:     100,
+		Interval:  time.Second,
+		ClaimIdle: 30 * time.Second,
+		MaxAge:    24 * time.Hour,
+	}
+
+	if v, err := strconv.Atoi(os.Getenv("OUTBOX_BATCH")); err == nil && v > 0 {
+		cfg.Batch = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("OUTBOX_INTERVAL")); err == nil && v > 0 {
+		cfg.Interval = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("OUTBOX_CLAIM_IDLE")); err == nil && v > 0 {
+		cfg.ClaimIdle = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("OUTBOX_MAX_AGE")); err == nil && v > 0 {
+		cfg.MaxAge = v
+	}
+
+	return cfg
+}
+
+// Relay publishes the outbox to the event bus and the webhooks. Events
+// are removed once published, at least once: the stream id is the
+// webhook delivery id so receivers can drop duplicates.
+type Relay struct {
+	cfg       Config
+	publisher events.Publisher
+	consumer  string
+}
+
+// NewRelay returns a relay reading the outbox as consumer. publisher may
+// be nil when no event bus is configured.
+func NewRelay(cfg Config, publisher events.Publisher, consumer string) *Relay {
+	return &Relay{cfg: cfg, publisher: publisher, consumer: consumer}
+}
+
+// Run relays batches until ctx is cancelled. Events failing to publish
+// stay pending and are claimed again after cfg.ClaimIdle, by this relay or
+// another one, until cfg.MaxAge.
+func (r *Relay) Run(ctx context.Context) {
+	if r.publisher != nil {
+		defer r.publisher.Close()
+	}
+
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := r.relayOnce(ctx)
+			if err != nil {
+				log.Printf("outbox: %v", err)
+				break
+			}
+			if n < r.cfg.Batch {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relayOnce relays one batch, preferring events idle in a consumer, and
+// returns its size.
+func (r *Relay) relayOnce(ctx context.Context) (int, error) {
+	rClient, err := database.Bulk()
+	if err != nil {
+		return 0, err
+	}
+
+	err = rClient.Do(radix.Cmd(nil, "XGROUP", "CREATE", links.OutboxKey(), Group, "0", "MKSTREAM"))
+	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		return 0, err
+	}
+
+	var entries []radix.StreamEntry
+	err = rClient.Do(radix.Cmd(radix.Tuple{nil, &entries, nil}, "XAUTOCLAIM", links.OutboxKey(), Group, r.consumer,
+		strconv.FormatInt(r.cfg.ClaimIdle.Milliseconds(), 10), "0-0", "COUNT", strconv.Itoa(r.cfg.Batch)))
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		var streams []radix.StreamEntries
+		err := rClient.Do(radix.Cmd(&streams, "XREADGROUP", "GROUP", Group, r.consumer,
+			"COUNT", strconv.Itoa(r.cfg.Batch), "STREAMS", links.OutboxKey(), ">"))
+		if err != nil {
+			return 0, err
+		}
+		for _, s := range streams {
+			entries = append(entries, s.Entries...)
+		}
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	var done []string
+	for _, entry := range entries {
+		id := entry.ID.String()
+		if err := r.publish(ctx, id, entry); err != nil {
+			if time.Since(time.UnixMilli(int64(entry.ID.Time))) < r.cfg.MaxAge {
+				failures.Inc()
+				log.Printf("outbox: failed to relay %s, err: %v", id, err)
+				continue
+			}
+			expired.Inc()
+			log.Printf("outbox: giving up on %s, err: %v", id, err)
+		} else {
+			relayed.Inc()
+		}
+		done = append(done, id)
+	}
+	if len(done) == 0 {
+		return len(entries), nil
+	}
+
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "XACK", append([]string{links.OutboxKey(), Group}, done...)...))
+	p.Append(radix.Cmd(nil, "XDEL", append([]string{links.OutboxKey()}, done...)...))
+	if err := rClient.Do(p); err != nil {
+		return 0, err
+	}
+
+	return len(entries), nil
+}
+
+func (r *Relay) publish(ctx context.Context, id string, entry radix.StreamEntry) error {
+	var eventType, payload string
+	for _, f := range entry.Fields {
+		switch f[0] {
+		case "type":
+			eventType = f[1]
+		case "payload":
+			payload = f[1]
+		}
+	}
+
+	if r.publisher != nil {
+		pctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := r.publisher.Publish(pctx, events.Subject(eventType), []byte(payload))
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+
+	return webhooks.Deliver(id, eventType, []byte(payload))
+}
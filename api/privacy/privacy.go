@@ -0,0 +1,107 @@
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"os"
+
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Policy is how the clicks on the links of a tenant are analyzed, and how
+// the data of its visitors and users is kept.
+type Policy struct {
+	// Private keeps no country, user agent or full referrer of clicks, and
+	// only truncated, possibly hashed, IPs.
+	Private bool
+	// HonorDNT keeps nothing but the click counts for visitors sending
+	// DNT: 1 or Sec-GPC: 1.
+	HonorDNT bool
+}
+
+// DefaultFromEnv reads the policy of tenants with none set: PRIVACY_MODE,
+// off unless "true", and PRIVACY_HONOR_DNT, on unless "false".
+func DefaultFromEnv() Policy {
+	return Policy{
+		Private:  os.Getenv("PRIVACY_MODE") == "true",
+		HonorDNT: os.Getenv("PRIVACY_HONOR_DNT") != "false",
+	}
+}
+
+// Load returns the policy of owner, the "privacy_mode" and "privacy_dnt"
+// fields of their profile overriding def. Anonymous links follow def.
+func Load(rClient database.ClientInterface, owner string, def Policy) (Policy, error) {
+	if owner == "" {
+		return def, nil
+	}
+
+	var fields []string
+	if err := rClient.Do(radix.Cmd(&fields, "HMGET", links.UserKey(owner), "privacy_mode", "privacy_dnt")); err != nil {
+		return def, err
+	}
+
+	policy := def
+	if len(fields) == 2 {
+		if fields[0] != "" {
+			policy.Private = fields[0] == "1"
+		}
+		if fields[1] != "" {
+			policy.HonorDNT = fields[1] == "1"
+		}
+	}
+
+	return policy, nil
+}
+
+// OptedOut reports whether a visitor whose request headers are read
+// through header asked not to be tracked, and the policy honors it.
+func (p Policy) OptedOut(header func(string) string) bool {
+	return p.HonorDNT && (header("DNT") == "1" || header("Sec-GPC") == "1")
+}
+
+// Referrer returns the referrer to keep, its origin only in private mode.
+func (p Policy) Referrer(referrer string) string {
+	if !p.Private || referrer == "" {
+		return referrer
+	}
+
+	u, err := url.Parse(referrer)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	return u.Scheme + "://" + u.Host
+}
+
+// IP returns the address to keep, in private mode truncated to its /24
+// or /48 network, then hashed with PRIVACY_IP_SALT when set so that
+// addresses can still be told apart without being stored.
+func (p Policy) IP(ip string) string {
+	if !p.Private {
+		return ip
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		parsed = v4.Mask(net.CIDRMask(24, 32))
+	} else {
+		parsed = parsed.Mask(net.CIDRMask(48, 128))
+	}
+
+	salt := os.Getenv("PRIVACY_IP_SALT")
+	if salt == "" {
+		return parsed.String()
+	}
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(parsed.String()))
+
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
@@ -0,0 +1,197 @@
+package quota
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/mail"
+	"github.com/ksarpe/redis-golang/metrics"
+	"github.com/ksarpe/redis-golang/webhooks"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+var graceRequests = metrics.NewCounter("quota_grace_requests_total", "Requests let through over the daily quota of a grace plan.")
+
+// Plan is a tier of the daily request quota of owners.
+type Plan struct {
+	Name string
+	// Limit is the requests a day of every owner on the plan.
+	Limit int64
+	// Grace lets the requests over the quota through, tagged, instead of
+	// refusing them.
+	Grace bool
+}
+
+// Config controls the daily request quotas. Owners without a plan, or
+// with a plan missing from Plans, have no quota.
+type Config struct {
+	Plans map[string]Plan
+	// Default is the plan of owners with none set.
+	Default string
+	Mailer  mail.Mailer
+}
+
+// ConfigFromEnv reads QUOTA_PLANS, a comma separated list of name=limit
+// tiers, "+grace" after the limit for grace mode, as in
+// "free=10000,pro=1000000+grace", and QUOTA_DEFAULT_PLAN. Quotas are off
+// without plans.
+func ConfigFromEnv() Config {
+	cfg := Config{Plans: map[string]Plan{}, Default: os.Getenv("QUOTA_DEFAULT_PLAN"), Mailer: mail.FromEnv()}
+
+	for _, tier := range strings.Split(os.Getenv("QUOTA_PLANS"), ",") {
+		name, limit, ok := strings.Cut(strings.TrimSpace(tier), "=")
+		if !ok || name == "" {
+			continue
+		}
+		limit, grace := strings.CutSuffix(limit, "+grace")
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || n <= 0 {
+			log.Printf("quota: ignoring plan %q", tier)
+			continue
+		}
+		cfg.Plans[name] = Plan{Name: name, Limit: n, Grace: grace}
+	}
+
+	return cfg
+}
+
+// Enabled reports whether any plan has a quota.
+func (cfg Config) Enabled() bool {
+	return len(cfg.Plans) > 0
+}
+
+// Usage is the quota of an owner after counting a request.
+type Usage struct {
+	Plan  string
+	Limit int64
+	Used  int64
+	// Reset is how long until the quota is renewed, at midnight UTC.
+	Reset time.Duration
+	Grace bool
+}
+
+// Exceeded reports whether the request counted went over the quota.
+func (u Usage) Exceeded() bool {
+	return u.Used > u.Limit
+}
+
+// Event is the payload of the quota.warning and quota.reached webhooks,
+// sent when an owner uses 80% and 100% of their quota.
+type Event struct {
+	Owner   string `json:"owner"`
+	Plan    string `json:"plan"`
+	Limit   int64  `json:"limit"`
+	Used    int64  `json:"used"`
+	ResetAt int64  `json:"reset_at"`
+	Grace   bool   `json:"grace"`
+}
+
+// Count counts a request of owner against the daily quota of their plan.
+// It returns false when the owner has no quota. Requests over the quota
+// are counted too, and the owner is notified the first time the day's
+// count reaches 80% and 100% of the quota.
+func Count(rClient database.ClientInterface, cfg Config, owner string) (Usage, bool, error) {
+	if !cfg.Enabled() || owner == "" {
+		return Usage{}, false, nil
+	}
+
+	now := clock.Now().UTC()
+	day := now.Truncate(24 * time.Hour)
+	reset := day.Add(24 * time.Hour).Sub(now)
+	key := links.QuotaKey(owner, day.Format("20060102"))
+
+	var plan string
+	var used int64
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(&plan, "HGET", links.UserKey(owner), "plan"))
+	p.Append(radix.Cmd(&used, "INCR", key))
+	p.Append(radix.Cmd(nil, "PEXPIRE", key, strconv.FormatInt((reset+time.Hour).Milliseconds(), 10)))
+	if err := rClient.Do(p); err != nil {
+		return Usage{}, false, err
+	}
+
+	if plan == "" {
+		plan = cfg.Default
+	}
+	tier, ok := cfg.Plans[plan]
+	if !ok {
+		return Usage{}, false, nil
+	}
+
+	usage := Usage{Plan: plan, Limit: tier.Limit, Used: used, Reset: reset, Grace: tier.Grace}
+	if usage.Exceeded() && usage.Grace {
+		graceRequests.Inc()
+	}
+
+	// INCR hands every count to a single request, each threshold is
+	// notified once a day.
+	switch used {
+	case tier.Limit:
+		notify(cfg, "quota.reached", owner, usage)
+	case (tier.Limit*4 + 4) / 5:
+		notify(cfg, "quota.warning", owner, usage)
+	}
+
+	return usage, true, nil
+}
+
+// notify sends the event to the webhooks and, when SMTP is configured, by
+// email to the owner, in the background.
+func notify(cfg Config, eventType, owner string, usage Usage) {
+	event := Event{
+		Owner:   owner,
+		Plan:    usage.Plan,
+		Limit:   usage.Limit,
+		Used:    usage.Used,
+		ResetAt: clock.Now().Add(usage.Reset).Unix(),
+		Grace:   usage.Grace,
+	}
+	webhooks.Send(eventType, event)
+
+	if !cfg.Mailer.Enabled() {
+		return
+	}
+	go func() {
+		if err := mailOwner(cfg.Mailer, event, eventType == "quota.reached"); err != nil {
+			log.Printf("quota: %v", err)
+		}
+	}()
+}
+
+func mailOwner(mailer mail.Mailer, event Event, reached bool) error {
+	rClient, err := database.Shared()
+	if err != nil {
+		return err
+	}
+
+	var email string
+	if err := rClient.Do(radix.Cmd(&email, "HGET", links.UserKey(event.Owner), "email")); err != nil {
+		return err
+	}
+	if email == "" {
+		return nil
+	}
+
+	resetAt := time.Unix(event.ResetAt, 0).UTC().Format(time.RFC1123)
+	subject := "You used 80% of your daily API quota"
+	body := fmt.Sprintf("You made %d of the %d API requests of your %s plan today.\n\nThe quota renews on %s.\n",
+		event.Used, event.Limit, event.Plan, resetAt)
+	if reached {
+		subject = "You reached your daily API quota"
+		next := "Further requests are refused"
+		if event.Grace {
+			next = "Further requests are still served, as overage"
+		}
+		body = fmt.Sprintf("You made all %d API requests of your %s plan today. %s until the quota renews on %s.\n",
+			event.Limit, event.Plan, next, resetAt)
+	}
+
+	return mailer.Send(email, subject, body)
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Result is the outcome of one attempt against a limit.
+type Result struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	// Reset is how long until the current window ends.
+	Reset time.Duration
+}
+
+// Allow counts an attempt against key, allowing limit attempts per window.
+// Windows are aligned on the clock, each counted under its own key.
+// Attempts over the limit are counted too, so hammering a limited key
+// doesn't get anything through.
+func Allow(rClient database.ClientInterface, key string, limit int64, window time.Duration) (Result, error) {
+	now := clock.Now()
+	bucket := now.UnixNano() / int64(window)
+	reset := time.Duration((bucket+1)*int64(window) - now.UnixNano())
+	key += ":" + strconv.FormatInt(bucket, 10)
+
+	var count int64
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(&count, "INCR", key))
+	p.Append(radix.Cmd(nil, "PEXPIRE", key, strconv.FormatInt((reset+time.Second).Milliseconds(), 10)))
+	if err := rClient.Do(p); err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Allowed:   count <= limit,
+		Limit:     limit,
+		Remaining: max(limit-count, 0),
+		Reset:     reset,
+	}, nil
+}
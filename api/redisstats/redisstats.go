@@ -0,0 +1,268 @@
+package redisstats
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/metrics"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Config controls the collector of redis server metrics.
+type Config struct {
+	Enabled  bool
+	Interval time.Duration
+}
+
+// ConfigFromEnv reads REDIS_EXPORTER_*.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Enabled:  os.Getenv("REDIS_EXPORTER_ENABLED") == "true",
+		Interval: 15 * time.Second,
+	}
+	if d, err := time.ParseDuration(os.Getenv("REDIS_EXPORTER_INTERVAL")); err == nil && d > 0 {
+		cfg.Interval = d
+	}
+
+	return cfg
+}
+
+// gauges mirror INFO fields whose value goes up and down.
+var gauges = map[string]*metrics.Gauge{
+	"used_memory":             metrics.NewGauge("redis_used_memory_bytes", "Memory allocated by redis."),
+	"used_memory_rss":         metrics.NewGauge("redis_used_memory_rss_bytes", "Memory of the redis process as seen by the OS."),
+	"maxmemory":               metrics.NewGauge("redis_maxmemory_bytes", "maxmemory of redis, 0 when unset."),
+	"mem_fragmentation_ratio": metrics.NewGauge("redis_mem_fragmentation_ratio", "used_memory_rss divided by used_memory."),
+	"connected_clients":       metrics.NewGauge("redis_connected_clients", "Clients connected to redis."),
+	"blocked_clients":         metrics.NewGauge("redis_blocked_clients", "Clients blocked in a blocking command."),
+	"uptime_in_seconds":       metrics.NewGauge("redis_uptime_seconds", "Seconds since redis started."),
+}
+
+// counters mirror INFO fields that only grow until redis restarts.
+var counters = map[string]*metrics.Counter{
+	"evicted_keys":               metrics.NewCounter("redis_evicted_keys_total", "Keys evicted by redis because of maxmemory."),
+	"expired_keys":               metrics.NewCounter("redis_expired_keys_total", "Keys expired by redis."),
+	"keyspace_hits":              metrics.NewCounter("redis_keyspace_hits_total", "Successful key lookups in redis."),
+	"keyspace_misses":            metrics.NewCounter("redis_keyspace_misses_total", "Failed key lookups in redis."),
+	"total_commands_processed":   metrics.NewCounter("redis_commands_processed_total", "Commands processed by redis."),
+	"rejected_connections":       metrics.NewCounter("redis_rejected_connections_total", "Connections rejected by redis because of maxclients."),
+	"total_connections_received": metrics.NewCounter("redis_connections_received_total", "Connections accepted by redis."),
+}
+
+var (
+	up       = metrics.NewGauge("redis_up", "1 when the last collection of redis metrics succeeded.")
+	keys     = metrics.NewGauge("redis_keys", "Keys in every redis database.")
+	slow     = metrics.NewCounter("redis_slow_commands_total", "Commands redis logged as slow.")
+	slowTime = metrics.NewCounter("redis_slow_commands_microseconds_total", "Time spent in commands redis logged as slow.")
+)
+
+// Collector polls INFO and SLOWLOG and publishes what they report on
+// /metrics. Counters only ever see the growth between two polls, so that a
+// restart of redis doesn't make them go down.
+type Collector struct {
+	last     map[string]int64
+	lastSlow int64
+}
+
+// NewCollector returns a collector that hasn't polled yet.
+func NewCollector() *Collector {
+	return &Collector{last: map[string]int64{}, lastSlow: -1}
+}
+
+// Run collects now and then every interval until ctx is cancelled. Every
+// process collects on its own as every process serves its own /metrics.
+func (c *Collector) Run(ctx context.Context, interval time.Duration) {
+	for {
+		if err := c.collect(); err != nil {
+			up.Set(0)
+			log.Printf("redisstats: %v", err)
+		} else {
+			up.Set(1)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (c *Collector) collect() error {
+	rClient, err := database.Bulk()
+	if err != nil {
+		return err
+	}
+
+	info, err := database.Info(rClient, "all")
+	if err != nil {
+		return err
+	}
+
+	for field, g := range gauges {
+		if v, err := strconv.ParseFloat(info[field], 64); err == nil {
+			g.Set(v)
+		}
+	}
+	for field, counter := range counters {
+		if v, err := strconv.ParseInt(info[field], 10, 64); err == nil {
+			counter.Add(c.growth(field, v))
+		}
+	}
+
+	// Keyspace lines read "db0:keys=12,expires=3,avg_ttl=0".
+	total := 0.0
+	for field, value := range info {
+		if !strings.HasPrefix(field, "db") {
+			continue
+		}
+		stats, _, _ := strings.Cut(value, ",")
+		if n, ok := strings.CutPrefix(stats, "keys="); ok {
+			v, _ := strconv.ParseFloat(n, 64)
+			total += v
+		}
+	}
+	keys.Set(total)
+
+	return c.collectSlowlog(rClient)
+}
+
+// growth returns how much the INFO counter field grew since the last poll.
+// A value below the last one means redis restarted and counts from zero.
+func (c *Collector) growth(field string, v int64) int64 {
+	last, seen := c.last[field]
+	c.last[field] = v
+	switch {
+	case !seen:
+		return 0
+	case v < last:
+		return v
+	}
+
+	return v - last
+}
+
+// collectSlowlog counts the slowlog entries added since the last poll. The
+// slowlog is bounded by slowlog-max-len, entries dropped between two polls
+// go uncounted.
+func (c *Collector) collectSlowlog(rClient database.ClientInterface) error {
+	entries, err := Slowlog(rClient, 128)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	newest := entries[0].ID
+	if c.lastSlow >= 0 {
+		for _, e := range entries {
+			// IDs restart at zero with redis, every entry is new then.
+			if e.ID <= c.lastSlow && newest >= c.lastSlow {
+				break
+			}
+			slow.Inc()
+			slowTime.Add(e.Duration.Microseconds())
+		}
+	}
+	c.lastSlow = newest
+
+	return nil
+}
+
+// SlowEntry is an entry of the redis slowlog.
+type SlowEntry struct {
+	ID       int64         `json:"id"`
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	Command  []string      `json:"command"`
+	Client   string        `json:"client,omitempty"`
+}
+
+// Slowlog returns the last n entries of the redis slowlog, newest first.
+func Slowlog(rClient database.ClientInterface, n int) ([]SlowEntry, error) {
+	var raw [][]interface{}
+	if err := rClient.Do(radix.Cmd(&raw, "SLOWLOG", "GET", strconv.Itoa(n))); err != nil {
+		return nil, err
+	}
+
+	entries := make([]SlowEntry, 0, len(raw))
+	for _, r := range raw {
+		// Entries are id, unix time, microseconds, arguments and, since
+		// redis 4, client address and name.
+		if len(r) < 4 {
+			continue
+		}
+		e := SlowEntry{
+			ID:       toInt(r[0]),
+			Time:     time.Unix(toInt(r[1]), 0),
+			Duration: time.Duration(toInt(r[2])) * time.Microsecond,
+		}
+		args, _ := r[3].([]interface{})
+		for _, arg := range args {
+			b, _ := arg.([]byte)
+			e.Command = append(e.Command, string(b))
+		}
+		if len(r) > 4 {
+			b, _ := r[4].([]byte)
+			e.Client = string(b)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+func toInt(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	case []byte:
+		i, _ := strconv.ParseInt(string(n), 10, 64)
+		return i
+	}
+
+	return 0
+}
+
+// keyless lists commands whose first argument isn't a key.
+var keyless = map[string]bool{
+	"AUTH": true, "CLIENT": true, "CLUSTER": true, "CONFIG": true, "DBSIZE": true,
+	"EXEC": true, "FLUSHALL": true, "FLUSHDB": true, "HELLO": true, "INFO": true,
+	"MEMORY": true, "MULTI": true, "PING": true, "PUBLISH": true, "SCAN": true,
+	"SCRIPT": true, "SELECT": true, "SLOWLOG": true, "SUBSCRIBE": true,
+}
+
+// Name returns the upper-cased command name of the entry.
+func (e SlowEntry) Name() string {
+	if len(e.Command) == 0 {
+		return ""
+	}
+
+	return strings.ToUpper(e.Command[0])
+}
+
+// Key returns the first key the entry's command touched, or "" for
+// commands without keys.
+func (e SlowEntry) Key() string {
+	name := e.Name()
+	switch {
+	case name == "" || keyless[name] || len(e.Command) < 2:
+		return ""
+	case name == "EVAL" || name == "EVALSHA" || name == "FCALL":
+		// EVAL script numkeys key...
+		if len(e.Command) > 3 && e.Command[2] != "0" {
+			return e.Command[3]
+		}
+		return ""
+	}
+
+	return e.Command[1]
+}
@@ -0,0 +1,132 @@
+package reminders
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/helpers"
+	"github.com/ksarpe/redis-golang/jobs"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/mail"
+	"github.com/ksarpe/redis-golang/webhooks"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Config controls when owners are reminded and how far one click extends.
+type Config struct {
+	Before   time.Duration
+	ExtendBy time.Duration
+}
+
+// ConfigFromEnv reads REMINDER_DAYS and REMINDER_EXTEND_BY.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Before:   3 * 24 * time.Hour,
+		ExtendBy: 30 * 24 * time.Hour,
+	}
+
+	if days, err := strconv.Atoi(os.Getenv("REMINDER_DAYS")); err == nil && days > 0 {
+		cfg.Before = time.Duration(days) * 24 * time.Hour
+	}
+	if v, err := time.ParseDuration(os.Getenv("REMINDER_EXTEND_BY")); err == nil && v > 0 {
+		cfg.ExtendBy = v
+	}
+
+	return cfg
+}
+
+// Reminder is the payload of the link.expiring webhook.
+type Reminder struct {
+	Short     string `json:"short"`
+	Owner     string `json:"owner,omitempty"`
+	ExpiresAt int64  `json:"expires_at"`
+	ExtendURL string `json:"extend_url"`
+}
+
+// Job returns the scheduled job notifying owners of shorts about to expire.
+// Every expiry is only reminded once, extending a short re-arms it.
+func Job(cfg Config, mailer mail.Mailer) jobs.Func {
+	return func(ctx context.Context, rClient database.ClientInterface) error {
+		now := clock.Now().Unix()
+
+		err := rClient.Do(radix.Cmd(nil, "ZREMRANGEBYSCORE", links.ExpiringKey(), "-inf", strconv.FormatInt(now, 10)))
+		if err != nil {
+			return err
+		}
+
+		var entries []string
+		err = rClient.Do(radix.Cmd(&entries, "ZRANGEBYSCORE", links.ExpiringKey(),
+			strconv.FormatInt(now, 10), strconv.FormatInt(now+int64(cfg.Before/time.Second), 10), "WITHSCORES"))
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i+1 < len(entries); i += 2 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// A failing reminder, such as one to a bad address, must not
+			// hold back the others.
+			if err := remind(rClient, mailer, entries[i], entries[i+1]); err != nil {
+				log.Printf("reminders: %s: %v", entries[i], err)
+			}
+		}
+
+		return nil
+	}
+}
+
+func remind(rClient database.ClientInterface, mailer mail.Mailer, short, expiresAt string) error {
+	var meta []string
+	if err := rClient.Do(links.FieldsCmd(&meta, short, "owner", "reminded_for")); err != nil {
+		return err
+	}
+	owner, remindedFor := meta[0], meta[1]
+	if remindedFor == expiresAt {
+		return nil
+	}
+
+	expires, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	token, err := helpers.RandomToken(16)
+	if err != nil {
+		return err
+	}
+	ttl := strconv.FormatInt(max(expires-clock.Now().Unix(), 1), 10)
+	if err := rClient.Do(radix.Cmd(nil, "SET", links.ExtendTokenKey(token), short, "EX", ttl)); err != nil {
+		return err
+	}
+
+	reminder := Reminder{
+		Short:     short,
+		Owner:     owner,
+		ExpiresAt: expires,
+		ExtendURL: os.Getenv("DOMAIN") + "/api/v1/extend/" + token,
+	}
+	webhooks.Send("link.expiring", reminder)
+
+	if owner != "" && mailer.Enabled() {
+		var email string
+		if err := rClient.Do(radix.Cmd(&email, "HGET", links.UserKey(owner), "email")); err != nil {
+			return err
+		}
+		if email != "" {
+			body := fmt.Sprintf("Your short %s/%s expires on %s.\n\nExtend it with one click:\n%s\n",
+				os.Getenv("DOMAIN"), short, time.Unix(expires, 0).UTC().Format(time.RFC1123), reminder.ExtendURL)
+			if err := mailer.Send(email, "Your short link "+short+" is about to expire", body); err != nil {
+				return err
+			}
+		}
+	}
+
+	return rClient.Do(links.WriteCmd(short, []string{"reminded_for", expiresAt}, nil))
+}
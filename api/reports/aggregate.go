@@ -0,0 +1,198 @@
+package reports
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/retention"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Dimensions of the aggregate reports.
+const (
+	Countries = "countries"
+	Referrers = "referrers"
+)
+
+const day = 24 * time.Hour
+
+// AggregateConfig controls the daily buckets behind the aggregate reports.
+type AggregateConfig struct {
+	// Retention is how many days of buckets are kept, and so the longest
+	// range a report may cover.
+	Retention int
+	// CacheTTL is how long a computed report is reused.
+	CacheTTL time.Duration
+}
+
+// AggregateConfigFromEnv reads the REPORT_CACHE_TTL environment variable,
+// buckets being kept for the click retention, see retention.ConfigFromEnv.
+func AggregateConfigFromEnv() AggregateConfig {
+	cfg := AggregateConfig{Retention: retention.ConfigFromEnv().ClickDays(), CacheTTL: 5 * time.Minute}
+
+	if v, err := time.ParseDuration(os.Getenv("REPORT_CACHE_TTL")); err == nil && v > 0 {
+		cfg.CacheTTL = v
+	}
+
+	return cfg
+}
+
+// Count is the clicks of one country or referrer.
+type Count struct {
+	Key    string `json:"key"`
+	Clicks int64  `json:"clicks"`
+}
+
+// Aggregate sums the clicks of a range of days by country and referrer,
+// most clicked first.
+type Aggregate struct {
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Total     int64   `json:"total"`
+	Countries []Count `json:"countries"`
+	Referrers []Count `json:"referrers"`
+}
+
+// AppendClick queues the commands counting a click of short in its daily
+// series and referrers, and in today's buckets of owner, if any, and of
+// every link. Clicks count towards the owner of the link at the time,
+// reports don't follow transfers.
+func AppendClick(p *radix.Pipeline, cfg AggregateConfig, short, owner, country, referrer string) {
+	AppendClickOn(p, cfg, Today(), short, owner, country, referrer)
+}
+
+// AppendClickOn is AppendClick for a click made on another day, such as a
+// replayed one. The buckets of the day expire as if counted on it.
+func AppendClickOn(p *radix.Pipeline, cfg AggregateConfig, today int64, short, owner, country, referrer string) {
+	if country == "" {
+		country = "unknown"
+	}
+	referrer = referrerHost(referrer)
+
+	expires := (today + int64(cfg.Retention) + 1 - Today()) * int64(day/time.Second)
+	ttl := strconv.FormatInt(max(expires, 1), 10)
+
+	p.Append(radix.Cmd(nil, "INCR", links.DayClicksKey(short, today)))
+	p.Append(radix.Cmd(nil, "EXPIRE", links.DayClicksKey(short, today), ttl))
+	p.Append(radix.Cmd(nil, "HINCRBY", links.ReferrersKey(short), referrer, "1"))
+
+	scopes := []string{""}
+	if owner != "" {
+		scopes = append(scopes, owner)
+	}
+	for _, scope := range scopes {
+		for dimension, value := range map[string]string{Countries: country, Referrers: referrer} {
+			key := links.ReportBucketKey(scope, dimension, today)
+			p.Append(radix.Cmd(nil, "HINCRBY", key, value, "1"))
+			p.Append(radix.Cmd(nil, "EXPIRE", key, ttl))
+		}
+	}
+}
+
+// Today returns the current UTC day as counted by the daily keys.
+func Today() int64 {
+	return DayOf(clock.Now())
+}
+
+// DayOf returns the UTC day of t as counted by the daily keys.
+func DayOf(t time.Time) int64 {
+	return t.Unix() / int64(day/time.Second)
+}
+
+// Date formats a day counted by the daily keys.
+func Date(d int64) string {
+	return time.Unix(d*int64(day/time.Second), 0).UTC().Format(time.DateOnly)
+}
+
+// referrerHost reduces a Referer header to its host, "direct" when there
+// is none.
+func referrerHost(referrer string) string {
+	u, err := url.Parse(referrer)
+	if err != nil || u.Hostname() == "" {
+		return "direct"
+	}
+
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// Report sums the buckets of owner, or of every link for an empty owner,
+// from the UTC day of from to that of to, both included. Results are cached
+// for cfg.CacheTTL.
+func Report(rClient database.ClientInterface, cfg AggregateConfig, owner string, from, to time.Time) (*Aggregate, error) {
+	first, last := DayOf(from), DayOf(to)
+	cacheKey := links.ReportCacheKey(owner, first, last)
+
+	var cached string
+	if err := rClient.Do(radix.Cmd(&cached, "GET", cacheKey)); err != nil {
+		return nil, err
+	}
+	if cached != "" {
+		report := new(Aggregate)
+		if err := json.Unmarshal([]byte(cached), report); err == nil {
+			return report, nil
+		}
+	}
+
+	buckets := make([]map[string]int64, 0, 2*(last-first+1))
+	p := radix.NewPipeline()
+	for d := first; d <= last; d++ {
+		for _, dimension := range []string{Countries, Referrers} {
+			buckets = append(buckets, nil)
+			p.Append(radix.Cmd(&buckets[len(buckets)-1], "HGETALL", links.ReportBucketKey(owner, dimension, d)))
+		}
+	}
+	if err := rClient.Do(p); err != nil {
+		return nil, err
+	}
+
+	countries, referrers := map[string]int64{}, map[string]int64{}
+	for i, bucket := range buckets {
+		sums := countries
+		if i%2 == 1 {
+			sums = referrers
+		}
+		for key, n := range bucket {
+			sums[key] += n
+		}
+	}
+
+	report := &Aggregate{
+		From:      Date(first),
+		To:        Date(last),
+		Countries: sorted(countries),
+		Referrers: sorted(referrers),
+	}
+	for _, c := range report.Countries {
+		report.Total += c.Clicks
+	}
+
+	// A report is as good uncached, the cache only spares redis.
+	if raw, err := json.Marshal(report); err == nil {
+		_ = rClient.Do(radix.Cmd(nil, "SET", cacheKey, string(raw), "PX", strconv.FormatInt(cfg.CacheTTL.Milliseconds(), 10)))
+	}
+
+	return report, nil
+}
+
+func sorted(sums map[string]int64) []Count {
+	counts := make([]Count, 0, len(sums))
+	for key, n := range sums {
+		counts = append(counts, Count{Key: key, Clicks: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Clicks != counts[j].Clicks {
+			return counts[i].Clicks > counts[j].Clicks
+		}
+		return counts[i].Key < counts[j].Key
+	})
+
+	return counts
+}
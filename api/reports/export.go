@@ -0,0 +1,62 @@
+package reports
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// exportBatch is the number of daily counters read per round trip.
+const exportBatch = 100
+
+// Export writes to s the daily clicks of short from the UTC day of from to
+// that of to, then its clicks of all time by country and by referrer. The
+// breakdowns come in hash order, read a page at a time.
+func Export(rClient database.ClientInterface, s Sheets, short string, from, to time.Time) error {
+	if err := s.Sheet("Clicks", "date", "clicks"); err != nil {
+		return err
+	}
+
+	first, last := DayOf(from), DayOf(to)
+	for start := first; start <= last; start += exportBatch {
+		end := min(start+exportBatch-1, last)
+
+		counts := make([]int64, end-start+1)
+		p := radix.NewPipeline()
+		for d := start; d <= end; d++ {
+			p.Append(radix.Cmd(&counts[d-start], "GET", links.DayClicksKey(short, d)))
+		}
+		if err := rClient.Do(p); err != nil {
+			return err
+		}
+
+		for i, n := range counts {
+			if err := s.Row(Date(start+int64(i)), n); err != nil {
+				return err
+			}
+		}
+	}
+
+	breakdowns := []struct{ sheet, column, key string }{
+		{"Countries", "country", links.CountriesKey(short)},
+		{"Referrers", "referrer", links.ReferrersKey(short)},
+	}
+	for _, b := range breakdowns {
+		if err := s.Sheet(b.sheet, b.column, "clicks"); err != nil {
+			return err
+		}
+
+		err := database.HScan(rClient, b.key, func(field, value string) error {
+			n, _ := strconv.ParseInt(value, 10, 64)
+			return s.Row(field, n)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package reports
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/jobs"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/mail"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// SnapshotKey returns the hash storing click totals at the last report, used
+// to compute the clicks of the reported period.
+func SnapshotKey(campaign string) string {
+	return "report:" + campaign
+}
+
+// UserSnapshotKey is SnapshotKey for the report of an owner's links.
+func UserSnapshotKey(owner string) string {
+	return "reports:user:" + owner
+}
+
+// LastRunKey returns the key holding the unix time reports were last sent.
+func LastRunKey() string {
+	return "reports:last_run"
+}
+
+// Job returns the scheduled job emailing a summary to every campaign with a
+// report address, and to every owner of links with an email address. Run
+// more often than period, it only sends once the last reports are period
+// old, so that a restart or a new leader neither skips nor repeats them.
+func Job(mailer mail.Mailer, period time.Duration) jobs.Func {
+	return func(ctx context.Context, rClient database.ClientInterface) error {
+		now := clock.Now()
+
+		var last int64
+		if err := rClient.Do(radix.Cmd(&last, "GET", LastRunKey())); err != nil {
+			return err
+		}
+		if now.Sub(time.Unix(last, 0)) < period {
+			return nil
+		}
+
+		var campaigns []string
+		if err := rClient.Do(radix.Cmd(&campaigns, "SMEMBERS", links.CampaignsKey())); err != nil {
+			return err
+		}
+
+		for _, campaign := range campaigns {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// A failing campaign, such as one with a bad address, must not
+			// hold back the reports of the others.
+			if err := reportCampaign(rClient, mailer, campaign); err != nil {
+				log.Printf("reports: campaign %s: %v", campaign, err)
+			}
+		}
+
+		err := database.Scan(rClient, links.UserLinksKey("*"), func(key string) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			owner := strings.TrimSuffix(strings.TrimPrefix(key, links.UserKey("")), ":links")
+			if err := reportUser(rClient, mailer, owner); err != nil {
+				log.Printf("reports: user %s: %v", owner, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return rClient.Do(radix.Cmd(nil, "SET", LastRunKey(), strconv.FormatInt(now.Unix(), 10)))
+	}
+}
+
+func reportCampaign(rClient database.ClientInterface, mailer mail.Mailer, campaign string) error {
+	var email string
+	if err := rClient.Do(radix.Cmd(&email, "HGET", links.CampaignKey(campaign), "report_email")); err != nil {
+		return err
+	}
+	if email == "" {
+		return nil
+	}
+
+	var shorts []string
+	if err := rClient.Do(radix.Cmd(&shorts, "SMEMBERS", links.CampaignLinksKey(campaign))); err != nil {
+		return err
+	}
+
+	return report(rClient, mailer, email, "campaign "+campaign, "Campaign "+campaign, shorts, SnapshotKey(campaign))
+}
+
+func reportUser(rClient database.ClientInterface, mailer mail.Mailer, owner string) error {
+	var email string
+	if err := rClient.Do(radix.Cmd(&email, "HGET", links.UserKey(owner), "email")); err != nil {
+		return err
+	}
+	if email == "" {
+		return nil
+	}
+
+	var shorts []string
+	if err := rClient.Do(radix.Cmd(&shorts, "SMEMBERS", links.UserLinksKey(owner))); err != nil {
+		return err
+	}
+
+	return report(rClient, mailer, email, "your links", "Links of "+owner, shorts, UserSnapshotKey(owner))
+}
+
+// report emails the clicks of shorts since the totals stored in snapshot,
+// which it then updates.
+func report(rClient database.ClientInterface, mailer mail.Mailer, email, subject, heading string, shorts []string, snapshot string) error {
+	sort.Strings(shorts)
+
+	var previous map[string]int64
+	if err := rClient.Do(radix.Cmd(&previous, "HGETALL", snapshot)); err != nil {
+		return err
+	}
+
+	clicks := make([]int64, len(shorts))
+	p := radix.NewPipeline()
+	for i, short := range shorts {
+		p.Append(links.ClicksCmd(&clicks[i], short))
+	}
+	if err := rClient.Do(p); err != nil {
+		return err
+	}
+
+	var total, period int64
+	var b strings.Builder
+	fields := []string{snapshot}
+	for i, short := range shorts {
+		delta := clicks[i] - previous[short]
+		if delta < 0 {
+			delta = clicks[i]
+		}
+		total += clicks[i]
+		period += delta
+		fmt.Fprintf(&b, "  %s: %d new, %d total\n", short, delta, clicks[i])
+		fields = append(fields, short, fmt.Sprint(clicks[i]))
+	}
+
+	body := fmt.Sprintf("%s\n\nClicks this period: %d\nClicks all time: %d\n\nPer link:\n%s",
+		heading, period, total, b.String())
+	if err := mailer.Send(email, "Weekly report for "+subject, body); err != nil {
+		return err
+	}
+
+	if len(fields) == 1 {
+		return nil
+	}
+
+	return rClient.Do(radix.Cmd(nil, "HSET", fields...))
+}
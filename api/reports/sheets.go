@@ -0,0 +1,179 @@
+package reports
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Sheets writes tables to a spreadsheet file as they are produced, one
+// sheet at a time, so that exports don't have to be held in memory.
+type Sheets interface {
+	// Sheet starts a table with the given column names.
+	Sheet(name string, columns ...string) error
+	// Row appends a row to the current table. Cells are strings or int64.
+	Row(cells ...any) error
+	// Close completes the file.
+	Close() error
+}
+
+// csvSheets writes the tables one after another, separated by an empty
+// line.
+type csvSheets struct {
+	w      *csv.Writer
+	sheets int
+}
+
+// NewCSV returns Sheets writing CSV to w.
+func NewCSV(w io.Writer) Sheets {
+	return &csvSheets{w: csv.NewWriter(w)}
+}
+
+func (s *csvSheets) Sheet(name string, columns ...string) error {
+	if s.sheets > 0 {
+		if err := s.w.Write(nil); err != nil {
+			return err
+		}
+	}
+	s.sheets++
+
+	return s.w.Write(columns)
+}
+
+func (s *csvSheets) Row(cells ...any) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		record[i] = fmt.Sprint(cell)
+	}
+
+	return s.w.Write(record)
+}
+
+func (s *csvSheets) Close() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// xlsxSheets writes an Office Open XML workbook with a worksheet per
+// table. Strings are stored inline rather than in a shared string table,
+// which would have to be complete before the first sheet.
+type xlsxSheets struct {
+	zw     *zip.Writer
+	sheet  *bufio.Writer
+	names  []string
+	rowNum int
+}
+
+// NewXLSX returns Sheets writing an .xlsx workbook to w.
+func NewXLSX(w io.Writer) Sheets {
+	return &xlsxSheets{zw: zip.NewWriter(w)}
+}
+
+const (
+	xlsxMain = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	xlsxRels = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	pkgRels  = "http://schemas.openxmlformats.org/package/2006/relationships"
+	xmlDecl  = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+)
+
+func (s *xlsxSheets) Sheet(name string, columns ...string) error {
+	if err := s.endSheet(); err != nil {
+		return err
+	}
+
+	s.names = append(s.names, name)
+	f, err := s.zw.Create("xl/worksheets/sheet" + strconv.Itoa(len(s.names)) + ".xml")
+	if err != nil {
+		return err
+	}
+	s.sheet = bufio.NewWriter(f)
+	s.rowNum = 0
+	s.sheet.WriteString(xmlDecl + `<worksheet xmlns="` + xlsxMain + `"><sheetData>`)
+
+	cells := make([]any, len(columns))
+	for i, column := range columns {
+		cells[i] = column
+	}
+
+	return s.Row(cells...)
+}
+
+func (s *xlsxSheets) Row(cells ...any) error {
+	s.rowNum++
+	fmt.Fprintf(s.sheet, `<row r="%d">`, s.rowNum)
+	for _, cell := range cells {
+		switch v := cell.(type) {
+		case int64:
+			fmt.Fprintf(s.sheet, `<c><v>%d</v></c>`, v)
+		default:
+			s.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			if err := xml.EscapeText(s.sheet, []byte(fmt.Sprint(v))); err != nil {
+				return err
+			}
+			s.sheet.WriteString(`</t></is></c>`)
+		}
+	}
+	_, err := s.sheet.WriteString(`</row>`)
+
+	return err
+}
+
+func (s *xlsxSheets) endSheet() error {
+	if s.sheet == nil {
+		return nil
+	}
+	s.sheet.WriteString(`</sheetData></worksheet>`)
+	err := s.sheet.Flush()
+	s.sheet = nil
+
+	return err
+}
+
+func (s *xlsxSheets) Close() error {
+	if err := s.endSheet(); err != nil {
+		return err
+	}
+
+	var types, sheets, rels string
+	for i, name := range s.names {
+		n := strconv.Itoa(i + 1)
+		types += `<Override PartName="/xl/worksheets/sheet` + n + `.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`
+		sheets += `<sheet name="` + escapeAttr(name) + `" sheetId="` + n + `" r:id="rId` + n + `"/>`
+		rels += `<Relationship Id="rId` + n + `" Type="` + xlsxRels + `/worksheet" Target="worksheets/sheet` + n + `.xml"/>`
+	}
+
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			types + `</Types>`},
+		{"_rels/.rels", `<Relationships xmlns="` + pkgRels + `">` +
+			`<Relationship Id="rId0" Type="` + xlsxRels + `/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", `<workbook xmlns="` + xlsxMain + `" xmlns:r="` + xlsxRels + `"><sheets>` + sheets + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="` + pkgRels + `">` + rels + `</Relationships>`},
+	}
+	for _, part := range parts {
+		f, err := s.zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, xmlDecl+part.body); err != nil {
+			return err
+		}
+	}
+
+	return s.zw.Close()
+}
+
+func escapeAttr(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+
+	return b.String()
+}
@@ -0,0 +1,130 @@
+package retention
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/jobs"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Config is how long each class of data is kept.
+type Config struct {
+	// ClickEvents covers the click stream waiting for export and the daily
+	// click analytics.
+	ClickEvents time.Duration
+	// Audit covers the creation feed and the webhook delivery logs, which
+	// are only capped in length when it is 0.
+	Audit time.Duration
+	// Trash is how long deleted links are kept before being erased, 0
+	// erasing them on deletion.
+	Trash time.Duration
+}
+
+// ConfigFromEnv reads CLICK_RETENTION, 90 days or REPORT_RETENTION_DAYS by
+// default, AUDIT_RETENTION and TRASH_RETENTION.
+func ConfigFromEnv() Config {
+	cfg := Config{ClickEvents: 90 * 24 * time.Hour}
+
+	if v, err := strconv.Atoi(os.Getenv("REPORT_RETENTION_DAYS")); err == nil && v > 0 {
+		cfg.ClickEvents = time.Duration(v) * 24 * time.Hour
+	}
+	if v, err := time.ParseDuration(os.Getenv("CLICK_RETENTION")); err == nil && v > 0 {
+		cfg.ClickEvents = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("AUDIT_RETENTION")); err == nil && v > 0 {
+		cfg.Audit = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("TRASH_RETENTION")); err == nil && v > 0 {
+		cfg.Trash = v
+	}
+
+	return cfg
+}
+
+// ClickDays returns the days of daily click analytics kept, rounded up.
+func (cfg Config) ClickDays() int {
+	day := 24 * time.Hour
+
+	return int((cfg.ClickEvents + day - 1) / day)
+}
+
+// Job returns the reaper, trimming every class of data to its retention.
+// The daily click analytics expire on their own, with TTLs derived from
+// ClickEvents.
+func Job(cfg Config) jobs.Func {
+	return func(ctx context.Context, rClient database.ClientInterface) error {
+		now := clock.Now()
+
+		if err := trim(rClient, links.ClickStreamKey(), now.Add(-cfg.ClickEvents)); err != nil {
+			return err
+		}
+
+		if cfg.Audit > 0 {
+			cutoff := now.Add(-cfg.Audit)
+			if err := trim(rClient, links.CreationStreamKey(), cutoff); err != nil {
+				return err
+			}
+			err := database.Scan(rClient, links.WebhookDeliveriesKey("*"), func(key string) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				return trim(rClient, key, cutoff)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// Without retention the trash is emptied of whatever it still holds.
+		return EmptyTrash(ctx, rClient, now.Add(-cfg.Trash))
+	}
+}
+
+// trim drops the entries of a stream older than cutoff, stream ids
+// starting with their time in milliseconds.
+func trim(rClient database.ClientInterface, key string, cutoff time.Time) error {
+	return rClient.Do(radix.Cmd(nil, "XTRIM", key, "MINID", "~", strconv.FormatInt(cutoff.UnixMilli(), 10)))
+}
+
+// EmptyTrash erases the links deleted before cutoff.
+func EmptyTrash(ctx context.Context, rClient database.ClientInterface, cutoff time.Time) error {
+	for ctx.Err() == nil {
+		var shorts []string
+		err := rClient.Do(radix.Cmd(&shorts, "ZRANGEBYSCORE", links.TrashIndexKey(),
+			"-inf", strconv.FormatInt(cutoff.Unix(), 10), "LIMIT", "0", "500"))
+		if err != nil || len(shorts) == 0 {
+			return err
+		}
+
+		p := radix.NewPipeline()
+		for _, short := range shorts {
+			AppendDrop(p, short)
+		}
+		if err := rClient.Do(p); err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// AppendTrash queues the commands moving the record of a deleted short to
+// the trash, where it is kept for Trash whatever its expiry was. The
+// record must exist.
+func AppendTrash(p *radix.Pipeline, short string) {
+	p.Append(radix.Cmd(nil, "RENAME", links.MetaKey(short), links.TrashKey(short)))
+	p.Append(radix.Cmd(nil, "PERSIST", links.TrashKey(short)))
+	p.Append(radix.Cmd(nil, "ZADD", links.TrashIndexKey(), strconv.FormatInt(clock.Now().Unix(), 10), short))
+}
+
+// AppendDrop queues the commands erasing short from the trash.
+func AppendDrop(p *radix.Pipeline, short string) {
+	p.Append(radix.Cmd(nil, "DEL", links.TrashKey(short)))
+	p.Append(radix.Cmd(nil, "ZREM", links.TrashIndexKey(), short))
+}
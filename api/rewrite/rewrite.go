@@ -0,0 +1,184 @@
+package rewrite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+const (
+	// RulesKey is the list of JSON encoded rules, in evaluation order.
+	RulesKey = "rewrite:rules"
+
+	// Channel is published to whenever the rules change so that every
+	// instance reloads its copy.
+	Channel = "rewrite:invalidate"
+)
+
+// Rule rewrites request paths matching Pattern, a regular expression that
+// must match the whole path without its leading slash. Target is expanded
+// with the submatches ($1, ${name}) and is either another short, optionally
+// followed by a sub-path, or an absolute URL to redirect to.
+type Rule struct {
+	Pattern string `json:"pattern"`
+	Target  string `json:"target"`
+
+	re *regexp.Regexp
+}
+
+// Compile validates every rule, preparing it for Apply.
+func Compile(rules []Rule) error {
+	for i := range rules {
+		if rules[i].Target == "" {
+			return fmt.Errorf("rule %d: target is required", i)
+		}
+		re, err := regexp.Compile("^(?:" + rules[i].Pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+		rules[i].re = re
+	}
+
+	return nil
+}
+
+// Load reads the rules stored in redis.
+func Load(rClient database.ClientInterface) ([]Rule, error) {
+	var raw []string
+	if err := rClient.Do(radix.Cmd(&raw, "LRANGE", RulesKey, "0", "-1")); err != nil {
+		return nil, err
+	}
+
+	rules := make([]Rule, len(raw))
+	for i, r := range raw {
+		if err := json.Unmarshal([]byte(r), &rules[i]); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+
+	return rules, nil
+}
+
+// Save replaces the stored rules and tells every instance to reload them.
+func Save(rClient database.ClientInterface, rules []Rule) error {
+	if err := Compile(rules); err != nil {
+		return err
+	}
+
+	args := []string{RulesKey}
+	for _, rule := range rules {
+		out, err := json.Marshal(rule)
+		if err != nil {
+			return err
+		}
+		args = append(args, string(out))
+	}
+
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "MULTI"))
+	p.Append(radix.Cmd(nil, "DEL", RulesKey))
+	if len(rules) > 0 {
+		p.Append(radix.Cmd(nil, "RPUSH", args...))
+	}
+	p.Append(radix.Cmd(nil, "EXEC"))
+	p.Append(radix.Cmd(nil, "PUBLISH", Channel, "1"))
+
+	return rClient.Do(p)
+}
+
+// Engine holds the compiled rules of this instance.
+type Engine struct {
+	rules atomic.Pointer[[]Rule]
+}
+
+// Default is the engine consulted by ResolveURL.
+var Default = &Engine{}
+
+// Apply returns the expansion of the first rule matching path.
+func (e *Engine) Apply(path string) (string, bool) {
+	rules := e.rules.Load()
+	if rules == nil {
+		return "", false
+	}
+
+	for _, rule := range *rules {
+		match := rule.re.FindStringSubmatchIndex(path)
+		if match == nil {
+			continue
+		}
+
+		return string(rule.re.ExpandString(nil, rule.Target, path, match)), true
+	}
+
+	return "", false
+}
+
+// Refresh reloads the rules from redis. Invalid stored rules are rejected as
+// a whole so a bad write never leaves a partial rule set in place.
+func (e *Engine) Refresh(rClient database.ClientInterface) error {
+	rules, err := Load(rClient)
+	if err != nil {
+		return err
+	}
+	if err := Compile(rules); err != nil {
+		return err
+	}
+
+	e.rules.Store(&rules)
+
+	return nil
+}
+
+// Run loads the rules, then reloads them on every invalidation and once per
+// interval in case an invalidation was missed while reconnecting.
+func (e *Engine) Run(ctx context.Context, interval time.Duration) {
+	refresh := func() {
+		rClient, err := database.NewDefaultClient()
+		if err != nil {
+			log.Printf("rewrite: %v", err)
+			return
+		}
+		defer rClient.Close()
+
+		if err := e.Refresh(rClient); err != nil {
+			log.Printf("rewrite: %v", err)
+		}
+	}
+	refresh()
+
+	go func() {
+		for ctx.Err() == nil {
+			err := database.Subscribe(ctx, Channel, func([]byte) { refresh() })
+			if err != nil {
+				log.Printf("rewrite: %v", err)
+				time.Sleep(time.Second)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refresh()
+		}
+	}
+}
+
+// IsExternal reports whether an expanded target is a URL to redirect to
+// rather than a short.
+func IsExternal(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
@@ -0,0 +1,104 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/helpers"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/lockout"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// RequireAdmin guards the admin API with the bearer token from ADMIN_TOKEN,
+// or an API key with the admin scope. The admin API is disabled when no
+// token is configured.
+func RequireAdmin(c *fiber.Ctx) error {
+	token := os.Getenv("ADMIN_TOKEN")
+	given := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+
+	if token == "" || given == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "admin token required"})
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	if d := lockout.Locked(rClient, lockoutConfig(), lockout.IP(c.IP())); d > 0 {
+		return lockedOut(c, d)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(given)) != 1 {
+		key, err := lookupAPIKey(rClient, helpers.HashToken(given))
+		if err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+		}
+		if key == nil || !slices.Contains(key.Scopes, ScopeAdmin) {
+			lockout.Fail(rClient, lockoutConfig(), lockout.IP(c.IP()))
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "admin token required"})
+		}
+	}
+
+	return c.Next()
+}
+
+// ListAlerts returns every short currently flagged, with the flag details.
+func ListAlerts(c *fiber.Ctx) error {
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	var shorts []string
+	if err := rClient.Do(radix.Cmd(&shorts, "SMEMBERS", links.FlaggedKey())); err != nil {
+		return dbError(err, "Unable to read alerts")
+	}
+
+	metas := make([]map[string]string, len(shorts))
+	p := radix.NewPipeline()
+	for i, short := range shorts {
+		p.Append(links.RecordCmd(&metas[i], short))
+	}
+	if err := rClient.Do(p); err != nil {
+		return dbError(err, "Unable to read alerts")
+	}
+
+	alerts := make([]fiber.Map, len(shorts))
+	for i, short := range shorts {
+		alerts[i] = fiber.Map{
+			"short":      short,
+			"reason":     metas[i]["flagged"],
+			"action":     metas[i]["flag_action"],
+			"flagged_at": metas[i]["flagged_at"],
+			"z_score":    metas[i]["flag_z_score"],
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"alerts": alerts})
+}
+
+// ClearAlert removes the flag from a short, lifting any throttling.
+func ClearAlert(c *fiber.Ctx) error {
+	short := shortParam(c)
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "SREM", links.FlaggedKey(), short))
+	p.Append(links.WriteCmd(short, nil, []string{"flagged", "flag_action", "flagged_at", "flag_z_score"}))
+	if err := rClient.Do(p); err != nil {
+		return dbError(err, "Unable to clear alert")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
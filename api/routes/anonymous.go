@@ -0,0 +1,91 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/destination"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/ratelimit"
+)
+
+// safetyConfig is read lazily so that the .env file is loaded first.
+var safetyConfig = sync.OnceValue(destination.SafetyConfigFromEnv)
+
+type anonymousConfig struct {
+	enabled bool
+	// limit is the links an IP may create an hour.
+	limit     int64
+	maxExpiry time.Duration
+}
+
+// anonymousSettings reads the tier of links created without an API key:
+// ANONYMOUS_LINKS, on unless "false", ANONYMOUS_RATE_LIMIT links an hour
+// per IP, 10 by default, and ANONYMOUS_MAX_EXPIRY, 24h by default.
+var anonymousSettings = sync.OnceValue(func() anonymousConfig {
+	cfg := anonymousConfig{
+		enabled:   os.Getenv("ANONYMOUS_LINKS") != "false",
+		limit:     10,
+		maxExpiry: 24 * time.Hour,
+	}
+	if v, err := strconv.ParseInt(os.Getenv("ANONYMOUS_RATE_LIMIT"), 10, 64); err == nil && v > 0 {
+		cfg.limit = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("ANONYMOUS_MAX_EXPIRY")); err == nil && v > 0 {
+		cfg.maxExpiry = v
+	}
+
+	return cfg
+})
+
+// limitAnonymous counts a link created without an API key against the
+// limit of its IP, exposing the result like limitCaller does. It refuses
+// the link when the tier is disabled or the limit exceeded, and when the
+// limit cannot be checked: the tier is the one abuse comes through.
+func limitAnonymous(c *fiber.Ctx, rClient database.ClientInterface) *fiber.Error {
+	cfg := anonymousSettings()
+	if !cfg.enabled {
+		return fiber.NewError(fiber.StatusUnauthorized, "API key required")
+	}
+
+	result, err := ratelimit.Allow(rClient, links.AnonymousRateKey(c.IP()), cfg.limit, time.Hour)
+	if err != nil {
+		return dbError(err, "Unable to connect to server")
+	}
+	c.Locals("ratelimit", result)
+	setRateLimitHeaders(c, result)
+	if !result.Allowed {
+		c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(int64((result.Reset+time.Second-1)/time.Second), 10))
+		return fiber.NewError(fiber.StatusTooManyRequests, "anonymous link limit reached, retry later or use an API key")
+	}
+
+	return nil
+}
+
+// screenAnonymous checks the destination of an anonymous link, and the
+// URL it was submitted as, with destination.Screen. Links are refused when
+// the screening cannot be done.
+func screenAnonymous(ctx context.Context, submitted, dest string) *fiber.Error {
+	urls := []string{dest}
+	if submitted != dest {
+		urls = append(urls, submitted)
+	}
+
+	err := destination.Screen(ctx, safetyConfig(), urls...)
+	if errors.Is(err, destination.ErrUnsafe) {
+		return errInvalid(err.Error())
+	}
+	if err != nil {
+		log.Printf("screening %s: %v", dest, err)
+		return fiber.NewError(fiber.StatusServiceUnavailable, "Unable to screen destination")
+	}
+
+	return nil
+}
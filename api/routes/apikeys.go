@@ -0,0 +1,322 @@
+package routes
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/helpers"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Scopes an API key can carry. The admin scope opens the admin API and
+// implies every other scope.
+const (
+	ScopeLinksRead  = "links:read"
+	ScopeLinksWrite = "links:write"
+	ScopeStatsRead  = "stats:read"
+	ScopeAdmin      = "admin"
+)
+
+// userScopes are granted to keys issued without scopes, including every
+// key issued before scopes existed.
+var userScopes = []string{ScopeLinksRead, ScopeLinksWrite, ScopeStatsRead}
+
+var knownScopes = []string{ScopeLinksRead, ScopeLinksWrite, ScopeStatsRead, ScopeAdmin}
+
+type apiKeyRequest struct {
+	Owner     string   `json:"owner"`
+	Email     string   `json:"email"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt int64    `json:"expires_at"`
+}
+
+type apiKey struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name,omitempty"`
+	Scopes    []string `json:"scopes"`
+	CreatedAt int64    `json:"created_at"`
+	ExpiresAt int64    `json:"expires_at,omitempty"`
+	Current   bool     `json:"current,omitempty"`
+
+	owner string
+}
+
+// lookupAPIKey returns the key with the given hash, nil when there is none.
+func lookupAPIKey(rClient database.ClientInterface, id string) (*apiKey, error) {
+	var meta map[string]string
+	err := database.HGetAll(rClient, links.APIKeyKey(id), &meta)
+	if errors.Is(err, database.ErrNil) || err == nil && meta["owner"] == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &apiKey{ID: id, Name: meta["name"], Scopes: userScopes, owner: meta["owner"]}
+	if meta["scopes"] != "" {
+		key.Scopes = strings.Fields(meta["scopes"])
+	}
+	key.CreatedAt, _ = strconv.ParseInt(meta["created_at"], 10, 64)
+	key.ExpiresAt, _ = strconv.ParseInt(meta["expires_at"], 10, 64)
+
+	return key, nil
+}
+
+// RequireScope rejects requests made with an API key lacking scope. Dashboard
+// sessions and tokens issued by single sign-on act as the user and carry
+// every user scope. Anonymous requests are rejected unless the route
+// allows them, see AllowAnonymous.
+func RequireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !hasScope(c, scope) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "API key lacks the " + scope + " scope"})
+		}
+
+		return c.Next()
+	}
+}
+
+// checkScope is RequireScope for handlers serving several operations.
+func checkScope(c *fiber.Ctx, scope string) error {
+	if !hasScope(c, scope) {
+		return fiber.NewError(fiber.StatusForbidden, "API key lacks the "+scope+" scope")
+	}
+
+	return nil
+}
+
+// AllowAnonymous opens a route to anonymous callers, whose requests then
+// pass RequireScope and checkScope for every scope but ScopeAdmin. It goes
+// after OptionalAPIKey, authenticated callers are still checked.
+func AllowAnonymous(c *fiber.Ctx) error {
+	c.Locals("anonymous", true)
+
+	return c.Next()
+}
+
+func hasScope(c *fiber.Ctx, scope string) bool {
+	scopes, ok := c.Locals("scopes").([]string)
+	if !ok {
+		if anonymous, _ := c.Locals("anonymous").(bool); Owner(c) == "" && !anonymous {
+			return false
+		}
+		return scope != ScopeAdmin
+	}
+
+	return slices.Contains(scopes, scope) || slices.Contains(scopes, ScopeAdmin)
+}
+
+// grantable reports whether a caller may hand out every scope of scopes.
+func grantable(c *fiber.Ctx, scopes []string) bool {
+	for _, scope := range scopes {
+		if !hasScope(c, scope) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseScopes validates requested scopes, no scopes meaning userScopes.
+func parseScopes(requested []string) ([]string, bool) {
+	if len(requested) == 0 {
+		return userScopes, true
+	}
+
+	scopes := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		if !slices.Contains(knownScopes, scope) {
+			return nil, false
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes, true
+}
+
+// issueAPIKey stores a new key and returns it in plain text, the only time
+// it is available. Keys with an expiry are dropped by redis when it passes.
+func issueAPIKey(rClient database.ClientInterface, owner string, body *apiKeyRequest, scopes []string) (string, *apiKey, error) {
+	token, err := helpers.RandomToken(24)
+	if err != nil {
+		return "", nil, err
+	}
+	plain := "sk_" + token
+
+	key := &apiKey{
+		ID:        helpers.HashToken(plain),
+		Name:      body.Name,
+		Scopes:    scopes,
+		CreatedAt: clock.Now().Unix(),
+		ExpiresAt: body.ExpiresAt,
+	}
+
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "HSET", links.APIKeyKey(key.ID),
+		"owner", owner,
+		"name", key.Name,
+		"scopes", strings.Join(scopes, " "),
+		"created_at", strconv.FormatInt(key.CreatedAt, 10),
+		"expires_at", strconv.FormatInt(key.ExpiresAt, 10)))
+	if key.ExpiresAt > 0 {
+		p.Append(radix.Cmd(nil, "EXPIREAT", links.APIKeyKey(key.ID), strconv.FormatInt(key.ExpiresAt, 10)))
+	}
+	p.Append(radix.Cmd(nil, "SADD", links.UserAPIKeysKey(owner), key.ID))
+	if body.Email != "" {
+		p.Append(radix.Cmd(nil, "HSET", links.UserKey(owner), "email", body.Email))
+	}
+	if err := rClient.Do(p); err != nil {
+		return "", nil, err
+	}
+
+	return plain, key, nil
+}
+
+// validKeyRequest checks the scopes and expiry of a key request, returning
+// the scopes to grant or an error message.
+func validKeyRequest(body *apiKeyRequest) ([]string, string) {
+	scopes, ok := parseScopes(body.Scopes)
+	if !ok {
+		return nil, "scopes must be among " + strings.Join(knownScopes, ", ")
+	}
+	if body.ExpiresAt != 0 && body.ExpiresAt <= clock.Now().Unix() {
+		return nil, "expires_at must be in the future"
+	}
+
+	return scopes, ""
+}
+
+// CreateAPIKey issues a new API key for any owner, with any scopes. The key
+// is only returned once, redis keeps its hash.
+func CreateAPIKey(c *fiber.Ctx) error {
+	body := new(apiKeyRequest)
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	if body.Owner == "" {
+		return errInvalid("owner is required")
+	}
+	scopes, msg := validKeyRequest(body)
+	if msg != "" {
+		return errInvalid(msg)
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	plain, key, err := issueAPIKey(rClient, body.Owner, body, scopes)
+	if err != nil {
+		return dbError(err, "Unable to create API key")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"owner": body.Owner, "api_key": plain, "key": key})
+}
+
+// CreateOwnAPIKey issues an API key for the caller. A key can't grant more
+// than the scopes of the credentials creating it.
+func CreateOwnAPIKey(c *fiber.Ctx) error {
+	body := new(apiKeyRequest)
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	scopes, msg := validKeyRequest(body)
+	if msg != "" {
+		return errInvalid(msg)
+	}
+	if !grantable(c, scopes) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "cannot grant scopes you don't have"})
+	}
+	body.Email = ""
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	plain, key, err := issueAPIKey(rClient, Owner(c), body, scopes)
+	if err != nil {
+		return dbError(err, "Unable to create API key")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"api_key": plain, "key": key})
+}
+
+// ListAPIKeys returns the caller's API keys, without the keys themselves.
+func ListAPIKeys(c *fiber.Ctx) error {
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	owner := Owner(c)
+	var ids []string
+	if err := rClient.Do(radix.Cmd(&ids, "SMEMBERS", links.UserAPIKeysKey(owner))); err != nil {
+		return dbError(err, "Unable to read API keys")
+	}
+
+	current, _ := c.Locals("apikey").(string)
+	keys := []*apiKey{}
+	for _, id := range ids {
+		key, err := lookupAPIKey(rClient, id)
+		if err != nil {
+			return dbError(err, "Unable to read API keys")
+		}
+		if key == nil || key.owner != owner {
+			// Expired or revoked, drop it from the index.
+			_ = rClient.Do(radix.Cmd(nil, "SREM", links.UserAPIKeysKey(owner), id))
+			continue
+		}
+		key.Current = id == current
+		keys = append(keys, key)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"keys": keys})
+}
+
+// RevokeAPIKey deletes one of the caller's API keys. Like creating keys,
+// only keys within the caller's own scopes can be revoked.
+func RevokeAPIKey(c *fiber.Ctx) error {
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	owner, id := Owner(c), c.Params("id")
+	key, err := lookupAPIKey(rClient, id)
+	if err != nil {
+		return dbError(err, "Unable to revoke API key")
+	}
+	if key == nil || key.owner != owner {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "API key not found"})
+	}
+	if !grantable(c, key.Scopes) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "cannot revoke a key with scopes you don't have"})
+	}
+
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "DEL", links.APIKeyKey(id)))
+	p.Append(radix.Cmd(nil, "SREM", links.UserAPIKeysKey(owner), id))
+	if err := rClient.Do(p); err != nil {
+		return dbError(err, "Unable to revoke API key")
+	}
+
+	authCache.Delete(id)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
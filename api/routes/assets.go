@@ -0,0 +1,101 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/metadata"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+type assetFetcher func(ctx context.Context, pageURL string, maxBytes int64) (*metadata.Asset, error)
+
+// LinkFavicon serves the favicon of a short's destination.
+func LinkFavicon(c *fiber.Ctx) error {
+	return serveAsset(c, "favicon", metadata.Favicon)
+}
+
+// LinkOGImage serves the og:image of a short's destination.
+func LinkOGImage(c *fiber.Ctx) error {
+	return serveAsset(c, "og-image", metadata.OGImage)
+}
+
+// serveAsset proxies a destination asset through the API so the dashboard
+// doesn't run into CORS, caching hits and misses in redis.
+func serveAsset(c *fiber.Ctx, kind string, fetch assetFetcher) error {
+	short := shortParam(c)
+
+	maxBytes := int64(256 << 10)
+	if v, err := strconv.ParseInt(os.Getenv("ASSET_MAX_BYTES"), 10, 64); err == nil && v > 0 {
+		maxBytes = v
+	}
+	ttl := 24 * time.Hour
+	if v, err := time.ParseDuration(os.Getenv("ASSET_CACHE_TTL")); err == nil && v > 0 {
+		ttl = v
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	key := links.AssetKey(kind, short)
+
+	var cached map[string]string
+	if err := rClient.Do(radix.Cmd(&cached, "HGETALL", key)); err == nil && len(cached) > 0 {
+		if cached["missing"] == "1" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": kind + " not found"})
+		}
+		// Assets cached before only raster images were kept are fetched
+		// again.
+		if _, ok := metadata.RasterType(cached["type"]); ok {
+			return sendAsset(c, cached["type"], []byte(cached["body"]), ttl)
+		}
+	}
+
+	info, err := loadLinkInfo(rClient, short)
+	if err != nil {
+		return dbError(err, "Unable to read link")
+	}
+	if info == nil || info.Protected {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "short not found"})
+	}
+
+	asset, err := fetch(c.Context(), info.URL, maxBytes)
+	if err != nil {
+		if errors.Is(err, metadata.ErrNotFound) || errors.Is(err, metadata.ErrNotAnImage) || errors.Is(err, metadata.ErrTooLarge) {
+			// Remember misses for a while so listings don't refetch every time.
+			p := radix.NewPipeline()
+			p.Append(radix.Cmd(nil, "HSET", key, "missing", "1"))
+			p.Append(radix.Cmd(nil, "EXPIRE", key, strconv.FormatInt(int64(time.Hour/time.Second), 10)))
+			_ = rClient.Do(p)
+
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": kind + " not found"})
+		}
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Unable to fetch " + kind})
+	}
+
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "HSET", key, "type", asset.ContentType, "body", string(asset.Body)))
+	p.Append(radix.Cmd(nil, "EXPIRE", key, strconv.FormatInt(int64(ttl/time.Second), 10)))
+	_ = rClient.Do(p)
+
+	return sendAsset(c, asset.ContentType, asset.Body, ttl)
+}
+
+func sendAsset(c *fiber.Ctx, contentType string, body []byte, ttl time.Duration) error {
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderCacheControl, "public, max-age="+strconv.FormatInt(int64(ttl/time.Second), 10))
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	// Third-party content served from the API origin must never run.
+	c.Set(fiber.HeaderContentSecurityPolicy, "default-src 'none'; sandbox")
+
+	return c.Status(fiber.StatusOK).Send(body)
+}
@@ -0,0 +1,174 @@
+package routes
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/helpers"
+	"github.com/ksarpe/redis-golang/jwt"
+	"github.com/ksarpe/redis-golang/lockout"
+)
+
+// lockoutConfig is read lazily so that the .env file is loaded first.
+var lockoutConfig = sync.OnceValue(lockout.ConfigFromEnv)
+
+// APIKeyHeader carries the API key as an alternative to a bearer token.
+const APIKeyHeader = "X-API-Key"
+
+// RequireAPIKey rejects requests without a valid API key, or token issued
+// by single sign-on, and exposes the key owner as the "owner" local and the
+// key's scopes as the "scopes" local, see RequireScope. Authenticated
+// requests are limited to API_RATE_LIMIT a minute, see limitCaller, and
+// to the daily quota of the owner's plan, see limitQuota.
+func RequireAPIKey(c *fiber.Ctx) error {
+	return apiKeyAuth(c, true)
+}
+
+// OptionalAPIKey attributes requests carrying a valid API key to its owner
+// but lets anonymous requests through.
+func OptionalAPIKey(c *fiber.Ctx) error {
+	return apiKeyAuth(c, false)
+}
+
+// Owner returns the owner of the API key used for the request, if any.
+func Owner(c *fiber.Ctx) string {
+	owner, _ := c.Locals("owner").(string)
+
+	return owner
+}
+
+func apiKeyAuth(c *fiber.Ctx, required bool) error {
+	key := c.Get(APIKeyHeader)
+	if key == "" {
+		key = strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	}
+	if key == "" {
+		// Bookmarklets and shell one-liners can't always set headers.
+		key = c.Query("api_key")
+	}
+
+	if key == "" {
+		if required {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "API key required"})
+		}
+		return c.Next()
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return offlineAuth(c, key)
+	}
+	defer rClient.Close()
+
+	// Guessing keys is slowed down per IP, valid keys included so the
+	// lockout can't be probed.
+	if d := lockout.Locked(rClient, lockoutConfig(), lockout.IP(c.IP())); d > 0 {
+		return lockedOut(c, d)
+	}
+
+	if jwt.Looks(key) {
+		secret := tokenSettings().secret
+		claims, err := jwt.Parse(key, secret, clock.Now())
+		if len(secret) == 0 || err != nil {
+			// Expired tokens are honest mistakes, not guesses.
+			if !errors.Is(err, jwt.ErrExpired) {
+				lockout.Fail(rClient, lockoutConfig(), lockout.IP(c.IP()))
+			}
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid token"})
+		}
+		c.Locals("owner", claims.Subject)
+		if d := limitCaller(c, rClient, "owner:"+claims.Subject); d > 0 {
+			return rateLimited(c, d)
+		}
+		if d := limitQuota(c, rClient, claims.Subject); d > 0 {
+			return quotaExceeded(c, d)
+		}
+		return c.Next()
+	}
+
+	meta, err := lookupAPIKey(rClient, helpers.HashToken(key))
+	if err != nil {
+		if database.Unavailable(err) {
+			return offlineAuth(c, key)
+		}
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	if meta == nil {
+		authCache.Delete(helpers.HashToken(key))
+		lockout.Fail(rClient, lockoutConfig(), lockout.IP(c.IP()))
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid API key"})
+	}
+	authCache.Store(meta.ID, cachedKey{key: meta, checkedAt: clock.Now()})
+
+	c.Locals("owner", meta.owner)
+	c.Locals("apikey", meta.ID)
+	c.Locals("scopes", meta.Scopes)
+	if d := limitCaller(c, rClient, meta.ID); d > 0 {
+		return rateLimited(c, d)
+	}
+	if d := limitQuota(c, rClient, meta.owner); d > 0 {
+		return quotaExceeded(c, d)
+	}
+
+	return c.Next()
+}
+
+// authCacheTTL is how long after its last check against redis an API key
+// is still honored while redis is unavailable.
+const authCacheTTL = 15 * time.Minute
+
+type cachedKey struct {
+	key       *apiKey
+	checkedAt time.Time
+}
+
+// authCache holds the API keys that authenticated lately by hash, so that
+// their callers are still known while redis is unavailable and their
+// writes can be journaled.
+var authCache sync.Map
+
+// offlineAuth authenticates key while redis is unavailable: tokens by
+// their signature, API keys from authCache. Lockouts, rate limits and
+// quotas can't be checked and are skipped until redis is back.
+func offlineAuth(c *fiber.Ctx, key string) error {
+	if jwt.Looks(key) {
+		secret := tokenSettings().secret
+		claims, err := jwt.Parse(key, secret, clock.Now())
+		if len(secret) == 0 || err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid token"})
+		}
+		c.Locals("owner", claims.Subject)
+		return c.Next()
+	}
+
+	v, ok := authCache.Load(helpers.HashToken(key))
+	if !ok {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	cached := v.(cachedKey)
+	now := clock.Now()
+	if now.Sub(cached.checkedAt) > authCacheTTL || cached.key.ExpiresAt > 0 && cached.key.ExpiresAt <= now.Unix() {
+		authCache.Delete(cached.key.ID)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	c.Locals("owner", cached.key.owner)
+	c.Locals("apikey", cached.key.ID)
+	c.Locals("scopes", cached.key.Scopes)
+
+	return c.Next()
+}
+
+// lockedOut answers a request from a subject locked out by repeated
+// authentication failures.
+func lockedOut(c *fiber.Ctx, d time.Duration) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10))
+
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "too many failed attempts, retry later"})
+}
@@ -0,0 +1,120 @@
+package routes
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/bloom"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// defaultResolveBatchMax caps a batch unless RESOLVE_BATCH_MAX says otherwise.
+const defaultResolveBatchMax = 100
+
+type resolveBatchRequest struct {
+	Shorts []string `json:"shorts"`
+}
+
+type resolveBatchResult struct {
+	Short  string `json:"short"`
+	URL    string `json:"url,omitempty"`
+	Status string `json:"status"`
+}
+
+// ResolveBatch expands many shorts at once without counting clicks, for chat
+// and preview services. Destinations of disabled, flagged or protected
+// shorts are not revealed, shorts that ParseShort rejects are answered as
+// invalid. Every batch counts against API_RATE_LIMIT, per IP for anonymous
+// callers.
+func ResolveBatch(c *fiber.Ctx) error {
+	body := new(resolveBatchRequest)
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	limit := defaultResolveBatchMax
+	if v, err := strconv.Atoi(os.Getenv("RESOLVE_BATCH_MAX")); err == nil && v > 0 {
+		limit = v
+	}
+	if len(body.Shorts) == 0 || len(body.Shorts) > limit {
+		return errInvalid("Between 1 and " + strconv.Itoa(limit) + " shorts are required")
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	// Authenticated callers were counted by OptionalAPIKey.
+	if Owner(c) == "" {
+		if d := limitCaller(c, rClient, "ip:"+c.IP()); d > 0 {
+			return rateLimited(c, d)
+		}
+	}
+
+	results := make([]resolveBatchResult, len(body.Shorts))
+	shorts := make([]string, len(body.Shorts))
+	var candidates []int
+	for i, short := range body.Shorts {
+		results[i] = resolveBatchResult{Short: short, Status: "not_found"}
+		if shorts[i], err = links.ParseShort(short); err != nil {
+			results[i].Status = "invalid"
+			continue
+		}
+		if !links.KnownMissing(shorts[i]) {
+			candidates = append(candidates, i)
+		}
+	}
+
+	// Like lookupShort, only a shared filter's "no" spares the lookup.
+	if bloom.Default.Authoritative() {
+		names := make([]string, len(candidates))
+		for j, i := range candidates {
+			names[j] = shorts[i]
+		}
+		found := bloom.Default.MightContainAll(rClient, names)
+		kept := candidates[:0]
+		for j, i := range candidates {
+			if found[j] {
+				kept = append(kept, i)
+			}
+		}
+		candidates = kept
+	}
+
+	// The remaining shorts are read in one round trip, links still in the
+	// v1 layout migrated on the way.
+	fields := make([]map[string]string, len(body.Shorts))
+	p := radix.NewPipeline()
+	for _, i := range candidates {
+		p.Append(links.LoadCmd(&fields[i], shorts[i]))
+	}
+	if len(candidates) > 0 {
+		if err := rClient.Do(p); err != nil {
+			return dbError(err, "Unable to resolve shorts")
+		}
+	}
+
+	for _, i := range candidates {
+		meta := fields[i]
+		switch {
+		case meta["url"] == "":
+			links.RememberMissing(shorts[i])
+		case meta["disabled"] == "1":
+			results[i].Status = "disabled"
+		case meta["flagged"] != "":
+			results[i].Status = "flagged"
+		case meta["password_hash"] != "":
+			results[i].Status = "protected"
+		default:
+			results[i].Status = "ok"
+			results[i].URL = meta["url"]
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"results": results})
+}
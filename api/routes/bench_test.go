@@ -0,0 +1,169 @@
+package routes_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/routes"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// fakeRedis answers the commands of the resolve and shorten paths from
+// memory, enough to time the handlers without a redis server.
+type fakeRedis struct {
+	hashes map[string]map[string]string
+	queued []interface{}
+	multi  bool
+}
+
+func (f *fakeRedis) reply(_ context.Context, args []string) interface{} {
+	cmd := strings.ToUpper(args[0])
+	if f.multi && cmd != "EXEC" {
+		f.queued = append(f.queued, f.exec(cmd, args[1:]))
+		return "QUEUED"
+	}
+
+	return f.exec(cmd, args[1:])
+}
+
+func (f *fakeRedis) exec(cmd string, args []string) interface{} {
+	switch cmd {
+	case "MULTI":
+		f.multi, f.queued = true, nil
+		return "OK"
+	case "EXEC":
+		f.multi = false
+		return f.queued
+	case "EVALSHA", "EVAL":
+		// The scripts reading a record get its key first.
+		if len(args) > 2 && args[1] != "0" {
+			return f.hgetall(args[2])
+		}
+		return nil
+	case "HGETALL":
+		return f.hgetall(args[0])
+	case "HGET":
+		return f.hashes[args[0]][args[1]]
+	case "HMGET":
+		values := make([]interface{}, len(args)-1)
+		for i, field := range args[1:] {
+			if v, ok := f.hashes[args[0]][field]; ok {
+				values[i] = v
+			}
+		}
+		return values
+	case "HSET":
+		h := f.hashes[args[0]]
+		if h == nil {
+			h = map[string]string{}
+			f.hashes[args[0]] = h
+		}
+		for i := 1; i+1 < len(args); i += 2 {
+			h[args[i]] = args[i+1]
+		}
+		return (len(args) - 1) / 2
+	case "EXISTS":
+		n := 0
+		for _, key := range args {
+			if _, ok := f.hashes[key]; ok {
+				n++
+			}
+		}
+		return n
+	case "INCR", "INCRBY", "HINCRBY", "SADD", "ZADD", "ZINCRBY", "PFADD", "EXPIRE", "PEXPIRE", "EXPIREAT", "DEL", "PUBLISH", "SISMEMBER":
+		return 0
+	case "SET", "SETEX", "XADD":
+		return "OK"
+	}
+
+	return fmt.Errorf("fakeRedis: unhandled %s", cmd)
+}
+
+func (f *fakeRedis) hgetall(key string) []string {
+	var fields []string
+	for k, v := range f.hashes[key] {
+		fields = append(fields, k, v)
+	}
+
+	return fields
+}
+
+type fakeClient struct {
+	conn radix.Conn
+}
+
+func (c fakeClient) Do(action radix.Action) error {
+	return action.Perform(context.Background(), c.conn)
+}
+
+func (c fakeClient) Close() error {
+	return c.conn.Close()
+}
+
+// useFakeRedis makes the routes run against a fakeRedis holding a link
+// of owner "bench" under short.
+func useFakeRedis(b *testing.B, short string) {
+	b.Helper()
+
+	f := &fakeRedis{hashes: map[string]map[string]string{
+		links.MetaKey(short): {
+			"url":        "https://example.com/landing",
+			"created_at": "1700000000",
+			"owner":      "bench",
+		},
+	}}
+	conn := radix.NewStubConn("tcp", "fake:6379", f.reply)
+	b.Cleanup(func() { conn.Close() })
+	database.SetShared(fakeClient{conn: conn})
+}
+
+// asOwner authenticates every request as owner, in place of the API key
+// middlewares.
+func asOwner(owner string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("owner", owner)
+		return c.Next()
+	}
+}
+
+func BenchmarkResolveURL(b *testing.B) {
+	useFakeRedis(b, "abc123")
+	app := fiber.New()
+	app.Get("/:url/*", routes.ResolveURL)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/abc123", nil), -1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusMovedPermanently {
+			b.Fatalf("GET /abc123 = %d", resp.StatusCode)
+		}
+	}
+}
+
+func BenchmarkShortenURL(b *testing.B) {
+	useFakeRedis(b, "abc123")
+	app := fiber.New()
+	app.Post("/", asOwner("bench"), routes.ShortenURL)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(fiber.MethodPost, "/", strings.NewReader(`{"url":"https://93.184.215.14/landing"}`))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusOK {
+			b.Fatalf("POST / = %d", resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package routes
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/reports"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// The /v4 routes mirror the most used endpoints of the Bitly v4 API, so
+// that tooling written for Bitly keeps working once pointed at this
+// service. Bitlinks are "<domain>/<short>", the domain being DOMAIN; any
+// domain is accepted on input.
+
+// bitlyTime is the time format of Bitly responses.
+const bitlyTime = "2006-01-02T15:04:05-0700"
+
+type bitlink struct {
+	CreatedAt      string   `json:"created_at"`
+	ID             string   `json:"id"`
+	Link           string   `json:"link"`
+	LongURL        string   `json:"long_url"`
+	Title          string   `json:"title,omitempty"`
+	Archived       bool     `json:"archived"`
+	CustomBitlinks []string `json:"custom_bitlinks"`
+	Tags           []string `json:"tags"`
+	Deeplinks      []string `json:"deeplinks"`
+}
+
+// bitlyError answers in the error format of Bitly, whose clients switch on
+// message.
+func bitlyError(c *fiber.Ctx, status int, message, description string) error {
+	return c.Status(status).JSON(fiber.Map{
+		"message":     message,
+		"description": description,
+		"resource":    "bitlinks",
+	})
+}
+
+// bitlyFailure maps an error of shorten to its closest Bitly message.
+func bitlyFailure(c *fiber.Ctx, ferr *fiber.Error) error {
+	message := "INTERNAL_ERROR"
+	switch ferr.Code {
+	case fiber.StatusBadRequest, fiber.StatusUnprocessableEntity:
+		message = "INVALID_ARG_LONG_URL"
+	case fiber.StatusConflict:
+		message = "ALREADY_A_BITLINK"
+	case fiber.StatusTooManyRequests:
+		message = "RATE_LIMIT_EXCEEDED"
+	case fiber.StatusServiceUnavailable:
+		message = "TEMPORARILY_UNAVAILABLE"
+	}
+
+	return bitlyError(c, ferr.Code, message, ferr.Message)
+}
+
+// parseBitlink returns the canonical short of a bitlink, with or without
+// scheme.
+func parseBitlink(id string) (string, bool) {
+	if _, rest, ok := strings.Cut(id, "://"); ok {
+		id = rest
+	}
+	_, display, ok := strings.Cut(id, "/")
+	if !ok {
+		return "", false
+	}
+
+	short, err := links.ParseShort(display)
+	return short, err == nil
+}
+
+func newBitlink(c *fiber.Ctx, short string, meta map[string]string) bitlink {
+	id := os.Getenv("DOMAIN") + "/" + links.DisplayShort(short)
+	created, _ := strconv.ParseInt(meta["created_at"], 10, 64)
+
+	return bitlink{
+		CreatedAt:      time.Unix(created, 0).UTC().Format(bitlyTime),
+		ID:             id,
+		Link:           c.Protocol() + "://" + id,
+		LongURL:        meta["url"],
+		Title:          meta["title"],
+		Archived:       meta["disabled"] == "1",
+		CustomBitlinks: []string{},
+		Tags:           []string{},
+		Deeplinks:      []string{},
+	}
+}
+
+// BitlyShorten is POST /v4/shorten: {"long_url": ...} in, a bitlink out.
+// domain and group_guid are accepted and ignored.
+func BitlyShorten(c *fiber.Ctx) error {
+	var body struct {
+		LongURL string `json:"long_url"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return bitlyError(c, fiber.StatusBadRequest, "INVALID_CONTENT_TYPE", "Cannot parse JSON")
+	}
+	if body.LongURL == "" {
+		return bitlyError(c, fiber.StatusBadRequest, "INVALID_ARG_LONG_URL", "long_url is required")
+	}
+	if err := writable(c); err != nil {
+		return bitlyError(c, fiber.StatusServiceUnavailable, "TEMPORARILY_UNAVAILABLE", errReadOnly().Message)
+	}
+	if err := creationPaused(c); err != nil {
+		return bitlyError(c, fiber.StatusServiceUnavailable, "TEMPORARILY_UNAVAILABLE", errMaintenance().Message)
+	}
+
+	resp, ferr := shorten(c, &request{URL: body.LongURL})
+	if ferr != nil {
+		return bitlyFailure(c, ferr)
+	}
+
+	short, _ := parseBitlink(resp.CustomShort)
+	link := newBitlink(c, short, map[string]string{
+		"url":        resp.URL,
+		"created_at": strconv.FormatInt(clock.Now().Unix(), 10),
+	})
+
+	return c.Status(fiber.StatusCreated).JSON(link)
+}
+
+// BitlyExpand is POST /v4/expand: {"bitlink_id": ...} in, its long URL
+// out.
+func BitlyExpand(c *fiber.Ctx) error {
+	var body struct {
+		BitlinkID string `json:"bitlink_id"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return bitlyError(c, fiber.StatusBadRequest, "INVALID_CONTENT_TYPE", "Cannot parse JSON")
+	}
+
+	link, fault := loadBitlink(c, body.BitlinkID)
+	if fault != nil {
+		return fault.send(c)
+	}
+
+	return c.JSON(fiber.Map{
+		"created_at": link.CreatedAt,
+		"id":         link.ID,
+		"link":       link.Link,
+		"long_url":   link.LongURL,
+	})
+}
+
+// BitlyGet is GET /v4/bitlinks/{bitlink}.
+func BitlyGet(c *fiber.Ctx) error {
+	link, fault := loadBitlink(c, c.Params("+"))
+	if fault != nil {
+		return fault.send(c)
+	}
+
+	return c.JSON(link)
+}
+
+// bitlyFault is an error to answer in the format of Bitly.
+type bitlyFault struct {
+	status               int
+	message, description string
+}
+
+func (f *bitlyFault) send(c *fiber.Ctx) error {
+	return bitlyError(c, f.status, f.message, f.description)
+}
+
+// loadBitlink loads a bitlink. Destinations of protected shorts are not
+// revealed.
+func loadBitlink(c *fiber.Ctx, id string) (*bitlink, *bitlyFault) {
+	short, ok := parseBitlink(id)
+	if !ok {
+		return nil, &bitlyFault{fiber.StatusBadRequest, "INVALID_ARG_BITLINK", "invalid bitlink"}
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return nil, &bitlyFault{fiber.StatusServiceUnavailable, "TEMPORARILY_UNAVAILABLE", "cannot connect to DB"}
+	}
+
+	meta, err := links.Load(rClient, short)
+	if err != nil {
+		return nil, &bitlyFault{fiber.StatusInternalServerError, "INTERNAL_ERROR", "Unable to read link"}
+	}
+	if meta == nil {
+		return nil, &bitlyFault{fiber.StatusNotFound, "NOT_FOUND", "short not found"}
+	}
+	if meta["password_hash"] != "" {
+		return nil, &bitlyFault{fiber.StatusForbidden, "FORBIDDEN", "short is password protected"}
+	}
+
+	link := newBitlink(c, short, meta)
+	return &link, nil
+}
+
+// BitlyClicksSummary is GET /v4/bitlinks/{bitlink}/clicks/summary. Units
+// of minutes and hours reach back an hour, days, weeks and months as far
+// as the daily counters are kept, and units=-1 counts every click.
+func BitlyClicksSummary(c *fiber.Ctx) error {
+	short, ok := parseBitlink(c.Params("+"))
+	if !ok {
+		return bitlyError(c, fiber.StatusBadRequest, "INVALID_ARG_BITLINK", "invalid bitlink")
+	}
+
+	unit := c.Query("unit", "day")
+	units := c.QueryInt("units", -1)
+
+	now := clock.Now()
+	var minutes, days int
+	switch unit {
+	case "minute":
+		minutes = units
+	case "hour":
+		minutes = 60 * units
+	case "day":
+		days = units
+	case "week":
+		days = 7 * units
+	case "month":
+		days = 30 * units
+	default:
+		return bitlyError(c, fiber.StatusBadRequest, "INVALID_ARG_UNIT", "unit must be minute, hour, day, week or month")
+	}
+	if units != -1 && (units < 1 || minutes > 60 || days > aggregateConfig().Retention) {
+		return bitlyError(c, fiber.StatusBadRequest, "INVALID_ARG_UNITS", "units reach back further than clicks are kept")
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return bitlyError(c, fiber.StatusServiceUnavailable, "TEMPORARILY_UNAVAILABLE", "cannot connect to DB")
+	}
+
+	var keys []string
+	switch {
+	case units == -1:
+		keys = append(keys, links.ClicksKey(short))
+	case minutes > 0:
+		for i := 0; i < minutes; i++ {
+			keys = append(keys, links.BucketKey(short, now.Unix()/60-int64(i)))
+		}
+	default:
+		today := reports.Today()
+		for i := 0; i < days; i++ {
+			keys = append(keys, links.DayClicksKey(short, today-int64(i)))
+		}
+	}
+
+	counts := make([]int64, len(keys))
+	p := radix.NewPipeline()
+	for i, key := range keys {
+		if units == -1 {
+			p.Append(links.ClicksCmd(&counts[i], short))
+			continue
+		}
+		p.Append(radix.Cmd(&counts[i], "GET", key))
+	}
+	if err := rClient.Do(p); err != nil {
+		return bitlyError(c, fiber.StatusInternalServerError, "INTERNAL_ERROR", "Unable to read clicks")
+	}
+
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+
+	return c.JSON(fiber.Map{
+		"unit_reference": now.UTC().Format(bitlyTime),
+		"total_clicks":   total,
+		"units":          units,
+		"unit":           unit,
+	})
+}
@@ -0,0 +1,141 @@
+package routes
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+var brandColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+)$`)
+
+type branding struct {
+	Name    string            `json:"name"`
+	LogoURL string            `json:"logo_url"`
+	Color   string            `json:"color"`
+	Domains []string          `json:"domains"`
+	Pages   map[string]string `json:"pages"`
+}
+
+// GetBranding returns the brand, custom domains and page templates of an
+// owner.
+func GetBranding(c *fiber.Ctx) error {
+	owner := c.Params("owner")
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	var fields map[string]string
+	if err := rClient.Do(radix.Cmd(&fields, "HGETALL", links.BrandingKey(owner))); err != nil {
+		return dbError(err, "Unable to load branding")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(brandingFromFields(fields))
+}
+
+// SetBranding replaces the brand of an owner. Pages are keyed by page name
+// and use html/template, with the "head" and "foot" templates of the
+// built-in pages available. Omitted pages use the built-in ones.
+func SetBranding(c *fiber.Ctx) error {
+	var body branding
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	owner := c.Params("owner")
+
+	if body.Color != "" && !brandColor.MatchString(body.Color) {
+		return errInvalid("color must be a hex color or a color name")
+	}
+	for name, text := range body.Pages {
+		if _, ok := pageTitles[name]; !ok {
+			return errInvalid("unknown page " + name)
+		}
+		if _, err := parsePage(name, text); err != nil {
+			return errInvalid(err.Error())
+		}
+	}
+	for i, domain := range body.Domains {
+		body.Domains[i] = strings.ToLower(domain)
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	var previous string
+	if err := rClient.Do(radix.Cmd(&previous, "HGET", links.BrandingKey(owner), "domains")); err != nil {
+		return dbError(err, "Unable to save branding")
+	}
+	for _, domain := range body.Domains {
+		var taken string
+		if err := rClient.Do(radix.Cmd(&taken, "HGET", links.DomainsKey(), domain)); err != nil {
+			return dbError(err, "Unable to save branding")
+		}
+		if taken != "" && taken != owner {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "domain " + domain + " belongs to another owner"})
+		}
+	}
+
+	fields := []string{links.BrandingKey(owner),
+		"name", body.Name,
+		"logo_url", body.LogoURL,
+		"color", body.Color,
+		"domains", strings.Join(body.Domains, ","),
+	}
+	for name, text := range body.Pages {
+		fields = append(fields, "page:"+name, text)
+	}
+
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "MULTI"))
+	for _, domain := range strings.Split(previous, ",") {
+		if domain != "" {
+			p.Append(radix.Cmd(nil, "HDEL", links.DomainsKey(), domain))
+		}
+	}
+	for _, domain := range body.Domains {
+		p.Append(radix.Cmd(nil, "HSET", links.DomainsKey(), domain, owner))
+	}
+	p.Append(radix.Cmd(nil, "DEL", links.BrandingKey(owner)))
+	p.Append(radix.Cmd(nil, "HSET", fields...))
+	p.Append(radix.Cmd(nil, "EXEC"))
+	if err := rClient.Do(p); err != nil {
+		return dbError(err, "Unable to save branding")
+	}
+
+	// Other instances pick the change up within BRANDING_CACHE_TTL.
+	brands.Delete(owner)
+
+	return c.Status(fiber.StatusOK).JSON(body)
+}
+
+func brandingFromFields(fields map[string]string) branding {
+	b := branding{
+		Name:    fields["name"],
+		LogoURL: fields["logo_url"],
+		Color:   fields["color"],
+		Domains: []string{},
+		Pages:   map[string]string{},
+	}
+	for _, domain := range strings.Split(fields["domains"], ",") {
+		if domain != "" {
+			b.Domains = append(b.Domains, domain)
+		}
+	}
+	for name := range pageTitles {
+		if text := fields["page:"+name]; text != "" {
+			b.Pages[name] = text
+		}
+	}
+
+	return b
+}
@@ -0,0 +1,316 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+var errCampaignNotFound = errors.New("campaign not found")
+
+type campaignRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	ReportEmail string   `json:"report_email"`
+	Shorts      []string `json:"shorts"`
+}
+
+type extendRequest struct {
+	Expiry expiry `json:"expiry"`
+}
+
+type campaignLinkStats struct {
+	Short    string `json:"short"`
+	Title    string `json:"title,omitempty"`
+	Clicks   int64  `json:"clicks"`
+	Disabled bool   `json:"disabled"`
+}
+
+type campaignStats struct {
+	Campaign    string              `json:"campaign"`
+	TotalClicks int64               `json:"total_clicks"`
+	Links       []campaignLinkStats `json:"links"`
+}
+
+// CreateCampaign registers a new campaign of the caller, optionally
+// grouping existing shorts of theirs.
+func CreateCampaign(c *fiber.Ctx) error {
+	body := new(campaignRequest)
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	if err := links.ValidateCampaign(body.Name); err != nil {
+		return errInvalid(err.Error())
+	}
+	if body.ReportEmail != "" {
+		addr, err := mail.ParseAddress(body.ReportEmail)
+		if err != nil {
+			return errInvalid("report_email must be an email address")
+		}
+		body.ReportEmail = addr.Address
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	// HSETNX leaves an existing campaign untouched, the whole campaign is
+	// written or none of it.
+	key := links.CampaignKey(body.Name)
+	var created []int
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "MULTI"))
+	p.Append(radix.Cmd(nil, "HSETNX", key, "created_at", strconv.FormatInt(clock.Now().Unix(), 10)))
+	p.Append(radix.Cmd(nil, "HSETNX", key, "name", body.Name))
+	p.Append(radix.Cmd(nil, "HSETNX", key, "description", body.Description))
+	p.Append(radix.Cmd(nil, "HSETNX", key, "report_email", body.ReportEmail))
+	p.Append(radix.Cmd(nil, "HSETNX", key, "owner", Owner(c)))
+	p.Append(radix.Cmd(nil, "SADD", links.CampaignsKey(), body.Name))
+	p.Append(radix.Cmd(&created, "EXEC"))
+	if err := rClient.Do(p); err != nil {
+		return dbError(err, "Unable to create campaign")
+	}
+	if len(created) == 0 || created[0] == 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Campaign already exists"})
+	}
+
+	if err := addCampaignLinks(rClient, Owner(c), body.Name, body.Shorts); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"campaign": body.Name, "links": len(body.Shorts)})
+}
+
+// AddCampaignLinks adds existing shorts of the caller to one of their
+// campaigns.
+func AddCampaignLinks(c *fiber.Ctx) error {
+	name := c.Params("name")
+	body := new(campaignRequest)
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	if _, err := campaignMembers(rClient, Owner(c), name); err != nil {
+		return campaignError(c, err)
+	}
+
+	if err := addCampaignLinks(rClient, Owner(c), name, body.Shorts); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"campaign": name, "added": len(body.Shorts)})
+}
+
+// CampaignStats returns the total clicks of a campaign with a per-link breakdown.
+func CampaignStats(c *fiber.Ctx) error {
+	name := c.Params("name")
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	shorts, err := campaignMembers(rClient, Owner(c), name)
+	if err != nil {
+		return campaignError(c, err)
+	}
+
+	clicks := make([]int64, len(shorts))
+	metas := make([][]string, len(shorts))
+	p := radix.NewPipeline()
+	for i, short := range shorts {
+		p.Append(links.ClicksCmd(&clicks[i], short))
+		p.Append(links.FieldsCmd(&metas[i], short, "disabled", "title"))
+	}
+	if err := rClient.Do(p); err != nil {
+		return dbError(err, "Unable to read campaign stats")
+	}
+
+	resp := campaignStats{Campaign: name, Links: make([]campaignLinkStats, len(shorts))}
+	for i, short := range shorts {
+		resp.TotalClicks += clicks[i]
+		resp.Links[i] = campaignLinkStats{
+			Short:    short,
+			Title:    metas[i][1],
+			Clicks:   clicks[i],
+			Disabled: metas[i][0] == "1",
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(resp)
+}
+
+// DisableCampaign stops every short of the campaign from resolving.
+func DisableCampaign(c *fiber.Ctx) error {
+	return setCampaignDisabled(c, true)
+}
+
+// EnableCampaign lets every short of the campaign resolve again.
+func EnableCampaign(c *fiber.Ctx) error {
+	return setCampaignDisabled(c, false)
+}
+
+// ExtendCampaign applies a new expiry to every short of the campaign.
+func ExtendCampaign(c *fiber.Ctx) error {
+	name := c.Params("name")
+	body := new(extendRequest)
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	ttl := body.Expiry.ttl(apiVersion(c))
+	if ttl < time.Second {
+		return errInvalid("Expiry must be positive")
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	shorts, err := campaignMembers(rClient, Owner(c), name)
+	if err != nil {
+		return campaignError(c, err)
+	}
+
+	p := radix.NewPipeline()
+	for _, short := range shorts {
+		links.AppendExpire(p, short, ttl)
+	}
+	if err := rClient.Do(p); err != nil {
+		return dbError(err, "Unable to extend campaign")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"campaign":    name,
+		"links":       len(shorts),
+		"expiry":      expiryIn(ttl, apiVersion(c)),
+		"expiry_unit": expiryUnit(apiVersion(c)),
+	})
+}
+
+func setCampaignDisabled(c *fiber.Ctx, disabled bool) error {
+	name := c.Params("name")
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	shorts, err := campaignMembers(rClient, Owner(c), name)
+	if err != nil {
+		return campaignError(c, err)
+	}
+
+	p := radix.NewPipeline()
+	for _, short := range shorts {
+		if disabled {
+			p.Append(links.WriteCmd(short, []string{"disabled", "1"}, nil))
+		} else {
+			p.Append(links.WriteCmd(short, nil, []string{"disabled"}))
+		}
+	}
+	if err := rClient.Do(p); err != nil {
+		return dbError(err, "Unable to update campaign")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"campaign": name, "links": len(shorts), "disabled": disabled})
+}
+
+// campaignMembers returns the shorts of the campaign name that belong to
+// owner, the shorts moved to other owners since being added staying out
+// of its stats and changes. Campaigns of other owners are not found.
+func campaignMembers(rClient database.ClientInterface, owner, name string) ([]string, error) {
+	var campaignOwner string
+	if err := rClient.Do(radix.Cmd(&campaignOwner, "HGET", links.CampaignKey(name), "owner")); err != nil {
+		return nil, err
+	}
+	if owner == "" || campaignOwner != owner {
+		return nil, errCampaignNotFound
+	}
+
+	var members []string
+	if err := rClient.Do(radix.Cmd(&members, "SMEMBERS", links.CampaignLinksKey(name))); err != nil {
+		return nil, err
+	}
+
+	owners := make([][]string, len(members))
+	p := radix.NewPipeline()
+	for i, short := range members {
+		p.Append(links.FieldsCmd(&owners[i], short, "owner"))
+	}
+	if err := rClient.Do(p); err != nil {
+		return nil, err
+	}
+
+	shorts := make([]string, 0, len(members))
+	for i, short := range members {
+		if owners[i][0] == owner {
+			shorts = append(shorts, short)
+		}
+	}
+
+	return shorts, nil
+}
+
+// campaignError answers a failed campaignMembers.
+func campaignError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errCampaignNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return dbError(err, "Unable to read campaign")
+}
+
+// addCampaignLinks moves shorts of owner to the campaign name, out of the
+// campaign they belonged to.
+func addCampaignLinks(rClient database.ClientInterface, owner, name string, shorts []string) *fiber.Error {
+	previous := make([]string, len(shorts))
+	for i, short := range shorts {
+		meta, err := links.Load(rClient, short)
+		if err != nil {
+			return dbError(err, "Unable to add links to campaign")
+		}
+		if meta == nil || owner == "" || meta["owner"] != owner {
+			return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("short %q not found", short))
+		}
+		previous[i] = meta["campaign"]
+	}
+
+	p := radix.NewPipeline()
+	for i, short := range shorts {
+		if previous[i] != "" && previous[i] != name {
+			p.Append(radix.Cmd(nil, "SREM", links.CampaignLinksKey(previous[i]), short))
+		}
+		p.Append(radix.Cmd(nil, "SADD", links.CampaignLinksKey(name), short))
+		p.Append(links.WriteCmd(short, []string{"campaign", name}, nil))
+	}
+	if err := rClient.Do(p); err != nil {
+		return dbError(err, "Unable to add links to campaign")
+	}
+
+	return nil
+}
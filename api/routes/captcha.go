@@ -0,0 +1,92 @@
+package routes
+
+import (
+	"html/template"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/anomaly"
+	"github.com/ksarpe/redis-golang/captcha"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/i18n"
+)
+
+var captchaProvider = sync.OnceValue(captcha.FromEnv)
+
+var captchaPage = template.Must(template.New("captcha").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<title>{{call .T "Checking your browser"}}</title>
+<script src="{{.ScriptURL}}" async defer></script>
+</head>
+<body>
+<p>{{call .T "This link received unusual traffic. Please confirm you are human to continue."}}</p>
+<form method="POST" action="/{{.Short}}">
+<div class="{{.WidgetClass}}" data-sitekey="{{.SiteKey}}"></div>
+<button type="submit">{{call .T "Continue"}}</button>
+</form>
+</body>
+</html>
+`))
+
+// needsCaptcha reports whether the visitor has to solve a challenge before
+// being redirected to a flagged short.
+func needsCaptcha(c *fiber.Ctx, meta map[string]string) bool {
+	p := captchaProvider()
+	if p == nil || meta["flagged"] == "" || meta["flag_action"] != anomaly.ActionCaptcha {
+		return false
+	}
+
+	return !p.ValidPass(c.Cookies(captcha.CookieName), clock.Now())
+}
+
+func renderCaptcha(c *fiber.Ctx, short string) error {
+	p := captchaProvider()
+
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	lang := langOf(c)
+	c.Set(fiber.HeaderContentLanguage, lang)
+
+	return captchaPage.Execute(c.Status(fiber.StatusForbidden), fiber.Map{
+		"Lang":        lang,
+		"T":           func(msg string, args ...any) string { return i18n.T(lang, msg, args...) },
+		"Short":       short,
+		"ScriptURL":   p.ScriptURL,
+		"WidgetClass": p.WidgetClass,
+		"SiteKey":     p.SiteKey,
+	})
+}
+
+// VerifyCaptcha checks a solved challenge, hands out the bypass cookie and
+// sends the visitor back to the short.
+func VerifyCaptcha(c *fiber.Ctx) error {
+	short := strings.TrimSuffix(c.Params("url")+"/"+c.Params("*"), "/")
+
+	p := captchaProvider()
+	if p == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "captcha is not enabled"})
+	}
+
+	ok, err := p.Verify(c.Context(), c.FormValue(p.ResponseField), c.IP())
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "unable to verify captcha"})
+	}
+	if !ok {
+		return renderCaptcha(c, short)
+	}
+
+	now := clock.Now()
+	c.Cookie(&fiber.Cookie{
+		Name:     captcha.CookieName,
+		Value:    p.PassCookie(now),
+		Expires:  now.Add(p.BypassTTL),
+		HTTPOnly: true,
+		Secure:   c.Protocol() == "https",
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+
+	return c.Redirect("/"+short, fiber.StatusSeeOther)
+}
@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/card"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// LinkCard serves a PNG preview card of a short, with its code, the domain
+// of its destination and a QR code, for chat tools to embed. Cards are
+// rendered on first request and cached for CARD_CACHE_TTL. The destination
+// of a protected short is left out.
+func LinkCard(c *fiber.Ctx) error {
+	short := shortParam(c)
+
+	ttl := 24 * time.Hour
+	if v, err := time.ParseDuration(os.Getenv("CARD_CACHE_TTL")); err == nil && v > 0 {
+		ttl = v
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return errUnavailable()
+	}
+
+	meta, err := links.Load(rClient, short)
+	if err != nil {
+		return dbError(err, "Unable to read link")
+	}
+	if meta == nil || meta["disabled"] == "1" {
+		return fiber.NewError(fiber.StatusNotFound, "short not found")
+	}
+
+	// Cached cards are only served while the short may be shown.
+	key := links.AssetKey("card", short)
+	if cached, err := database.GetString(rClient, key); err == nil && cached != "" {
+		return sendAsset(c, "image/png", []byte(cached), ttl)
+	}
+
+	display := links.DisplayShort(short)
+	cd := card.Card{
+		Short: display,
+		URL:   c.Protocol() + "://" + os.Getenv("DOMAIN") + "/" + display,
+	}
+	if u, err := url.Parse(meta["url"]); err == nil && meta["password_hash"] == "" {
+		cd.Domain = u.Hostname()
+	}
+	if meta["owner"] != "" {
+		var color string
+		if err := rClient.Do(radix.Cmd(&color, "HGET", links.BrandingKey(meta["owner"]), "color")); err == nil {
+			cd.Accent, _ = card.ParseColor(color)
+		}
+	}
+
+	png, err := card.Render(cd)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Unable to render card")
+	}
+
+	// Cards can always be rendered again, a failed write only costs that.
+	_ = rClient.Do(radix.Cmd(nil, "SET", key, string(png), "EX", strconv.FormatInt(int64(ttl/time.Second), 10)))
+
+	return sendAsset(c, "image/png", png, ttl)
+}
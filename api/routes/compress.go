@@ -0,0 +1,80 @@
+package routes
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// defaultCompressTypes are the content types worth compressing, redirects
+// and images are left alone.
+const defaultCompressTypes = "application/json,text/plain,text/csv,text/html,application/xml"
+
+// Compress returns a middleware compressing responses with brotli or gzip,
+// depending on Accept-Encoding, once they are at least COMPRESS_MIN_BYTES
+// long and of one of the COMPRESS_TYPES. COMPRESS_LEVEL selects speed,
+// best or off, anything else meaning the default level.
+func Compress() fiber.Handler {
+	minBytes := 1024
+	if v, err := strconv.Atoi(os.Getenv("COMPRESS_MIN_BYTES")); err == nil && v >= 0 {
+		minBytes = v
+	}
+
+	types := os.Getenv("COMPRESS_TYPES")
+	if types == "" {
+		types = defaultCompressTypes
+	}
+	allowed := strings.Split(types, ",")
+	for i := range allowed {
+		allowed[i] = strings.TrimSpace(allowed[i])
+	}
+
+	noop := func(*fasthttp.RequestCtx) {}
+	var compressor fasthttp.RequestHandler
+	switch os.Getenv("COMPRESS_LEVEL") {
+	case "off":
+		return func(c *fiber.Ctx) error { return c.Next() }
+	case "speed":
+		compressor = fasthttp.CompressHandlerBrotliLevel(noop, fasthttp.CompressBrotliBestSpeed, fasthttp.CompressBestSpeed)
+	case "best":
+		compressor = fasthttp.CompressHandlerBrotliLevel(noop, fasthttp.CompressBrotliBestCompression, fasthttp.CompressBestCompression)
+	default:
+		compressor = fasthttp.CompressHandlerBrotliLevel(noop, fasthttp.CompressBrotliDefaultCompression, fasthttp.CompressDefaultCompression)
+	}
+
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		// Body would drain a stream, event streams must reach the client
+		// as they are written.
+		if c.Response().IsBodyStream() {
+			return nil
+		}
+
+		if len(c.Response().Body()) < minBytes || !compressible(string(c.Response().Header.ContentType()), allowed) {
+			return nil
+		}
+
+		compressor(c.Context())
+
+		return nil
+	}
+}
+
+func compressible(contentType string, allowed []string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+
+	for _, t := range allowed {
+		if strings.EqualFold(mediaType, t) {
+			return true
+		}
+	}
+
+	return false
+}
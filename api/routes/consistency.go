@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/consistency"
+	"github.com/ksarpe/redis-golang/database"
+)
+
+// RunConsistencyCheck verifies the keyspace invariants on demand, repairing
+// discrepancies when called with ?repair=true.
+func RunConsistencyCheck(c *fiber.Ctx) error {
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	report, err := consistency.Check(c.Context(), rClient, c.QueryBool("repair"))
+	if err != nil {
+		return dbError(err, "Unable to check consistency")
+	}
+
+	_ = consistency.Save(rClient, report)
+
+	return c.Status(fiber.StatusOK).JSON(report)
+}
+
+// LastConsistencyReport returns the report of the latest check.
+func LastConsistencyReport(c *fiber.Ctx) error {
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	report, err := database.GetString(rClient, consistency.LastReportKey)
+	if err != nil || report == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "no consistency report yet"})
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+	return c.Status(fiber.StatusOK).SendString(report)
+}
@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/redisstats"
+)
+
+type slowEntry struct {
+	ID           int64     `json:"id"`
+	Time         time.Time `json:"time"`
+	Microseconds int64     `json:"duration_us"`
+	Command      string    `json:"command"`
+	Key          string    `json:"key,omitempty"`
+	Namespace    string    `json:"namespace,omitempty"`
+	Args         []string  `json:"args"`
+	Client       string    `json:"client,omitempty"`
+}
+
+type slowSummary struct {
+	Command      string `json:"command"`
+	Namespace    string `json:"namespace,omitempty"`
+	Count        int    `json:"count"`
+	Microseconds int64  `json:"total_us"`
+	Max          int64  `json:"max_us"`
+}
+
+// Slowlog returns the last ?limit= (default 128, at most 1024) entries of
+// the redis slowlog, each tagged with the namespace of the key it touched
+// so that slow commands can be traced to the feature issuing them, and a
+// summary per command and namespace, slowest first.
+func Slowlog(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 128)
+	if limit < 1 || limit > 1024 {
+		return errInvalid("limit must be between 1 and 1024")
+	}
+
+	rClient, err := database.Bulk()
+	if err != nil {
+		return errUnavailable()
+	}
+
+	entries, err := redisstats.Slowlog(rClient, limit)
+	if err != nil {
+		return dbError(err, "Unable to read the slowlog")
+	}
+
+	out := make([]slowEntry, 0, len(entries))
+	summaries := map[[2]string]*slowSummary{}
+	for _, e := range entries {
+		entry := slowEntry{
+			ID:           e.ID,
+			Time:         e.Time.UTC(),
+			Microseconds: e.Duration.Microseconds(),
+			Command:      e.Name(),
+			Key:          e.Key(),
+			Args:         e.Command,
+			Client:       e.Client,
+		}
+		if entry.Key != "" {
+			entry.Namespace = links.Namespace(entry.Key)
+		}
+		out = append(out, entry)
+
+		id := [2]string{entry.Command, entry.Namespace}
+		s, ok := summaries[id]
+		if !ok {
+			s = &slowSummary{Command: entry.Command, Namespace: entry.Namespace}
+			summaries[id] = s
+		}
+		s.Count++
+		s.Microseconds += entry.Microseconds
+		s.Max = max(s.Max, entry.Microseconds)
+	}
+
+	summary := make([]slowSummary, 0, len(summaries))
+	for _, s := range summaries {
+		summary = append(summary, *s)
+	}
+	sort.Slice(summary, func(i, j int) bool { return summary[i].Microseconds > summary[j].Microseconds })
+
+	return c.JSON(fiber.Map{
+		"entries": out,
+		"summary": summary,
+	})
+}
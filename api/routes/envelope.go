@@ -0,0 +1,113 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// EnvelopeHeader lets a client pick the response shape of its requests,
+// "true" or "false", whatever API_ENVELOPE says, so that clients can move
+// to the envelope one at a time. The response carries it back when the
+// body is wrapped.
+const EnvelopeHeader = "X-API-Envelope"
+
+// envelopeDefault is read lazily so that the .env file is loaded first.
+// Bodies are left as they are unless API_ENVELOPE is true.
+var envelopeDefault = sync.OnceValue(func() bool {
+	return os.Getenv("API_ENVELOPE") == "true"
+})
+
+// envelope is the shape of every JSON response of the API when enabled,
+// exactly one of Data and Error being set.
+type envelope struct {
+	Data  json.RawMessage `json:"data"`
+	Error *envelopeError  `json:"error"`
+	Meta  envelopeMeta    `json:"meta"`
+}
+
+type envelopeError struct {
+	Message string `json:"message"`
+	// Details holds the other fields of the error body, such as the
+	// maintenance window refusing a request.
+	Details map[string]json.RawMessage `json:"details,omitempty"`
+}
+
+type envelopeMeta struct {
+	Status int `json:"status"`
+}
+
+// Envelope returns a middleware wrapping the JSON responses of the API in
+// an envelope, failed ones reporting their "error" field as error.message.
+// GraphQL keeps the envelope of its specification, streams and plain text
+// answers are left alone.
+func Envelope() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rest, ok := apiPath(c.Path())
+		if !ok || rest == "/graphql" || !wantsEnvelope(c) {
+			return c.Next()
+		}
+
+		err := c.Next()
+
+		var fe *fiber.Error
+		if errors.As(err, &fe) {
+			return sendEnvelope(c, envelope{Error: &envelopeError{Message: fe.Message}, Meta: envelopeMeta{Status: fe.Code}})
+		}
+
+		resp := c.Response()
+		if err != nil || resp.IsBodyStream() {
+			return err
+		}
+		mediaType, _, _ := strings.Cut(string(resp.Header.ContentType()), ";")
+		if strings.TrimSpace(mediaType) != fiber.MIMEApplicationJSON {
+			return nil
+		}
+
+		status := resp.StatusCode()
+		if status < fiber.StatusBadRequest {
+			data := json.RawMessage(resp.Body())
+			if len(data) == 0 {
+				data = json.RawMessage("null")
+			}
+			return sendEnvelope(c, envelope{Data: data, Meta: envelopeMeta{Status: status}})
+		}
+
+		var body map[string]json.RawMessage
+		var msg string
+		if json.Unmarshal(resp.Body(), &body) != nil || json.Unmarshal(body["error"], &msg) != nil {
+			return nil
+		}
+		delete(body, "error")
+		e := &envelopeError{Message: msg}
+		if len(body) > 0 {
+			e.Details = body
+		}
+
+		return sendEnvelope(c, envelope{Error: e, Meta: envelopeMeta{Status: status}})
+	}
+}
+
+func wantsEnvelope(c *fiber.Ctx) bool {
+	switch c.Get(EnvelopeHeader) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	return envelopeDefault()
+}
+
+func sendEnvelope(c *fiber.Ctx, e envelope) error {
+	if e.Data == nil {
+		e.Data = json.RawMessage("null")
+	}
+	c.Set(EnvelopeHeader, "true")
+
+	return c.Status(e.Meta.Status).JSON(e)
+}
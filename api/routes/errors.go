@@ -0,0 +1,50 @@
+package routes
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+)
+
+// Error classes are mapped to statuses here only, so clients can tell what
+// is worth retrying: invalid requests fail again unless changed, conflicts
+// need another alias, and unavailability passes.
+
+// errInvalid reports a well-formed request whose content is rejected.
+func errInvalid(message string) *fiber.Error {
+	return fiber.NewError(fiber.StatusUnprocessableEntity, message)
+}
+
+// errConflict reports a request clashing with existing data, such as a
+// custom short already in use.
+func errConflict(message string) *fiber.Error {
+	return fiber.NewError(fiber.StatusConflict, message)
+}
+
+// errUnavailable reports redis being unreachable.
+func errUnavailable() *fiber.Error {
+	return fiber.NewError(fiber.StatusServiceUnavailable, "cannot connect to DB")
+}
+
+// dbError reports a failed redis command: errUnavailable when redis could
+// not be reached, an internal error with message otherwise.
+func dbError(err error, message string) *fiber.Error {
+	if database.Unavailable(err) {
+		return errUnavailable()
+	}
+
+	return fiber.NewError(fiber.StatusInternalServerError, message)
+}
+
+// ErrorHandler answers the errors returned by handlers, negotiated like
+// their other answers. A *fiber.Error keeps its status, other errors are
+// mapped by dbError.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var fe *fiber.Error
+	if !errors.As(err, &fe) {
+		fe = dbError(err, "Internal Server Error")
+	}
+
+	return negotiateError(c, fe.Code, fe.Message)
+}
@@ -0,0 +1,114 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errExpiry = errors.New(`expiry must be a duration such as "24h" or "7d", or a number`)
+
+// expiry is a lifetime as sent by clients: a duration string such as
+// "90m", "24h" or "7d", or a bare number. Numbers are hours on /api/v1, as
+// they always were, and seconds from /api/v2 on, see ttl.
+type expiry struct {
+	d      time.Duration
+	number int64
+	isNum  bool
+}
+
+func (e *expiry) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*e = expiry{}
+		return nil
+	}
+
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		parsed, err := parseExpiry(s)
+		if err != nil {
+			return err
+		}
+		*e = parsed
+		return nil
+	}
+
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return errExpiry
+	}
+	*e = expiry{number: n, isNum: true}
+
+	return nil
+}
+
+// parseExpiry parses the expiry of a query string, where numbers come as
+// text.
+func parseExpiry(s string) (expiry, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return expiry{}, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return expiry{number: n, isNum: true}, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseInt(days, 10, 64)
+		if err != nil || n > int64(time.Duration(1<<63-1)/(24*time.Hour)) {
+			return expiry{}, errExpiry
+		}
+		return expiry{d: time.Duration(n) * 24 * time.Hour}, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return expiry{}, errExpiry
+	}
+
+	return expiry{d: d}, nil
+}
+
+// set reports whether the client sent an expiry.
+func (e expiry) set() bool {
+	return e.isNum || e.d != 0
+}
+
+// ttl returns the lifetime for a request served by the given API version.
+func (e expiry) ttl(version int) time.Duration {
+	if !e.isNum {
+		return e.d
+	}
+
+	unit := time.Hour
+	if version >= 2 {
+		unit = time.Second
+	}
+	if e.number > int64(math.MaxInt64/unit) {
+		return math.MaxInt64
+	}
+
+	return time.Duration(e.number) * unit
+}
+
+// expiryUnit names the unit of the numbers an API version answers expiries
+// with.
+func expiryUnit(version int) string {
+	if version >= 2 {
+		return "seconds"
+	}
+
+	return "hours"
+}
+
+// expiryIn expresses ttl in the unit of an API version, rounding down.
+func expiryIn(ttl time.Duration, version int) int64 {
+	if version >= 2 {
+		return int64(ttl / time.Second)
+	}
+
+	return int64(ttl / time.Hour)
+}
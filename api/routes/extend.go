@@ -0,0 +1,181 @@
+package routes
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/events"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/reminders"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+var reminderConfig = sync.OnceValue(reminders.ConfigFromEnv)
+
+// ExtendByToken consumes a one-click token from a reminder and pushes the
+// expiry of its short back by REMINDER_EXTEND_BY.
+func ExtendByToken(c *fiber.Ctx) error {
+	token := c.Params("token")
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	var short string
+	if err := rClient.Do(radix.Cmd(&short, "GETDEL", links.ExtendTokenKey(token))); err != nil || short == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "extend link is invalid or expired"})
+	}
+
+	return extend(c, rClient, short, reminderConfig().ExtendBy)
+}
+
+// ExtendLink pushes the expiry of one of the caller's shorts back by the
+// given expiry.
+func ExtendLink(c *fiber.Ctx) error {
+	short := shortParam(c)
+	body := new(extendRequest)
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	ttl := body.Expiry.ttl(apiVersion(c))
+	if ttl < time.Second {
+		return errInvalid("Expiry must be positive")
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	var owner []string
+	if err := rClient.Do(links.FieldsCmd(&owner, short, "owner")); err != nil || owner[0] != Owner(c) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "short not found"})
+	}
+
+	return extend(c, rClient, short, ttl)
+}
+
+// extend adds by to the remaining lifetime of short. Shorts without a TTL
+// are left alone since they never expire.
+func extend(c *fiber.Ctx, rClient database.ClientInterface, short string, by time.Duration) error {
+	exists, err := links.Exists(rClient, short)
+	if err != nil {
+		return dbError(err, "Unable to extend link")
+	}
+	if !exists {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "short not found"})
+	}
+
+	var ttl int64
+	if err := rClient.Do(radix.Cmd(&ttl, "TTL", links.MetaKey(short))); err != nil {
+		return dbError(err, "Unable to extend link")
+	}
+
+	switch {
+	case ttl == -2:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "short not found"})
+	case ttl == -1:
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"short": short, "expires_at": nil})
+	}
+
+	newTTL := time.Duration(ttl)*time.Second + by
+
+	p := radix.NewPipeline()
+	links.AppendExpire(p, short, newTTL)
+	if err := rClient.Do(p); err != nil {
+		return dbError(err, "Unable to extend link")
+	}
+
+	expiresAt := clock.Now().Add(newTTL).Unix()
+	events.Emit("link.extended", events.Link{Short: short, ExpiresAt: expiresAt})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"short": short, "expires_at": expiresAt})
+}
+
+type bulkExtendRequest struct {
+	Shorts   []string `json:"shorts"`
+	Campaign string   `json:"campaign"`
+	Expiry   expiry   `json:"expiry"`
+}
+
+type bulkExtendResult struct {
+	Short     string `json:"short"`
+	Status    string `json:"status"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+}
+
+// BulkExtend sets a new expiry on a list of the caller's shorts
+// or on the caller's shorts of a campaign, reporting the outcome per short.
+func BulkExtend(c *fiber.Ctx) error {
+	body := new(bulkExtendRequest)
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	ttl := body.Expiry.ttl(apiVersion(c))
+	if ttl < time.Second {
+		return errInvalid("Expiry must be positive")
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	shorts := body.Shorts
+	if body.Campaign != "" {
+		members, err := campaignMembers(rClient, Owner(c), body.Campaign)
+		if err != nil {
+			return campaignError(c, err)
+		}
+		shorts = append(shorts, members...)
+	}
+
+	if len(shorts) == 0 {
+		return errInvalid("No shorts given")
+	}
+
+	owners := make([]string, len(shorts))
+	exists := make([]bool, len(shorts))
+	for i, short := range shorts {
+		fields, err := links.Load(rClient, short)
+		if err != nil {
+			return dbError(err, "Unable to extend links")
+		}
+		exists[i], owners[i] = fields != nil, fields["owner"]
+	}
+
+	expiresAt := clock.Now().Add(ttl).Unix()
+	results := make([]bulkExtendResult, len(shorts))
+	p := radix.NewPipeline()
+	for i, short := range shorts {
+		results[i] = bulkExtendResult{Short: short, Status: "not_found"}
+		if !exists[i] || owners[i] != Owner(c) {
+			continue
+		}
+		links.AppendExpire(p, short, ttl)
+		results[i].Status = "extended"
+		results[i].ExpiresAt = expiresAt
+	}
+	if err := rClient.Do(p); err != nil {
+		return dbError(err, "Unable to extend links")
+	}
+
+	for _, result := range results {
+		if result.Status == "extended" {
+			events.Emit("link.extended", events.Link{Short: result.Short, ExpiresAt: result.ExpiresAt})
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"results": results})
+}
@@ -0,0 +1,145 @@
+package routes
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/ratelimit"
+)
+
+type extensionConfig struct {
+	// origins are the prefixes of the origins allowed to call the
+	// extension endpoint from a browser.
+	origins []string
+	maxAge  time.Duration
+	limit   int64
+}
+
+// extensionSettings reads EXTENSION_* lazily so that the .env file is
+// loaded first.
+var extensionSettings = sync.OnceValue(func() extensionConfig {
+	cfg := extensionConfig{
+		origins: []string{"chrome-extension://", "moz-extension://", "safari-web-extension://"},
+		maxAge:  24 * time.Hour,
+		limit:   30,
+	}
+
+	if v := os.Getenv("EXTENSION_ORIGINS"); v != "" {
+		cfg.origins = nil
+		for _, origin := range strings.Split(v, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				cfg.origins = append(cfg.origins, origin)
+			}
+		}
+	}
+	if v, err := time.ParseDuration(os.Getenv("EXTENSION_PREFLIGHT_MAX_AGE")); err == nil && v >= 0 {
+		cfg.maxAge = v
+	}
+	if v, err := strconv.ParseInt(os.Getenv("EXTENSION_RATE_LIMIT"), 10, 64); err == nil && v > 0 {
+		cfg.limit = v
+	}
+
+	return cfg
+})
+
+// jsonpCallback restricts JSONP callbacks to plain, possibly dotted,
+// identifiers so they can't inject script.
+var jsonpCallback = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+// ExtensionCORS lets browser extensions, by default any Chrome, Firefox or
+// Safari extension, call the extension endpoint, and answers their
+// preflight requests with a long Access-Control-Max-Age so that a popup
+// costs a single round trip.
+func ExtensionCORS(c *fiber.Ctx) error {
+	cfg := extensionSettings()
+
+	origin := c.Get(fiber.HeaderOrigin)
+	allowed := false
+	for _, prefix := range cfg.origins {
+		if prefix == "*" || (origin != "" && strings.HasPrefix(origin, prefix)) {
+			allowed = true
+			break
+		}
+	}
+	c.Vary(fiber.HeaderOrigin)
+
+	if allowed && origin != "" {
+		c.Set(fiber.HeaderAccessControlAllowOrigin, origin)
+	}
+	if c.Method() != fiber.MethodOptions {
+		return c.Next()
+	}
+
+	if allowed && origin != "" {
+		c.Set(fiber.HeaderAccessControlAllowMethods, "GET, POST")
+		c.Set(fiber.HeaderAccessControlAllowHeaders, "Content-Type, Authorization, "+APIKeyHeader)
+		c.Set(fiber.HeaderAccessControlMaxAge, strconv.FormatInt(int64(cfg.maxAge/time.Second), 10))
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ExtensionShorten shortens the URL of the current tab for browser
+// extensions, from a {"url": ...} body or, for JSONP, the url query
+// parameter with ?callback=. It answers with the short URL only, and limits
+// each token to EXTENSION_RATE_LIMIT shortenings a minute.
+func ExtensionShorten(c *fiber.Ctx) error {
+	callback := c.Query("callback")
+	if callback != "" && (c.Method() != fiber.MethodGet || !jsonpCallback.MatchString(callback)) {
+		return errInvalid("invalid callback")
+	}
+
+	// JSONP callers can't see statuses, they get errors in the payload.
+	reply := func(status int, payload fiber.Map) error {
+		if callback != "" {
+			return c.JSONP(payload, callback)
+		}
+		return c.Status(status).JSON(payload)
+	}
+
+	body := new(request)
+	if c.Method() == fiber.MethodPost {
+		var in struct {
+			URL string `json:"url"`
+		}
+		if err := c.BodyParser(&in); err != nil {
+			return reply(fiber.StatusBadRequest, fiber.Map{"error": "Cannot parse JSON"})
+		}
+		body.URL = in.URL
+	} else {
+		body.URL = c.Query("url")
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return reply(fiber.StatusServiceUnavailable, fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	token, _ := c.Locals("apikey").(string)
+	if token == "" {
+		token = "owner:" + Owner(c)
+	}
+	cfg := extensionSettings()
+	result, err := ratelimit.Allow(rClient, links.ExtensionRateKey(token), cfg.limit, time.Minute)
+	if err == nil {
+		setRateLimitHeaders(c, result)
+	}
+	if err == nil && !result.Allowed {
+		c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(int64((result.Reset+time.Second-1)/time.Second), 10))
+		return reply(fiber.StatusTooManyRequests, fiber.Map{"error": "rate limit exceeded, retry later"})
+	}
+
+	resp, ferr := shorten(c, body)
+	if ferr != nil {
+		return reply(ferr.Code, fiber.Map{"error": ferr.Message})
+	}
+
+	return reply(fiber.StatusOK, fiber.Map{"short_url": resp.CustomShort})
+}
@@ -0,0 +1,212 @@
+package routes
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/suggest"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Fallback modes for shorts that don't exist.
+const (
+	FallbackJSON     = "json"
+	FallbackRedirect = "redirect"
+	FallbackProxy    = "proxy"
+	FallbackTemplate = "template"
+)
+
+// fallback is what a domain answers for unknown shorts. Target is the page
+// to redirect to, the legacy shortener to ask or the HTML template file,
+// depending on Mode. "{short}" in a redirect target is replaced by the short.
+type fallback struct {
+	Mode   string `json:"mode"`
+	Target string `json:"target"`
+
+	page *template.Template
+}
+
+type fallbackConfig struct {
+	byDomain map[string]*fallback
+	fallback *fallback
+}
+
+// fallbacks is read lazily so that the .env file is loaded first.
+var fallbacks = sync.OnceValue(loadFallbacks)
+
+var legacyClient = &http.Client{
+	Timeout: 3 * time.Second,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// loadFallbacks reads the default from FALLBACK_MODE and FALLBACK_TARGET
+// and per-domain overrides from the JSON object in FALLBACK_DOMAINS_FILE,
+// keyed by host name. Invalid entries are logged and answer JSON.
+func loadFallbacks() fallbackConfig {
+	cfg := fallbackConfig{
+		byDomain: map[string]*fallback{},
+		fallback: prepareFallback("default", &fallback{Mode: os.Getenv("FALLBACK_MODE"), Target: os.Getenv("FALLBACK_TARGET")}),
+	}
+
+	path := os.Getenv("FALLBACK_DOMAINS_FILE")
+	if path == "" {
+		return cfg
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("fallback: %v", err)
+		return cfg
+	}
+
+	var domains map[string]*fallback
+	if err := json.Unmarshal(data, &domains); err != nil {
+		log.Printf("fallback: failed to parse %s, err: %v", path, err)
+		return cfg
+	}
+	for domain, f := range domains {
+		cfg.byDomain[strings.ToLower(domain)] = prepareFallback(domain, f)
+	}
+
+	return cfg
+}
+
+func prepareFallback(name string, f *fallback) *fallback {
+	switch f.Mode {
+	case "", FallbackJSON:
+		return &fallback{Mode: FallbackJSON}
+	case FallbackRedirect, FallbackProxy:
+		if f.Target != "" {
+			return f
+		}
+	case FallbackTemplate:
+		page, err := template.ParseFiles(f.Target)
+		if err == nil {
+			f.page = page
+			return f
+		}
+		log.Printf("fallback %s: %v", name, err)
+	}
+
+	log.Printf("fallback %s: invalid mode %q or missing target", name, f.Mode)
+
+	return &fallback{Mode: FallbackJSON}
+}
+
+// shortNotFound answers a request for a short that doesn't exist with the
+// fallback configured for the requested domain. Browsers get the not found
+// page of the domain's owner. With SUGGESTIONS_ENABLED, the JSON and HTML
+// answers carry close existing shorts.
+func shortNotFound(c *fiber.Ctx, short string) error {
+	cfg := fallbacks()
+	f, ok := cfg.byDomain[strings.ToLower(c.Hostname())]
+	if !ok {
+		f = cfg.fallback
+	}
+
+	var suggestions []string
+	if f.Mode == FallbackJSON || f.Mode == FallbackTemplate {
+		suggestions = suggestionsFor(short)
+	}
+
+	switch f.Mode {
+	case FallbackRedirect:
+		return c.Redirect(strings.ReplaceAll(f.Target, "{short}", url.PathEscape(short)), fiber.StatusFound)
+	case FallbackProxy:
+		if location := askLegacy(f.Target, short); location != "" {
+			return c.Redirect(location, fiber.StatusFound)
+		}
+	case FallbackTemplate:
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		return f.page.Execute(c.Status(fiber.StatusNotFound), fiber.Map{"Short": short, "Host": c.Hostname(), "Suggestions": suggestions})
+	}
+
+	if wantsHTML(c) {
+		if rClient, err := database.Shared(); err == nil {
+			owner := domainOwner(rClient, c.Hostname())
+			if recentlyExpired(rClient, short) {
+				return renderPage(c, rClient, owner, PageExpired, fiber.StatusGone, fiber.Map{"Short": short})
+			}
+			return renderPage(c, rClient, owner, PageNotFound, fiber.StatusNotFound, fiber.Map{"Short": short, "Suggestions": suggestions})
+		}
+	}
+
+	if len(suggestions) == 0 {
+		return sendJSON(c, fiber.StatusNotFound, shortNotFoundBody)
+	}
+
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		"error":        "short not found in the database or cannot connect to DB",
+		"did_you_mean": suggestions,
+	})
+}
+
+// recentlyExpired reports whether short expired and the reminders job has
+// not yet dropped it from links.ExpiringKey.
+func recentlyExpired(rClient database.ClientInterface, short string) bool {
+	id, err := links.ParseShort(short)
+	if err != nil {
+		return false
+	}
+
+	var score radix.Maybe
+	var expiresAt int64
+	score.Rcv = &expiresAt
+	if err := rClient.Do(radix.Cmd(&score, "ZSCORE", links.ExpiringKey(), id)); err != nil || score.Null {
+		return false
+	}
+
+	return expiresAt <= clock.Now().Unix()
+}
+
+// suggestionsFor returns the shorts close to short as users type them, or
+// nil when suggestions are disabled or unavailable.
+func suggestionsFor(short string) []string {
+	if os.Getenv("SUGGESTIONS_ENABLED") != "true" {
+		return nil
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return nil
+	}
+
+	found, err := suggest.Lookup(rClient, short, 3)
+	if err != nil {
+		return nil
+	}
+	for i := range found {
+		found[i] = links.DisplayShort(found[i])
+	}
+
+	return found
+}
+
+// askLegacy returns where the legacy shortener at base redirects short to,
+// or "" when it doesn't know it either.
+func askLegacy(base, short string) string {
+	resp, err := legacyClient.Head(strings.TrimSuffix(base, "/") + "/" + url.PathEscape(short))
+	if err != nil {
+		return ""
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return ""
+	}
+
+	return resp.Header.Get("Location")
+}
@@ -0,0 +1,148 @@
+package routes
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// feedBlock is how long a feed waits for a creation before sending a
+// heartbeat.
+const feedBlock = 15 * time.Second
+
+type feedConfig struct {
+	enabled bool
+	maxLen  int
+}
+
+// creationFeed reads CREATION_FEED* lazily so that the .env file is loaded
+// first.
+var creationFeed = sync.OnceValue(func() feedConfig {
+	cfg := feedConfig{enabled: os.Getenv("CREATION_FEED") == "true", maxLen: 10000}
+	if v, err := strconv.Atoi(os.Getenv("CREATION_FEED_MAXLEN")); err == nil && v > 0 {
+		cfg.maxLen = v
+	}
+
+	return cfg
+})
+
+type creation struct {
+	Short     string `json:"short"`
+	URL       string `json:"url"`
+	Owner     string `json:"owner,omitempty"`
+	Campaign  string `json:"campaign,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// recordCreation adds a creation to the stream behind the admin feed,
+// keeping roughly the last CREATION_FEED_MAXLEN.
+func recordCreation(rClient database.ClientInterface, e creation) error {
+	cfg := creationFeed()
+	if !cfg.enabled {
+		return nil
+	}
+
+	return rClient.Do(radix.Cmd(nil, "XADD", links.CreationStreamKey(), "MAXLEN", "~", strconv.Itoa(cfg.maxLen), "*",
+		"short", e.Short,
+		"url", e.URL,
+		"owner", e.Owner,
+		"campaign", e.Campaign,
+		"ip", e.IP,
+		"created_at", strconv.FormatInt(e.CreatedAt, 10),
+	))
+}
+
+// CreationFeed streams link creations as server-sent events, the event id
+// being the stream id. Clients reconnecting with Last-Event-ID, or passing
+// ?since=<id>, resume after that creation; others start with new ones.
+func CreationFeed(c *fiber.Ctx) error {
+	if !creationFeed().enabled {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "creation feed is disabled"})
+	}
+
+	last := c.Get("Last-Event-ID")
+	if last == "" {
+		last = c.Query("since", "$")
+	}
+
+	// XREAD BLOCK holds its connection, so every feed gets its own.
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	// Pin "$" to the current end of the stream, a bare "$" in the loop
+	// would skip creations made between two reads.
+	if last == "$" {
+		var latest []radix.StreamEntry
+		if err := rClient.Do(radix.Cmd(&latest, "XREVRANGE", links.CreationStreamKey(), "+", "-", "COUNT", "1")); err != nil {
+			rClient.Close()
+			return dbError(err, "Unable to read feed")
+		}
+		last = "0-0"
+		if len(latest) > 0 {
+			last = latest[0].ID.String()
+		}
+	}
+
+	return streamEvents(c, func(w *eventWriter) error {
+		defer rClient.Close()
+
+		for database.Ctx.Err() == nil {
+			var streams []radix.StreamEntries
+			err := rClient.Do(radix.Cmd(&streams, "XREAD", "COUNT", "100",
+				"BLOCK", strconv.FormatInt(feedBlock.Milliseconds(), 10),
+				"STREAMS", links.CreationStreamKey(), last))
+			if err != nil {
+				return err
+			}
+
+			if len(streams) == 0 {
+				if err := w.Heartbeat(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			for _, entry := range streams[0].Entries {
+				last = entry.ID.String()
+				body, _ := json.Marshal(decodeCreation(entry))
+				if err := w.Event(last, "link.created", body); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func decodeCreation(entry radix.StreamEntry) creation {
+	var e creation
+	for _, f := range entry.Fields {
+		switch f[0] {
+		case "short":
+			e.Short = f[1]
+		case "url":
+			e.URL = f[1]
+		case "owner":
+			e.Owner = f[1]
+		case "campaign":
+			e.Campaign = f[1]
+		case "ip":
+			e.IP = f[1]
+		case "created_at":
+			e.CreatedAt, _ = strconv.ParseInt(f[1], 10, 64)
+		}
+	}
+
+	return e
+}
@@ -0,0 +1,306 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/graphql"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// maxGraphQLPage caps the links query.
+const maxGraphQLPage = 100
+
+var errAPIKeyRequired = errors.New("API key required")
+
+// GraphQL serves the GraphQL API:
+//
+//	query {
+//	  link(short: ID!): Link
+//	  links(first: Int = 20, after: ID): [Link!]!   # the caller's links
+//	  stats(short: ID!): Stats
+//	}
+//	mutation {
+//	  createLink(input: CreateLinkInput!): CreatedLink!
+//	  updateLink(short: ID!, input: UpdateLinkInput!): Link!
+//	  deleteLink(short: ID!): Boolean!
+//	}
+//
+// Mutations require an API key, acting on the caller's links only.
+// Fields mirror the REST API in camel case, Link.stats included. It runs
+// the same code as the REST handlers, so validation and errors match.
+func GraphQL(c *fiber.Ctx) error {
+	var req graphql.Request
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(graphql.Response{
+			Errors: []graphql.Error{{Message: "Cannot parse JSON"}},
+		})
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(graphql.Response{
+			Errors: []graphql.Error{{Message: "cannot connect to DB"}},
+		})
+	}
+	defer rClient.Close()
+
+	resp := graphqlSchema(c, rClient).Execute(req)
+	if resp.Data == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(resp)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(resp)
+}
+
+func graphqlSchema(c *fiber.Ctx, rClient database.ClientInterface) *graphql.Schema {
+	link := func(short string) (any, error) {
+		info, err := loadLinkInfo(rClient, short)
+		if err != nil {
+			return nil, errors.New("Unable to read link")
+		}
+		if info == nil {
+			return nil, nil
+		}
+		if info.Protected {
+			info.URL, info.OriginalURL = "", ""
+		}
+		return graphqlLink(rClient, info)
+	}
+
+	return &graphql.Schema{
+		Query: map[string]graphql.Resolver{
+			"link": func(args map[string]any) (any, error) {
+				short, err := graphqlShort(args)
+				if err != nil {
+					return nil, err
+				}
+				return link(short)
+			},
+			"links": func(args map[string]any) (any, error) {
+				owner := Owner(c)
+				if owner == "" {
+					return nil, errAPIKeyRequired
+				}
+				if err := checkScope(c, ScopeLinksRead); err != nil {
+					return nil, err
+				}
+
+				var shorts []string
+				if err := rClient.Do(radix.Cmd(&shorts, "SMEMBERS", links.UserLinksKey(owner))); err != nil {
+					return nil, errors.New("Unable to read links")
+				}
+				sort.Strings(shorts)
+
+				after, _ := args["after"].(string)
+				first := min(graphqlInt(args["first"], 20), maxGraphQLPage)
+				start := sort.SearchStrings(shorts, after)
+				if start < len(shorts) && shorts[start] == after {
+					start++
+				}
+
+				page := []any{}
+				for _, short := range shorts[start:] {
+					if len(page) == first {
+						break
+					}
+					l, err := link(short)
+					if err != nil {
+						return nil, err
+					}
+					if l != nil {
+						page = append(page, l)
+					}
+				}
+				return page, nil
+			},
+			"stats": func(args map[string]any) (any, error) {
+				if Owner(c) == "" {
+					return nil, errAPIKeyRequired
+				}
+				if err := checkScope(c, ScopeStatsRead); err != nil {
+					return nil, err
+				}
+				short, err := graphqlShort(args)
+				if err != nil {
+					return nil, err
+				}
+				meta, err := links.Load(rClient, short)
+				if err != nil || meta == nil || meta["owner"] != Owner(c) {
+					return nil, err
+				}
+				return linkStats(rClient, short)
+			},
+		},
+		Mutation: map[string]graphql.Resolver{
+			"createLink": func(args map[string]any) (any, error) {
+				if Owner(c) == "" {
+					return nil, errAPIKeyRequired
+				}
+				if err := checkScope(c, ScopeLinksWrite); err != nil {
+					return nil, err
+				}
+				if err := writable(c); err != nil {
+					return nil, err
+				}
+				if err := creationPaused(c); err != nil {
+					return nil, err
+				}
+				body := new(request)
+				if err := graphqlInput(args, body); err != nil {
+					return nil, err
+				}
+				resp, ferr := shorten(c, body)
+				if ferr != nil {
+					return nil, ferr
+				}
+				return resp, nil
+			},
+			"updateLink": func(args map[string]any) (any, error) {
+				if Owner(c) == "" {
+					return nil, errAPIKeyRequired
+				}
+				if err := checkScope(c, ScopeLinksWrite); err != nil {
+					return nil, err
+				}
+				if err := writable(c); err != nil {
+					return nil, err
+				}
+				short, err := graphqlShort(args)
+				if err != nil {
+					return nil, err
+				}
+				body := new(updateLinkRequest)
+				if err := graphqlInput(args, body); err != nil {
+					return nil, err
+				}
+				info, ferr := updateLink(rClient, Owner(c), short, body)
+				if ferr != nil {
+					return nil, ferr
+				}
+				return graphqlLink(rClient, info)
+			},
+			"deleteLink": func(args map[string]any) (any, error) {
+				if Owner(c) == "" {
+					return nil, errAPIKeyRequired
+				}
+				if err := checkScope(c, ScopeLinksWrite); err != nil {
+					return nil, err
+				}
+				if err := writable(c); err != nil {
+					return nil, err
+				}
+				short, err := graphqlShort(args)
+				if err != nil {
+					return nil, err
+				}
+				if ferr := deleteLink(rClient, Owner(c), short); ferr != nil {
+					return nil, ferr
+				}
+				return true, nil
+			},
+		},
+	}
+}
+
+// graphqlLink adds the stats field, only loaded when selected.
+func graphqlLink(rClient database.ClientInterface, info *linkInfo) (map[string]any, error) {
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+
+	fields["stats"] = graphql.Resolver(func(map[string]any) (any, error) {
+		return linkStats(rClient, info.Short)
+	})
+
+	return fields, nil
+}
+
+type countryClicks struct {
+	Country string `json:"country"`
+	Clicks  int64  `json:"clicks"`
+}
+
+type stats struct {
+	Clicks    int64           `json:"clicks"`
+	HeadHits  int64           `json:"head_requests"`
+	Countries []countryClicks `json:"countries"`
+}
+
+// linkStats returns the click counters of short, countries by clicks.
+func linkStats(rClient database.ClientInterface, short string) (*stats, error) {
+	var s stats
+	var countries map[string]string
+	p := radix.NewPipeline()
+	p.Append(links.ClicksCmd(&s.Clicks, short))
+	p.Append(radix.Cmd(&s.HeadHits, "GET", links.HeadRequestsKey(short)))
+	p.Append(radix.Cmd(&countries, "HGETALL", links.CountriesKey(short)))
+	if err := rClient.Do(p); err != nil {
+		return nil, errors.New("Unable to read stats")
+	}
+
+	s.Countries = make([]countryClicks, 0, len(countries))
+	for country, clicks := range countries {
+		n, _ := strconv.ParseInt(clicks, 10, 64)
+		s.Countries = append(s.Countries, countryClicks{Country: country, Clicks: n})
+	}
+	sort.Slice(s.Countries, func(i, j int) bool {
+		if s.Countries[i].Clicks != s.Countries[j].Clicks {
+			return s.Countries[i].Clicks > s.Countries[j].Clicks
+		}
+		return s.Countries[i].Country < s.Countries[j].Country
+	})
+
+	return &s, nil
+}
+
+func graphqlShort(args map[string]any) (string, error) {
+	raw, _ := args["short"].(string)
+	short, err := links.ParseShort(raw)
+	if err != nil {
+		return "", err
+	}
+
+	return short, nil
+}
+
+// graphqlInput decodes the input argument into the REST request body it
+// mirrors.
+func graphqlInput(args map[string]any, body any) error {
+	input, ok := args["input"].(map[string]any)
+	if !ok {
+		return errors.New("input is required")
+	}
+
+	raw, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, body); err != nil {
+		return errors.New("invalid input")
+	}
+
+	return nil
+}
+
+func graphqlInt(v any, fallback int) int {
+	switch v := v.(type) {
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+
+	return fallback
+}
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/health"
+)
+
+// Health reports the latest outcome of every background health check. The
+// status is 503 only when a check is critical, warnings keep it at 200.
+func Health(c *fiber.Ctx) error {
+	checks, status := health.Snapshot()
+
+	code := fiber.StatusOK
+	if status == health.StatusCritical {
+		code = fiber.StatusServiceUnavailable
+	}
+
+	return c.Status(code).JSON(fiber.Map{"status": status, "checks": checks})
+}
@@ -0,0 +1,311 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/bloom"
+	"github.com/ksarpe/redis-golang/bootstrap"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/destination"
+	"github.com/ksarpe/redis-golang/events"
+	"github.com/ksarpe/redis-golang/helpers"
+	"github.com/ksarpe/redis-golang/journal"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/outbox"
+	"github.com/ksarpe/redis-golang/privacy"
+	"github.com/ksarpe/redis-golang/suggest"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// linkCreated is the type of the journaled link creations.
+const linkCreated = "link.created"
+
+var errShortTaken = errors.New("short taken by another link")
+
+var errShortReserved = errors.New("short reserved")
+
+// pendingLink is a link to write, journaled when redis is unavailable to
+// be written once it is back.
+type pendingLink struct {
+	Short   string    `json:"short"`
+	Meta    []string  `json:"meta"`
+	Expires time.Time `json:"expires"`
+	Org     string    `json:"org,omitempty"`
+	Suggest bool      `json:"suggest,omitempty"`
+	// Checked is false for links journaled before the short could be
+	// checked, whose owner policy and organization are only read on
+	// replay.
+	Checked  bool        `json:"checked"`
+	Event    events.Link `json:"event"`
+	Creation creation    `json:"creation"`
+}
+
+// field returns the value of name in the record of l.
+func (l pendingLink) field(name string) string {
+	for i := 0; i+1 < len(l.Meta); i += 2 {
+		if l.Meta[i] == name {
+			return l.Meta[i+1]
+		}
+	}
+
+	return ""
+}
+
+type waitConfig struct {
+	replicas int
+	timeout  time.Duration
+}
+
+// replicaWait reads LINK_WAIT_REPLICAS and LINK_WAIT_TIMEOUT, 100ms by
+// default, lazily so that the .env file is loaded first.
+//
+// The service never reads from replicas, every read goes to the primary,
+// so links resolve as soon as created whatever the setting. The wait is
+// only about durability across a failover: promoting a replica that lags
+// behind would lose the links it misses, answering 404 for a short just
+// handed out. With LINK_WAIT_REPLICAS set, links are only answered once
+// that many replicas have them, or the timeout passed. Links are still
+// created when replicas lag.
+var replicaWait = sync.OnceValue(func() waitConfig {
+	cfg := waitConfig{timeout: 100 * time.Millisecond}
+	cfg.replicas, _ = strconv.Atoi(os.Getenv("LINK_WAIT_REPLICAS"))
+	if v, err := time.ParseDuration(os.Getenv("LINK_WAIT_TIMEOUT")); err == nil && v > 0 {
+		cfg.timeout = v
+	}
+
+	return cfg
+})
+
+// writeLink writes the record of l and adds it to the sets and feeds
+// listing it. Every step can be written again, so a link failing half way
+// is journaled whole.
+func writeLink(rClient database.ClientInterface, l pendingLink) error {
+	// A link replayed past its expiry is gone already.
+	ttl := l.Expires.Sub(clock.Now())
+	if ttl < time.Second {
+		return nil
+	}
+
+	if err := outbox.Write(rClient, links.MetaKey(l.Short), l.Meta, nil, "link.created", l.Event); err != nil {
+		return err
+	}
+
+	p := radix.NewPipeline()
+	links.AppendExpire(p, l.Short, ttl)
+	if err := rClient.Do(p); err != nil {
+		return err
+	}
+
+	// Visitors who tried the short before it existed must find it now.
+	if err := links.Forget(rClient, l.Short); err != nil {
+		return err
+	}
+
+	// Totals are informational, like the feed below. WAIT follows the last
+	// write on the same connection, covering the record written before.
+	p = radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "HINCRBY", links.CountersKey(), "created", "1"))
+	var acked int
+	cfg := replicaWait()
+	if cfg.replicas > 0 {
+		p.Append(radix.Cmd(&acked, "WAIT", strconv.Itoa(cfg.replicas), strconv.FormatInt(cfg.timeout.Milliseconds(), 10)))
+	}
+	if err := rClient.Do(p); err == nil && acked < cfg.replicas {
+		log.Printf("links: %s reached %d of %d replicas within %v", l.Short, acked, cfg.replicas, cfg.timeout)
+	}
+
+	// The short exists either way, a missed feed entry only affects
+	// moderation tooling.
+	_ = recordCreation(rClient, l.Creation)
+
+	if owner := l.Event.Owner; owner != "" {
+		p := radix.NewPipeline()
+		p.Append(radix.Cmd(nil, "SADD", links.UserLinksKey(owner), l.Short))
+		if l.Org != "" {
+			p.Append(radix.Cmd(nil, "SADD", links.OrgLinksKey(l.Org), l.Short))
+		}
+		if err := rClient.Do(p); err != nil {
+			return err
+		}
+	}
+
+	if l.Suggest {
+		p := radix.NewPipeline()
+		suggest.AppendIndex(p, l.Short)
+		if err := rClient.Do(p); err != nil {
+			return err
+		}
+	}
+
+	if campaign := l.Event.Campaign; campaign != "" {
+		if err := rClient.Do(radix.Cmd(nil, "SADD", links.CampaignLinksKey(campaign), l.Short)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// journalLink journals l when writing it failed with err because redis
+// was unavailable, reporting whether it did.
+func journalLink(err error, l pendingLink) bool {
+	if !journal.Enabled() || !database.Unavailable(err) {
+		return false
+	}
+	if err := journal.Append(linkCreated, l); err != nil {
+		log.Printf("journal: %v", err)
+		return false
+	}
+
+	return true
+}
+
+// deferShorten journals the link asked for in body when redis cannot be
+// reached at all, answering it as pending. Only links of known owners
+// with a generated short and no campaign can be created without reading
+// redis, with the default scheme policy: their destinations are neither
+// upgraded nor flattened, and their owner policy and organization are
+// checked on replay. Other requests fail as unavailable.
+func deferShorten(c *fiber.Ctx, body *request) (*response, *fiber.Error) {
+	owner := Owner(c)
+	if !journal.Enabled() || owner == "" || body.CustomShort != "" || body.Campaign != "" {
+		return nil, errUnavailable()
+	}
+
+	if err := links.ValidateNotes(body.Title, body.Description); err != nil {
+		return nil, errInvalid(err.Error())
+	}
+	policy := destination.DefaultPolicy()
+	body.URL = policy.WithScheme(body.URL)
+	scheme, err := policy.Check(body.URL)
+	if err != nil {
+		return nil, errInvalid(err.Error())
+	}
+	if scheme != "http" && scheme != "https" {
+		return nil, errUnavailable()
+	}
+	if !govalidator.IsURL(body.URL) {
+		return nil, errInvalid("Invalid URL")
+	}
+	if !helpers.RemoveDomainError(body.URL) {
+		return nil, errInvalid("Domain error")
+	}
+	if err := destination.CheckPublic(c.Context(), body.URL); err != nil {
+		return nil, errInvalid(err.Error())
+	}
+
+	ttl, ferr := linkTTL(c, body)
+	if ferr != nil {
+		return nil, ferr
+	}
+	if ttl == 0 {
+		ttl = 24 * time.Hour
+	}
+	meta, ferr := linkMeta(body, owner, body.URL, 0, "")
+	if ferr != nil {
+		return nil, ferr
+	}
+
+	// The privacy policy of owner cannot be read, only a cached one
+	// applies.
+	ip := privacy.Policy{Private: true, HonorDNT: true}.IP(c.IP())
+	if cached, ok := policies.Load(owner); ok {
+		ip = cached.(cachedPolicy).policy.IP(c.IP())
+	}
+
+	// Words reserved by admins are checked again on replay.
+	id, err := links.NewShort(func(short string) (bool, error) {
+		return slices.Contains(bootstrap.Reserved, short), nil
+	})
+	if err != nil {
+		return nil, errUnavailable()
+	}
+	l := pendingLink{
+		Short:   id,
+		Meta:    meta,
+		Expires: clock.Now().Add(ttl),
+		Event:   events.Link{Short: id, URL: body.URL, Owner: owner},
+		Creation: creation{
+			Short:     id,
+			URL:       body.URL,
+			Owner:     owner,
+			IP:        ip,
+			CreatedAt: clock.Now().Unix(),
+		},
+	}
+	if err := journal.Append(linkCreated, l); err != nil {
+		log.Printf("journal: %v", err)
+		return nil, errUnavailable()
+	}
+
+	return newResponse(c, body, body.URL, id, ttl, l.Expires, true), nil
+}
+
+// replayLink writes a journaled link. Links journaled before their short
+// was checked are dropped when another link took it or their owner may
+// no longer create them.
+func replayLink(ctx context.Context, rClient database.ClientInterface, data json.RawMessage) error {
+	var l pendingLink
+	if err := json.Unmarshal(data, &l); err != nil {
+		return err
+	}
+
+	if !l.Checked {
+		taken, err := links.Exists(rClient, l.Short)
+		if err != nil {
+			return err
+		}
+		if taken {
+			// Unless written by an earlier replay of l.
+			var fields []string
+			if err := rClient.Do(links.FieldsCmd(&fields, l.Short, "url", "created_at")); err != nil {
+				return err
+			}
+			if fields[0] != l.Event.URL || fields[1] != l.field("created_at") {
+				return fmt.Errorf("%w: %s", errShortTaken, l.Short)
+			}
+		}
+
+		reserved, err := reservedIn(rClient)(l.Short)
+		if err != nil {
+			return err
+		}
+		if reserved {
+			return fmt.Errorf("%w: %s", errShortReserved, l.Short)
+		}
+
+		policy, err := schemePolicy(rClient, l.Event.Owner)
+		if err != nil {
+			return err
+		}
+		if _, err := policy.Check(l.Event.URL); err != nil {
+			return err
+		}
+		if l.Org, err = links.OrgOf(rClient, l.Event.Owner); err != nil {
+			return err
+		}
+		if err := bloom.Default.Add(rClient, l.Short); err != nil {
+			return err
+		}
+	}
+
+	return writeLink(rClient, l)
+}
+
+// JournalHandlers returns the handlers replaying the writes journaled by
+// the routes.
+func JournalHandlers() map[string]journal.Handler {
+	return map[string]journal.Handler{linkCreated: replayLink}
+}
@@ -0,0 +1,37 @@
+package routes
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/budget"
+)
+
+// Latency records how long every request took against its route, for the
+// rolling p99 of budget.Default.
+func Latency() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+		budget.Default.ObserveRoute(c.Method()+" "+c.Route().Path, time.Since(start))
+
+		return err
+	}
+}
+
+// Shed turns away non-critical routes while redis is over its latency
+// budget, leaving its capacity to redirects.
+func Shed(c *fiber.Ctx) error {
+	if budget.Default.Shedding() {
+		c.Set(fiber.HeaderRetryAfter, "30")
+		return fiber.NewError(fiber.StatusServiceUnavailable, "temporarily disabled under load")
+	}
+
+	return c.Next()
+}
+
+// LatencyReport returns the rolling p99 of redis and of every route, and
+// whether non-critical work is being shed.
+func LatencyReport(c *fiber.Ctx) error {
+	return c.JSON(budget.Default.Snapshot())
+}
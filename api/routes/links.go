@@ -0,0 +1,307 @@
+package routes
+
+import (
+	"net/url"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/events"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/outbox"
+	"github.com/ksarpe/redis-golang/retention"
+	"github.com/ksarpe/redis-golang/top"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+type linkInfo struct {
+	Short       string `json:"short"`
+	URL         string `json:"url,omitempty"`
+	OriginalURL string `json:"original_url,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Campaign    string `json:"campaign,omitempty"`
+	Clicks      int64  `json:"clicks"`
+	HeadHits    int64  `json:"head_requests"`
+	CreatedAt   int64  `json:"created_at,omitempty"`
+	Disabled    bool   `json:"disabled"`
+	Protected   bool   `json:"protected"`
+	Indexable   bool   `json:"indexable"`
+	Passthrough bool   `json:"passthrough"`
+	MaxRPM      int64  `json:"max_clicks_per_minute,omitempty"`
+	// ExpiresAt and ExpiresIn, in seconds, are unset for shorts that
+	// never expire.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+
+	DestinationStatus    string `json:"destination_status,omitempty"`
+	DestinationCheckedAt int64  `json:"destination_checked_at,omitempty"`
+	HTTPSUpgrade         string `json:"https_upgrade,omitempty"`
+}
+
+type updateLinkRequest struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Indexable   *bool   `json:"indexable"`
+	// MaxRPM of 0 lifts the limit.
+	MaxRPM *int64 `json:"max_clicks_per_minute"`
+}
+
+// GetLink returns the destination, notes and click count of a short, or
+// just the destination for Accept: text/plain clients.
+func GetLink(c *fiber.Ctx) error {
+	short := shortParam(c)
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	info, err := loadLinkInfo(rClient, short)
+	if err != nil {
+		return dbError(err, "Unable to read link")
+	}
+	if info == nil {
+		return negotiateError(c, fiber.StatusNotFound, "short not found")
+	}
+
+	// The destination of a protected short is only revealed by resolving it.
+	if info.Protected {
+		info.URL, info.OriginalURL = "", ""
+		if wantsText(c) {
+			return negotiateError(c, fiber.StatusForbidden, "short is password protected")
+		}
+	}
+
+	return negotiate(c, fiber.StatusOK, info.URL, info)
+}
+
+// UpdateLink changes the title, description, indexing opt-in or click
+// limit of one of the caller's shorts.
+func UpdateLink(c *fiber.Ctx) error {
+	short := shortParam(c)
+	body := new(updateLinkRequest)
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	info, ferr := updateLink(rClient, Owner(c), short, body)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(info)
+}
+
+// updateLink applies body to short if it belongs to owner, returning the
+// updated link or the error to report to the client. Shorts created
+// without an API key have no owner and can't be updated.
+func updateLink(rClient database.ClientInterface, owner, short string, body *updateLinkRequest) (*linkInfo, *fiber.Error) {
+	link, err := links.LoadLink(rClient, short)
+	if err != nil {
+		return nil, dbError(err, "Unable to update link")
+	}
+	if link == nil || owner == "" || link.Owner != owner {
+		return nil, fiber.NewError(fiber.StatusNotFound, "short not found")
+	}
+
+	var update links.Link
+	var changed []string
+	if body.Title != nil {
+		update.Title = *body.Title
+		changed = append(changed, "title")
+	}
+	if body.Description != nil {
+		update.Description = *body.Description
+		changed = append(changed, "description")
+	}
+	if err := links.ValidateNotes(update.Title, update.Description); err != nil {
+		return nil, errInvalid(err.Error())
+	}
+	if body.Indexable != nil {
+		update.Indexable = *body.Indexable
+		changed = append(changed, "indexable")
+	}
+
+	if body.MaxRPM != nil {
+		if *body.MaxRPM < 0 {
+			return nil, errInvalid("max_clicks_per_minute can't be negative")
+		}
+		update.MaxRPM = *body.MaxRPM
+		changed = append(changed, "max_rpm")
+	}
+
+	// Without names MarshalHash would return every field.
+	var set, del []string
+	if len(changed) > 0 {
+		if set, del, err = database.MarshalHash(&update, changed...); err != nil {
+			return nil, fiber.NewError(fiber.StatusInternalServerError, "Unable to update link")
+		}
+	}
+
+	event := events.Link{Short: short, URL: link.URL, Campaign: link.Campaign}
+	if link.PasswordHash != "" {
+		event.URL = ""
+	}
+	if err := outbox.Write(rClient, links.MetaKey(short), set, del, "link.updated", event); err != nil {
+		return nil, dbError(err, "Unable to update link")
+	}
+
+	info, err := loadLinkInfo(rClient, short)
+	if err != nil || info == nil {
+		return nil, dbError(err, "Unable to read link")
+	}
+	if info.Protected {
+		info.URL, info.OriginalURL = "", ""
+	}
+
+	return info, nil
+}
+
+// DeleteLink removes one of the caller's shorts with its analytics.
+func DeleteLink(c *fiber.Ctx) error {
+	short := shortParam(c)
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	if ferr := deleteLink(rClient, Owner(c), short); ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// retentionConfig is read lazily so that the .env file is loaded first.
+var retentionConfig = sync.OnceValue(retention.ConfigFromEnv)
+
+// deleteLink removes short if it belongs to owner, keeping its record in
+// the trash for TRASH_RETENTION. Shorts created without an API key have no
+// owner and can't be deleted.
+func deleteLink(rClient database.ClientInterface, owner, short string) *fiber.Error {
+	meta, err := links.Load(rClient, short)
+	if err != nil {
+		return dbError(err, "Unable to delete link")
+	}
+	if meta == nil || owner == "" || meta["owner"] != owner {
+		return fiber.NewError(fiber.StatusNotFound, "short not found")
+	}
+
+	org, err := links.OrgOf(rClient, owner)
+	if err != nil {
+		return dbError(err, "Unable to delete link")
+	}
+
+	deleted, err := outbox.Cmd("link.deleted", events.Link{Short: short, Owner: owner, Campaign: meta["campaign"]})
+	if err != nil {
+		return dbError(err, "Unable to delete link")
+	}
+
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "MULTI"))
+	if retentionConfig().Trash > 0 {
+		retention.AppendTrash(p, short)
+	} else {
+		p.Append(radix.Cmd(nil, "DEL", links.MetaKey(short)))
+	}
+	p.Append(radix.Cmd(nil, "DEL", append(links.ClickKeys(short),
+		links.HeadRequestsKey(short), links.CountriesKey(short), links.ReferrersKey(short))...))
+	p.Append(radix.Cmd(nil, "SREM", links.UserLinksKey(owner), short))
+	if org != "" {
+		p.Append(radix.Cmd(nil, "SREM", links.OrgLinksKey(org), short))
+	}
+	if meta["campaign"] != "" {
+		p.Append(radix.Cmd(nil, "SREM", links.CampaignLinksKey(meta["campaign"]), short))
+	}
+	p.Append(radix.Cmd(nil, "ZREM", links.ExpiringKey(), short))
+	top.AppendForget(p, short)
+	p.Append(radix.Cmd(nil, "HINCRBY", links.CountersKey(), "deleted", "1"))
+	p.Append(deleted)
+	p.Append(radix.Cmd(nil, "EXEC"))
+	if err := rClient.Do(p); err != nil {
+		return dbError(err, "Unable to delete link")
+	}
+
+	return nil
+}
+
+// loadLinkInfo returns nil when the short does not exist.
+func loadLinkInfo(rClient database.ClientInterface, short string) (*linkInfo, error) {
+	link, err := links.LoadLink(rClient, short)
+	if err != nil || link == nil {
+		return nil, err
+	}
+
+	var clicks, heads, ttl int64
+	p := radix.NewPipeline()
+	p.Append(links.ClicksCmd(&clicks, short))
+	p.Append(radix.Cmd(&heads, "GET", links.HeadRequestsKey(short)))
+	p.Append(radix.Cmd(&ttl, "TTL", links.MetaKey(short)))
+	if err := rClient.Do(p); err != nil {
+		return nil, err
+	}
+
+	// The TTL is authoritative, shorts given one before expires_at was
+	// recorded have none.
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = link.ExpiresAt
+		if expiresAt == 0 {
+			expiresAt = clock.Now().Unix() + ttl
+		}
+	} else {
+		ttl = 0
+	}
+
+	return &linkInfo{
+		Short:       short,
+		URL:         link.URL,
+		OriginalURL: link.OriginalURL,
+		Title:       link.Title,
+		Description: link.Description,
+		Campaign:    link.Campaign,
+		Clicks:      clicks,
+		HeadHits:    heads,
+		CreatedAt:   link.CreatedAt,
+		Disabled:    link.Disabled,
+		Protected:   link.PasswordHash != "",
+		Indexable:   link.Indexable,
+		Passthrough: link.Passthrough,
+		MaxRPM:      link.MaxRPM,
+		ExpiresAt:   expiresAt,
+		ExpiresIn:   ttl,
+
+		DestinationStatus:    link.DestinationStatus,
+		DestinationCheckedAt: link.DestinationCheckedAt,
+		HTTPSUpgrade:         link.HTTPSUpgrade,
+	}, nil
+}
+
+// shortParam returns the :short route parameter in canonical form. Shorts
+// with several segments are addressed with their separators escaped, as in
+// /api/v1/links/team%2Fdocs.
+func shortParam(c *fiber.Ctx) string {
+	raw, err := url.PathUnescape(c.Params("short"))
+	if err != nil {
+		return c.Params("short")
+	}
+
+	short, err := links.ParseShort(raw)
+	if err != nil {
+		return raw
+	}
+
+	return short
+}
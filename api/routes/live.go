@@ -0,0 +1,145 @@
+package routes
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/live"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+type liveConfig struct {
+	enabled  bool
+	interval time.Duration
+}
+
+// liveStats reads LIVE_STATS* lazily so that the .env file is loaded first.
+var liveStats = sync.OnceValue(func() liveConfig {
+	cfg := liveConfig{enabled: os.Getenv("LIVE_STATS") == "true", interval: 5 * time.Second}
+	if v, err := time.ParseDuration(os.Getenv("LIVE_STATS_INTERVAL")); err == nil && v > 0 {
+		cfg.interval = v
+	}
+
+	return cfg
+})
+
+type liveCounters struct {
+	Clicks int64 `json:"clicks"`
+}
+
+// LiveLink streams the clicks of a short as server-sent events: a "stats"
+// event with the click counter on connect and every LIVE_STATS_INTERVAL,
+// and a "click" event per click in between. Only the owner of the short
+// may stream it.
+func LiveLink(c *fiber.Ctx) error {
+	if !liveStats().enabled {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "live stats are disabled"})
+	}
+
+	short := shortParam(c)
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	meta, err := links.Load(rClient, short)
+	if err != nil {
+		return dbError(err, "Unable to stream stats")
+	}
+	if meta == nil || Owner(c) == "" || meta["owner"] != Owner(c) {
+		return fiber.NewError(fiber.StatusNotFound, "short not found")
+	}
+
+	return streamLive(c, live.LinkChannel(short), func() (int64, error) {
+		var clicks int64
+		err := rClient.Do(links.ClicksCmd(&clicks, short))
+		return clicks, err
+	})
+}
+
+// LiveCampaign streams the clicks of every short of a campaign, like
+// LiveLink with the campaign total in the "stats" events.
+func LiveCampaign(c *fiber.Ctx) error {
+	if !liveStats().enabled {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "live stats are disabled"})
+	}
+
+	name := c.Params("name")
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	owner := Owner(c)
+	if _, err := campaignMembers(rClient, owner, name); err != nil {
+		return campaignError(c, err)
+	}
+
+	return streamLive(c, live.CampaignChannel(name), func() (int64, error) {
+		shorts, err := campaignMembers(rClient, owner, name)
+		if err != nil {
+			return 0, err
+		}
+
+		clicks := make([]int64, len(shorts))
+		p := radix.NewPipeline()
+		for i, short := range shorts {
+			p.Append(links.ClicksCmd(&clicks[i], short))
+		}
+		if err := rClient.Do(p); err != nil {
+			return 0, err
+		}
+
+		var total int64
+		for _, n := range clicks {
+			total += n
+		}
+		return total, nil
+	})
+}
+
+func streamLive(c *fiber.Ctx, channel string, counter func() (int64, error)) error {
+	interval := liveStats().interval
+	messages, stop := live.Default.Listen(channel)
+
+	return streamEvents(c, func(w *eventWriter) error {
+		defer stop()
+
+		sendStats := func() error {
+			clicks, err := counter()
+			if err != nil {
+				// Skip this update, the next tick tries again.
+				return w.Heartbeat()
+			}
+			body, _ := json.Marshal(liveCounters{Clicks: clicks})
+			return w.Event("", "stats", body)
+		}
+
+		if err := sendStats(); err != nil {
+			return err
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-database.Ctx.Done():
+				return nil
+			case message := <-messages:
+				if err := w.Event("", "click", message); err != nil {
+					return err
+				}
+			case <-ticker.C:
+				if err := sendStats(); err != nil {
+					return err
+				}
+			}
+		}
+	})
+}
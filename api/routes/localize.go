@@ -0,0 +1,76 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/i18n"
+)
+
+const langLocal = "lang"
+
+// Localize returns a middleware picking the language of the request from
+// Accept-Language and translating the error of failed responses, the
+// "error" field of JSON bodies or a whole plain text body. Messages without
+// a translation are left in English.
+func Localize() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lang := i18n.Match(c.Get(fiber.HeaderAcceptLanguage))
+		c.Locals(langLocal, lang)
+
+		err := c.Next()
+		if lang == i18n.Default {
+			return err
+		}
+
+		var fe *fiber.Error
+		if errors.As(err, &fe) && i18n.Translated(lang, fe.Message) {
+			c.Set(fiber.HeaderContentLanguage, lang)
+			return fiber.NewError(fe.Code, i18n.T(lang, fe.Message))
+		}
+
+		resp := c.Response()
+		if err != nil || resp.StatusCode() < fiber.StatusBadRequest || resp.IsBodyStream() {
+			return err
+		}
+
+		mediaType, _, _ := strings.Cut(string(resp.Header.ContentType()), ";")
+		switch strings.TrimSpace(mediaType) {
+		case fiber.MIMEApplicationJSON:
+			var body map[string]json.RawMessage
+			var msg string
+			if json.Unmarshal(resp.Body(), &body) != nil || json.Unmarshal(body["error"], &msg) != nil || !i18n.Translated(lang, msg) {
+				return nil
+			}
+			body["error"], _ = json.Marshal(i18n.T(lang, msg))
+			translated, err := json.Marshal(body)
+			if err != nil {
+				return nil
+			}
+			resp.SetBodyRaw(translated)
+		case fiber.MIMETextPlain:
+			msg := string(resp.Body())
+			if !i18n.Translated(lang, msg) {
+				return nil
+			}
+			resp.SetBodyString(i18n.T(lang, msg))
+		default:
+			return nil
+		}
+
+		c.Set(fiber.HeaderContentLanguage, lang)
+
+		return nil
+	}
+}
+
+// langOf returns the language Localize picked for the request.
+func langOf(c *fiber.Ctx) string {
+	if lang, ok := c.Locals(langLocal).(string); ok {
+		return lang
+	}
+
+	return i18n.Match(c.Get(fiber.HeaderAcceptLanguage))
+}
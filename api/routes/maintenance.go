@@ -0,0 +1,209 @@
+package routes
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/helpers"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// maintenanceRefresh is how long an instance trusts its copy of the
+// schedule.
+const maintenanceRefresh = 10 * time.Second
+
+// MaintenanceHeader carries the message of the window in progress on every
+// API response, for clients to show as a banner.
+const MaintenanceHeader = "X-Maintenance"
+
+type maintenanceWindow struct {
+	ID       string `json:"id"`
+	StartsAt int64  `json:"starts_at"`
+	EndsAt   int64  `json:"ends_at"`
+	Message  string `json:"message,omitempty"`
+}
+
+type cachedWindows struct {
+	windows []maintenanceWindow
+	expires time.Time
+}
+
+var maintenanceCache atomic.Pointer[cachedWindows]
+
+// loadWindows returns the windows not over yet, soonest first, dropping
+// the others from redis.
+func loadWindows(rClient database.ClientInterface) ([]maintenanceWindow, error) {
+	var fields map[string]string
+	if err := rClient.Do(radix.Cmd(&fields, "HGETALL", links.MaintenanceKey())); err != nil {
+		return nil, err
+	}
+
+	now := clock.Now().Unix()
+	windows := []maintenanceWindow{}
+	var over []string
+	for id, data := range fields {
+		var w maintenanceWindow
+		if json.Unmarshal([]byte(data), &w) != nil || w.EndsAt <= now {
+			over = append(over, id)
+			continue
+		}
+		windows = append(windows, w)
+	}
+	if len(over) > 0 {
+		_ = rClient.Do(radix.Cmd(nil, "HDEL", append([]string{links.MaintenanceKey()}, over...)...))
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].StartsAt < windows[j].StartsAt })
+
+	return windows, nil
+}
+
+// activeWindow returns the maintenance window in progress, if any. The
+// schedule is cached for maintenanceRefresh and kept when redis fails.
+func activeWindow() (maintenanceWindow, bool) {
+	cached := maintenanceCache.Load()
+	if cached == nil || clock.Now().After(cached.expires) {
+		var windows []maintenanceWindow
+		rClient, err := database.Shared()
+		if err == nil {
+			windows, err = loadWindows(rClient)
+		}
+		if err != nil && cached != nil {
+			windows = cached.windows
+		}
+		cached = &cachedWindows{windows: windows, expires: clock.Now().Add(maintenanceRefresh)}
+		maintenanceCache.Store(cached)
+	}
+
+	now := clock.Now().Unix()
+	for _, w := range cached.windows {
+		if w.StartsAt <= now && now < w.EndsAt {
+			return w, true
+		}
+	}
+
+	return maintenanceWindow{}, false
+}
+
+// creates reports whether a request creates shorts.
+func creates(c *fiber.Ctx) bool {
+	rest, ok := apiPath(c.Path())
+
+	return ok && ((c.Method() == fiber.MethodPost && (rest == "" || rest == "/ext/shorten")) ||
+		(c.Method() == fiber.MethodGet && (rest == "/shorten" || rest == "/ext/shorten")))
+}
+
+// errMaintenance is returned by creations refused during maintenance.
+func errMaintenance() *fiber.Error {
+	return fiber.NewError(fiber.StatusServiceUnavailable, "link creation is paused for maintenance")
+}
+
+// creationPaused returns errMaintenance during a maintenance window, for
+// handlers serving other operations too. Retry-After is the window's end.
+func creationPaused(c *fiber.Ctx) error {
+	w, ok := activeWindow()
+	if !ok {
+		return nil
+	}
+	c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(max(w.EndsAt-clock.Now().Unix(), 1), 10))
+
+	return errMaintenance()
+}
+
+// Maintenance returns a middleware announcing the maintenance window in
+// progress on API responses and refusing to create shorts during it.
+func Maintenance() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !strings.HasPrefix(c.Path(), "/api/") {
+			return c.Next()
+		}
+
+		w, ok := activeWindow()
+		if !ok {
+			return c.Next()
+		}
+		c.Set(MaintenanceHeader, w.Message)
+
+		if creates(c) && creationPaused(c) != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error":       errMaintenance().Message,
+				"maintenance": w,
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// ListMaintenance returns the scheduled maintenance windows, for clients
+// to announce them ahead of time.
+func ListMaintenance(c *fiber.Ctx) error {
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	windows, err := loadWindows(rClient)
+	if err != nil {
+		return dbError(err, "Unable to read maintenance windows")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"windows": windows})
+}
+
+// ScheduleMaintenance adds a maintenance window, in unix seconds.
+func ScheduleMaintenance(c *fiber.Ctx) error {
+	w := new(maintenanceWindow)
+
+	if err := c.BodyParser(&w); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	if w.EndsAt <= w.StartsAt || w.EndsAt <= clock.Now().Unix() {
+		return errInvalid("ends_at must be after starts_at and in the future")
+	}
+
+	id, err := helpers.RandomToken(8)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to schedule maintenance"})
+	}
+	w.ID = id
+	data, _ := json.Marshal(w)
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	if err := rClient.Do(radix.Cmd(nil, "HSET", links.MaintenanceKey(), id, string(data))); err != nil {
+		return dbError(err, "Unable to schedule maintenance")
+	}
+	maintenanceCache.Store(nil)
+
+	return c.Status(fiber.StatusCreated).JSON(w)
+}
+
+// CancelMaintenance removes a maintenance window, ending it if it is in
+// progress.
+func CancelMaintenance(c *fiber.Ctx) error {
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	var removed int
+	if err := rClient.Do(radix.Cmd(&removed, "HDEL", links.MaintenanceKey(), c.Params("id"))); err != nil {
+		return dbError(err, "Unable to cancel maintenance")
+	}
+	if removed == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "maintenance window not found"})
+	}
+	maintenanceCache.Store(nil)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
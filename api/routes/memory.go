@@ -0,0 +1,80 @@
+package routes
+
+import (
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+type namespaceMemory struct {
+	Namespace      string `json:"namespace"`
+	Keys           int64  `json:"keys"`
+	SampledKeys    int64  `json:"sampled_keys"`
+	SampledBytes   int64  `json:"sampled_bytes"`
+	EstimatedBytes int64  `json:"estimated_bytes"`
+}
+
+// MemoryUsage estimates the memory used by each key namespace. Every key is
+// counted but only one in ?rate= keys (default 10) is measured with MEMORY
+// USAGE, the average of the sample being extrapolated to the namespace.
+func MemoryUsage(c *fiber.Ctx) error {
+	rate := int64(c.QueryInt("rate", 10))
+	if rate < 1 {
+		rate = 1
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	usage := map[string]*namespaceMemory{}
+	var seen int64
+	err = database.Scan(rClient, "*", func(key string) error {
+		ns := links.Namespace(key)
+		m, ok := usage[ns]
+		if !ok {
+			m = &namespaceMemory{Namespace: ns}
+			usage[ns] = m
+		}
+		m.Keys++
+
+		seen++
+		if seen%rate != 0 {
+			return nil
+		}
+
+		var bytes int64
+		if err := rClient.Do(radix.Cmd(&bytes, "MEMORY", "USAGE", key, "SAMPLES", "5")); err != nil {
+			return err
+		}
+		m.SampledKeys++
+		m.SampledBytes += bytes
+
+		return nil
+	})
+	if err != nil {
+		return dbError(err, "Unable to measure memory")
+	}
+
+	report := make([]namespaceMemory, 0, len(usage))
+	var total int64
+	for _, m := range usage {
+		if m.SampledKeys > 0 {
+			m.EstimatedBytes = m.SampledBytes * m.Keys / m.SampledKeys
+		}
+		total += m.EstimatedBytes
+		report = append(report, *m)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].EstimatedBytes > report[j].EstimatedBytes })
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"sample_rate":     rate,
+		"estimated_bytes": total,
+		"namespaces":      report,
+	})
+}
@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+)
+
+// MigrateSchema converts every link still stored in an older key layout to
+// the current one. Links are also migrated lazily when read, this only
+// speeds up the tail.
+func MigrateSchema(c *fiber.Ctx) error {
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	stats, err := links.MigrateAll(c.Context(), rClient)
+	if err != nil {
+		fe := dbError(err, "Unable to migrate links")
+		return c.Status(fe.Code).JSON(fiber.Map{"error": fe.Message, "progress": stats})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"schema_version": links.SchemaVersion, "scanned": stats.Scanned, "migrated": stats.Migrated})
+}
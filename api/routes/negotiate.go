@@ -0,0 +1,30 @@
+package routes
+
+import "github.com/gofiber/fiber/v2"
+
+// wantsText reports whether the Accept header prefers text/plain over JSON.
+// Clients sending no Accept header, or */*, keep getting JSON.
+func wantsText(c *fiber.Ctx) bool {
+	return c.Accepts(fiber.MIMEApplicationJSON, fiber.MIMETextPlain) == fiber.MIMETextPlain
+}
+
+// wantsHTML reports whether the Accept header prefers text/html over JSON,
+// as browsers send it.
+func wantsHTML(c *fiber.Ctx) bool {
+	return c.Accepts(fiber.MIMEApplicationJSON, fiber.MIMETextHTML) == fiber.MIMETextHTML
+}
+
+// negotiate answers with text for plain text clients and v as JSON
+// otherwise.
+func negotiate(c *fiber.Ctx, status int, text string, v any) error {
+	if wantsText(c) {
+		return c.Status(status).SendString(text)
+	}
+
+	return c.Status(status).JSON(v)
+}
+
+// negotiateError reports an error in the format negotiated by the client.
+func negotiateError(c *fiber.Ctx, status int, message string) error {
+	return negotiate(c, status, message, fiber.Map{"error": message})
+}
@@ -0,0 +1,309 @@
+package routes
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+var orgID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,39}$`)
+
+type orgRequest struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	MaxLinks *int   `json:"max_links"`
+}
+
+type orgMemberRequest struct {
+	Role string `json:"role"`
+}
+
+type org struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	MaxLinks int               `json:"max_links"`
+	Links    int64             `json:"links"`
+	Members  map[string]string `json:"members"`
+}
+
+// CreateOrg creates an organization with the caller as its admin. The
+// caller's links join the organization pool.
+func CreateOrg(c *fiber.Ctx) error {
+	body := new(orgRequest)
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	if !orgID.MatchString(body.ID) {
+		return errInvalid("id must be 2 to 40 lowercase letters, digits or '-'")
+	}
+	maxLinks := 0
+	if body.MaxLinks != nil {
+		maxLinks = *body.MaxLinks
+	}
+	if maxLinks < 0 {
+		return errInvalid("max_links must not be negative")
+	}
+	if body.Name == "" {
+		body.Name = body.ID
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	err = links.CreateOrg(rClient, body.ID, body.Name, Owner(c), maxLinks)
+	if errors.Is(err, links.ErrOrgExists) || errors.Is(err, links.ErrOtherOrg) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return dbError(err, "Unable to create organization")
+	}
+
+	info, err := loadOrg(rClient, body.ID)
+	if err != nil {
+		return dbError(err, "Unable to read organization")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(info)
+}
+
+// GetOrg returns an organization, its members and usage to its members.
+func GetOrg(c *fiber.Ctx) error {
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	info, err := loadOrg(rClient, c.Params("org"))
+	if err != nil {
+		return dbError(err, "Unable to read organization")
+	}
+	if info == nil || info.Members[Owner(c)] == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(info)
+}
+
+// UpdateOrg changes the name or the shared quota of an organization.
+func UpdateOrg(c *fiber.Ctx) error {
+	body := new(orgRequest)
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	if body.MaxLinks != nil && *body.MaxLinks < 0 {
+		return errInvalid("max_links must not be negative")
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	id := c.Params("org")
+	info, err := loadOrg(rClient, id)
+	if err != nil {
+		return dbError(err, "Unable to update organization")
+	}
+	if info == nil || info.Members[Owner(c)] == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+	}
+	if info.Members[Owner(c)] != links.RoleAdmin {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "organization admin required"})
+	}
+
+	fields := []string{links.OrgKey(id)}
+	if body.Name != "" {
+		fields = append(fields, "name", body.Name)
+		info.Name = body.Name
+	}
+	if body.MaxLinks != nil {
+		fields = append(fields, "max_links", strconv.Itoa(*body.MaxLinks))
+		info.MaxLinks = *body.MaxLinks
+	}
+	if len(fields) > 1 {
+		if err := rClient.Do(radix.Cmd(nil, "HSET", fields...)); err != nil {
+			return dbError(err, "Unable to update organization")
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(info)
+}
+
+// SetOrgMember adds a user to an organization or changes their role. Only
+// admins manage members.
+func SetOrgMember(c *fiber.Ctx) error {
+	body := new(orgMemberRequest)
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	if body.Role == "" {
+		body.Role = links.RoleMember
+	}
+	if body.Role != links.RoleAdmin && body.Role != links.RoleMember {
+		return errInvalid("role must be admin or member")
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	id := c.Params("org")
+	if err := requireOrgRole(rClient, id, Owner(c), links.RoleAdmin); err != nil {
+		return err
+	}
+
+	err = links.JoinOrg(rClient, id, c.Params("member"), body.Role)
+	if errors.Is(err, links.ErrOtherOrg) || errors.Is(err, links.ErrLastAdmin) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return dbError(err, "Unable to update members")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"org": id, "member": c.Params("member"), "role": body.Role})
+}
+
+// RemoveOrgMember removes a user and their links from an organization.
+// Admins remove anyone, members may only leave.
+func RemoveOrgMember(c *fiber.Ctx) error {
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	id, member := c.Params("org"), c.Params("member")
+	role := links.RoleAdmin
+	if member == Owner(c) {
+		role = links.RoleMember
+	}
+	if err := requireOrgRole(rClient, id, Owner(c), role); err != nil {
+		return err
+	}
+
+	err = links.LeaveOrg(rClient, id, member)
+	if errors.Is(err, links.ErrNotMember) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+	if errors.Is(err, links.ErrLastAdmin) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return dbError(err, "Unable to update members")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// OrgLinks lists a page of the organization's link pool to its members,
+// following the cursor of the previous page.
+func OrgLinks(c *fiber.Ctx) error {
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	id := c.Params("org")
+	if err := requireOrgRole(rClient, id, Owner(c), links.RoleMember); err != nil {
+		return err
+	}
+
+	cursor := c.Query("cursor", "0")
+	var shorts []string
+	err = rClient.Do(radix.Cmd(radix.Tuple{&cursor, &shorts}, "SSCAN", links.OrgLinksKey(id), cursor, "COUNT", "100"))
+	if err != nil {
+		return dbError(err, "Unable to read organization links")
+	}
+
+	infos := []linkInfo{}
+	for _, short := range shorts {
+		info, err := loadLinkInfo(rClient, short)
+		if err != nil {
+			return dbError(err, "Unable to read organization links")
+		}
+		if info != nil {
+			infos = append(infos, *info)
+		}
+	}
+
+	resp := fiber.Map{"links": infos}
+	if cursor != "0" {
+		resp["next_cursor"] = cursor
+	}
+
+	return c.Status(fiber.StatusOK).JSON(resp)
+}
+
+// requireOrgRole checks that user has at least role in the organization,
+// returning the error to answer with otherwise. Non-members can't tell
+// whether the organization exists.
+func requireOrgRole(rClient database.ClientInterface, id, user, role string) *fiber.Error {
+	var current string
+	if err := rClient.Do(radix.Cmd(&current, "HGET", links.OrgMembersKey(id), user)); err != nil {
+		return dbError(err, "Unable to read organization")
+	}
+	if current == "" {
+		return fiber.NewError(fiber.StatusNotFound, links.ErrOrgNotFound.Error())
+	}
+	if role == links.RoleAdmin && current != links.RoleAdmin {
+		return fiber.NewError(fiber.StatusForbidden, "organization admin required")
+	}
+
+	return nil
+}
+
+// orgQuotaReached reports whether an organization has used its shared
+// quota.
+func orgQuotaReached(rClient database.ClientInterface, id string) (bool, error) {
+	var maxLinks, count int64
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(&maxLinks, "HGET", links.OrgKey(id), "max_links"))
+	p.Append(radix.Cmd(&count, "SCARD", links.OrgLinksKey(id)))
+	if err := rClient.Do(p); err != nil {
+		return false, err
+	}
+
+	return maxLinks > 0 && count >= maxLinks, nil
+}
+
+func loadOrg(rClient database.ClientInterface, id string) (*org, error) {
+	var fields, members map[string]string
+	var count int64
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(&fields, "HGETALL", links.OrgKey(id)))
+	p.Append(radix.Cmd(&members, "HGETALL", links.OrgMembersKey(id)))
+	p.Append(radix.Cmd(&count, "SCARD", links.OrgLinksKey(id)))
+	if err := rClient.Do(p); err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	maxLinks, _ := strconv.Atoi(fields["max_links"])
+
+	return &org{
+		ID:       id,
+		Name:     fields["name"],
+		MaxLinks: maxLinks,
+		Links:    count,
+		Members:  members,
+	}, nil
+}
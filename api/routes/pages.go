@@ -0,0 +1,163 @@
+package routes
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/i18n"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// HTML pages shown to browsers instead of JSON errors.
+const (
+	PageNotFound = "not_found"
+	PageExpired  = "expired"
+	PageWarning  = "warning"
+	PagePassword = "password"
+	PageBusy     = "busy"
+)
+
+var pageTitles = map[string]string{
+	PageNotFound: "Short not found",
+	PageExpired:  "Link unavailable",
+	PageWarning:  "Before you continue",
+	PagePassword: "Password required",
+	PageBusy:     "Too many visits",
+}
+
+//go:embed pages/*.html
+var pageFiles embed.FS
+
+// pageLayout defines the "head" and "foot" templates shared by the built-in
+// pages and available to tenant overrides.
+var pageLayout = template.Must(template.ParseFS(pageFiles, "pages/layout.html"))
+
+var defaultPages = func() map[string]*template.Template {
+	pages := map[string]*template.Template{}
+	for name := range pageTitles {
+		pages[name] = template.Must(template.Must(pageLayout.Clone()).ParseFS(pageFiles, "pages/"+name+".html")).Lookup(name + ".html")
+	}
+
+	return pages
+}()
+
+// parsePage parses a tenant override of a built-in page.
+func parsePage(name, text string) (*template.Template, error) {
+	layout, err := pageLayout.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	return layout.New(name).Parse(text)
+}
+
+// brand is the tenant styling passed to pages as .Brand.
+type brand struct {
+	Name    string
+	LogoURL string
+	Color   string
+
+	pages map[string]*template.Template
+}
+
+type cachedBrand struct {
+	brand   *brand
+	expires time.Time
+}
+
+var brands sync.Map
+
+// brandingTTL is read lazily so that the .env file is loaded first.
+var brandingTTL = sync.OnceValue(func() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("BRANDING_CACHE_TTL"))
+	if err != nil {
+		ttl = time.Minute
+	}
+
+	return ttl
+})
+
+// brandFor returns the branding of owner, cached for BRANDING_CACHE_TTL.
+// Owners without branding, and lookups failing, get the built-in pages.
+func brandFor(rClient database.ClientInterface, owner string) *brand {
+	if owner == "" {
+		return &brand{}
+	}
+	if cached, ok := brands.Load(owner); ok && clock.Now().Before(cached.(cachedBrand).expires) {
+		return cached.(cachedBrand).brand
+	}
+
+	var fields map[string]string
+	if err := rClient.Do(radix.Cmd(&fields, "HGETALL", links.BrandingKey(owner))); err != nil {
+		return &brand{}
+	}
+
+	b := &brand{
+		Name:    fields["name"],
+		LogoURL: fields["logo_url"],
+		Color:   fields["color"],
+		pages:   map[string]*template.Template{},
+	}
+	for name := range pageTitles {
+		text := fields["page:"+name]
+		if text == "" {
+			continue
+		}
+		page, err := parsePage(name, text)
+		if err != nil {
+			log.Printf("branding %s: page %s: %v", owner, name, err)
+			continue
+		}
+		b.pages[name] = page
+	}
+
+	brands.Store(owner, cachedBrand{brand: b, expires: clock.Now().Add(brandingTTL())})
+
+	return b
+}
+
+// domainOwner returns the owner whose custom domain is host, or "".
+func domainOwner(rClient database.ClientInterface, host string) string {
+	var owner string
+	_ = rClient.Do(radix.Cmd(&owner, "HGET", links.DomainsKey(), strings.ToLower(host)))
+
+	return owner
+}
+
+// renderPage answers with the page name in owner's branding and the
+// request's language. Pages translate text with {{call .T "message" args}}.
+// A tenant page failing to execute falls back to the built-in one.
+func renderPage(c *fiber.Ctx, rClient database.ClientInterface, owner, name string, status int, data fiber.Map) error {
+	b := brandFor(rClient, owner)
+	lang := langOf(c)
+	data["Lang"] = lang
+	data["T"] = func(msg string, args ...any) string { return i18n.T(lang, msg, args...) }
+	data["Title"] = i18n.T(lang, pageTitles[name])
+	data["Brand"] = b
+
+	var body bytes.Buffer
+	page, ok := b.pages[name]
+	if !ok || page.Execute(&body, data) != nil {
+		body.Reset()
+		if err := defaultPages[name].Execute(&body, data); err != nil {
+			return err
+		}
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderContentLanguage, lang)
+	c.Vary(fiber.HeaderAcceptLanguage)
+
+	return c.Status(status).Send(body.Bytes())
+}
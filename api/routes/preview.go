@@ -0,0 +1,98 @@
+package routes
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/helpers"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/lockout"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// PasswordHeader lets API clients send the password of a protected short
+// without putting it in the URL.
+const PasswordHeader = "X-Link-Password"
+
+type previewTokenRequest struct {
+	TTL time.Duration `json:"ttl"`
+}
+
+// CreatePreviewToken issues a one-time token opening a protected short
+// without its password, e.g. for a moderator reviewing the destination.
+// The TTL is given in seconds and defaults to PREVIEW_TOKEN_TTL.
+func CreatePreviewToken(c *fiber.Ctx) error {
+	short := shortParam(c)
+	body := new(previewTokenRequest)
+
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+		}
+	}
+
+	ttl := body.TTL * time.Second
+	if ttl <= 0 {
+		ttl = 15 * time.Minute
+		if v, err := time.ParseDuration(os.Getenv("PREVIEW_TOKEN_TTL")); err == nil {
+			ttl = v
+		}
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	if exists, err := links.Exists(rClient, short); err != nil || !exists {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "short not found"})
+	}
+
+	token, err := helpers.RandomToken(16)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to create token"})
+	}
+
+	seconds := strconv.FormatInt(int64(ttl/time.Second), 10)
+	if err := rClient.Do(radix.Cmd(nil, "SET", links.PreviewKey(token), short, "EX", seconds)); err != nil {
+		return dbError(err, "Unable to create token")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"token":      token,
+		"url":        os.Getenv("DOMAIN") + "/" + links.DisplayShort(short) + "?preview=" + token,
+		"expires_in": int64(ttl / time.Second),
+	})
+}
+
+// unlocked reports whether the request may open a password protected short,
+// either with the right password or by consuming a preview token.
+func unlocked(c *fiber.Ctx, rClient database.ClientInterface, short, hash string) bool {
+	// HEAD requests come from unfurlers and must not burn the token.
+	if token := c.Query("preview"); token != "" && c.Method() != fiber.MethodHead {
+		var target string
+		err := rClient.Do(radix.Cmd(&target, "GETDEL", links.PreviewKey(token)))
+		if err == nil && target == short {
+			return true
+		}
+	}
+
+	password := c.Get(PasswordHeader)
+	if password == "" {
+		password = c.Query("password")
+	}
+
+	if password == "" {
+		return false
+	}
+	if !helpers.CheckPassword(hash, password) {
+		lockout.Fail(rClient, lockoutConfig(), lockout.IP(c.IP()), lockout.Account("link:"+short))
+		return false
+	}
+
+	return true
+}
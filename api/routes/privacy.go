@@ -0,0 +1,101 @@
+package routes
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/privacy"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// defaultPrivacy is read lazily so that the .env file is loaded first.
+var defaultPrivacy = sync.OnceValue(privacy.DefaultFromEnv)
+
+// privacyTTL is how long the policy of a tenant is cached, a change
+// reaches the other instances after it.
+const privacyTTL = time.Minute
+
+type cachedPolicy struct {
+	policy  privacy.Policy
+	expires time.Time
+}
+
+var policies sync.Map
+
+// privacyFor returns the privacy policy of owner, read on every click and
+// so cached. Lookups failing get the strictest policy.
+func privacyFor(rClient database.ClientInterface, owner string) privacy.Policy {
+	if owner == "" {
+		return defaultPrivacy()
+	}
+	if cached, ok := policies.Load(owner); ok && clock.Now().Before(cached.(cachedPolicy).expires) {
+		return cached.(cachedPolicy).policy
+	}
+
+	policy, err := privacy.Load(rClient, owner, defaultPrivacy())
+	if err != nil {
+		return privacy.Policy{Private: true, HonorDNT: true}
+	}
+	policies.Store(owner, cachedPolicy{policy: policy, expires: clock.Now().Add(privacyTTL)})
+
+	return policy
+}
+
+type privacySettings struct {
+	Private  *bool `json:"private"`
+	HonorDNT *bool `json:"honor_dnt"`
+}
+
+// GetPrivacy returns the privacy policy applied to the links of the key
+// owner.
+func GetPrivacy(c *fiber.Ctx) error {
+	rClient, err := database.Shared()
+	if err != nil {
+		return errUnavailable()
+	}
+
+	policy, err := privacy.Load(rClient, Owner(c), defaultPrivacy())
+	if err != nil {
+		return dbError(err, "Unable to load privacy settings")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"owner": Owner(c), "private": policy.Private, "honor_dnt": policy.HonorDNT})
+}
+
+// SetPrivacy sets the privacy policy of the key owner's links. A null
+// setting restores the deployment default, PRIVACY_MODE or
+// PRIVACY_HONOR_DNT.
+func SetPrivacy(c *fiber.Ctx) error {
+	var body privacySettings
+	if err := c.BodyParser(&body); err != nil {
+		return errInvalid("Cannot parse JSON")
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return errUnavailable()
+	}
+
+	owner := Owner(c)
+	p := radix.NewPipeline()
+	for field, value := range map[string]*bool{"privacy_mode": body.Private, "privacy_dnt": body.HonorDNT} {
+		switch {
+		case value == nil:
+			p.Append(radix.Cmd(nil, "HDEL", links.UserKey(owner), field))
+		case *value:
+			p.Append(radix.Cmd(nil, "HSET", links.UserKey(owner), field, "1"))
+		default:
+			p.Append(radix.Cmd(nil, "HSET", links.UserKey(owner), field, "0"))
+		}
+	}
+	if err := rClient.Do(p); err != nil {
+		return dbError(err, "Unable to save privacy settings")
+	}
+	policies.Delete(owner)
+
+	return GetPrivacy(c)
+}
@@ -0,0 +1,498 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/helpers"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/lockout"
+	"github.com/ksarpe/redis-golang/privacy"
+	"github.com/ksarpe/redis-golang/retention"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Purge statuses.
+const (
+	purgeQueued  = "queued"
+	purgeRunning = "running"
+	purgeDone    = "done"
+	purgeFailed  = "failed"
+)
+
+// purgeReportTTL is how long the report of a purge is kept.
+const purgeReportTTL = 30 * 24 * time.Hour
+
+// purge is a request to erase the data of an owner or an IP, and its
+// report once run.
+type purge struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner,omitempty"`
+	IP    string `json:"ip,omitempty"`
+	// Links is what happens to the links of Owner, "delete" or "reassign"
+	// to ReassignTo.
+	Links      string `json:"links,omitempty"`
+	ReassignTo string `json:"reassign_to,omitempty"`
+
+	Status      string `json:"status"`
+	RequestedAt int64  `json:"requested_at"`
+	StartedAt   int64  `json:"started_at,omitempty"`
+	FinishedAt  int64  `json:"finished_at,omitempty"`
+
+	LinksDeleted    int `json:"links_deleted"`
+	LinksReassigned int `json:"links_reassigned"`
+	// KeysDeleted counts the profile, credentials, sessions, caches and
+	// counters removed.
+	KeysDeleted int `json:"keys_deleted"`
+	// FeedEntries and ClickEvents count the entries removed from the
+	// creation feed and the click stream waiting for export.
+	FeedEntries      int      `json:"feed_entries"`
+	ClickEvents      int      `json:"click_events"`
+	SessionsScrubbed int      `json:"sessions_scrubbed"`
+	Warnings         []string `json:"warnings,omitempty"`
+	Error            string   `json:"error,omitempty"`
+}
+
+// RequestPurge queues the erasure of every piece of data about an owner,
+// an IP, or both, for GDPR requests. The links of the owner are deleted,
+// or reassigned to another owner with "links": "reassign". The purge runs
+// in the background, its report is returned by PurgeReport.
+func RequestPurge(c *fiber.Ctx) error {
+	var body struct {
+		Owner      string `json:"owner"`
+		IP         string `json:"ip"`
+		Links      string `json:"links"`
+		ReassignTo string `json:"reassign_to"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return errInvalid("Cannot parse JSON")
+	}
+	if body.Owner == "" && body.IP == "" {
+		return errInvalid("owner or ip required")
+	}
+	if body.IP != "" && net.ParseIP(body.IP) == nil {
+		return errInvalid("invalid ip")
+	}
+	if body.Owner != "" && body.Links == "" {
+		body.Links = "delete"
+	}
+	switch {
+	case body.Owner == "" && body.Links != "":
+		return errInvalid("links requires an owner")
+	case body.Links != "" && body.Links != "delete" && body.Links != "reassign":
+		return errInvalid(`links must be "delete" or "reassign"`)
+	case body.Links == "reassign" && (body.ReassignTo == "" || body.ReassignTo == body.Owner):
+		return errInvalid("reassign_to must be another owner")
+	case body.Links != "reassign" && body.ReassignTo != "":
+		return errInvalid(`reassign_to requires "links": "reassign"`)
+	}
+
+	id, err := helpers.RandomToken(12)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Unable to queue purge")
+	}
+	job := &purge{
+		ID:          id,
+		Owner:       body.Owner,
+		IP:          body.IP,
+		Links:       body.Links,
+		ReassignTo:  body.ReassignTo,
+		Status:      purgeQueued,
+		RequestedAt: clock.Now().Unix(),
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return errUnavailable()
+	}
+	if err := savePurge(rClient, job); err != nil {
+		return dbError(err, "Unable to queue purge")
+	}
+	if err := rClient.Do(radix.Cmd(nil, "RPUSH", links.PurgeQueueKey(), id)); err != nil {
+		return dbError(err, "Unable to queue purge")
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
+
+// PurgeReport returns the state of a purge, with what it erased once done.
+func PurgeReport(c *fiber.Ctx) error {
+	rClient, err := database.Shared()
+	if err != nil {
+		return errUnavailable()
+	}
+
+	job, err := loadPurge(rClient, c.Params("id"))
+	if err != nil {
+		return dbError(err, "Unable to load purge")
+	}
+	if job == nil {
+		return fiber.NewError(fiber.StatusNotFound, "purge not found")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(job)
+}
+
+func savePurge(rClient database.ClientInterface, job *purge) error {
+	report, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return database.SetWithTTL(rClient, links.PurgeKey(job.ID), string(report), purgeReportTTL)
+}
+
+func loadPurge(rClient database.ClientInterface, id string) (*purge, error) {
+	report, err := database.GetString(rClient, links.PurgeKey(id))
+	if err != nil || report == "" {
+		return nil, err
+	}
+
+	job := &purge{}
+	if err := json.Unmarshal([]byte(report), job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// RunPurges runs the queued purges one after the other. A purge
+// interrupted by a crash is reported running forever, requesting it again
+// finishes it.
+func RunPurges(ctx context.Context, rClient database.ClientInterface) error {
+	for ctx.Err() == nil {
+		var id string
+		if err := rClient.Do(radix.Cmd(&id, "LPOP", links.PurgeQueueKey())); err != nil {
+			return err
+		}
+		if id == "" {
+			return nil
+		}
+
+		job, err := loadPurge(rClient, id)
+		if err != nil {
+			return err
+		}
+		if job == nil {
+			continue
+		}
+
+		job.Status, job.StartedAt = purgeRunning, clock.Now().Unix()
+		_ = savePurge(rClient, job)
+
+		job.Status = purgeDone
+		if err := runPurge(ctx, rClient, job); err != nil {
+			log.Printf("purge %s: %v", id, err)
+			job.Status, job.Error = purgeFailed, err.Error()
+		}
+		job.FinishedAt = clock.Now().Unix()
+		if err := savePurge(rClient, job); err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+func runPurge(ctx context.Context, rClient database.ClientInterface, job *purge) error {
+	var shorts []string
+	if job.Owner != "" {
+		var err error
+		if shorts, err = purgeOwnerLinks(rClient, job); err != nil {
+			return err
+		}
+		if err := purgeOwner(rClient, job); err != nil {
+			return err
+		}
+	}
+	if job.IP != "" {
+		if err := purgeIP(rClient, job); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return scrubStreams(rClient, job, shorts)
+}
+
+// purgeOwnerLinks deletes or reassigns the links of the owner, returning
+// the deleted ones.
+func purgeOwnerLinks(rClient database.ClientInterface, job *purge) ([]string, error) {
+	var shorts []string
+	if err := rClient.Do(radix.Cmd(&shorts, "SMEMBERS", links.UserLinksKey(job.Owner))); err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	defer func() {
+		// Nothing of the owner's links is left in the trash.
+		p := radix.NewPipeline()
+		for _, short := range deleted {
+			retention.AppendDrop(p, short)
+		}
+		if err := rClient.Do(p); err != nil {
+			job.Warnings = append(job.Warnings, fmt.Sprintf("emptying trash: %v", err))
+		}
+	}()
+	for _, short := range shorts {
+		if job.Links == "reassign" {
+			moved, err := reassignLink(rClient, job.Owner, job.ReassignTo, short)
+			if err != nil {
+				return nil, err
+			}
+			if moved {
+				job.LinksReassigned++
+			}
+			continue
+		}
+
+		ferr := deleteLink(rClient, job.Owner, short)
+		if ferr != nil && ferr.Code != fiber.StatusNotFound {
+			return nil, ferr
+		}
+		if ferr == nil {
+			job.LinksDeleted++
+			deleted = append(deleted, short)
+		}
+	}
+
+	return deleted, nil
+}
+
+// reassignLink hands short over from one owner to another, and between
+// their organization pools. It reports false when from no longer owns it.
+func reassignLink(rClient database.ClientInterface, from, to, short string) (bool, error) {
+	link, err := links.LoadLink(rClient, short)
+	if err != nil || link == nil || link.Owner != from {
+		return false, err
+	}
+
+	var fromOrg, toOrg string
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(&fromOrg, "HGET", links.UserKey(from), "org"))
+	p.Append(radix.Cmd(&toOrg, "HGET", links.UserKey(to), "org"))
+	if err := rClient.Do(p); err != nil {
+		return false, err
+	}
+
+	p = radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "MULTI"))
+	p.Append(links.WriteCmd(short, []string{"owner", to}, nil))
+	p.Append(radix.Cmd(nil, "SMOVE", links.UserLinksKey(from), links.UserLinksKey(to), short))
+	if fromOrg != toOrg {
+		if fromOrg != "" {
+			p.Append(radix.Cmd(nil, "SREM", links.OrgLinksKey(fromOrg), short))
+		}
+		if toOrg != "" {
+			p.Append(radix.Cmd(nil, "SADD", links.OrgLinksKey(toOrg), short))
+		}
+	}
+	p.Append(radix.Cmd(nil, "EXEC"))
+
+	return true, rClient.Do(p)
+}
+
+// purgeOwner erases the profile of the owner and everything attached to
+// it: organization membership, API keys, sessions, branding and custom
+// domains, Slack accounts, transfers, reports, caches and counters.
+func purgeOwner(rClient database.ClientInterface, job *purge) error {
+	owner := job.Owner
+
+	org, err := links.OrgOf(rClient, owner)
+	if err != nil {
+		return err
+	}
+	if org != "" {
+		if err := links.LeaveOrg(rClient, org, owner); err != nil {
+			job.Warnings = append(job.Warnings, fmt.Sprintf("leaving organization %s: %v", org, err))
+		}
+	}
+
+	var keyIDs, sessions []string
+	var domains, slackUsers map[string]string
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(&keyIDs, "SMEMBERS", links.UserAPIKeysKey(owner)))
+	p.Append(radix.Cmd(&sessions, "SMEMBERS", links.UserSessionsKey(owner)))
+	p.Append(radix.Cmd(&domains, "HGETALL", links.DomainsKey()))
+	p.Append(radix.Cmd(&slackUsers, "HGETALL", links.SlackUsersKey()))
+	if err := rClient.Do(p); err != nil {
+		return err
+	}
+
+	keys := []string{
+		links.UserKey(owner), links.UserLinksKey(owner), links.UserAPIKeysKey(owner),
+		links.UserSessionsKey(owner), links.BrandingKey(owner), links.UserTransfersKey(owner),
+	}
+	patterns := []string{
+		"report:d:user:" + globEscape(owner) + ":*",
+		"report:cache:" + globEscape(owner) + ":*",
+		"sitemap:" + globEscape(owner) + ":*",
+		links.QuotaKey(globEscape(owner), "*"),
+		links.APIRateKey("owner:"+globEscape(owner)) + ":*",
+	}
+	for _, id := range keyIDs {
+		keys = append(keys, links.APIKeyKey(id))
+		patterns = append(patterns,
+			links.APIRateKey(globEscape(id))+":*",
+			links.ExtensionRateKey(globEscape(id))+":*")
+	}
+	for _, id := range sessions {
+		keys = append(keys, links.SessionKey(id))
+	}
+	if err := deleteKeys(rClient, job, keys, patterns); err != nil {
+		return err
+	}
+
+	p = radix.NewPipeline()
+	for domain, domainOwner := range domains {
+		if domainOwner == owner {
+			p.Append(radix.Cmd(nil, "HDEL", links.DomainsKey(), domain))
+		}
+	}
+	for slackUser, slackOwner := range slackUsers {
+		if slackOwner == owner {
+			p.Append(radix.Cmd(nil, "HDEL", links.SlackUsersKey(), slackUser))
+		}
+	}
+	if err := rClient.Do(p); err != nil {
+		return err
+	}
+
+	// Other instances drop them within their cache TTLs.
+	brands.Delete(owner)
+	policies.Delete(owner)
+
+	return nil
+}
+
+// purgeIP erases the limits and lockouts of an IP and scrubs it from the
+// dashboard sessions.
+func purgeIP(rClient database.ClientInterface, job *purge) error {
+	patterns := []string{
+		links.AnonymousRateKey(globEscape(job.IP)) + ":*",
+		"lockout:" + lockout.IP(globEscape(job.IP)),
+		"lockout:" + lockout.IP(globEscape(job.IP)) + ":*",
+	}
+	if err := deleteKeys(rClient, job, nil, patterns); err != nil {
+		return err
+	}
+
+	return database.Scan(rClient, links.SessionKey("*"), func(key string) error {
+		var ip string
+		if err := rClient.Do(radix.Cmd(&ip, "HGET", key, "ip")); err != nil || ip != job.IP {
+			return err
+		}
+		job.SessionsScrubbed++
+
+		return rClient.Do(radix.Cmd(nil, "HDEL", key, "ip"))
+	})
+}
+
+// deleteKeys deletes keys and the keys matching patterns.
+func deleteKeys(rClient database.ClientInterface, job *purge, keys, patterns []string) error {
+	for _, pattern := range patterns {
+		err := database.Scan(rClient, pattern, func(key string) error {
+			keys = append(keys, key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), 500)]
+		keys = keys[len(batch):]
+
+		var n int
+		if err := rClient.Do(radix.Cmd(&n, "DEL", batch...)); err != nil {
+			return err
+		}
+		job.KeysDeleted += n
+	}
+
+	return nil
+}
+
+// scrubStreams removes from the creation feed the entries of the owner or
+// the IP, and from the click stream the clicks on the deleted shorts. The
+// feed keeps IPs truncated or hashed by privacy mode, the IP is matched
+// that way too.
+func scrubStreams(rClient database.ClientInterface, job *purge, shorts []string) error {
+	ips := map[string]bool{}
+	if job.IP != "" {
+		ips[job.IP] = true
+		ips[privacy.Policy{Private: true}.IP(job.IP)] = true
+	}
+	n, err := scrubStream(rClient, links.CreationStreamKey(), func(entry radix.StreamEntry) bool {
+		e := decodeCreation(entry)
+		return job.Owner != "" && e.Owner == job.Owner || ips[e.IP]
+	})
+	job.FeedEntries += n
+	if err != nil || len(shorts) == 0 {
+		return err
+	}
+
+	deleted := map[string]bool{}
+	for _, short := range shorts {
+		deleted[short] = true
+	}
+	n, err = scrubStream(rClient, links.ClickStreamKey(), func(entry radix.StreamEntry) bool {
+		for _, f := range entry.Fields {
+			if f[0] == "short" {
+				return deleted[f[1]]
+			}
+		}
+		return false
+	})
+	job.ClickEvents += n
+
+	return err
+}
+
+// scrubStream deletes the entries of a stream matching match, returning
+// how many were deleted.
+func scrubStream(rClient database.ClientInterface, key string, match func(radix.StreamEntry) bool) (int, error) {
+	deleted := 0
+	start := "-"
+	for {
+		var entries []radix.StreamEntry
+		if err := rClient.Do(radix.Cmd(&entries, "XRANGE", key, start, "+", "COUNT", "1000")); err != nil {
+			return deleted, err
+		}
+
+		var ids []string
+		for _, entry := range entries {
+			if match(entry) {
+				ids = append(ids, entry.ID.String())
+			}
+		}
+		if len(ids) > 0 {
+			var n int
+			if err := rClient.Do(radix.Cmd(&n, "XDEL", append([]string{key}, ids...)...)); err != nil {
+				return deleted, err
+			}
+			deleted += n
+		}
+
+		if len(entries) < 1000 {
+			return deleted, nil
+		}
+		start = "(" + entries[len(entries)-1].ID.String()
+	}
+}
+
+// globEscape escapes the SCAN MATCH wildcards of s.
+func globEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`).Replace(s)
+}
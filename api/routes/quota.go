@@ -0,0 +1,88 @@
+package routes
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/quota"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// quotaConfig is read lazily so that the .env file is loaded first.
+var quotaConfig = sync.OnceValue(quota.ConfigFromEnv)
+
+// limitQuota counts an authenticated request against the daily quota of
+// owner, exposing the usage as the "quota" local and in the X-Quota-*
+// headers. Requests over the quota of a grace plan are let through, tagged
+// with X-Quota-Exceeded. It returns how long until the quota renews when
+// the request is refused, and 0 when the quota cannot be checked.
+func limitQuota(c *fiber.Ctx, rClient database.ClientInterface, owner string) time.Duration {
+	usage, ok, err := quota.Count(rClient, quotaConfig(), owner)
+	if err != nil || !ok {
+		return 0
+	}
+	c.Locals("quota", usage)
+
+	c.Set("X-Quota-Plan", usage.Plan)
+	c.Set("X-Quota-Limit", strconv.FormatInt(usage.Limit, 10))
+	c.Set("X-Quota-Remaining", strconv.FormatInt(max(usage.Limit-usage.Used, 0), 10))
+	c.Set("X-Quota-Reset", strconv.FormatInt(int64((usage.Reset+time.Second-1)/time.Second), 10))
+	if !usage.Exceeded() {
+		return 0
+	}
+	if usage.Grace {
+		c.Set("X-Quota-Exceeded", "grace")
+		return 0
+	}
+
+	return usage.Reset
+}
+
+// quotaExceeded answers a request over the daily quota, d being how long
+// until it renews.
+func quotaExceeded(c *fiber.Ctx, d time.Duration) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10))
+
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "daily quota exceeded"})
+}
+
+// SetPlan sets the quota plan of an owner, one of QUOTA_PLANS. An empty
+// plan restores QUOTA_DEFAULT_PLAN.
+func SetPlan(c *fiber.Ctx) error {
+	var body struct {
+		Plan string `json:"plan"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return errInvalid("Cannot parse JSON")
+	}
+
+	cfg := quotaConfig()
+	if _, ok := cfg.Plans[body.Plan]; body.Plan != "" && !ok {
+		return errInvalid("unknown plan")
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return errUnavailable()
+	}
+
+	owner := c.Params("owner")
+	cmd := radix.Cmd(nil, "HSET", links.UserKey(owner), "plan", body.Plan)
+	if body.Plan == "" {
+		cmd = radix.Cmd(nil, "HDEL", links.UserKey(owner), "plan")
+	}
+	if err := rClient.Do(cmd); err != nil {
+		return dbError(err, "Unable to save plan")
+	}
+
+	plan := body.Plan
+	if plan == "" {
+		plan = cfg.Default
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"owner": owner, "plan": plan})
+}
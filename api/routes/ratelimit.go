@@ -0,0 +1,79 @@
+package routes
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/ratelimit"
+)
+
+// apiRateLimit returns the requests a minute allowed to each API key, or
+// owner for tokens, from API_RATE_LIMIT, 600 by default and 0 to disable.
+var apiRateLimit = sync.OnceValue(func() int64 {
+	if v, err := strconv.ParseInt(os.Getenv("API_RATE_LIMIT"), 10, 64); err == nil && v >= 0 {
+		return v
+	}
+
+	return 600
+})
+
+// limitCaller counts an authenticated request against API_RATE_LIMIT,
+// exposing the result as the "ratelimit" local and in the headers of the
+// response. It returns how long until the next window when the limit is
+// exceeded, and 0 when it cannot be checked.
+func limitCaller(c *fiber.Ctx, rClient database.ClientInterface, subject string) time.Duration {
+	limit := apiRateLimit()
+	if limit == 0 {
+		return 0
+	}
+
+	result, err := ratelimit.Allow(rClient, links.APIRateKey(subject), limit, time.Minute)
+	if err != nil {
+		return 0
+	}
+	c.Locals("ratelimit", result)
+	setRateLimitHeaders(c, result)
+	if result.Allowed {
+		return 0
+	}
+
+	return result.Reset
+}
+
+// rateLimited answers a request over its rate limit, d being how long
+// until the next window.
+func rateLimited(c *fiber.Ctx, d time.Duration) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10))
+
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "rate limit exceeded, retry later"})
+}
+
+// setRateLimitHeaders describes result in the RateLimit-* headers of the
+// IETF draft, the reset being in seconds from now, and in the legacy
+// X-RateLimit-* headers, the reset being a Unix time as most clients
+// expect. The last limit checked for a request wins.
+func setRateLimitHeaders(c *fiber.Ctx, result ratelimit.Result) {
+	limit := strconv.FormatInt(result.Limit, 10)
+	remaining := strconv.FormatInt(result.Remaining, 10)
+	reset := strconv.FormatInt(int64((result.Reset+time.Second-1)/time.Second), 10)
+
+	c.Set("RateLimit-Limit", limit)
+	c.Set("RateLimit-Remaining", remaining)
+	c.Set("RateLimit-Reset", reset)
+	c.Set("X-RateLimit-Limit", limit)
+	c.Set("X-RateLimit-Remaining", remaining)
+	c.Set("X-RateLimit-Reset", strconv.FormatInt(clock.Now().Add(result.Reset).Unix(), 10))
+}
+
+// callerRate returns the rate limit checked for the request, if any.
+func callerRate(c *fiber.Ctx) (ratelimit.Result, bool) {
+	result, ok := c.Locals("ratelimit").(ratelimit.Result)
+
+	return result, ok
+}
@@ -0,0 +1,196 @@
+package routes
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// readOnlyRefresh is how long an instance trusts its copy of the flag.
+const readOnlyRefresh = 2 * time.Second
+
+const defaultRetryAfter = 60
+
+type readOnlyState struct {
+	Enabled    bool   `json:"enabled"`
+	Reason     string `json:"reason,omitempty"`
+	RetryAfter int64  `json:"retry_after"`
+	Since      int64  `json:"since,omitempty"`
+	// Forced is set when READ_ONLY=true, which can't be lifted through the
+	// admin API.
+	Forced bool `json:"forced,omitempty"`
+}
+
+type cachedReadOnly struct {
+	state   readOnlyState
+	expires time.Time
+}
+
+var readOnlyCache atomic.Pointer[cachedReadOnly]
+
+// readOnlyMode returns the read-only flag, from READ_ONLY or else from
+// redis. When redis can't be read the last known state is kept, a failover
+// must not lift the flag.
+func readOnlyMode() readOnlyState {
+	if os.Getenv("READ_ONLY") == "true" {
+		return readOnlyState{Enabled: true, RetryAfter: defaultRetryAfter, Forced: true}
+	}
+
+	cached := readOnlyCache.Load()
+	if cached != nil && clock.Now().Before(cached.expires) {
+		return cached.state
+	}
+
+	state, err := loadReadOnly()
+	if err != nil {
+		if cached == nil {
+			return readOnlyState{}
+		}
+		state = cached.state
+	}
+	readOnlyCache.Store(&cachedReadOnly{state: state, expires: clock.Now().Add(readOnlyRefresh)})
+
+	return state
+}
+
+func loadReadOnly() (readOnlyState, error) {
+	rClient, err := database.Shared()
+	if err != nil {
+		return readOnlyState{}, err
+	}
+
+	var fields map[string]string
+	err = database.HGetAll(rClient, links.ReadOnlyKey(), &fields)
+	if errors.Is(err, database.ErrNil) || err == nil && fields["since"] == "" {
+		return readOnlyState{}, nil
+	}
+	if err != nil {
+		return readOnlyState{}, err
+	}
+
+	state := readOnlyState{Enabled: true, Reason: fields["reason"], RetryAfter: defaultRetryAfter}
+	state.Since, _ = strconv.ParseInt(fields["since"], 10, 64)
+	if v, err := strconv.ParseInt(fields["retry_after"], 10, 64); err == nil && v > 0 {
+		state.RetryAfter = v
+	}
+
+	return state, nil
+}
+
+// writes reports whether a request changes data. Resolving shorts, the
+// captcha included, and the admin API, needed to lift the flag, never
+// count as writes. GraphQL mutations are checked by their resolvers.
+func writes(c *fiber.Ctx) bool {
+	path := c.Path()
+	if !strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/auth/") && !strings.HasPrefix(path, "/dashboard/") {
+		return false
+	}
+	rest, api := apiPath(path)
+	if api && (rest == "/resolve/batch" || rest == "/graphql") {
+		return false
+	}
+
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		// Shortcuts for clients that can only follow links.
+		return api && (rest == "/shorten" || rest == "/ext/shorten" || strings.HasPrefix(rest, "/extend/"))
+	}
+
+	return true
+}
+
+// errReadOnly is returned by writes refused in read-only mode.
+func errReadOnly() *fiber.Error {
+	return fiber.NewError(fiber.StatusServiceUnavailable, "service is in read-only mode, retry later")
+}
+
+// writable returns errReadOnly in read-only mode, for handlers serving
+// reads and writes alike.
+func writable(c *fiber.Ctx) error {
+	state := readOnlyMode()
+	if !state.Enabled {
+		return nil
+	}
+	c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(state.RetryAfter, 10))
+
+	return errReadOnly()
+}
+
+// ReadOnly returns a middleware refusing writes with 503 while the service
+// is in read-only mode, shorts keep resolving.
+func ReadOnly() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !writes(c) {
+			return c.Next()
+		}
+		if err := writable(c); err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": errReadOnly().Message})
+		}
+
+		return c.Next()
+	}
+}
+
+type readOnlyRequest struct {
+	Enabled    bool   `json:"enabled"`
+	Reason     string `json:"reason"`
+	RetryAfter int64  `json:"retry_after"`
+}
+
+// GetReadOnly reports whether the service is in read-only mode.
+func GetReadOnly(c *fiber.Ctx) error {
+	state, err := loadReadOnly()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	if forced := readOnlyMode(); forced.Forced {
+		state = forced
+	}
+
+	return c.Status(fiber.StatusOK).JSON(state)
+}
+
+// SetReadOnly turns read-only mode on or off for every instance, which
+// follow within a few seconds. retry_after, in seconds, is sent to clients
+// in the Retry-After header.
+func SetReadOnly(c *fiber.Ctx) error {
+	body := new(readOnlyRequest)
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	if body.RetryAfter < 0 {
+		return errInvalid("retry_after can't be negative")
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	cmd := radix.Cmd(nil, "DEL", links.ReadOnlyKey())
+	if body.Enabled {
+		if body.RetryAfter == 0 {
+			body.RetryAfter = defaultRetryAfter
+		}
+		cmd = radix.Cmd(nil, "HSET", links.ReadOnlyKey(),
+			"since", strconv.FormatInt(clock.Now().Unix(), 10),
+			"reason", body.Reason,
+			"retry_after", strconv.FormatInt(body.RetryAfter, 10))
+	}
+	if err := rClient.Do(cmd); err != nil {
+		return dbError(err, "Unable to update read-only mode")
+	}
+	readOnlyCache.Store(nil)
+
+	return GetReadOnly(c)
+}
@@ -0,0 +1,138 @@
+package routes
+
+import (
+	"bufio"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/reports"
+	"github.com/valyala/fasthttp"
+)
+
+// aggregateConfig is read lazily so that the .env file is loaded first.
+var aggregateConfig = sync.OnceValue(reports.AggregateConfigFromEnv)
+
+// ClickReport summarizes the clicks on the links of the caller by country
+// and referrer between ?from and ?to, UTC dates defaulting to the last 30
+// days.
+func ClickReport(c *fiber.Ctx) error {
+	return clickReport(c, Owner(c))
+}
+
+// AdminClickReport is ClickReport over the links of ?owner, or every link
+// without one.
+func AdminClickReport(c *fiber.Ctx) error {
+	return clickReport(c, c.Query("owner"))
+}
+
+func clickReport(c *fiber.Ctx, owner string) error {
+	cfg := aggregateConfig()
+	from, to, err := reportRange(c, cfg)
+	if err != nil {
+		return err
+	}
+
+	rClient, err := database.Bulk()
+	if err != nil {
+		return errUnavailable()
+	}
+
+	report, err := reports.Report(rClient, cfg, owner, from, to)
+	if err != nil {
+		return dbError(err, "Unable to compute report")
+	}
+
+	return c.JSON(report)
+}
+
+// ExportStats downloads the daily clicks of one of the caller's shorts
+// between ?from and ?to, as for ClickReport, with its clicks by country and
+// referrer, as ?format=csv or xlsx. The file is streamed as it is read.
+func ExportStats(c *fiber.Ctx) error {
+	short := shortParam(c)
+
+	format := c.Query("format", "csv")
+	contentType, ok := exportTypes[format]
+	if !ok {
+		return errInvalid("format must be csv or xlsx")
+	}
+
+	from, to, err := reportRange(c, aggregateConfig())
+	if err != nil {
+		return err
+	}
+
+	rClient, err := database.Bulk()
+	if err != nil {
+		return errUnavailable()
+	}
+
+	meta, err := links.Load(rClient, short)
+	if err != nil {
+		return dbError(err, "Unable to export stats")
+	}
+	if meta == nil || Owner(c) == "" || meta["owner"] != Owner(c) {
+		return fiber.NewError(fiber.StatusNotFound, "short not found")
+	}
+
+	c.Attachment(strings.ReplaceAll(short, "/", "_") + "-stats." + format)
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		sheets := reports.NewCSV(w)
+		if format == "xlsx" {
+			sheets = reports.NewXLSX(w)
+		}
+
+		err := reports.Export(rClient, sheets, short, from, to)
+		if err == nil {
+			err = sheets.Close()
+		}
+		if err != nil {
+			log.Printf("export %s: %v", short, err)
+		}
+	}))
+
+	return nil
+}
+
+var exportTypes = map[string]string{
+	"csv":  "text/csv; charset=utf-8",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// reportRange reads the ?from and ?to dates of a report, defaulting to the
+// last 30 days, within the retention of the daily buckets.
+func reportRange(c *fiber.Ctx, cfg reports.AggregateConfig) (time.Time, time.Time, error) {
+	today := clock.Now().UTC().Truncate(24 * time.Hour)
+	to, err := reportDate(c.Query("to"), today)
+	if err != nil {
+		return time.Time{}, time.Time{}, errInvalid("to must be a date such as 2006-01-02")
+	}
+	from, err := reportDate(c.Query("from"), to.AddDate(0, 0, -29))
+	if err != nil {
+		return time.Time{}, time.Time{}, errInvalid("from must be a date such as 2006-01-02")
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, errInvalid("from must not be after to")
+	}
+	if from.Before(today.AddDate(0, 0, 1-cfg.Retention)) {
+		return time.Time{}, time.Time{}, errInvalid("reports only cover the last " + strconv.Itoa(cfg.Retention) + " days")
+	}
+
+	return from, to, nil
+}
+
+func reportDate(s string, fallback time.Time) (time.Time, error) {
+	if s == "" {
+		return fallback, nil
+	}
+
+	return time.Parse(time.DateOnly, s)
+}
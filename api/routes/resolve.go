@@ -1,33 +1,328 @@
 package routes
 
 import (
+	"log"
+	neturl "net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/analytics"
+	"github.com/ksarpe/redis-golang/anomaly"
+	"github.com/ksarpe/redis-golang/bloom"
+	"github.com/ksarpe/redis-golang/budget"
+	"github.com/ksarpe/redis-golang/clicklog"
+	"github.com/ksarpe/redis-golang/clock"
 	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/events"
+	"github.com/ksarpe/redis-golang/geoip"
+	"github.com/ksarpe/redis-golang/journal"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/lockout"
+	"github.com/ksarpe/redis-golang/live"
+	"github.com/ksarpe/redis-golang/ratelimit"
+	"github.com/ksarpe/redis-golang/reports"
+	"github.com/ksarpe/redis-golang/rewrite"
+	"github.com/ksarpe/redis-golang/top"
 	radix "github.com/mediocregopher/radix/v4"
 )
 
+// anomalyConfig is read lazily so that the .env file is loaded first.
+var anomalyConfig = sync.OnceValue(anomaly.ConfigFromEnv)
+
+// analyticsConfig is read lazily so that the .env file is loaded first.
+var analyticsConfig = sync.OnceValue(analytics.ConfigFromEnv)
+
+// Pre-encoded bodies of the common resolve failures, keeping JSON encoding
+// off the redirect path.
+var (
+	shortNotFoundBody = []byte(`{"error":"short not found in the database or cannot connect to DB"}`)
+	shortDisabledBody = []byte(`{"error":"short has been disabled"}`)
+)
 
+// ResolveURL redirects to the destination of a short. Plain shorts are
+// loaded and counted in a single round trip on the shared pool, the
+// remaining click analytics go out in one pipeline.
 func ResolveURL(c *fiber.Ctx) error{
-	url := c.Params("url")
+	path := strings.TrimSuffix(c.Params("url")+"/"+c.Params("*"), "/")
 
-	r := database.RadixV4ClientsProducer{}
+	if target, ok := rewrite.Default.Apply(path); ok {
+		if rewrite.IsExternal(target) {
+			return c.Redirect(target, fiber.StatusFound)
+		}
+		path = target
+	}
 
-	rClient, err := r.NewClient("db:6379")
+	rClient, err := database.Shared()
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "short not found in the database or cannot connect to DB",
-		})
+		return errUnavailable()
 	}
-	defer rClient.Close()
 
-	var result string
-	err = rClient.Do(radix.Cmd(&result, "GET", url))
+	head := c.Method() == fiber.MethodHead
+	url, rest, meta, err := lookupShort(rClient, path, !head)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "short not found in the database or cannot connect to DB",
+		return dbError(err, "Unable to connect to server")
+	}
+	if meta == nil {
+		return shortNotFound(c, path)
+	}
+	result := meta["url"]
+	counted := !head && rest == "" && links.Counted(meta)
+
+	if meta["passthrough"] == "1" {
+		result = passthrough(result, rest, string(c.Request().URI().QueryString()))
+	} else if rest != "" {
+		return shortNotFound(c, path)
+	}
+
+	if meta["disabled"] == "1" {
+		if wantsHTML(c) {
+			return renderPage(c, rClient, meta["owner"], PageExpired, fiber.StatusGone, fiber.Map{"Short": links.DisplayShort(url)})
+		}
+		return sendJSON(c, fiber.StatusGone, shortDisabledBody)
+	}
+
+	if meta["password_hash"] != "" && (c.Get(PasswordHeader) != "" || c.Query("password") != "") {
+		if d := lockout.Locked(rClient, lockoutConfig(), lockout.IP(c.IP()), lockout.Account("link:"+url)); d > 0 {
+			return lockedOut(c, d)
+		}
+	}
+	if meta["password_hash"] != "" && !unlocked(c, rClient, url, meta["password_hash"]) {
+		if wantsHTML(c) {
+			return renderPage(c, rClient, meta["owner"], PagePassword, fiber.StatusUnauthorized, fiber.Map{
+				"Short":  links.DisplayShort(url),
+				"Failed": c.Query("password") != "",
+			})
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "short is password protected",
 		})
 	}
 
+	if needsCaptcha(c, meta) {
+		return renderCaptcha(c, links.DisplayShort(url))
+	}
+
+	if needsWarning(c, meta) {
+		next := c.OriginalURL()
+		if strings.Contains(next, "?") {
+			next += "&warned=1"
+		} else {
+			next += "?warned=1"
+		}
+		if wantsHTML(c) {
+			return renderPage(c, rClient, meta["owner"], PageWarning, fiber.StatusOK, fiber.Map{
+				"Short":    links.DisplayShort(url),
+				"URL":      result,
+				"Continue": next,
+			})
+		}
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error":    "short is flagged as suspicious",
+			"continue": next,
+		})
+	}
+
+	if anomaly.Throttled(rClient, anomalyConfig(), url, meta) {
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": "short is temporarily throttled",
+		})
+	}
+
+	if wait := overLinkLimit(rClient, url, meta, head); wait > 0 {
+		seconds := int64((wait + time.Second - 1) / time.Second)
+		c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(seconds, 10))
+		if wantsHTML(c) {
+			return renderPage(c, rClient, meta["owner"], PageBusy, fiber.StatusTooManyRequests, fiber.Map{
+				"Short":      links.DisplayShort(url),
+				"RetryAfter": seconds,
+			})
+		}
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": "short is receiving too many visits, retry later",
+		})
+	}
+
+	setRobotsTag(c, meta)
+
+	// Link checkers and unfurlers only HEAD the short, keep them out of the
+	// click analytics.
+	if head {
+		_, _ = database.Incr(rClient, links.HeadRequestsKey(url))
+		return c.Redirect(result, 301)
+	}
+
+	// Click counting is best effort, a failed pipeline must not break the
+	// redirect.
+	p := radix.NewPipeline()
+	if !counted {
+		p.Append(radix.Cmd(nil, "INCR", links.ClickCounterKey(url)))
+		p.Append(radix.Cmd(nil, "HINCRBY", links.CountersKey(), "clicks", "1"))
+	}
+	// The buckets are only read by anomaly detection.
+	if anomalyConfig().Enabled {
+		anomaly.AppendRecord(p, url)
+	}
+	// Private mode keeps no country, user agent or full referrer, and
+	// visitors opting out under the owner's policy are only counted, with
+	// no event recorded.
+	policy := privacyFor(rClient, meta["owner"])
+	optedOut := policy.OptedOut(func(name string) string { return c.Get(name) })
+	country, userAgent, referrer := "", "", ""
+	if !optedOut {
+		if !policy.Private {
+			country = geoip.Default.Country(c.IP())
+			userAgent = c.Get(fiber.HeaderUserAgent)
+		}
+		referrer = policy.Referrer(c.Get(fiber.HeaderReferer))
+	}
+	// Past its latency budget redis only gets the click count and the
+	// anomaly records protecting it.
+	if !budget.Default.Shedding() {
+		top.AppendRecord(p, url)
+		if country != "" {
+			p.Append(radix.Cmd(nil, "HINCRBY", links.CountriesKey(url), country, "1"))
+		}
+		reports.AppendClick(p, aggregateConfig(), url, meta["owner"], country, referrer)
+		if liveStats().enabled && !optedOut {
+			live.AppendPublish(p, live.Click{Short: url, Campaign: meta["campaign"], Country: country, Timestamp: clock.Now().Unix()})
+		}
+		if cfg := analyticsConfig(); cfg.Enabled && !optedOut {
+			analytics.AppendEvent(p, cfg, analytics.Event{
+				Short:     url,
+				Timestamp: clock.Now().Unix(),
+				Country:   country,
+				Referrer:  referrer,
+				UserAgent: userAgent,
+			})
+		}
+	}
+	if err := rClient.Do(p); err != nil && !counted && journal.Enabled() && database.Unavailable(err) {
+		// Only the click counters are journaled, the rest of the analytics
+		// of the click is lost.
+		err := journal.AppendCmds(
+			[]string{"INCR", links.ClicksKey(url)},
+			[]string{"HINCRBY", links.CountersKey(), "clicks", "1"},
+		)
+		if err != nil {
+			log.Printf("journal: %v", err)
+		}
+	}
+	clicklog.Log(clicklog.Entry{
+		Short:     url,
+		Timestamp: clock.Now().Unix(),
+		Owner:     meta["owner"],
+		Campaign:  meta["campaign"],
+		Country:   country,
+		Referrer:  referrer,
+		UserAgent: userAgent,
+	})
+
+	if events.Clicks() && !optedOut {
+		events.Emit("link.clicked", events.Click{Short: url, Country: country, Referrer: referrer})
+	}
+
 	return c.Redirect(result, 301)
+}
+
+// lookupShort finds the deepest short addressed by path, returning it in
+// canonical form with the sub-path following it. Sub-paths only resolve for
+// passthrough shorts, which Hit can't tell before loading, so Hit only
+// counts the click when there is none.
+func lookupShort(rClient database.ClientInterface, path string, count bool) (string, string, map[string]string, error) {
+	for _, prefix := range links.Prefixes(path) {
+		short, err := links.ParseShort(prefix.Short)
+		if err != nil {
+			continue
+		}
+		if !bloom.Default.MightContain(rClient, short) {
+			// An in-memory filter misses the shorts whose announcement
+			// this instance did not receive, only redis can tell.
+			if bloom.Default.Authoritative() {
+				continue
+			}
+			exists, err := links.Exists(rClient, short)
+			if err != nil {
+				return "", "", nil, err
+			}
+			if !exists {
+				continue
+			}
+		}
+
+		meta, err := links.Hit(rClient, short, count && prefix.Rest == "")
+		if err != nil {
+			return "", "", nil, err
+		}
+		if meta != nil {
+			return short, prefix.Rest, meta, nil
+		}
+	}
+
+	return "", "", nil, nil
+}
+
+// overLinkLimit counts a resolution against the max_rpm of a short and
+// returns how long until the next minute window when it is exceeded. HEAD
+// requests don't count, and the limit is not enforced without redis.
+func overLinkLimit(rClient database.ClientInterface, short string, meta map[string]string, head bool) time.Duration {
+	limit, _ := strconv.ParseInt(meta["max_rpm"], 10, 64)
+	if limit <= 0 || head {
+		return 0
+	}
+
+	result, err := ratelimit.Allow(rClient, links.LinkRateKey(short), limit, time.Minute)
+	if err != nil || result.Allowed {
+		return 0
+	}
+
+	return result.Reset
+}
+
+// needsWarning reports whether the visitor has to confirm a warning page
+// before being redirected to a flagged short.
+func needsWarning(c *fiber.Ctx, meta map[string]string) bool {
+	return meta["flagged"] != "" && meta["flag_action"] == anomaly.ActionWarn && c.Query("warned") != "1"
+}
+
+// passthrough forwards the sub-path and query string a passthrough short was
+// requested with to its destination. The sub-path can't climb above the
+// destination path and the password, preview and warning parameters stay
+// here.
+func passthrough(dest, rest, query string) string {
+	u, err := neturl.Parse(dest)
+	if err != nil {
+		return dest
+	}
+
+	if rest != "" {
+		clean := strings.TrimPrefix(path.Clean("/"+rest), "/")
+		if strings.HasSuffix(rest, "/") && clean != "" {
+			clean += "/"
+		}
+		u = u.JoinPath(clean)
+	}
+
+	if values, err := neturl.ParseQuery(query); err == nil {
+		values.Del("password")
+		values.Del("preview")
+		values.Del("warned")
+		if forwarded := values.Encode(); forwarded != "" {
+			if u.RawQuery != "" {
+				u.RawQuery += "&"
+			}
+			u.RawQuery += forwarded
+		}
+	}
+
+	return u.String()
+}
+
+func sendJSON(c *fiber.Ctx, status int, body []byte) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
 
-}
\ No newline at end of file
+	return c.Status(status).Send(body)
+}
@@ -0,0 +1,51 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/rewrite"
+)
+
+// ListRewriteRules returns the rewrite rules in evaluation order.
+func ListRewriteRules(c *fiber.Ctx) error {
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	rules, err := rewrite.Load(rClient)
+	if err != nil {
+		return dbError(err, "Unable to read rewrite rules")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"rules": rules})
+}
+
+// ReplaceRewriteRules stores a new ordered list of rewrite rules, which
+// every instance picks up right away.
+func ReplaceRewriteRules(c *fiber.Ctx) error {
+	var body struct {
+		Rules []rewrite.Rule `json:"rules"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	if err := rewrite.Compile(body.Rules); err != nil {
+		return errInvalid(err.Error())
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	if err := rewrite.Save(rClient, body.Rules); err != nil {
+		return dbError(err, "Unable to save rewrite rules")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"rules": body.Rules})
+}
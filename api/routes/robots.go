@@ -0,0 +1,39 @@
+package routes
+
+import (
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const defaultRobotsTxt = "User-agent: *\nDisallow: /api/\nDisallow: /admin/\n"
+
+// RobotsTxt serves the crawler policy from ROBOTS_TXT_FILE or ROBOTS_TXT,
+// falling back to keeping crawlers out of the API.
+func RobotsTxt(c *fiber.Ctx) error {
+	body := os.Getenv("ROBOTS_TXT")
+
+	if path := os.Getenv("ROBOTS_TXT_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("unable to read robots.txt")
+		}
+		body = string(data)
+	}
+
+	if body == "" {
+		body = defaultRobotsTxt
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+
+	return c.Status(fiber.StatusOK).SendString(body)
+}
+
+// setRobotsTag asks search engines not to index redirects when
+// ROBOTS_NOINDEX is enabled, unless the short opted in to indexing.
+func setRobotsTag(c *fiber.Ctx, meta map[string]string) {
+	if os.Getenv("ROBOTS_NOINDEX") == "true" && meta["indexable"] != "1" {
+		c.Set("X-Robots-Tag", "noindex")
+	}
+}
@@ -0,0 +1,50 @@
+package routes
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/destination"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// SetAllowedSchemes sets the destination schemes an owner may shorten,
+// overriding ALLOWED_SCHEMES. An empty list restores the default.
+func SetAllowedSchemes(c *fiber.Ctx) error {
+	var body struct {
+		Schemes []string `json:"schemes"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	owner := c.Params("owner")
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	if len(body.Schemes) == 0 {
+		if err := rClient.Do(radix.Cmd(nil, "HDEL", links.UserKey(owner), "allowed_schemes")); err != nil {
+			return dbError(err, "Unable to save schemes")
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"owner": owner, "schemes": destination.DefaultPolicy()})
+	}
+
+	policy := destination.ParseSchemes(strings.Join(body.Schemes, ","))
+	if err := policy.Validate(); err != nil {
+		return errInvalid(err.Error())
+	}
+
+	err = rClient.Do(radix.Cmd(nil, "HSET", links.UserKey(owner), "allowed_schemes", strings.Join(policy, ",")))
+	if err != nil {
+		return dbError(err, "Unable to save schemes")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"owner": owner, "schemes": policy})
+}
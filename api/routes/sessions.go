@@ -0,0 +1,295 @@
+package routes
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/helpers"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// SessionCookie holds the dashboard session token. Redis only keeps its
+// hash, which doubles as the session id shown in device listings.
+const SessionCookie = "sid"
+
+// touchInterval limits how often a session's last_seen is written.
+const touchInterval = time.Minute
+
+type sessionConfig struct {
+	idle   time.Duration
+	maxAge time.Duration
+}
+
+// sessionSettings is read lazily so that the .env file is loaded first.
+// Sessions expire after SESSION_IDLE_TTL without requests and after
+// SESSION_MAX_AGE at the latest.
+var sessionSettings = sync.OnceValue(func() sessionConfig {
+	cfg := sessionConfig{idle: 24 * time.Hour, maxAge: 30 * 24 * time.Hour}
+	if d, err := time.ParseDuration(os.Getenv("SESSION_IDLE_TTL")); err == nil && d > 0 {
+		cfg.idle = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("SESSION_MAX_AGE")); err == nil && d > 0 {
+		cfg.maxAge = d
+	}
+
+	return cfg
+})
+
+type session struct {
+	ID        string `json:"id"`
+	UserAgent string `json:"user_agent"`
+	IP        string `json:"ip"`
+	CreatedAt int64  `json:"created_at"`
+	LastSeen  int64  `json:"last_seen"`
+	Current   bool   `json:"current"`
+}
+
+// startSession creates a dashboard session for owner and sets its cookie.
+func startSession(c *fiber.Ctx, rClient database.ClientInterface, owner string) error {
+	token, err := helpers.RandomToken(32)
+	if err != nil {
+		return err
+	}
+	id := helpers.HashToken(token)
+	cfg := sessionSettings()
+	now := strconv.FormatInt(clock.Now().Unix(), 10)
+
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "HSET", links.SessionKey(id),
+		"owner", owner,
+		"user_agent", c.Get(fiber.HeaderUserAgent),
+		"ip", c.IP(),
+		"created_at", now,
+		"last_seen", now))
+	p.Append(radix.Cmd(nil, "EXPIRE", links.SessionKey(id), strconv.FormatInt(int64(cfg.idle/time.Second), 10)))
+	p.Append(radix.Cmd(nil, "SADD", links.UserSessionsKey(owner), id))
+	if err := rClient.Do(p); err != nil {
+		return err
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(cfg.maxAge / time.Second),
+		HTTPOnly: true,
+		Secure:   c.Protocol() == "https",
+		SameSite: fiber.CookieSameSiteStrictMode,
+	})
+
+	return nil
+}
+
+// RequireSession rejects requests without a live dashboard session and
+// exposes its owner as the "owner" local, like RequireAPIKey. Each request
+// pushes the idle expiry back, up to SESSION_MAX_AGE after the login.
+func RequireSession(c *fiber.Ctx) error {
+	token := c.Cookies(SessionCookie)
+	if token == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "login required"})
+	}
+	id := helpers.HashToken(token)
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	var fields map[string]string
+	if err := rClient.Do(radix.Cmd(&fields, "HGETALL", links.SessionKey(id))); err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	cfg := sessionSettings()
+	now := clock.Now()
+	createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+	if fields["owner"] == "" || now.After(time.Unix(createdAt, 0).Add(cfg.maxAge)) {
+		_ = revokeSessions(rClient, fields["owner"], id)
+		c.ClearCookie(SessionCookie)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "login required"})
+	}
+
+	lastSeen, _ := strconv.ParseInt(fields["last_seen"], 10, 64)
+	if now.Sub(time.Unix(lastSeen, 0)) >= touchInterval {
+		p := radix.NewPipeline()
+		p.Append(radix.Cmd(nil, "HSET", links.SessionKey(id), "last_seen", strconv.FormatInt(now.Unix(), 10), "ip", c.IP()))
+		p.Append(radix.Cmd(nil, "EXPIRE", links.SessionKey(id), strconv.FormatInt(int64(cfg.idle/time.Second), 10)))
+		_ = rClient.Do(p)
+	}
+
+	c.Locals("owner", fields["owner"])
+	c.Locals("session", id)
+
+	return c.Next()
+}
+
+// CurrentUser returns the profile of the logged in user.
+func CurrentUser(c *fiber.Ctx) error {
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	var profile struct {
+		Email string `redis:"email"`
+		Org   string `redis:"org"`
+	}
+	err = database.HGetAll(rClient, links.UserKey(Owner(c)), &profile)
+	if err != nil && !errors.Is(err, database.ErrNil) {
+		return dbError(err, "Unable to read profile")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"owner": Owner(c),
+		"email": profile.Email,
+		"org":   profile.Org,
+	})
+}
+
+// ListSessions returns the devices the user is logged in on.
+func ListSessions(c *fiber.Ctx) error {
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	owner := Owner(c)
+	var ids []string
+	if err := rClient.Do(radix.Cmd(&ids, "SMEMBERS", links.UserSessionsKey(owner))); err != nil {
+		return dbError(err, "Unable to read sessions")
+	}
+
+	current, _ := c.Locals("session").(string)
+	sessions := []session{}
+	for _, id := range ids {
+		var fields map[string]string
+		if err := rClient.Do(radix.Cmd(&fields, "HGETALL", links.SessionKey(id))); err != nil {
+			return dbError(err, "Unable to read sessions")
+		}
+		if fields["owner"] != owner {
+			// Expired, drop it from the index.
+			_ = rClient.Do(radix.Cmd(nil, "SREM", links.UserSessionsKey(owner), id))
+			continue
+		}
+
+		createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+		lastSeen, _ := strconv.ParseInt(fields["last_seen"], 10, 64)
+		sessions = append(sessions, session{
+			ID:        id,
+			UserAgent: fields["user_agent"],
+			IP:        fields["ip"],
+			CreatedAt: createdAt,
+			LastSeen:  lastSeen,
+			Current:   id == current,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"sessions": sessions})
+}
+
+// RevokeSession logs the user out of one device.
+func RevokeSession(c *fiber.Ctx) error {
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	owner, id := Owner(c), c.Params("id")
+	var member int
+	if err := rClient.Do(radix.Cmd(&member, "SISMEMBER", links.UserSessionsKey(owner), id)); err != nil {
+		return dbError(err, "Unable to revoke session")
+	}
+	if member == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+
+	if err := revokeSessions(rClient, owner, id); err != nil {
+		return dbError(err, "Unable to revoke session")
+	}
+	if current, _ := c.Locals("session").(string); current == id {
+		c.ClearCookie(SessionCookie)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RevokeAllSessions logs the user out everywhere, except on this device
+// with ?keep_current=true.
+func RevokeAllSessions(c *fiber.Ctx) error {
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	owner := Owner(c)
+	var ids []string
+	if err := rClient.Do(radix.Cmd(&ids, "SMEMBERS", links.UserSessionsKey(owner))); err != nil {
+		return dbError(err, "Unable to revoke sessions")
+	}
+
+	current, _ := c.Locals("session").(string)
+	keep := c.QueryBool("keep_current")
+	revoke := ids[:0]
+	for _, id := range ids {
+		if !keep || id != current {
+			revoke = append(revoke, id)
+		}
+	}
+
+	if err := revokeSessions(rClient, owner, revoke...); err != nil {
+		return dbError(err, "Unable to revoke sessions")
+	}
+	if !keep {
+		c.ClearCookie(SessionCookie)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"revoked": len(revoke)})
+}
+
+// Logout ends the current session.
+func Logout(c *fiber.Ctx) error {
+	token := c.Cookies(SessionCookie)
+	c.ClearCookie(SessionCookie)
+	if token == "" {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	id := helpers.HashToken(token)
+	var owner string
+	if err := rClient.Do(radix.Cmd(&owner, "HGET", links.SessionKey(id), "owner")); err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	if err := revokeSessions(rClient, owner, id); err != nil {
+		return dbError(err, "Unable to revoke session")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func revokeSessions(rClient database.ClientInterface, owner string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	p := radix.NewPipeline()
+	for _, id := range ids {
+		p.Append(radix.Cmd(nil, "DEL", links.SessionKey(id)))
+		if owner != "" {
+			p.Append(radix.Cmd(nil, "SREM", links.UserSessionsKey(owner), id))
+		}
+	}
+
+	return rClient.Do(p)
+}
@@ -2,28 +2,71 @@ package routes
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/google/uuid"
+	"github.com/ksarpe/redis-golang/bloom"
+	"github.com/ksarpe/redis-golang/clock"
 	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/destination"
+	"github.com/ksarpe/redis-golang/events"
 	"github.com/ksarpe/redis-golang/helpers"
+	"github.com/ksarpe/redis-golang/links"
 	radix "github.com/mediocregopher/radix/v4"
 	"github.com/asaskevich/govalidator"
 )
 
+// flattenConfig is read lazily so that the .env file is loaded first.
+var flattenConfig = sync.OnceValue(destination.FlattenConfigFromEnv)
+
+// upgradeConfig is read lazily so that the .env file is loaded first.
+var upgradeConfig = sync.OnceValue(destination.UpgradeConfigFromEnv)
+
 type request struct {
-	URL         string        `json:"url"`
-	CustomShort string        `json:"short"`
-	Expiry      time.Duration `json:"expiry"`
+	URL         string `json:"url"`
+	CustomShort string `json:"short"`
+	Expiry      expiry `json:"expiry"`
+	// ExpiresAt, in RFC 3339, is an alternative to Expiry.
+	ExpiresAt   time.Time `json:"expires_at"`
+	Campaign    string    `json:"campaign"`
+	Password    string    `json:"password"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Indexable   bool      `json:"indexable"`
+	Passthrough bool      `json:"passthrough"`
+	// MaxClicksPerMinute protects fragile destinations, visitors beyond it
+	// are asked to come back later.
+	MaxClicksPerMinute int64 `json:"max_clicks_per_minute"`
 }
 
 type response struct {
-	URL             string        `json:"url"`
-	CustomShort     string        `json:"short"`
-	Expiry          time.Duration `json:"expiry"`
-	XRateRemaining  int           `json:"rate_limit"`
-	XRateLimitReset time.Duration `json:"rate_limit_reset"`
+	URL         string `json:"url"`
+	CustomShort string `json:"short"`
+	// Expiry is in ExpiryUnit, which depends on the API version.
+	Expiry             int64         `json:"expiry"`
+	ExpiryUnit         string        `json:"expiry_unit"`
+	ExpiresAt          int64         `json:"expires_at"`
+	XRateRemaining     int           `json:"rate_limit"`
+	XRateLimitReset    time.Duration `json:"rate_limit_reset"`
+	Campaign           string        `json:"campaign,omitempty"`
+	Title              string        `json:"title,omitempty"`
+	Description        string        `json:"description,omitempty"`
+	Passthrough        bool          `json:"passthrough,omitempty"`
+	OriginalURL        string        `json:"original_url,omitempty"`
+	MaxClicksPerMinute int64         `json:"max_clicks_per_minute,omitempty"`
+	// Pending is set for links journaled while redis was unavailable, which
+	// only resolve once replayed.
+	Pending bool `json:"pending,omitempty"`
+}
+
+// responseV2 is response as of /api/v2, durations being whole seconds
+// rather than nanoseconds.
+type responseV2 struct {
+	*response
+	XRateLimitReset int64 `json:"rate_limit_reset"`
 }
 
 func ShortenURL(c *fiber.Ctx) error {
@@ -33,79 +76,371 @@ func ShortenURL(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error":"Cannot parse JSON"})
 	}
 
-	//implement rate limiting
+	resp, ferr := shorten(c, body)
+	if ferr != nil {
+		return negotiateError(c, ferr.Code, ferr.Message)
+	}
+
+	status := fiber.StatusOK
+	if resp.Pending {
+		status = fiber.StatusAccepted
+	}
+
+	if apiVersion(c) >= 2 {
+		return negotiate(c, status, resp.CustomShort, responseV2{
+			response:        resp,
+			XRateLimitReset: int64(resp.XRateLimitReset.Seconds()),
+		})
+	}
+
+	return negotiate(c, status, resp.CustomShort, resp)
+}
+
+// ShortenByGet shortens the url query parameter and answers with the plain
+// text short URL, for bookmarklets and scripts that can't POST JSON.
+func ShortenByGet(c *fiber.Ctx) error {
+	ttl, err := parseExpiry(c.Query("expiry"))
+	if err != nil {
+		return errInvalid(err.Error())
+	}
+	var expiresAt time.Time
+	if v := c.Query("expires_at"); v != "" {
+		if expiresAt, err = time.Parse(time.RFC3339, v); err != nil {
+			return errInvalid("expires_at must be an RFC 3339 time")
+		}
+	}
+
+	body := &request{
+		URL:         c.Query("url"),
+		CustomShort: c.Query("short"),
+		Expiry:      ttl,
+		ExpiresAt:   expiresAt,
+	}
+
+	resp, ferr := shorten(c, body)
+	if ferr != nil {
+		return c.Status(ferr.Code).SendString(ferr.Message)
+	}
+
+	if resp.Pending {
+		return c.Status(fiber.StatusAccepted).SendString(resp.CustomShort)
+	}
+
+	return c.Status(fiber.StatusOK).SendString(resp.CustomShort)
+}
+
+// shorten validates body and stores the new short, returning the error to
+// report to the client if any.
+func shorten(c *fiber.Ctx, body *request) (*response, *fiber.Error) {
+	rClient, err := database.Shared()
+	if err != nil {
+		return deferShorten(c, body)
+	}
+
+	if err := links.ValidateNotes(body.Title, body.Description); err != nil {
+		return nil, errInvalid(err.Error())
+	}
 
-	r := database.RadixV4ClientsProducer{}
-	rClient, err := r.NewClient("db:6379")
+	owner := Owner(c)
+	anonymous := owner == ""
+	if anonymous {
+		if ferr := limitAnonymous(c, rClient); ferr != nil {
+			return nil, ferr
+		}
+	}
+
+	// The first read tells whether redis went away since the client was
+	// dialed.
+	policy, err := schemePolicy(rClient, owner)
+	if database.Unavailable(err) {
+		return deferShorten(c, body)
+	}
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error":err.Error()})
+		return nil, dbError(err, "Unable to connect to server")
+	}
 
+	body.URL = policy.WithScheme(body.URL)
+	scheme, err := policy.Check(body.URL)
+	if err != nil {
+		return nil, errInvalid(err.Error())
+	}
+	web := scheme == "http" || scheme == "https"
+	if anonymous && !web {
+		return nil, errInvalid("links created without an API key must point to http(s) URLs")
 	}
-	defer rClient.Close()
 
 	//check if the input is an actual URL
 
-	if !govalidator.IsURL(body.URL){
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error":"Invalid URL"})
+	if web && !govalidator.IsURL(body.URL){
+		return nil, errInvalid("Invalid URL")
 	}
 
 	//check for domain error
 
-	if !helpers.RemoveDomainError(body.URL){
-		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error":"Domain error"})
+	if web && !helpers.RemoveDomainError(body.URL){
+		return nil, errInvalid("Domain error")
 	}
 
-	//enforce https, SSL
+	if web {
+		if err := destination.CheckPublic(c.Context(), body.URL); err != nil {
+			return nil, errInvalid(err.Error())
+		}
+	}
 
-	body.URL = helpers.EnforceHTTP(body.URL)
+	// Visitors skip the destination's own redirects, the submitted URL is
+	// kept for reference.
+	submitted := body.URL
+	hops := 0
+	upgrade := ""
+	if cfg := upgradeConfig(); cfg.Enabled && web {
+		body.URL, upgrade = destination.Upgrade(c.Context(), cfg, body.URL)
+	}
+	if cfg := flattenConfig(); cfg.Enabled && web {
+		body.URL, hops = destination.Flatten(c.Context(), cfg, body.URL)
+	}
+	if anonymous {
+		if ferr := screenAnonymous(c.Context(), submitted, body.URL); ferr != nil {
+			return nil, ferr
+		}
+	}
 
 	var id string
 
 	if body.CustomShort == ""{
-		id = uuid.New().String()[:6]
-	} else {
-		id = body.CustomShort
+		if id, err = links.NewShort(reservedIn(rClient)); err != nil {
+			return nil, dbError(err, "Unable to connect to server")
+		}
+	} else if id, err = links.ParseShort(body.CustomShort); err != nil {
+		return nil, errInvalid(err.Error())
 	}
-	r2 := database.RadixV4ClientsProducer{}
-	rClient2, err := r2.NewClient("db:6379")
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error":err.Error()})
 
+	// A short the shared filter has never seen is free without a lookup.
+	taken := false
+	if !bloom.Default.Authoritative() || bloom.Default.MightContain(rClient, id) {
+		taken, err = links.Exists(rClient, id)
+		if err != nil {
+			return nil, dbError(err, "Unable to connect to server")
+		}
+	}
+	if taken {
+		return nil, errConflict("URL custom short is already in use")
 	}
-	defer rClient2.Close()
 
-	var result string
-	err = rClient2.Do(radix.Cmd(&result, "GET", id))
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error":"Error creating Client"})
+	if body.CustomShort != "" {
+		reserved, err := reservedIn(rClient)(id)
+		if err != nil {
+			return nil, dbError(err, "Unable to connect to server")
+		}
+		if reserved {
+			return nil, errConflict("URL custom short is reserved")
+		}
 	}
-	if result != "" {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"error":"URL custom short is already in use",
-		})
+
+	if body.Campaign != "" {
+		var campaignOwner string
+		err = rClient.Do(radix.Cmd(&campaignOwner, "HGET", links.CampaignKey(body.Campaign), "owner"))
+		if err != nil {
+			return nil, dbError(err, "Unable to connect to server")
+		}
+		if owner == "" || campaignOwner != owner {
+			return nil, fiber.NewError(fiber.StatusNotFound, "campaign not found")
+		}
 	}
 
-	if body.Expiry == 0 {
-		body.Expiry = 24
+	org := ""
+	if owner != "" {
+		if org, err = links.OrgOf(rClient, owner); err != nil {
+			return nil, dbError(err, "Unable to connect to server")
+		}
+	}
+	if org != "" {
+		if reached, err := orgQuotaReached(rClient, org); err != nil {
+			return nil, dbError(err, "Unable to connect to server")
+		} else if reached {
+			return nil, fiber.NewError(fiber.StatusForbidden, "organization link quota reached")
+		}
 	}
 
-	err = rClient2.Do(radix.Cmd(nil, "SET", id, body.URL))
+	ttl, ferr := linkTTL(c, body)
+	if ferr != nil {
+		return nil, ferr
+	}
+	if ttl == 0 {
+		ttl = 24 * time.Hour
+		var hours string
+		if err := rClient.Do(radix.Cmd(&hours, "HGET", links.DefaultsKey(), "expiry_hours")); err == nil {
+			if v, err := strconv.Atoi(hours); err == nil && v > 0 {
+				ttl = time.Duration(v) * time.Hour
+			}
+		}
+	}
+	if cfg := anonymousSettings(); anonymous && ttl > cfg.maxExpiry {
+		ttl = cfg.maxExpiry
+	}
 
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":"Unable to connect to server",
-		})
+	meta, ferr := linkMeta(body, owner, submitted, hops, upgrade)
+	if ferr != nil {
+		return nil, ferr
+	}
+
+	l := pendingLink{
+		Short:   id,
+		Meta:    meta,
+		Expires: clock.Now().Add(ttl),
+		Org:     org,
+		Suggest: body.CustomShort != "" && os.Getenv("SUGGESTIONS_ENABLED") == "true",
+		Checked: true,
+		Event:   events.Link{Short: id, URL: body.URL, Owner: owner, Campaign: body.Campaign},
+		Creation: creation{
+			Short:     id,
+			URL:       body.URL,
+			Owner:     owner,
+			Campaign:  body.Campaign,
+			IP:        privacyFor(rClient, owner).IP(c.IP()),
+			CreatedAt: clock.Now().Unix(),
+		},
 	}
+	pending := false
+	if err := writeLink(rClient, l); err != nil {
+		if pending = journalLink(err, l); !pending {
+			return nil, dbError(err, "Unable to connect to server")
+		}
+	}
+	// Added once the record is written, so that shorts rejected or failing
+	// to be written are no false positives. Journaled shorts are added on
+	// replay.
+	if !pending {
+		if err := bloom.Default.Add(rClient, id); err != nil {
+			return nil, dbError(err, "Unable to connect to server")
+		}
+	}
+	return newResponse(c, body, submitted, id, ttl, l.Expires, pending), nil
+}
 
+// newResponse describes the short id created for body, pending until
+// replayed when journaled.
+func newResponse(c *fiber.Ctx, body *request, submitted, id string, ttl time.Duration, expires time.Time, pending bool) *response {
 	resp := response{
-		URL: body.URL,
-		CustomShort: "",
-		Expiry: body.Expiry,
-		XRateRemaining: 10,
-		XRateLimitReset: 30 * time.Second,
+		URL:                body.URL,
+		Expiry:             expiryIn(ttl, apiVersion(c)),
+		ExpiryUnit:         expiryUnit(apiVersion(c)),
+		ExpiresAt:          expires.Unix(),
+		XRateRemaining:     10,
+		XRateLimitReset:    30 * time.Second,
+		Campaign:           body.Campaign,
+		Title:              body.Title,
+		Description:        body.Description,
+		Passthrough:        body.Passthrough,
+		MaxClicksPerMinute: body.MaxClicksPerMinute,
+		Pending:            pending,
 	}
 
-	resp.CustomShort = os.Getenv("DOMAIN") + "/" + id
+	// Requests without any rate limit keep the values the fields always
+	// had.
+	if rate, ok := callerRate(c); ok {
+		resp.XRateRemaining = int(rate.Remaining)
+		resp.XRateLimitReset = rate.Reset
+	}
+
+	if body.URL != submitted {
+		resp.OriginalURL = submitted
+	}
+
+	resp.CustomShort = os.Getenv("DOMAIN") + "/" + links.DisplayShort(id)
+
+	return &resp
+}
+
+// reservedIn reports whether the first segment of a short is a word of
+// ReservedKey, which no link may take.
+func reservedIn(rClient database.ClientInterface) func(short string) (bool, error) {
+	return func(short string) (bool, error) {
+		var reserved int
+		first, _, _ := strings.Cut(short, "/")
+		err := rClient.Do(radix.Cmd(&reserved, "SISMEMBER", links.ReservedKey(), strings.ToLower(first)))
+
+		return reserved == 1, err
+	}
+}
+
+// schemePolicy returns the schemes owner may shorten, set per account by
+// admins and defaulting to ALLOWED_SCHEMES.
+func schemePolicy(rClient database.ClientInterface, owner string) (destination.SchemePolicy, error) {
+	if owner != "" {
+		var list string
+		if err := rClient.Do(radix.Cmd(&list, "HGET", links.UserKey(owner), "allowed_schemes")); err != nil {
+			return nil, err
+		}
+		if list != "" {
+			return destination.ParseSchemes(list), nil
+		}
+	}
+
+	return destination.DefaultPolicy(), nil
+}
+
+// linkTTL returns the lifetime asked for in body, 0 when it asks for none.
+func linkTTL(c *fiber.Ctx, body *request) (time.Duration, *fiber.Error) {
+	if body.Expiry.set() && !body.ExpiresAt.IsZero() {
+		return 0, errInvalid("expiry and expires_at cannot both be set")
+	}
+	if !body.ExpiresAt.IsZero() {
+		ttl := body.ExpiresAt.Sub(clock.Now())
+		if ttl < time.Second {
+			return 0, errInvalid("expires_at must be in the future")
+		}
+		return ttl, nil
+	}
+	if !body.Expiry.set() {
+		return 0, nil
+	}
+
+	ttl := body.Expiry.ttl(apiVersion(c))
+	if ttl < time.Second {
+		return 0, errInvalid("Expiry must be positive")
+	}
+
+	return ttl, nil
+}
+
+// linkMeta returns the fields of the record of a link created for body.
+func linkMeta(body *request, owner, submitted string, hops int, upgrade string) ([]string, *fiber.Error) {
+	meta := []string{
+		"url", links.EncodeURL(body.URL),
+		"created_at", strconv.FormatInt(clock.Now().Unix(), 10),
+		"campaign", body.Campaign,
+		"title", body.Title,
+		"description", body.Description,
+		"owner", owner,
+	}
+
+	if body.Indexable {
+		meta = append(meta, "indexable", "1")
+	}
+
+	if body.Passthrough {
+		meta = append(meta, "passthrough", "1")
+	}
+
+	if body.MaxClicksPerMinute > 0 {
+		meta = append(meta, "max_rpm", strconv.FormatInt(body.MaxClicksPerMinute, 10))
+	}
+
+	if body.URL != submitted {
+		meta = append(meta, "original_url", submitted, "redirect_hops", strconv.Itoa(hops))
+	}
+
+	if upgrade != "" {
+		meta = append(meta, "https_upgrade", upgrade)
+	}
+
+	if body.Password != "" {
+		hash, err := helpers.HashPassword(body.Password)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusInternalServerError, "Unable to protect link")
+		}
+		meta = append(meta, "password_hash", hash)
+	}
 
-	return c.Status(fiber.StatusOK).JSON(resp)
+	return meta, nil
 }
@@ -0,0 +1,176 @@
+package routes
+
+import (
+	"encoding/xml"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+const sitemapNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapToggle struct {
+	Enabled bool `json:"enabled"`
+}
+
+type sitemapURL struct {
+	Loc string `xml:"loc"`
+}
+
+type urlSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	NS      string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	NS       string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// SetSitemap lets the key owner opt in to, or out of, a public sitemap of
+// their indexable shorts.
+func SetSitemap(c *fiber.Ctx) error {
+	owner := Owner(c)
+	body := new(sitemapToggle)
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	cmd := radix.Cmd(nil, "HDEL", links.UserKey(owner), "sitemap")
+	if body.Enabled {
+		cmd = radix.Cmd(nil, "HSET", links.UserKey(owner), "sitemap", "1")
+	}
+	if err := rClient.Do(cmd); err != nil {
+		return dbError(err, "Unable to update sitemap setting")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"owner": owner, "sitemap": body.Enabled})
+}
+
+// SitemapIndex serves the sitemap index listing the pages of an owner.
+func SitemapIndex(c *fiber.Ctx) error {
+	return serveSitemap(c, 0)
+}
+
+// SitemapPage serves one page of an owner's public shorts.
+func SitemapPage(c *fiber.Ctx) error {
+	page, err := strconv.Atoi(c.Params("page"))
+	if err != nil || page < 1 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "sitemap page not found"})
+	}
+
+	return serveSitemap(c, page)
+}
+
+// serveSitemap renders lazily and caches each page for SITEMAP_CACHE_TTL.
+func serveSitemap(c *fiber.Ctx, page int) error {
+	owner := c.Params("owner")
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	var enabled string
+	if err := rClient.Do(radix.Cmd(&enabled, "HGET", links.UserKey(owner), "sitemap")); err != nil || enabled != "1" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "sitemap not found"})
+	}
+
+	if cached, err := database.GetString(rClient, links.SitemapKey(owner, page)); err == nil && cached != "" {
+		return sendXML(c, cached)
+	}
+
+	shorts, err := publicShorts(rClient, owner)
+	if err != nil {
+		return dbError(err, "Unable to build sitemap")
+	}
+
+	size := 1000
+	if v, err := strconv.Atoi(os.Getenv("SITEMAP_PAGE_SIZE")); err == nil && v > 0 && v <= 50000 {
+		size = v
+	}
+	pages := (len(shorts) + size - 1) / size
+	base := c.Protocol() + "://" + os.Getenv("DOMAIN")
+
+	var doc any
+	if page == 0 {
+		index := sitemapIndex{NS: sitemapNS}
+		for i := 1; i <= pages; i++ {
+			index.Sitemaps = append(index.Sitemaps, sitemapURL{Loc: base + "/sitemaps/" + owner + "/" + strconv.Itoa(i)})
+		}
+		doc = index
+	} else {
+		if page > pages {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "sitemap page not found"})
+		}
+		set := urlSet{NS: sitemapNS}
+		for _, short := range shorts[(page-1)*size : min(page*size, len(shorts))] {
+			set.URLs = append(set.URLs, sitemapURL{Loc: base + "/" + short})
+		}
+		doc = set
+	}
+
+	out, err := xml.Marshal(doc)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to build sitemap"})
+	}
+	rendered := xml.Header + string(out)
+
+	ttl := time.Hour
+	if v, err := time.ParseDuration(os.Getenv("SITEMAP_CACHE_TTL")); err == nil && v > 0 {
+		ttl = v
+	}
+	_ = rClient.Do(radix.Cmd(nil, "SET", links.SitemapKey(owner, page), rendered, "EX", strconv.FormatInt(int64(ttl/time.Second), 10)))
+
+	return sendXML(c, rendered)
+}
+
+// publicShorts returns the owner's shorts that opted in to indexing and
+// aren't protected or disabled, sorted for stable pagination.
+func publicShorts(rClient database.ClientInterface, owner string) ([]string, error) {
+	var shorts []string
+	if err := rClient.Do(radix.Cmd(&shorts, "SMEMBERS", links.UserLinksKey(owner))); err != nil {
+		return nil, err
+	}
+	sort.Strings(shorts)
+
+	metas := make([][]string, len(shorts))
+	p := radix.NewPipeline()
+	for i, short := range shorts {
+		p.Append(links.FieldsCmd(&metas[i], short, "indexable", "password_hash", "disabled"))
+	}
+	if err := rClient.Do(p); err != nil {
+		return nil, err
+	}
+
+	public := shorts[:0]
+	for i, short := range shorts {
+		if metas[i][0] == "1" && metas[i][1] == "" && metas[i][2] != "1" {
+			public = append(public, short)
+		}
+	}
+
+	return public, nil
+}
+
+func sendXML(c *fiber.Ctx, body string) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationXMLCharsetUTF8)
+
+	return c.Status(fiber.StatusOK).SendString(body)
+}
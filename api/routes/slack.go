@@ -0,0 +1,211 @@
+package routes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/helpers"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// slackMaxSkew is how old a signed Slack request may be, as recommended by
+// Slack to defeat replays.
+const slackMaxSkew = 5 * time.Minute
+
+// slackConnectTTL is how long a connect code stays valid.
+const slackConnectTTL = 10 * time.Minute
+
+type slackConfig struct {
+	secret    []byte
+	anonymous bool
+}
+
+// slackSettings reads SLACK_* lazily so that the .env file is loaded first.
+var slackSettings = sync.OnceValue(func() slackConfig {
+	return slackConfig{
+		secret:    []byte(os.Getenv("SLACK_SIGNING_SECRET")),
+		anonymous: os.Getenv("SLACK_ALLOW_ANONYMOUS") == "true",
+	}
+})
+
+const slackHelp = "Usage: `/short <url> [custom short]` to shorten a URL, `/short connect` to link your account."
+
+// SlackCommand implements the Slack slash command contract: "<url>
+// [custom short]" shortens a URL on behalf of the shortener user the Slack
+// user is connected to, "connect" hands out a code to connect one. Replies
+// are ephemeral, only the caller sees them. Requests must be signed with
+// SLACK_SIGNING_SECRET.
+func SlackCommand(c *fiber.Ctx) error {
+	cfg := slackSettings()
+	if len(cfg.secret) == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "slack integration is disabled"})
+	}
+	if !validSlackSignature(cfg.secret, c.Get("X-Slack-Request-Timestamp"), c.Body(), c.Get("X-Slack-Signature")) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid signature"})
+	}
+
+	form, err := url.ParseQuery(string(c.Body()))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse form"})
+	}
+	user := form.Get("team_id") + ":" + form.Get("user_id")
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return slackReply(c, "The shortener is unavailable, try again later.")
+	}
+
+	args := strings.Fields(form.Get("text"))
+	switch {
+	case len(args) == 0 || args[0] == "help":
+		return slackReply(c, slackHelp)
+	case args[0] == "connect":
+		return slackConnect(c, rClient, user)
+	case len(args) > 2:
+		return slackReply(c, slackHelp)
+	}
+
+	var owner string
+	if err := rClient.Do(radix.Cmd(&owner, "HGET", links.SlackUsersKey(), user)); err != nil {
+		return slackReply(c, "The shortener is unavailable, try again later.")
+	}
+	if owner == "" && !cfg.anonymous {
+		return slackReply(c, "Your Slack account is not connected yet, run `/short connect` first.")
+	}
+
+	if err := writable(c); err != nil {
+		return slackReply(c, errReadOnly().Message)
+	}
+	if err := creationPaused(c); err != nil {
+		return slackReply(c, errMaintenance().Message)
+	}
+
+	body := &request{URL: args[0]}
+	if len(args) == 2 {
+		body.CustomShort = args[1]
+	}
+	// Slack wraps URLs as <https://example.com|example.com>.
+	body.URL, _, _ = strings.Cut(strings.Trim(body.URL, "<>"), "|")
+
+	c.Locals("owner", owner)
+	resp, ferr := shorten(c, body)
+	if ferr != nil {
+		return slackReply(c, "Could not shorten "+body.URL+": "+ferr.Message)
+	}
+
+	return slackReply(c, resp.CustomShort)
+}
+
+// slackConnect hands out a one-time code connecting the Slack user to the
+// shortener user redeeming it with ConnectSlack.
+func slackConnect(c *fiber.Ctx, rClient database.ClientInterface, user string) error {
+	code, err := helpers.RandomToken(6)
+	if err != nil {
+		return slackReply(c, "Could not create a connect code, try again.")
+	}
+
+	err = rClient.Do(radix.Cmd(nil, "SET", links.SlackConnectKey(code), user,
+		"EX", strconv.Itoa(int(slackConnectTTL/time.Second))))
+	if err != nil {
+		return slackReply(c, "The shortener is unavailable, try again later.")
+	}
+
+	return slackReply(c, "Your connect code is `"+code+"`, valid for 10 minutes. "+
+		"Enter it in the dashboard or POST it as {\"code\": ...} to /api/v1/integrations/slack/connect with your API key.")
+}
+
+// ConnectSlack redeems a code from `/short connect`, making the Slack user
+// act as the caller from then on.
+func ConnectSlack(c *fiber.Ctx) error {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	if body.Code == "" {
+		return errInvalid("code is required")
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return errUnavailable()
+	}
+
+	var user string
+	if err := rClient.Do(radix.Cmd(&user, "GETDEL", links.SlackConnectKey(body.Code))); err != nil {
+		return dbError(err, "Unable to connect Slack account")
+	}
+	if user == "" {
+		return fiber.NewError(fiber.StatusNotFound, "unknown or expired code")
+	}
+
+	if err := rClient.Do(radix.Cmd(nil, "HSET", links.SlackUsersKey(), user, Owner(c))); err != nil {
+		return dbError(err, "Unable to connect Slack account")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// SetSlackUser maps a Slack user, given as team and user id, to an owner,
+// or removes the mapping for an empty owner.
+func SetSlackUser(c *fiber.Ctx) error {
+	var body struct {
+		Owner string `json:"owner"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return errUnavailable()
+	}
+
+	user := c.Params("team") + ":" + c.Params("user")
+	cmd := radix.Cmd(nil, "HSET", links.SlackUsersKey(), user, body.Owner)
+	if body.Owner == "" {
+		cmd = radix.Cmd(nil, "HDEL", links.SlackUsersKey(), user)
+	}
+	if err := rClient.Do(cmd); err != nil {
+		return dbError(err, "Unable to map Slack user")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// validSlackSignature checks the v0 signature Slack sends with every
+// request: an HMAC-SHA256 of "v0:<timestamp>:<body>".
+func validSlackSignature(secret []byte, timestamp string, body []byte, signature string) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := clock.Now().Sub(time.Unix(ts, 0)); skew > slackMaxSkew || skew < -slackMaxSkew {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(want), []byte(signature))
+}
+
+// slackReply answers a slash command with a message only the caller sees.
+// Slack shows nothing for non-200 responses, so errors are replies too.
+func slackReply(c *fiber.Ctx, text string) error {
+	return c.JSON(fiber.Map{"response_type": "ephemeral", "text": text})
+}
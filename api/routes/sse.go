@@ -0,0 +1,70 @@
+package routes
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// sseWriteTimeout bounds each write of an event stream. The server's
+// WriteTimeout covers the whole response, which an event stream outlives.
+const sseWriteTimeout = 30 * time.Second
+
+// eventWriter writes server-sent events to a client.
+type eventWriter struct {
+	w    *bufio.Writer
+	conn net.Conn
+}
+
+// streamEvents answers with a text/event-stream fed by stream, which runs
+// after the handler returns and must stop as soon as a write fails, the
+// client having gone away.
+func streamEvents(c *fiber.Ctx, stream func(e *eventWriter) error) error {
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	// Keep reverse proxies from buffering the stream.
+	c.Set("X-Accel-Buffering", "no")
+
+	conn := c.Context().Conn()
+	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		_ = stream(&eventWriter{w: w, conn: conn})
+	}))
+
+	return nil
+}
+
+// Event writes one server-sent event and flushes it.
+func (e *eventWriter) Event(id, event string, data []byte) error {
+	if id != "" {
+		fmt.Fprintf(e.w, "id: %s\n", id)
+	}
+	if event != "" {
+		fmt.Fprintf(e.w, "event: %s\n", event)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fmt.Fprintf(e.w, "data: %s\n", line)
+	}
+	e.w.WriteString("\n")
+
+	return e.flush()
+}
+
+// Heartbeat writes an SSE comment keeping idle connections open through
+// proxies.
+func (e *eventWriter) Heartbeat() error {
+	e.w.WriteString(": keepalive\n\n")
+
+	return e.flush()
+}
+
+func (e *eventWriter) flush() error {
+	_ = e.conn.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+
+	return e.w.Flush()
+}
@@ -0,0 +1,232 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/helpers"
+	"github.com/ksarpe/redis-golang/jwt"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/lockout"
+	"github.com/ksarpe/redis-golang/oidc"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// loginTTL bounds the time between starting a login and the callback.
+const loginTTL = 10 * time.Minute
+
+type tokenConfig struct {
+	secret []byte
+	ttl    time.Duration
+	issuer string
+}
+
+// tokenSettings is read lazily so that the .env file is loaded first.
+// Without JWT_SECRET no tokens are issued nor accepted.
+var tokenSettings = sync.OnceValue(func() tokenConfig {
+	cfg := tokenConfig{
+		secret: []byte(os.Getenv("JWT_SECRET")),
+		ttl:    12 * time.Hour,
+		issuer: os.Getenv("DOMAIN"),
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("JWT_TTL")); err == nil && ttl > 0 {
+		cfg.ttl = ttl
+	}
+
+	return cfg
+})
+
+// ssoProvider is nil unless OIDC_ISSUER, OIDC_CLIENT_ID and JWT_SECRET are
+// set.
+var ssoProvider = sync.OnceValue(func() *oidc.Provider {
+	cfg := oidc.ConfigFromEnv()
+	if !cfg.Enabled() || len(tokenSettings().secret) == 0 {
+		return nil
+	}
+
+	return oidc.New(cfg)
+})
+
+// pendingLogin is kept in redis between the redirect to the provider and
+// the callback.
+type pendingLogin struct {
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	ReturnTo string `json:"return_to,omitempty"`
+}
+
+// SSOLogin sends the browser to the identity provider. return_to, when
+// allowed by OIDC_RETURN_URLS, receives the token in its fragment after the
+// login, e.g. the dashboard.
+func SSOLogin(c *fiber.Ctx) error {
+	provider := ssoProvider()
+	if provider == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": oidc.ErrNotConfigured.Error()})
+	}
+
+	returnTo := c.Query("return_to")
+	if returnTo != "" && !allowedReturn(returnTo) {
+		return errInvalid("return_to is not allowed")
+	}
+
+	var login pendingLogin
+	var state string
+	var err error
+	for _, token := range []*string{&state, &login.Nonce, &login.Verifier} {
+		if *token, err = helpers.RandomToken(32); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to start login"})
+		}
+	}
+	login.ReturnTo = returnTo
+
+	authURL, err := provider.AuthURL(c.Context(), state, login.Nonce, login.Verifier)
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "identity provider unavailable"})
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	data, _ := json.Marshal(login)
+	seconds := strconv.FormatInt(int64(loginTTL/time.Second), 10)
+	if err := rClient.Do(radix.Cmd(nil, "SET", links.LoginStateKey(state), string(data), "EX", seconds)); err != nil {
+		return dbError(err, "Unable to start login")
+	}
+
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// SSOCallback finishes the login started by SSOLogin, starts a dashboard
+// session and issues an API token for the user. Users are identified by
+// their email address, or by their subject at the provider when it has
+// none.
+func SSOCallback(c *fiber.Ctx) error {
+	provider := ssoProvider()
+	if provider == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": oidc.ErrNotConfigured.Error()})
+	}
+
+	if reason := c.Query("error"); reason != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "login failed: " + reason})
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	if d := lockout.Locked(rClient, lockoutConfig(), lockout.IP(c.IP())); d > 0 {
+		return lockedOut(c, d)
+	}
+
+	// GETDEL makes the state single use.
+	var data string
+	if err := rClient.Do(radix.Cmd(&data, "GETDEL", links.LoginStateKey(c.Query("state")))); err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	var login pendingLogin
+	if data == "" || json.Unmarshal([]byte(data), &login) != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "login expired, please try again"})
+	}
+
+	identity, err := provider.Exchange(c.Context(), c.Query("code"), login.Verifier, login.Nonce)
+	if errors.Is(err, oidc.ErrInvalidToken) || errors.Is(err, oidc.ErrDomain) {
+		lockout.Fail(rClient, lockoutConfig(), lockout.IP(c.IP()))
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "identity provider unavailable"})
+	}
+
+	owner := strings.ToLower(identity.Email)
+	if owner == "" {
+		owner = identity.Subject
+	}
+
+	now := clock.Now()
+	err = rClient.Do(radix.Cmd(nil, "HSET", links.UserKey(owner),
+		"email", identity.Email,
+		"sso_subject", identity.Subject,
+		"last_login_at", strconv.FormatInt(now.Unix(), 10)))
+	if err != nil {
+		return dbError(err, "Unable to record login")
+	}
+
+	if err := startSession(c, rClient, owner); err != nil {
+		return dbError(err, "Unable to start session")
+	}
+
+	token, claims, err := issueToken(owner, identity, now)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to issue token"})
+	}
+
+	if login.ReturnTo != "" {
+		fragment := url.Values{"token": {token}, "expires_at": {strconv.FormatInt(claims.ExpiresAt, 10)}}
+		return c.Redirect(login.ReturnTo+"#"+fragment.Encode(), fiber.StatusFound)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"token":      token,
+		"token_type": "Bearer",
+		"owner":      owner,
+		"expires_at": claims.ExpiresAt,
+	})
+}
+
+func issueToken(owner string, identity *oidc.Identity, now time.Time) (string, jwt.Claims, error) {
+	cfg := tokenSettings()
+
+	id, err := helpers.RandomToken(12)
+	if err != nil {
+		return "", jwt.Claims{}, err
+	}
+
+	claims := jwt.Claims{
+		Issuer:    cfg.issuer,
+		Subject:   owner,
+		Email:     identity.Email,
+		Name:      identity.Name,
+		ID:        id,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(cfg.ttl).Unix(),
+	}
+	token, err := jwt.Sign(claims, cfg.secret)
+
+	return token, claims, err
+}
+
+// allowedReturn reports whether the login may redirect to u, which must
+// have the scheme and host of one of the comma separated OIDC_RETURN_URLS
+// and a path below it.
+func allowedReturn(u string) bool {
+	target, err := url.Parse(u)
+	if err != nil || target.User != nil || target.Fragment != "" {
+		return false
+	}
+
+	for _, allowed := range strings.Split(os.Getenv("OIDC_RETURN_URLS"), ",") {
+		prefix, err := url.Parse(strings.TrimSpace(allowed))
+		if err != nil || prefix.Host == "" {
+			continue
+		}
+		if target.Scheme == prefix.Scheme && target.Host == prefix.Host && strings.HasPrefix(target.Path, prefix.Path) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,70 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/top"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// maxTopLimit caps the length of a leaderboard.
+const maxTopLimit = 100
+
+// TopLinks lists the most clicked shorts of a period, ?period=7d by
+// default, for dashboards and trending views. Disabled, protected and
+// deleted shorts are left out.
+func TopLinks(c *fiber.Ctx) error {
+	period := c.Query("period", "7d")
+	days, err := top.ParsePeriod(period)
+	if err != nil {
+		return errInvalid(err.Error())
+	}
+
+	limit := c.QueryInt("limit", 10)
+	if limit < 1 || limit > maxTopLimit {
+		return errInvalid("limit must be between 1 and 100")
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return errUnavailable()
+	}
+
+	// Fetch extra entries to make up for the hidden shorts.
+	entries, err := top.Top(rClient, days, 2*limit)
+	if err != nil {
+		return dbError(err, "Unable to load top links")
+	}
+
+	fields := make([][]string, len(entries))
+	p := radix.NewPipeline()
+	for i, entry := range entries {
+		p.Append(links.FieldsCmd(&fields[i], entry.Short, "url", "disabled", "password_hash"))
+	}
+	if err := rClient.Do(p); err != nil {
+		return dbError(err, "Unable to load top links")
+	}
+
+	visible := make([]top.Entry, 0, limit)
+	for i, entry := range entries {
+		url, disabled, protected := fields[i][0], fields[i][1], fields[i][2]
+		if url == "" {
+			meta, err := links.Load(rClient, entry.Short)
+			if err != nil {
+				return dbError(err, "Unable to load top links")
+			}
+			url, disabled, protected = meta["url"], meta["disabled"], meta["password_hash"]
+		}
+		if url == "" || disabled == "1" || protected != "" {
+			continue
+		}
+
+		visible = append(visible, entry)
+		if len(visible) == limit {
+			break
+		}
+	}
+
+	return c.JSON(fiber.Map{"period": period, "links": visible})
+}
@@ -0,0 +1,254 @@
+package routes
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/events"
+	"github.com/ksarpe/redis-golang/helpers"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+type transferRequest struct {
+	Short    string `json:"short"`
+	Campaign string `json:"campaign"`
+	To       string `json:"to"`
+}
+
+type transfer struct {
+	ID        string `json:"id"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Short     string `json:"short,omitempty"`
+	Campaign  string `json:"campaign,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// transferTTL is read lazily so that the .env file is loaded first.
+var transferTTL = sync.OnceValue(func() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("TRANSFER_TTL"))
+	if err != nil || ttl <= 0 {
+		ttl = 72 * time.Hour
+	}
+
+	return ttl
+})
+
+// CreateTransfer offers a short, or every short of a campaign owned by the
+// caller, to another owner. Nothing moves until the recipient accepts.
+func CreateTransfer(c *fiber.Ctx) error {
+	body := new(transferRequest)
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	owner := Owner(c)
+	if body.To == "" || body.To == owner {
+		return errInvalid("a recipient other than yourself is required")
+	}
+	if (body.Short == "") == (body.Campaign == "") {
+		return errInvalid("either short or campaign is required")
+	}
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	t := transfer{From: owner, To: body.To, Campaign: body.Campaign}
+	if body.Short != "" {
+		if t.Short, err = links.ParseShort(body.Short); err != nil {
+			return errInvalid(err.Error())
+		}
+	}
+
+	shorts, err := transferShorts(rClient, t)
+	if err != nil {
+		return dbError(err, "Unable to create transfer")
+	}
+	if len(shorts) == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "no links of yours to transfer"})
+	}
+
+	if t.ID, err = helpers.RandomToken(12); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to create transfer"})
+	}
+	now := clock.Now()
+	t.CreatedAt = now.Unix()
+	t.ExpiresAt = now.Add(transferTTL()).Unix()
+
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "HSET", links.TransferKey(t.ID),
+		"from", t.From,
+		"to", t.To,
+		"short", t.Short,
+		"campaign", t.Campaign,
+		"created_at", strconv.FormatInt(t.CreatedAt, 10),
+		"expires_at", strconv.FormatInt(t.ExpiresAt, 10)))
+	p.Append(radix.Cmd(nil, "EXPIREAT", links.TransferKey(t.ID), strconv.FormatInt(t.ExpiresAt, 10)))
+	p.Append(radix.Cmd(nil, "SADD", links.UserTransfersKey(t.To), t.ID))
+	if err := rClient.Do(p); err != nil {
+		return dbError(err, "Unable to create transfer")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(t)
+}
+
+// ListTransfers returns the pending transfers offered to the caller.
+func ListTransfers(c *fiber.Ctx) error {
+	owner := Owner(c)
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	var ids []string
+	if err := rClient.Do(radix.Cmd(&ids, "SMEMBERS", links.UserTransfersKey(owner))); err != nil {
+		return dbError(err, "Unable to read transfers")
+	}
+
+	transfers := []transfer{}
+	for _, id := range ids {
+		t, err := loadTransfer(rClient, id)
+		if err != nil {
+			return dbError(err, "Unable to read transfers")
+		}
+		if t == nil {
+			// Expired, drop it from the index.
+			_ = rClient.Do(radix.Cmd(nil, "SREM", links.UserTransfersKey(owner), id))
+			continue
+		}
+		transfers = append(transfers, *t)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"transfers": transfers})
+}
+
+// AcceptTransfer moves the offered shorts to the caller, who must be the
+// recipient. Shorts the sender deleted or gave away meanwhile are skipped.
+func AcceptTransfer(c *fiber.Ctx) error {
+	owner := Owner(c)
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	t, err := loadTransfer(rClient, c.Params("id"))
+	if err != nil {
+		return dbError(err, "Unable to accept transfer")
+	}
+	if t == nil || t.To != owner {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "transfer not found"})
+	}
+
+	shorts, err := transferShorts(rClient, *t)
+	if err != nil {
+		return dbError(err, "Unable to accept transfer")
+	}
+
+	moved, err := links.Transfer(rClient, t.ID, t.From, t.To, t.Campaign, shorts)
+	if errors.Is(err, links.ErrTransferNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "transfer not found"})
+	}
+	if err != nil {
+		return dbError(err, "Unable to accept transfer")
+	}
+
+	for _, short := range moved {
+		events.Emit("link.transferred", events.Link{Short: short, Owner: t.To, Campaign: t.Campaign})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"id": t.ID, "owner": t.To, "moved": moved})
+}
+
+// CancelTransfer withdraws a transfer when called by the sender and
+// declines it when called by the recipient.
+func CancelTransfer(c *fiber.Ctx) error {
+	owner := Owner(c)
+
+	rClient, err := database.NewDefaultClient()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+	defer rClient.Close()
+
+	t, err := loadTransfer(rClient, c.Params("id"))
+	if err != nil {
+		return dbError(err, "Unable to cancel transfer")
+	}
+	if t == nil || (t.From != owner && t.To != owner) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "transfer not found"})
+	}
+
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "DEL", links.TransferKey(t.ID)))
+	p.Append(radix.Cmd(nil, "SREM", links.UserTransfersKey(t.To), t.ID))
+	if err := rClient.Do(p); err != nil {
+		return dbError(err, "Unable to cancel transfer")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// transferShorts returns the shorts of a transfer still owned by its
+// sender, loading them so v1 links are migrated before the transfer
+// script writes to them.
+func transferShorts(rClient database.ClientInterface, t transfer) ([]string, error) {
+	var candidates []string
+	if t.Campaign == "" {
+		candidates = []string{t.Short}
+	} else if err := rClient.Do(radix.Cmd(&candidates, "SMEMBERS", links.CampaignLinksKey(t.Campaign))); err != nil {
+		return nil, err
+	}
+
+	var shorts []string
+	for _, short := range candidates {
+		meta, err := links.Load(rClient, short)
+		if err != nil {
+			return nil, err
+		}
+		if meta != nil && meta["owner"] == t.From {
+			shorts = append(shorts, short)
+		}
+	}
+
+	return shorts, nil
+}
+
+func loadTransfer(rClient database.ClientInterface, id string) (*transfer, error) {
+	var fields map[string]string
+	err := database.HGetAll(rClient, links.TransferKey(id), &fields)
+	if errors.Is(err, database.ErrNil) || err == nil && fields["to"] == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+	expiresAt, _ := strconv.ParseInt(fields["expires_at"], 10, 64)
+
+	return &transfer{
+		ID:        id,
+		From:      fields["from"],
+		To:        fields["to"],
+		Short:     fields["short"],
+		Campaign:  fields["campaign"],
+		CreatedAt: createdAt,
+		ExpiresAt: expiresAt,
+	}, nil
+}
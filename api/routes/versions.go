@@ -0,0 +1,75 @@
+package routes
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LatestVersion is the newest version of the API.
+const LatestVersion = 2
+
+const versionLocal = "api_version"
+
+// APIVersion returns the middleware of the /api/v<version> route group.
+// Handlers are shared between versions and shape their answers with
+// apiVersion. A version with API_V<version>_SUNSET set, as RFC 3339, is
+// deprecated: its responses carry the Deprecation and Sunset headers and
+// link to the latest version.
+func APIVersion(version int) fiber.Handler {
+	prefix := "/api/v" + strconv.Itoa(version)
+
+	var sunset time.Time
+	if v := os.Getenv("API_V" + strconv.Itoa(version) + "_SUNSET"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err == nil {
+			sunset = t
+		}
+	}
+
+	return func(c *fiber.Ctx) error {
+		c.Locals(versionLocal, version)
+
+		if !sunset.IsZero() {
+			c.Set("Deprecation", "true")
+			c.Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			successor := "/api/v" + strconv.Itoa(LatestVersion) + strings.TrimPrefix(c.Path(), prefix)
+			c.Append(fiber.HeaderLink, "<"+successor+`>; rel="successor-version"`)
+		}
+
+		return c.Next()
+	}
+}
+
+// apiVersion returns the version of the API serving the request, 1 outside
+// of the versioned routes.
+func apiVersion(c *fiber.Ctx) int {
+	if v, ok := c.Locals(versionLocal).(int); ok {
+		return v
+	}
+
+	return 1
+}
+
+// apiPath returns the path of an API request below its version prefix, ""
+// for the root of a version, and false for requests outside of the API.
+func apiPath(path string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, "/api/v")
+	if !ok {
+		return "", false
+	}
+
+	i := 0
+	for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+		i++
+	}
+	if i == 0 || (i < len(rest) && rest[i] != '/') {
+		return "", false
+	}
+
+	return strings.TrimSuffix(rest[i:], "/"), true
+}
@@ -0,0 +1,174 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	"github.com/ksarpe/redis-golang/top"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// warmChannel carries the shorts warmed by WarmLinks and the owners of
+// their brandings, so that every process warms its own caches.
+const warmChannel = "warm:links"
+
+// maxWarmShorts caps the shorts of one warm-up request.
+const maxWarmShorts = 1000
+
+type warmed struct {
+	Shorts []string `json:"shorts"`
+	Owners []string `json:"owners"`
+}
+
+// WarmLinks prepares shorts for a spike of traffic, such as a marketing
+// blast: their records are read, migrating v1 links and refreshing their
+// keys for LRU eviction, they are dropped from the negative caches, and
+// every process reloads the brandings of their owners. There is no local
+// cache of records nor reads from replicas to warm beyond that.
+func WarmLinks(c *fiber.Ctx) error {
+	var body struct {
+		Shorts []string `json:"shorts"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return errInvalid("Cannot parse JSON")
+	}
+	if len(body.Shorts) == 0 || len(body.Shorts) > maxWarmShorts {
+		return errInvalid("shorts must list between 1 and 1000 shorts")
+	}
+
+	rClient, err := database.Bulk()
+	if err != nil {
+		return errUnavailable()
+	}
+
+	w, missing, err := warmLinks(rClient, body.Shorts)
+	if err != nil {
+		return dbError(err, "Unable to warm links")
+	}
+
+	payload, _ := json.Marshal(w)
+	if err := rClient.Do(radix.Cmd(nil, "PUBLISH", warmChannel, string(payload))); err != nil {
+		return dbError(err, "Unable to warm links")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"warmed": len(w.Shorts), "owners": len(w.Owners), "missing": missing})
+}
+
+// warmLinks reads the records of shorts and drops the existing ones from
+// the negative caches. It returns them with their owners, and the missing
+// shorts.
+func warmLinks(rClient database.ClientInterface, shorts []string) (warmed, []string, error) {
+	w := warmed{Shorts: []string{}, Owners: []string{}}
+	missing := []string{}
+
+	fields := make([][]string, len(shorts))
+	p := radix.NewPipeline()
+	for i, short := range shorts {
+		p.Append(links.FieldsCmd(&fields[i], short, "url", "owner"))
+	}
+	if err := rClient.Do(p); err != nil {
+		return w, nil, err
+	}
+
+	owners := map[string]bool{}
+	for i, short := range shorts {
+		url, owner := fields[i][0], fields[i][1]
+		if url == "" {
+			meta, err := links.Load(rClient, short)
+			if err != nil {
+				return w, nil, err
+			}
+			url, owner = meta["url"], meta["owner"]
+		}
+		if url == "" {
+			missing = append(missing, short)
+			continue
+		}
+
+		if err := links.Forget(rClient, short); err != nil {
+			return w, nil, err
+		}
+		w.Shorts = append(w.Shorts, short)
+		if owner != "" && !owners[owner] {
+			owners[owner] = true
+			w.Owners = append(w.Owners, owner)
+		}
+	}
+
+	return w, missing, nil
+}
+
+// warmLocal warms the caches of this process for w, reloading the
+// brandings even when still cached so that they last BRANDING_CACHE_TTL
+// from now.
+func warmLocal(rClient database.ClientInterface, w warmed) {
+	for _, short := range w.Shorts {
+		links.ForgetLocal(short)
+	}
+	for _, owner := range w.Owners {
+		brands.Delete(owner)
+		brandFor(rClient, owner)
+	}
+}
+
+// RunWarmer warms the top n links of all time in this process, then the
+// links of every WarmLinks request until ctx is done. It runs in every
+// process, n of 0 skips the startup warm-up.
+func RunWarmer(ctx context.Context, n int) {
+	if n > 0 {
+		if err := warmTop(n); err != nil {
+			log.Printf("warm: %v", err)
+		}
+	}
+
+	for ctx.Err() == nil {
+		err := database.Subscribe(ctx, warmChannel, func(message []byte) {
+			var w warmed
+			if err := json.Unmarshal(message, &w); err != nil {
+				return
+			}
+			rClient, err := database.Shared()
+			if err != nil {
+				return
+			}
+			warmLocal(rClient, w)
+		})
+		if err != nil {
+			log.Printf("warm: %v", err)
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+func warmTop(n int) error {
+	rClient, err := database.Bulk()
+	if err != nil {
+		return err
+	}
+
+	entries, err := top.Top(rClient, 0, n)
+	if err != nil {
+		return err
+	}
+	shorts := make([]string, len(entries))
+	for i, entry := range entries {
+		shorts[i] = entry.Short
+	}
+	if len(shorts) == 0 {
+		return nil
+	}
+
+	w, _, err := warmLinks(rClient, shorts)
+	if err != nil {
+		return err
+	}
+	warmLocal(rClient, w)
+	log.Printf("warm: %d top links, %d brandings", len(w.Shorts), len(w.Owners))
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package routes
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/webhooks"
+)
+
+// maxDeliveries bounds one page of a delivery log.
+const maxDeliveries = 100
+
+// ListWebhooks returns the configured webhooks and their ids.
+func ListWebhooks(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"webhooks": webhooks.Webhooks()})
+}
+
+// WebhookDeliveries returns the last ?limit= delivery attempts of a
+// webhook, newest first, with their status codes and errors.
+func WebhookDeliveries(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 20)
+	if limit <= 0 || limit > maxDeliveries {
+		limit = maxDeliveries
+	}
+
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	attempts, err := webhooks.Deliveries(rClient, c.Params("id"), limit)
+	if errors.Is(err, webhooks.ErrUnknownWebhook) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return dbError(err, "Unable to read deliveries")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"deliveries": attempts})
+}
+
+// RedeliverWebhook sends a logged delivery again and returns the outcome
+// of the new attempt.
+func RedeliverWebhook(c *fiber.Ctx) error {
+	rClient, err := database.Shared()
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cannot connect to DB"})
+	}
+
+	attempt, err := webhooks.Redeliver(rClient, c.Params("id"), c.Params("attempt"))
+	if errors.Is(err, webhooks.ErrUnknownWebhook) || errors.Is(err, webhooks.ErrUnknownDelivery) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return dbError(err, "Unable to redeliver")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(attempt)
+}
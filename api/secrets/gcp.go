@@ -0,0 +1,108 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// metadataTokenURL serves access tokens of the service account of the
+// instance, pod (with workload identity) or Cloud Run service.
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+var errNotFound = errors.New("not found")
+
+// GCPProvider reads the latest version of each setting from Google Cloud
+// Secret Manager, the secret being named after the setting with
+// underscores replaced by dashes and Prefix prepended, so DB_PASS is
+// read from <prefix>db-pass.
+type GCPProvider struct {
+	Project string
+	Prefix  string
+
+	Client *http.Client
+}
+
+func gcpFromEnv() *GCPProvider {
+	return &GCPProvider{
+		Project: os.Getenv("GCP_SECRETS_PROJECT"),
+		Prefix:  os.Getenv("GCP_SECRETS_PREFIX"),
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Secret implements Provider.
+func (g *GCPProvider) Secret(ctx context.Context, name string) (string, error) {
+	token, err := g.token(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	secret := g.Prefix + strings.ReplaceAll(strings.ToLower(name), "_", "-")
+	endpoint := "https://secretmanager.googleapis.com/v1/projects/" + url.PathEscape(g.Project) +
+		"/secrets/" + url.PathEscape(secret) + "/versions/latest:access"
+
+	var body struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	err = g.get(ctx, endpoint, map[string]string{"Authorization": "Bearer " + token}, &body)
+	if errors.Is(err, errNotFound) {
+		// Missing secrets read as empty, like unset settings.
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to access secret %s, err: %w", secret, err)
+	}
+
+	value, err := base64.StdEncoding.DecodeString(body.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode secret %s, err: %w", secret, err)
+	}
+
+	return string(value), nil
+}
+
+func (g *GCPProvider) token(ctx context.Context) (string, error) {
+	var body struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := g.get(ctx, metadataTokenURL, map[string]string{"Metadata-Flavor": "Google"}, &body); err != nil {
+		return "", fmt.Errorf("failed to get an access token from the metadata server, err: %w", err)
+	}
+
+	return body.AccessToken, nil
+}
+
+func (g *GCPProvider) get(ctx context.Context, endpoint string, header map[string]string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status: %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
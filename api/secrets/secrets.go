@@ -0,0 +1,117 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var errUnknownProvider = errors.New("unknown secrets provider")
+
+// Provider returns the current value of a named secret, such as DB_PASS
+// or DB_TLS_CA_CERT.
+type Provider interface {
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// Remote reports whether p stores the secrets themselves, TLS material
+// included, rather than pointing at files.
+func Remote(p Provider) bool {
+	_, local := p.(FileProvider)
+
+	return !local
+}
+
+// FromEnv returns the provider selected by SECRETS_PROVIDER: file, the
+// default, vault or gcp.
+func FromEnv() (Provider, error) {
+	switch os.Getenv("SECRETS_PROVIDER") {
+	case "", "file":
+		return FileProvider{}, nil
+	case "vault":
+		return vaultFromEnv(), nil
+	case "gcp":
+		return gcpFromEnv(), nil
+	}
+
+	return nil, errUnknownProvider
+}
+
+// FileProvider reads a secret from the file NAME_FILE points to, as
+// mounted by Kubernetes or Docker secrets, falling back to the NAME
+// setting. Files are read on every call so rotations are picked up.
+type FileProvider struct{}
+
+// Secret implements Provider.
+func (FileProvider) Secret(_ context.Context, name string) (string, error) {
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return os.Getenv(name), nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(raw), "\r\n"), nil
+}
+
+// Value is a secret kept up to date by polling its provider.
+type Value struct {
+	provider Provider
+	name     string
+	current  atomic.Pointer[string]
+}
+
+// Watch reads the secret name, then polls p for it every interval until
+// ctx is cancelled. A failed poll keeps the last known value.
+func Watch(ctx context.Context, p Provider, name string, interval time.Duration) (*Value, error) {
+	v := &Value{provider: p, name: name}
+
+	secret, err := p.Secret(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	v.current.Store(&secret)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				v.refresh(ctx)
+			}
+		}
+	}()
+
+	return v, nil
+}
+
+func (v *Value) refresh(ctx context.Context) {
+	secret, err := v.provider.Secret(ctx, v.name)
+	if err != nil {
+		log.Printf("secrets: failed to refresh %s, keeping the current value, err: %v", v.name, err)
+		return
+	}
+	// An empty read is usually a file caught mid-rotation.
+	if secret == "" || secret == v.Get() {
+		return
+	}
+
+	v.current.Store(&secret)
+	log.Printf("secrets: %s rotated", v.name)
+}
+
+// Get returns the current value of the secret.
+func (v *Value) Get() string {
+	return *v.current.Load()
+}
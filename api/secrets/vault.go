@@ -0,0 +1,104 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultProvider reads secrets from one HashiCorp Vault KV version 2
+// secret, each setting being a field of it, as in
+//
+//	vault kv put secret/shortener DB_PASS=... DB_TLS_CA_CERT=@ca.pem
+//
+// The token is read from TokenFile on every request when set, so a token
+// renewed by the Vault agent sidecar is picked up.
+type VaultProvider struct {
+	Addr      string
+	Path      string
+	Token     string
+	TokenFile string
+
+	Client *http.Client
+
+	mu      sync.Mutex
+	fetched time.Time
+	data    map[string]string
+}
+
+// vaultCacheTTL lets the watchers of several settings share one read.
+const vaultCacheTTL = time.Second
+
+func vaultFromEnv() *VaultProvider {
+	return &VaultProvider{
+		Addr:      os.Getenv("VAULT_ADDR"),
+		Path:      os.Getenv("VAULT_SECRET_PATH"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		TokenFile: os.Getenv("VAULT_TOKEN_FILE"),
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Secret implements Provider.
+func (v *VaultProvider) Secret(ctx context.Context, name string) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.data == nil || time.Since(v.fetched) > vaultCacheTTL {
+		data, err := v.read(ctx)
+		if err != nil {
+			return "", err
+		}
+		v.data, v.fetched = data, time.Now()
+	}
+
+	// Missing fields read as empty, like unset settings.
+	return v.data[name], nil
+}
+
+func (v *VaultProvider) read(ctx context.Context) (map[string]string, error) {
+	token := v.Token
+	if v.TokenFile != "" {
+		raw, err := os.ReadFile(v.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(raw))
+	}
+
+	// KV v2 serves secret/foo at secret/data/foo.
+	mount, path, _ := strings.Cut(strings.Trim(v.Path, "/"), "/")
+	url := strings.TrimSuffix(v.Addr, "/") + "/v1/" + mount + "/data/" + path
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from vault, err: %w", v.Path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to read %s from vault, status: %s", v.Path, resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode %s from vault, err: %w", v.Path, err)
+	}
+
+	return body.Data.Data, nil
+}
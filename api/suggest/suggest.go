@@ -0,0 +1,210 @@
+package suggest
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// MaxDistance is the largest edit distance between normalized forms for a
+// short to be suggested.
+const MaxDistance = 2
+
+// NormalizedKey returns the set of shorts sharing a normalized form.
+func NormalizedKey(normalized string) string {
+	return "suggest:norm:" + normalized
+}
+
+// SoundexKey returns the set of shorts sharing a soundex code.
+func SoundexKey(code string) string {
+	return "suggest:sx:" + code
+}
+
+// Normalize folds the differences people get wrong when typing a short:
+// case, separators and digits that look like letters.
+func Normalize(short string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(short) {
+		switch r {
+		case '-', '_', '.', '~', '/':
+			continue
+		case '0':
+			r = 'o'
+		case '1':
+			r = 'l'
+		case '5':
+			r = 's'
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// Soundex returns the American soundex code of the letters of s, or "" when
+// s has none.
+func Soundex(s string) string {
+	codes := map[rune]byte{
+		'b': '1', 'f': '1', 'p': '1', 'v': '1',
+		'c': '2', 'g': '2', 'j': '2', 'k': '2', 'q': '2', 's': '2', 'x': '2', 'z': '2',
+		'd': '3', 't': '3',
+		'l': '4',
+		'm': '5', 'n': '5',
+		'r': '6',
+	}
+
+	out := make([]byte, 0, 4)
+	var last byte
+	for _, r := range strings.ToLower(s) {
+		if r < 'a' || r > 'z' {
+			continue
+		}
+		code := codes[r]
+		if len(out) == 0 {
+			out = append(out, byte(r-'a'+'A'))
+			last = code
+			continue
+		}
+		// h and w don't separate letters with the same code, vowels do.
+		if r == 'h' || r == 'w' {
+			continue
+		}
+		if code != 0 && code != last {
+			out = append(out, code)
+			if len(out) == 4 {
+				break
+			}
+		}
+		last = code
+	}
+
+	if len(out) == 0 {
+		return ""
+	}
+	for len(out) < 4 {
+		out = append(out, '0')
+	}
+
+	return string(out)
+}
+
+// indexKeys returns the sets short is indexed in.
+func indexKeys(short string) []string {
+	normalized := Normalize(short)
+	keys := []string{NormalizedKey(normalized)}
+	if code := Soundex(normalized); code != "" {
+		keys = append(keys, SoundexKey(code))
+	}
+
+	return keys
+}
+
+// AppendIndex queues the commands adding short to the suggestion index.
+func AppendIndex(p *radix.Pipeline, short string) {
+	for _, key := range indexKeys(short) {
+		p.Append(radix.Cmd(nil, "SADD", key, short))
+	}
+}
+
+// Rebuild indexes every existing short, for deployments enabling
+// suggestions after shorts were created. It returns the number indexed.
+func Rebuild(ctx context.Context, rClient database.ClientInterface) (int, error) {
+	indexed := 0
+	err := database.Scan(rClient, links.MetaKey("*"), func(key string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		short, _ := links.ShortFromKey(key)
+		p := radix.NewPipeline()
+		AppendIndex(p, short)
+		if err := rClient.Do(p); err != nil {
+			return err
+		}
+		indexed++
+
+		return nil
+	})
+
+	return indexed, err
+}
+
+// Lookup returns up to limit existing shorts close to the one requested,
+// closest first. Shorts that no longer exist are dropped from the index.
+func Lookup(rClient database.ClientInterface, requested string, limit int) ([]string, error) {
+	normalized := Normalize(requested)
+
+	var candidates []string
+	if err := rClient.Do(radix.Cmd(&candidates, "SUNION", indexKeys(requested)...)); err != nil {
+		return nil, err
+	}
+
+	type scored struct {
+		short    string
+		distance int
+	}
+	var matches []scored
+	for _, short := range candidates {
+		if short == requested {
+			continue
+		}
+		d := distance(normalized, Normalize(short))
+		if d > MaxDistance {
+			continue
+		}
+		matches = append(matches, scored{short, d})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].distance < matches[j].distance })
+
+	var suggestions []string
+	for _, m := range matches {
+		if len(suggestions) == limit {
+			break
+		}
+
+		exists, err := links.Exists(rClient, m.short)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			p := radix.NewPipeline()
+			for _, key := range indexKeys(m.short) {
+				p.Append(radix.Cmd(nil, "SREM", key, m.short))
+			}
+			_ = rClient.Do(p)
+			continue
+		}
+
+		suggestions = append(suggestions, m.short)
+	}
+
+	return suggestions, nil
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
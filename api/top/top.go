@@ -0,0 +1,164 @@
+package top
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ksarpe/redis-golang/clock"
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/jobs"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+// Days is the longest period covered by the daily leaderboards, which
+// expire after it.
+const Days = 30
+
+// All is the period of the all-time leaderboard.
+const All = "all"
+
+// cacheTTL is how long the union of the daily leaderboards behind a period
+// is reused.
+const cacheTTL = time.Minute
+
+var errPeriod = errors.New(`period must be "all" or a number of days such as "7d", up to ` + strconv.Itoa(Days) + "d")
+
+// Config controls how large the leaderboards may grow.
+type Config struct {
+	// MaxEntries is the number of shorts each leaderboard is trimmed to.
+	MaxEntries int
+}
+
+// ConfigFromEnv reads the TOP_* environment variables.
+func ConfigFromEnv() Config {
+	cfg := Config{MaxEntries: 10000}
+
+	if v, err := strconv.Atoi(os.Getenv("TOP_MAX_ENTRIES")); err == nil && v > 0 {
+		cfg.MaxEntries = v
+	}
+
+	return cfg
+}
+
+// Entry is a short with its clicks during a period.
+type Entry struct {
+	Short  string `json:"short"`
+	Clicks int64  `json:"clicks"`
+}
+
+// AppendRecord queues the commands counting a click of short on the daily
+// and all-time leaderboards.
+func AppendRecord(p *radix.Pipeline, short string) {
+	AppendRecordOn(p, today(), short)
+}
+
+// AppendRecordOn is AppendRecord for a click made on another day, such as
+// a replayed one.
+func AppendRecordOn(p *radix.Pipeline, day int64, short string) {
+	key := links.TopDayKey(day)
+
+	p.Append(radix.Cmd(nil, "ZINCRBY", key, "1", short))
+	p.Append(radix.Cmd(nil, "EXPIRE", key, strconv.FormatInt(max((day+Days+1-today())*24*60*60, 1), 10)))
+	p.Append(radix.Cmd(nil, "ZINCRBY", links.TopAllKey(), "1", short))
+}
+
+// AppendForget queues the commands removing a deleted short from the
+// all-time leaderboard. Daily leaderboards forget it as they expire.
+func AppendForget(p *radix.Pipeline, short string) {
+	p.Append(radix.Cmd(nil, "ZREM", links.TopAllKey(), short))
+}
+
+// ParsePeriod parses a period such as "7d", returning its number of days,
+// 0 for All.
+func ParsePeriod(period string) (int, error) {
+	if period == All {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(strings.TrimSuffix(period, "d"))
+	if err != nil || !strings.HasSuffix(period, "d") || n < 1 || n > Days {
+		return 0, errPeriod
+	}
+
+	return n, nil
+}
+
+// Top returns the most clicked shorts of the last days, today included,
+// or of all time for 0 days. Shorts beyond the MaxEntries of a day may be
+// missing from longer periods.
+func Top(rClient database.ClientInterface, days, limit int) ([]Entry, error) {
+	key, err := periodKey(rClient, days)
+	if err != nil {
+		return nil, err
+	}
+
+	var pairs []string
+	if err := rClient.Do(radix.Cmd(&pairs, "ZREVRANGE", key, "0", strconv.Itoa(limit-1), "WITHSCORES")); err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		clicks, _ := strconv.ParseFloat(pairs[i+1], 64)
+		entries = append(entries, Entry{Short: pairs[i], Clicks: int64(clicks)})
+	}
+
+	return entries, nil
+}
+
+// periodKey returns the leaderboard of a period, computing and caching the
+// union of its days for longer periods.
+func periodKey(rClient database.ClientInterface, days int) (string, error) {
+	day := today()
+	switch days {
+	case 0:
+		return links.TopAllKey(), nil
+	case 1:
+		return links.TopDayKey(day), nil
+	}
+
+	key := links.TopPeriodKey(days, day)
+	var exists int
+	if err := rClient.Do(radix.Cmd(&exists, "EXISTS", key)); err != nil {
+		return "", err
+	}
+	if exists == 1 {
+		return key, nil
+	}
+
+	args := []string{key, strconv.Itoa(days)}
+	for i := 0; i < days; i++ {
+		args = append(args, links.TopDayKey(day-int64(i)))
+	}
+
+	p := radix.NewPipeline()
+	p.Append(radix.Cmd(nil, "ZUNIONSTORE", args...))
+	p.Append(radix.Cmd(nil, "EXPIRE", key, strconv.Itoa(int(cacheTTL/time.Second))))
+
+	return key, rClient.Do(p)
+}
+
+// Job returns the scheduled job trimming the all-time and current daily
+// leaderboards to cfg.MaxEntries, dropping the least clicked shorts.
+func Job(cfg Config) jobs.Func {
+	return func(ctx context.Context, rClient database.ClientInterface) error {
+		day := today()
+		stop := strconv.Itoa(-cfg.MaxEntries - 1)
+
+		p := radix.NewPipeline()
+		for _, key := range []string{links.TopAllKey(), links.TopDayKey(day), links.TopDayKey(day - 1)} {
+			p.Append(radix.Cmd(nil, "ZREMRANGEBYRANK", key, "0", stop))
+		}
+
+		return rClient.Do(p)
+	}
+}
+
+func today() int64 {
+	return clock.Now().Unix() / (24 * 60 * 60)
+}
@@ -0,0 +1,213 @@
+package webhooks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ksarpe/redis-golang/database"
+	"github.com/ksarpe/redis-golang/links"
+	radix "github.com/mediocregopher/radix/v4"
+)
+
+var (
+	ErrUnknownWebhook  = errors.New("webhook not found")
+	ErrUnknownDelivery = errors.New("delivery not found")
+)
+
+type config struct {
+	retries int
+	logSize int
+}
+
+// settings is read lazily so that the .env file is loaded first.
+var settings = sync.OnceValue(func() config {
+	cfg := config{retries: 3, logSize: 100}
+	if v, err := strconv.Atoi(os.Getenv("WEBHOOK_RETRIES")); err == nil && v >= 0 {
+		cfg.retries = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("WEBHOOK_LOG_SIZE")); err == nil && v > 0 {
+		cfg.logSize = v
+	}
+
+	return cfg
+})
+
+// Webhook is a configured endpoint. Its id is derived from the URL so it
+// stays stable across restarts and doesn't leak credentials in the URL.
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// ID returns the id of the webhook at url.
+func ID(url string) string {
+	sum := sha256.Sum256([]byte(url))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// Webhooks returns the configured webhooks.
+func Webhooks() []Webhook {
+	urls := URLs()
+	hooks := make([]Webhook, len(urls))
+	for i, u := range urls {
+		hooks[i] = Webhook{ID: ID(u), URL: u}
+	}
+
+	return hooks
+}
+
+func find(id string) (string, bool) {
+	for _, u := range URLs() {
+		if ID(u) == id {
+			return u, true
+		}
+	}
+
+	return "", false
+}
+
+// Attempt is one entry of a webhook's delivery log, ID being its stream id.
+// Retries and redeliveries share the Delivery of the first attempt.
+type Attempt struct {
+	ID          string `json:"id"`
+	Delivery    string `json:"delivery"`
+	Event       string `json:"event"`
+	Attempt     int    `json:"attempt"`
+	Status      int    `json:"status"`
+	Error       string `json:"error,omitempty"`
+	DurationMS  int64  `json:"duration_ms"`
+	At          int64  `json:"at"`
+	RedeliverOf string `json:"redelivery_of,omitempty"`
+	Body        string `json:"body,omitempty"`
+}
+
+// attemptDelivery delivers a.Body once and records the attempt, which is
+// returned with its outcome.
+func attemptDelivery(url string, a Attempt) Attempt {
+	start := time.Now()
+	status, err := deliver(url, a.Delivery, []byte(a.Body))
+
+	a.Status = status
+	a.DurationMS = time.Since(start).Milliseconds()
+	a.At = start.Unix()
+	if err != nil {
+		a.Error = err.Error()
+	}
+	a.ID = record(url, a)
+
+	return a
+}
+
+// record appends a to the delivery log of url, keeping roughly the last
+// WEBHOOK_LOG_SIZE attempts, and returns its stream id. The log is best
+// effort, deliveries go on without redis.
+func record(url string, a Attempt) string {
+	rClient, err := database.Bulk()
+	if err != nil {
+		return ""
+	}
+
+	var id string
+	err = rClient.Do(radix.Cmd(&id, "XADD", links.WebhookDeliveriesKey(ID(url)), "MAXLEN", "~", strconv.Itoa(settings().logSize), "*",
+		"delivery", a.Delivery,
+		"event", a.Event,
+		"attempt", strconv.Itoa(a.Attempt),
+		"status", strconv.Itoa(a.Status),
+		"error", a.Error,
+		"duration_ms", strconv.FormatInt(a.DurationMS, 10),
+		"at", strconv.FormatInt(a.At, 10),
+		"redelivery_of", a.RedeliverOf,
+		"body", a.Body,
+	))
+	if err != nil {
+		log.Printf("webhooks: failed to record delivery, err: %v", err)
+	}
+
+	return id
+}
+
+// Deliveries returns the last count attempts to deliver to the webhook id,
+// newest first.
+func Deliveries(rClient database.ClientInterface, id string, count int) ([]Attempt, error) {
+	if _, ok := find(id); !ok {
+		return nil, ErrUnknownWebhook
+	}
+
+	var entries []radix.StreamEntry
+	if err := rClient.Do(radix.Cmd(&entries, "XREVRANGE", links.WebhookDeliveriesKey(id), "+", "-", "COUNT", strconv.Itoa(count))); err != nil {
+		return nil, err
+	}
+
+	attempts := make([]Attempt, len(entries))
+	for i, entry := range entries {
+		attempts[i] = decode(entry)
+	}
+
+	return attempts, nil
+}
+
+// Redeliver sends the event of a logged attempt to the webhook id again,
+// with the same body and delivery id, and returns the new attempt.
+func Redeliver(rClient database.ClientInterface, id, attemptID string) (Attempt, error) {
+	url, ok := find(id)
+	if !ok {
+		return Attempt{}, ErrUnknownWebhook
+	}
+
+	var entries []radix.StreamEntry
+	err := rClient.Do(radix.Cmd(&entries, "XRANGE", links.WebhookDeliveriesKey(id), attemptID, attemptID))
+	if err != nil && strings.Contains(err.Error(), "Invalid stream ID") {
+		return Attempt{}, ErrUnknownDelivery
+	}
+	if err != nil {
+		return Attempt{}, err
+	}
+	if len(entries) == 0 {
+		return Attempt{}, ErrUnknownDelivery
+	}
+	previous := decode(entries[0])
+
+	return attemptDelivery(url, Attempt{
+		Delivery:    previous.Delivery,
+		Event:       previous.Event,
+		Attempt:     1,
+		RedeliverOf: attemptID,
+		Body:        previous.Body,
+	}), nil
+}
+
+func decode(entry radix.StreamEntry) Attempt {
+	a := Attempt{ID: entry.ID.String()}
+	for _, f := range entry.Fields {
+		switch f[0] {
+		case "delivery":
+			a.Delivery = f[1]
+		case "event":
+			a.Event = f[1]
+		case "attempt":
+			a.Attempt, _ = strconv.Atoi(f[1])
+		case "status":
+			a.Status, _ = strconv.Atoi(f[1])
+		case "error":
+			a.Error = f[1]
+		case "duration_ms":
+			a.DurationMS, _ = strconv.ParseInt(f[1], 10, 64)
+		case "at":
+			a.At, _ = strconv.ParseInt(f[1], 10, 64)
+		case "redelivery_of":
+			a.RedeliverOf = f[1]
+		case "body":
+			a.Body = f[1]
+		}
+	}
+
+	return a
+}
@@ -0,0 +1,135 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ksarpe/redis-golang/helpers"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the body keyed with
+// WEBHOOK_SECRET, so receivers can authenticate deliveries.
+const SignatureHeader = "X-Webhook-Signature"
+
+// DeliveryHeader carries the delivery id, the same for retries and
+// redeliveries of an event so receivers can drop duplicates.
+const DeliveryHeader = "X-Webhook-Delivery"
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Event is the envelope POSTed to every webhook.
+type Event struct {
+	Type      string `json:"type"`
+	CreatedAt int64  `json:"created_at"`
+	Data      any    `json:"data"`
+}
+
+// URLs returns the webhook endpoints configured through WEBHOOK_URLS.
+func URLs() []string {
+	var urls []string
+	for _, u := range strings.Split(os.Getenv("WEBHOOK_URLS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+
+	return urls
+}
+
+// Send delivers the event to every configured webhook in the background,
+// retrying failed deliveries WEBHOOK_RETRIES times with exponential backoff.
+// Every attempt is recorded in the webhook's delivery log.
+func Send(eventType string, data any) {
+	urls := URLs()
+	if len(urls) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(Event{Type: eventType, CreatedAt: time.Now().Unix(), Data: data})
+	if err != nil {
+		log.Printf("webhooks: failed to marshal %s event, err: %v", eventType, err)
+		return
+	}
+
+	delivery, err := helpers.RandomToken(12)
+	if err != nil {
+		log.Printf("webhooks: failed to create delivery id, err: %v", err)
+		return
+	}
+
+	cfg := settings()
+	for _, u := range urls {
+		go func(u string) {
+			backoff := time.Second
+			for attempt := 1; ; attempt++ {
+				a := attemptDelivery(u, Attempt{Delivery: delivery, Event: eventType, Attempt: attempt, Body: string(body)})
+				if a.Error == "" {
+					return
+				}
+				if attempt > cfg.retries {
+					log.Printf("webhooks: %s, giving up after %d attempts", a.Error, attempt)
+					return
+				}
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+		}(u)
+	}
+}
+
+// Deliver sends an already encoded event to every configured webhook once,
+// waiting for the outcome. delivery should stay the same when the caller
+// retries, receivers drop the duplicates by it.
+func Deliver(delivery, eventType string, body []byte) error {
+	var errs []error
+	for _, u := range URLs() {
+		a := attemptDelivery(u, Attempt{Delivery: delivery, Event: eventType, Attempt: 1, Body: string(body)})
+		if a.Error != "" {
+			errs = append(errs, errors.New(a.Error))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Sign returns the signature of body for the configured secret.
+func Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("WEBHOOK_SECRET")))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver POSTs body to url and returns the response status, 0 when there
+// was no response.
+func deliver(url, delivery string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(body))
+	req.Header.Set(DeliveryHeader, delivery)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to deliver to %s, err: %w", url, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("failed to deliver to %s, status: %s", url, resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}